@@ -0,0 +1,39 @@
+-- ArchLens Pipeline Runs
+-- Durable state for api-gateway pipeline orchestration
+
+-- ──────────────────────────────────────────────
+-- Pipeline Runs
+-- ──────────────────────────────────────────────
+CREATE TABLE pipeline_runs (
+    id                UUID PRIMARY KEY,
+    org_id            UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    repo_id           UUID NOT NULL REFERENCES repositories(id) ON DELETE CASCADE,
+    commit_sha        TEXT NOT NULL DEFAULT '',
+    branch            TEXT NOT NULL,
+    status            TEXT NOT NULL DEFAULT 'pending',  -- pending, running, completed, failed, cancelled
+    metadata          JSONB NOT NULL DEFAULT '{}',
+    total_duration_ms DOUBLE PRECISION NOT NULL DEFAULT 0,
+    resume_count      INTEGER NOT NULL DEFAULT 0,
+    heartbeat_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),  -- refreshed by the owning gateway replica
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at      TIMESTAMPTZ,
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_pipeline_runs_repo_created ON pipeline_runs(repo_id, created_at DESC);
+CREATE INDEX idx_pipeline_runs_active ON pipeline_runs(heartbeat_at)
+    WHERE status IN ('pending', 'running');
+
+CREATE TABLE pipeline_stage_results (
+    run_id            UUID NOT NULL REFERENCES pipeline_runs(id) ON DELETE CASCADE,
+    stage             TEXT NOT NULL,
+    status            TEXT NOT NULL,
+    started_at        TIMESTAMPTZ NOT NULL,
+    ended_at          TIMESTAMPTZ,
+    duration_ms       DOUBLE PRECISION NOT NULL DEFAULT 0,
+    output            JSONB,
+    error             TEXT,
+    PRIMARY KEY (run_id, stage)
+);
+
+CREATE TRIGGER trg_pipeline_runs_updated_at BEFORE UPDATE ON pipeline_runs
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at();
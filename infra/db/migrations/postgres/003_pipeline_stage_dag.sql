@@ -0,0 +1,8 @@
+-- ArchLens Pipeline Stage DAG
+-- Per-run disabled stages and per-stage retry attempts
+
+ALTER TABLE pipeline_runs
+    ADD COLUMN disabled_stages TEXT[] NOT NULL DEFAULT '{}';
+
+ALTER TABLE pipeline_stage_results
+    ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
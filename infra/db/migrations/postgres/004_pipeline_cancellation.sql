@@ -0,0 +1,5 @@
+-- ArchLens Pipeline Cancellation
+-- Cancellation requests for runs owned by another gateway replica
+
+ALTER TABLE pipeline_runs
+    ADD COLUMN cancel_requested_at TIMESTAMPTZ;
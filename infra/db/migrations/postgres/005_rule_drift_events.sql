@@ -0,0 +1,11 @@
+-- ArchLens Rule Evaluation
+-- Drift events outlive the rules that raised them, and the rule engine
+-- looks up open events per rule to avoid recording a violation twice
+
+ALTER TABLE drift_events
+    DROP CONSTRAINT drift_events_rule_id_fkey,
+    ADD CONSTRAINT drift_events_rule_id_fkey
+        FOREIGN KEY (rule_id) REFERENCES architectural_rules(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_drift_open_rule ON drift_events(repo_id, rule_id) WHERE status = 'open';
+CREATE INDEX idx_rules_org ON architectural_rules(org_id, created_at DESC);
@@ -0,0 +1,18 @@
+-- ArchLens API Keys
+-- Org-scoped credentials for CI and other non-interactive clients. Only a
+-- SHA-256 hash of each key is stored; the prefix identifies a key in listings.
+
+CREATE TABLE api_keys (
+    id              UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id          UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name            TEXT NOT NULL,
+    prefix          TEXT NOT NULL,
+    key_hash        TEXT NOT NULL UNIQUE,
+    scopes          TEXT[] NOT NULL DEFAULT '{}',
+    created_by      UUID REFERENCES users(id) ON DELETE SET NULL,
+    expires_at      TIMESTAMPTZ,
+    last_used_at    TIMESTAMPTZ,
+    revoked_at      TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_api_keys_org ON api_keys(org_id, created_at DESC);
@@ -0,0 +1,17 @@
+-- ArchLens Custom Roles
+-- Organization-defined roles alongside the built-in viewer, developer,
+-- architect and admin roles. Users hold a role by name through their token.
+
+CREATE TABLE org_roles (
+    id              UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id          UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    name            TEXT NOT NULL,
+    description     TEXT,
+    permissions     TEXT[] NOT NULL DEFAULT '{}',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE(org_id, name)
+);
+
+CREATE TRIGGER trg_org_roles_updated_at BEFORE UPDATE ON org_roles
+    FOR EACH ROW EXECUTE FUNCTION update_updated_at();
@@ -0,0 +1,19 @@
+-- ArchLens Tenant Key-Encryption Keys
+-- KEKs wrap the per-value data keys of an organization's encrypted data. The
+-- key material lives in a KMS; only a reference to it is stored. An
+-- organization has at most one active KEK; retired ones still decrypt until
+-- their data is re-encrypted, revoked ones have been destroyed.
+
+CREATE TABLE tenant_keks (
+    id              UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    org_id          UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    provider        TEXT NOT NULL,
+    key_ref         TEXT NOT NULL,
+    status          TEXT NOT NULL DEFAULT 'active' CHECK (status IN ('active', 'retired', 'revoked')),
+    created_by      UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    retired_at      TIMESTAMPTZ,
+    revoked_at      TIMESTAMPTZ
+);
+CREATE INDEX idx_tenant_keks_org ON tenant_keks(org_id, created_at DESC);
+CREATE UNIQUE INDEX idx_tenant_keks_active ON tenant_keks(org_id) WHERE status = 'active';
@@ -0,0 +1,13 @@
+-- ArchLens Encrypted Artifacts
+-- Source file contents and pipeline stage outputs (AST snapshots, AI analysis
+-- payloads) are stored as per-tenant ciphertexts. Rows written before this
+-- migration keep their plaintext until `encrypt-rows` encrypts them in place;
+-- stage outputs gain an id so the re-encryption job can page through them.
+
+ALTER TABLE code_files
+    ADD COLUMN content TEXT;
+
+ALTER TABLE pipeline_stage_results
+    ALTER COLUMN output TYPE TEXT USING output::text,
+    ADD COLUMN id UUID NOT NULL DEFAULT uuid_generate_v4();
+CREATE UNIQUE INDEX idx_pipeline_stage_results_id ON pipeline_stage_results(id);
@@ -0,0 +1,17 @@
+-- ArchLens Audit Trail
+-- Every mutating API request is recorded with its actor, request ID and
+-- outcome. actor keeps the raw user subject or API key ID, since actor_id
+-- only resolves for users known to the organization. Resource IDs come from
+-- request paths and are not necessarily UUIDs.
+
+ALTER TABLE audit_log
+    ALTER COLUMN resource_id TYPE TEXT USING resource_id::text,
+    ADD COLUMN actor_type   TEXT NOT NULL DEFAULT 'user',  -- user, api_key
+    ADD COLUMN actor        TEXT,
+    ADD COLUMN request_id   TEXT,
+    ADD COLUMN outcome      TEXT NOT NULL DEFAULT 'success',  -- success, failure, denied
+    ADD COLUMN status_code  INTEGER;
+
+CREATE INDEX idx_audit_org_resource ON audit_log(org_id, resource_type, resource_id, created_at DESC);
+CREATE INDEX idx_audit_org_action ON audit_log(org_id, action, created_at DESC);
+CREATE INDEX idx_audit_org_actor ON audit_log(org_id, actor, created_at DESC);
@@ -0,0 +1,7 @@
+-- ArchLens List Pagination
+-- List endpoints page with keyset cursors ordered by (created_at, id), so
+-- each page is an index range scan no matter how deep the cursor is
+
+CREATE INDEX idx_repositories_org_created ON repositories(org_id, created_at DESC, id DESC);
+CREATE INDEX idx_drift_repo_created ON drift_events(repo_id, created_at DESC, id DESC);
+CREATE INDEX idx_synthetic_fixes_drift_created ON synthetic_fixes(drift_event_id, created_at DESC, id DESC);
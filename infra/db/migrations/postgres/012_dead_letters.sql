@@ -0,0 +1,22 @@
+-- ArchLens Dead-Letter Queue
+-- Downstream calls that failed for good are kept here and replayed with
+-- backoff by the gateway; those out of retries are parked until replayed by
+-- hand. Payloads are per-tenant ciphertexts.
+
+CREATE TABLE dead_letters (
+    id               UUID PRIMARY KEY,
+    org_id           UUID NOT NULL REFERENCES organizations(id) ON DELETE CASCADE,
+    original_topic   TEXT NOT NULL,
+    payload          TEXT NOT NULL,
+    error            TEXT NOT NULL DEFAULT '',
+    status           TEXT NOT NULL DEFAULT 'pending',  -- pending, parked
+    retry_count      INTEGER NOT NULL DEFAULT 0,
+    max_retries      INTEGER NOT NULL,
+    metadata         JSONB NOT NULL DEFAULT '{}',
+    first_failed_at  TIMESTAMPTZ NOT NULL,
+    last_failed_at   TIMESTAMPTZ NOT NULL,
+    next_attempt_at  TIMESTAMPTZ  -- NULL once parked
+);
+CREATE INDEX idx_dead_letters_due ON dead_letters(next_attempt_at)
+    WHERE status = 'pending';
+CREATE INDEX idx_dead_letters_org_topic ON dead_letters(org_id, original_topic, last_failed_at DESC);
@@ -0,0 +1,17 @@
+-- ArchLens Source Ciphertexts
+-- Patches of synthetic fixes and phantom executions, and the summaries and
+-- violations of analyses, quote repository source and are stored as
+-- per-tenant ciphertexts like the artifacts of 009. Rows written before this
+-- migration keep their plaintext until `encrypt-rows` encrypts them in place.
+-- code_files.content is dropped: nothing stores file contents.
+
+ALTER TABLE code_files
+    DROP COLUMN content;
+
+ALTER TABLE analysis_results
+    ALTER COLUMN summary DROP DEFAULT,
+    ALTER COLUMN violations DROP DEFAULT,
+    ALTER COLUMN summary TYPE TEXT USING summary::text,
+    ALTER COLUMN violations TYPE TEXT USING violations::text,
+    ALTER COLUMN summary SET DEFAULT '{}',
+    ALTER COLUMN violations SET DEFAULT '[]';
@@ -0,0 +1,87 @@
+// Command encrypt-rows encrypts in place the rows of encrypted fields that
+// were written in plaintext before the fields were encrypted. It uses the
+// gateway's configuration and is safe to run while gateways are serving:
+// rows changed meanwhile are left to the writer.
+//
+//	encrypt-rows [-field synthetic_fixes.patch] [-dry-run]
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/config"
+	"github.com/archlens/api-gateway/internal/security"
+	"github.com/archlens/api-gateway/internal/store"
+	"go.uber.org/zap"
+)
+
+func main() {
+	field := flag.String("field", "", "only migrate this field, e.g. synthetic_fixes.patch")
+	dryRun := flag.Bool("dry-run", false, "only count the plaintext rows")
+	flag.Parse()
+
+	cfg := config.Load()
+	logger, err := zap.NewProduction()
+	if err != nil {
+		panic(fmt.Sprintf("failed to init logger: %v", err))
+	}
+	defer logger.Sync()
+	sugar := logger.Sugar()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	dbCtx, dbCancel := context.WithTimeout(ctx, 10*time.Second)
+	st, err := store.New(dbCtx, cfg.PostgresDSN)
+	dbCancel()
+	if err != nil {
+		sugar.Fatalw("failed to connect to postgres", "error", err)
+	}
+	defer st.Close()
+
+	if cfg.EncryptionKeys == "" {
+		sugar.Fatalw("ENCRYPTION_KEY or ENCRYPTION_KEYS is required")
+	}
+	encryptor, err := security.ParseKeyring(cfg.EncryptionKeys)
+	if err != nil {
+		sugar.Fatalw("invalid encryption keyring", "error", err)
+	}
+	localKMS, err := security.NewLocalKMS(cfg.KMSLocalDir, encryptor)
+	if err != nil {
+		sugar.Fatalw("failed to initialize local kms", "error", err)
+	}
+	envelope := security.NewEnvelope(encryptor, st, map[string]security.KMS{"local": localKMS})
+	st.UseEncryption(envelope)
+	migrator := security.NewReencryptor(envelope, st, sugar)
+
+	fields := st.EncryptedFields()
+	if *field != "" {
+		fields = []string{*field}
+	}
+	failed := false
+	for _, f := range fields {
+		remaining, err := migrator.CountPlaintext(ctx, f)
+		if err != nil {
+			sugar.Fatalw("failed to count plaintext rows", "field", f, "error", err)
+		}
+		if *dryRun || remaining == 0 {
+			sugar.Infow("plaintext rows", "field", f, "rows", remaining)
+			continue
+		}
+		encrypted, skipped, err := migrator.EncryptPlaintext(ctx, f)
+		if err != nil {
+			sugar.Fatalw("failed to encrypt rows", "field", f, "encrypted", encrypted, "error", err)
+		}
+		sugar.Infow("encrypted rows", "field", f, "encrypted", encrypted, "failed", skipped)
+		failed = failed || skipped > 0
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
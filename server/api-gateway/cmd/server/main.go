@@ -5,20 +5,30 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/archlens/api-gateway/internal/auth"
 	"github.com/archlens/api-gateway/internal/config"
 	"github.com/archlens/api-gateway/internal/handler"
 	"github.com/archlens/api-gateway/internal/middleware"
+	"github.com/archlens/api-gateway/internal/openapi"
+	"github.com/archlens/api-gateway/internal/pipeline"
+	"github.com/archlens/api-gateway/internal/ratelimit"
+	"github.com/archlens/api-gateway/internal/realtime"
+	"github.com/archlens/api-gateway/internal/resilience"
+	"github.com/archlens/api-gateway/internal/security"
+	"github.com/archlens/api-gateway/internal/store"
 	"github.com/archlens/api-gateway/internal/telemetry"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/helmet"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/gofiber/swagger"
+	"github.com/redis/go-redis/v9"
 	"go.uber.org/zap"
 )
 
@@ -48,6 +58,138 @@ func main() {
 		defer shutdown(context.Background())
 	}
 
+	// ── PostgreSQL ──
+	dbCtx, dbCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	st, err := store.New(dbCtx, cfg.PostgresDSN)
+	dbCancel()
+	if err != nil {
+		sugar.Fatalw("failed to connect to postgres", "error", err)
+	}
+	defer st.Close()
+
+	// ── Encryption ──
+	keyring := cfg.EncryptionKeys
+	if keyring == "" {
+		if cfg.Env == "production" {
+			sugar.Fatalw("ENCRYPTION_KEY or ENCRYPTION_KEYS is required in production")
+		}
+		sugar.Warnw("no encryption key configured, using the insecure development key")
+		keyring = "1:" + strings.Repeat("00", 32)
+	}
+	encryptor, err := security.ParseKeyring(keyring)
+	if err != nil {
+		sugar.Fatalw("invalid encryption keyring", "error", err)
+	}
+	sugar.Infow("encryption keyring loaded", "current_key_id", encryptor.CurrentKeyID(), "key_ids", encryptor.KeyIDs())
+	localKMS, err := security.NewLocalKMS(cfg.KMSLocalDir, encryptor)
+	if err != nil {
+		sugar.Fatalw("failed to initialize local kms", "error", err)
+	}
+	envelope := security.NewEnvelope(encryptor, st, map[string]security.KMS{"local": localKMS})
+	st.UseEncryption(envelope)
+
+	// ── Redis ──
+	// Callers such as the rate limiter bound their Redis calls with context
+	// deadlines, which go-redis only honours when told to
+	rdb := redis.NewClient(&redis.Options{Addr: cfg.RedisAddr, ContextTimeoutEnabled: true})
+	defer rdb.Close()
+
+	// ── Realtime Hub ──
+	// Events go through a Redis stream so every replica's clients see them;
+	// without Redis the gateway falls back to a single-replica in-memory log
+	var eventLog realtime.EventLog
+	pingCtx, pingCancel := context.WithTimeout(context.Background(), 3*time.Second)
+	if err := rdb.Ping(pingCtx).Err(); err != nil {
+		sugar.Warnw("redis unavailable, realtime events limited to this replica", "error", err)
+		eventLog = realtime.NewMemoryLog(0)
+	} else {
+		eventLog = realtime.NewRedisLog(rdb, sugar, 0)
+	}
+	pingCancel()
+	hub := realtime.NewHub(sugar, eventLog)
+
+	// ── Pipeline Orchestrator ──
+	orchestrator := pipeline.NewOrchestrator(sugar, pipeline.ServiceEndpoints{
+		CognitiveURL: cfg.CognitiveURL,
+		CitadelURL:   cfg.CitadelURL,
+		VaultURL:     cfg.VaultServiceURL,
+		AuditURL:     cfg.AuditServiceURL,
+	}, st)
+	orchestrator.UseRuleStore(st)
+	// Replicas share breaker state so a failing service is cut off by all of
+	// them at once; each falls back to its own state while Redis is down
+	var sharedBreakers *resilience.SharedBreakers
+	if cfg.SharedBreakers {
+		sharedBreakers = resilience.NewSharedBreakers(rdb, sugar)
+		orchestrator.UseSharedBreakers(sharedBreakers)
+	}
+	// ── Dead-Letter Queue ──
+	// Stage calls that failed for their service being down are kept and
+	// replayed with backoff instead of being lost
+	var dlqStore resilience.DeadLetterStore
+	var kafkaDLQ *resilience.KafkaDeadLetterStore
+	switch cfg.DLQBackend {
+	case "memory":
+		dlqStore = resilience.NewMemoryDeadLetterStore(sugar, 0)
+	case "kafka":
+		kafkaDLQ = resilience.NewKafkaDeadLetterStore(strings.Split(cfg.KafkaBrokers, ","), rdb, sugar)
+		defer kafkaDLQ.Close()
+		dlqStore = kafkaDLQ
+	case "postgres":
+		dlqStore = st.DeadLetters()
+	default:
+		sugar.Fatalw("unknown DLQ backend", "backend", cfg.DLQBackend)
+	}
+	dlq := resilience.NewDeadLetterQueue(dlqStore, resilience.DefaultDeadLetterConfig(), sugar)
+	orchestrator.UseDeadLetters(dlq)
+
+	realtime.PublishPipeline(hub, orchestrator)
+
+	// ── Background Workers ──
+	bgCtx, bgCancel := context.WithCancel(context.Background())
+	defer bgCancel()
+	go orchestrator.Run(bgCtx)
+	go hub.Run(bgCtx)
+	if sharedBreakers != nil {
+		go sharedBreakers.Run(bgCtx)
+	}
+	go dlq.Run(bgCtx)
+	if kafkaDLQ != nil {
+		go kafkaDLQ.Run(bgCtx)
+	}
+	go security.NewReencryptor(envelope, st, sugar).Run(bgCtx, cfg.ReencryptPeriod)
+
+	// ── Authentication ──
+	oidcProvider := auth.NewProvider(cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret, nil)
+	signingKeys := auth.NewKeySet(oidcProvider, sugar)
+	go signingKeys.Run(bgCtx, cfg.JWKSRefresh)
+	verifierCfg := auth.VerifierConfig{
+		Issuer:    oidcProvider.Issuer(),
+		Audience:  cfg.OIDCAudience,
+		ClockSkew: cfg.OIDCClockSkew,
+	}
+	if cfg.AllowHMACTokens {
+		sugar.Warnw("accepting HS256 tokens signed with JWT_SECRET; disable with AUTH_ALLOW_HMAC=false")
+		verifierCfg.HMACSecret = cfg.JWTSecret
+	}
+	verifier := auth.NewVerifier(signingKeys, verifierCfg)
+
+	// ── Authorization ──
+	policy := security.NewPolicy(st)
+	ownership := security.NewOwnershipResolver(st, st, sugar)
+	auditTrail := security.NewAuditTrail(st, st, sugar)
+
+	// ── Rate Limiting ──
+	// Token buckets live in Redis so budgets hold across replicas
+	limits := ratelimit.NewLimiter(rdb, st, ratelimit.DefaultBudgets(), sugar)
+
+	// ── API Contract ──
+	// Requests are validated against the OpenAPI document served at /docs
+	spec, err := openapi.Load()
+	if err != nil {
+		sugar.Fatalw("failed to load openapi document", "error", err)
+	}
+
 	// ── Fiber App ──
 	app := fiber.New(fiber.Config{
 		AppName:               "ArchLens API Gateway",
@@ -66,15 +208,11 @@ func main() {
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     cfg.CORSOrigins,
 		AllowMethods:     "GET,POST,PUT,PATCH,DELETE,OPTIONS",
-		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,X-Request-ID,X-Archlens-Client",
+		AllowHeaders:     "Origin,Content-Type,Accept,Authorization,X-Request-ID,X-Archlens-Client,Idempotency-Key",
+		ExposeHeaders:    "RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset,RateLimit-Policy,Retry-After,Idempotent-Replayed",
 		AllowCredentials: true,
 		MaxAge:           3600,
 	}))
-	app.Use(limiter.New(limiter.Config{
-		Max:               100,
-		Expiration:        1 * time.Minute,
-		LimiterMiddleware: limiter.SlidingWindow{},
-	}))
 	app.Use(middleware.RequestLogger(sugar))
 	app.Use(middleware.MetricsMiddleware())
 
@@ -83,57 +221,104 @@ func main() {
 	app.Get("/ready", handler.ReadinessCheck(cfg))
 	app.Get("/metrics", handler.PrometheusMetrics())
 
+	// ── API Docs ──
+	app.Get("/docs/openapi.json", spec.JSON())
+	app.Get("/docs/openapi.yaml", spec.YAML())
+	app.Get("/docs/*", swagger.New(swagger.Config{Title: "ArchLens API", URL: "/docs/openapi.json"}))
+
 	// ── API v1 ──
 	v1 := app.Group("/api/v1")
 
 	// Public
-	v1.Post("/auth/token", handler.AuthToken(cfg))
+	v1.Post("/auth/token", limits.LimitByIP(), spec.Validate(), handler.AuthToken(oidcProvider))
 
-	// Protected routes
-	protected := v1.Group("", middleware.JWTAuth(cfg))
+	// Protected routes. Every route declares the permission it requires, and
+	// routes addressing a resource by ID check that the caller's org owns it.
+	// All spend from the plan's default budget; expensive routes also spend
+	// from their own. Mutating requests are audited, including denied ones;
+	// replays of an Idempotency-Key are answered before either. Parameters
+	// and bodies are validated against the OpenAPI document last.
+	protected := v1.Group("", middleware.JWTAuth(verifier, st), middleware.Idempotency(rdb, cfg.IdempotencyTTL, sugar),
+		auditTrail.Record(), limits.Limit(ratelimit.ClassDefault), spec.Validate())
+	can := policy.Require
+	owns := ownership.Require
 
 	// Organizations
-	protected.Get("/organizations", handler.ListOrganizations())
-	protected.Post("/organizations", handler.CreateOrganization())
-	protected.Get("/organizations/:orgId", handler.GetOrganization())
+	protected.Get("/organizations", can(security.PermOrgsRead), handler.ListOrganizations(st))
+	protected.Post("/organizations", can(security.PermOrgsCreate), handler.CreateOrganization(st))
+	protected.Get("/organizations/:orgId", can(security.PermOrgsRead), handler.GetOrganization(st))
+
+	// API Keys
+	protected.Get("/organizations/:orgId/api-keys", can(security.PermAPIKeysManage), handler.ListAPIKeys(st))
+	protected.Post("/organizations/:orgId/api-keys", can(security.PermAPIKeysManage), handler.CreateAPIKey(st, policy))
+	protected.Get("/organizations/:orgId/api-keys/:keyId", can(security.PermAPIKeysManage), handler.GetAPIKey(st))
+	protected.Patch("/organizations/:orgId/api-keys/:keyId", can(security.PermAPIKeysManage), handler.UpdateAPIKey(st, policy))
+	protected.Delete("/organizations/:orgId/api-keys/:keyId", can(security.PermAPIKeysManage), handler.RevokeAPIKey(st))
+
+	// Roles
+	protected.Get("/organizations/:orgId/roles", can(security.PermOrgsRead), handler.ListRoles(st))
+	protected.Post("/organizations/:orgId/roles", can(security.PermRolesManage), handler.CreateRole(st, policy))
+	protected.Put("/organizations/:orgId/roles/:roleId", can(security.PermRolesManage), handler.UpdateRole(st, policy))
+	protected.Delete("/organizations/:orgId/roles/:roleId", can(security.PermRolesManage), handler.DeleteRole(st, policy))
+
+	// Encryption keys
+	protected.Get("/organizations/:orgId/keks", can(security.PermKEKsManage), handler.ListKEKs(st))
+	protected.Post("/organizations/:orgId/keks", can(security.PermKEKsManage), handler.CreateKEK(st, envelope))
+	protected.Post("/organizations/:orgId/keks/rotate", can(security.PermKEKsManage), handler.RotateKEK(st, envelope))
+	protected.Post("/organizations/:orgId/keks/:kekId/revoke", can(security.PermKEKsManage), handler.RevokeKEK(st, envelope))
 
 	// Repositories
-	protected.Get("/organizations/:orgId/repos", handler.ListRepositories())
-	protected.Post("/organizations/:orgId/repos", handler.CreateRepository())
-	protected.Get("/repos/:repoId", handler.GetRepository())
-	protected.Post("/repos/:repoId/analyze", handler.TriggerAnalysis())
+	protected.Get("/organizations/:orgId/repos", can(security.PermReposRead), handler.ListRepositories(st))
+	protected.Post("/organizations/:orgId/repos", can(security.PermReposWrite), handler.CreateRepository(st))
+	protected.Get("/repos/:repoId", can(security.PermReposRead), owns(security.ResourceRepository, "repoId"), handler.GetRepository(st))
+	protected.Post("/repos/:repoId/analyze", can(security.PermAnalysisTrigger), owns(security.ResourceRepository, "repoId"), limits.Limit(ratelimit.ClassAnalyze), handler.TriggerAnalysis(st, orchestrator))
+
+	// Pipelines
+	protected.Get("/repos/:repoId/pipelines", can(security.PermPipelinesRead), owns(security.ResourceRepository, "repoId"), handler.ListPipelineRuns(st, orchestrator))
+	protected.Put("/repos/:repoId/pipeline-config", can(security.PermPipelinesWrite), owns(security.ResourceRepository, "repoId"), handler.UpdatePipelineConfig(st, orchestrator))
+	protected.Get("/pipelines/stages", can(security.PermPipelinesRead), handler.ListPipelineStages(orchestrator))
+	protected.Get("/pipelines/:id", can(security.PermPipelinesRead), owns(security.ResourcePipelineRun, "id"), handler.GetPipelineRun(orchestrator))
+	protected.Get("/pipelines/:id/events", can(security.PermPipelinesRead), owns(security.ResourcePipelineRun, "id"), handler.PipelineEvents(orchestrator, hub, sugar))
+	protected.Post("/pipelines/:id/cancel", can(security.PermPipelinesWrite), owns(security.ResourcePipelineRun, "id"), handler.CancelPipelineRun(orchestrator))
 
 	// Analysis
-	protected.Get("/repos/:repoId/analyses", handler.ListAnalyses())
-	protected.Get("/analyses/:analysisId", handler.GetAnalysis())
-	protected.Get("/analyses/:analysisId/dependencies", handler.GetDependencyGraph())
+	protected.Get("/repos/:repoId/analyses", can(security.PermAnalysisRead), owns(security.ResourceRepository, "repoId"), handler.ListAnalyses(st))
+	protected.Get("/analyses/:analysisId", can(security.PermAnalysisRead), owns(security.ResourceAnalysis, "analysisId"), handler.GetAnalysis())
+	protected.Get("/analyses/:analysisId/dependencies", can(security.PermAnalysisRead), owns(security.ResourceAnalysis, "analysisId"), handler.GetDependencyGraph(st))
 
 	// Drift & Violations
-	protected.Get("/repos/:repoId/drift", handler.ListDriftEvents())
-	protected.Patch("/drift/:driftId", handler.UpdateDriftEvent())
+	protected.Get("/repos/:repoId/drift", can(security.PermDriftRead), owns(security.ResourceRepository, "repoId"), handler.ListDriftEvents(st))
+	protected.Patch("/drift/:driftId", can(security.PermDriftWrite), owns(security.ResourceDrift, "driftId"), handler.UpdateDriftEvent())
 
 	// Architectural Rules
-	protected.Get("/organizations/:orgId/rules", handler.ListRules())
-	protected.Post("/organizations/:orgId/rules", handler.CreateRule())
-	protected.Put("/rules/:ruleId", handler.UpdateRule())
-	protected.Delete("/rules/:ruleId", handler.DeleteRule())
+	protected.Get("/organizations/:orgId/rules", can(security.PermRulesRead), handler.ListRules(st))
+	protected.Post("/organizations/:orgId/rules", can(security.PermRulesWrite), handler.CreateRule(st))
+	protected.Put("/rules/:ruleId", can(security.PermRulesWrite), owns(security.ResourceRule, "ruleId"), handler.UpdateRule(st))
+	protected.Delete("/rules/:ruleId", can(security.PermRulesDelete), owns(security.ResourceRule, "ruleId"), handler.DeleteRule(st))
 
 	// Phantom Execution
-	protected.Post("/repos/:repoId/phantom", handler.CreatePhantomExecution())
-	protected.Get("/phantom/:phantomId", handler.GetPhantomExecution())
+	protected.Post("/repos/:repoId/phantom", can(security.PermPhantomRun), owns(security.ResourceRepository, "repoId"), limits.Limit(ratelimit.ClassPhantom), handler.CreatePhantomExecution())
+	protected.Get("/phantom/:phantomId", can(security.PermPhantomRead), owns(security.ResourcePhantom, "phantomId"), handler.GetPhantomExecution())
 
 	// Synthetic Fixes
-	protected.Get("/drift/:driftId/fixes", handler.ListSyntheticFixes())
-	protected.Post("/fixes/:fixId/apply", handler.ApplySyntheticFix())
+	protected.Get("/drift/:driftId/fixes", can(security.PermFixesRead), owns(security.ResourceDrift, "driftId"), handler.ListSyntheticFixes(st))
+	protected.Post("/fixes/:fixId/apply", can(security.PermFixesApply), owns(security.ResourceFix, "fixId"), handler.ApplySyntheticFix())
 
 	// Metrics
-	protected.Get("/repos/:repoId/metrics", handler.GetArchitectureMetrics())
+	protected.Get("/repos/:repoId/metrics", can(security.PermMetricsRead), owns(security.ResourceRepository, "repoId"), handler.GetArchitectureMetrics())
 
 	// Audit Log
-	protected.Get("/organizations/:orgId/audit", handler.ListAuditLog())
+	protected.Get("/organizations/:orgId/audit", can(security.PermAuditRead), handler.ListAuditLog(st, sugar))
+
+	// Dead-Letter Queue
+	protected.Get("/organizations/:orgId/dlq", can(security.PermDLQManage), handler.ListDeadLetters(dlq))
+	protected.Delete("/organizations/:orgId/dlq", can(security.PermDLQManage), handler.PurgeDeadLetters(dlq))
+	protected.Post("/organizations/:orgId/dlq/replay", can(security.PermDLQManage), handler.ReplayDeadLetters(dlq))
+	protected.Get("/organizations/:orgId/dlq/:messageId", can(security.PermDLQManage), handler.GetDeadLetter(dlq))
+	protected.Post("/organizations/:orgId/dlq/:messageId/replay", can(security.PermDLQManage), handler.ReplayDeadLetter(dlq))
 
 	// ── WebSocket ──
-	app.Get("/ws", middleware.JWTAuth(cfg), handler.WebSocketUpgrade())
+	app.Get("/ws", handler.WebSocketUpgrade(), middleware.JWTAuth(verifier, st), limits.Limit(ratelimit.ClassDefault), can(security.PermPipelinesRead), handler.WebSocketHub(hub, sugar))
 
 	// ── Graceful Shutdown ──
 	quit := make(chan os.Signal, 1)
@@ -149,6 +334,7 @@ func main() {
 
 	<-quit
 	sugar.Info("shutting down gracefully...")
+	bgCancel()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	_ = ctx
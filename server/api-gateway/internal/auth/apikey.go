@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// APIKeyPrefix marks bearer tokens that are API keys rather than JWTs
+const APIKeyPrefix = "alk_"
+
+// GenerateAPIKey returns a new key of the form alk_<prefix>_<secret>, its
+// display prefix and the hash to store. The key itself is shown once and
+// never persisted.
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	buf := make([]byte, 30)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(buf[:4])
+	key = APIKeyPrefix + prefix + "_" + base64.RawURLEncoding.EncodeToString(buf[4:])
+	return key, APIKeyPrefix + prefix, HashAPIKey(key), nil
+}
+
+// HashAPIKey hashes a key for storage and lookup. Keys carry 208 bits of
+// randomness, so a fast unsalted hash is sufficient.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsAPIKey reports whether a bearer token looks like an API key
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
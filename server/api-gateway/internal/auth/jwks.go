@@ -0,0 +1,215 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// minRefreshInterval limits how often an unknown key ID triggers a JWKS
+// fetch, so tokens with bogus key IDs cannot hammer the provider
+const minRefreshInterval = 30 * time.Second
+
+var ErrUnknownKey = errors.New("signing key not found in provider key set")
+
+// KeySet caches the provider's JSON Web Key Set. Keys are refreshed in the
+// background and on demand when a token references an unknown key ID, which
+// picks up key rotations without a restart.
+type KeySet struct {
+	provider *Provider
+	logger   *zap.SugaredLogger
+
+	mu        sync.RWMutex
+	keys      map[string]interface{}
+	fetchedAt time.Time
+
+	// refreshMu serializes fetches so concurrent misses share one request
+	refreshMu sync.Mutex
+}
+
+func NewKeySet(provider *Provider, logger *zap.SugaredLogger) *KeySet {
+	return &KeySet{provider: provider, logger: logger, keys: map[string]interface{}{}}
+}
+
+// Run refreshes the key set every interval until ctx is done
+func (k *KeySet) Run(ctx context.Context, interval time.Duration) {
+	if err := k.refresh(ctx); err != nil {
+		k.logger.Warnw("failed to fetch oidc signing keys", "error", err)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.refresh(ctx); err != nil {
+				k.logger.Warnw("failed to refresh oidc signing keys", "error", err)
+			}
+		}
+	}
+}
+
+// Key returns the public key for a key ID, fetching the key set if the ID
+// is unknown and the cached set is not fresh
+func (k *KeySet) Key(ctx context.Context, kid string) (interface{}, error) {
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+
+	k.refreshMu.Lock()
+	defer k.refreshMu.Unlock()
+	// Another request may have refreshed while this one waited
+	if key, ok := k.lookup(kid); ok {
+		return key, nil
+	}
+	k.mu.RLock()
+	fresh := time.Since(k.fetchedAt) < minRefreshInterval
+	k.mu.RUnlock()
+	if !fresh {
+		if err := k.fetch(ctx); err != nil {
+			return nil, err
+		}
+		if key, ok := k.lookup(kid); ok {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+func (k *KeySet) lookup(kid string) (interface{}, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.keys[kid]
+	return key, ok
+}
+
+func (k *KeySet) refresh(ctx context.Context) error {
+	k.refreshMu.Lock()
+	defer k.refreshMu.Unlock()
+	return k.fetch(ctx)
+}
+
+// fetch downloads the key set; callers must hold refreshMu
+func (k *KeySet) fetch(ctx context.Context) error {
+	doc, err := k.provider.discover(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, doc.JWKSURI, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.provider.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("jwks request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("jwks request failed: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return fmt.Errorf("jwks request failed: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			k.logger.Warnw("ignoring unusable oidc signing key", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.fetchedAt = time.Now()
+	k.mu.Unlock()
+	return nil
+}
+
+// jsonWebKey is an RSA or EC public key in JWK form (RFC 7517)
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (j jsonWebKey) publicKey() (interface{}, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := decodeBigInt(j.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := decodeBigInt(j.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %w", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("exponent too large")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", j.Crv)
+		}
+		x, err := decodeBigInt(j.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %w", err)
+		}
+		y, err := decodeBigInt(j.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %w", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point is not on curve %s", j.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", j.Kty)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func newTestKeySet(t *testing.T, f *fakeOIDC) *KeySet {
+	t.Helper()
+	return NewKeySet(NewProvider(f.URL, "gateway", "s3cret", nil), zap.NewNop().Sugar())
+}
+
+func TestKeySetPicksUpRotatedKeys(t *testing.T) {
+	ctx := context.Background()
+	f := newFakeOIDC(t)
+	oldKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.setKeys(rsaJWK("old", &oldKey.PublicKey))
+	ks := newTestKeySet(t, f)
+
+	if _, err := ks.Key(ctx, "old"); err != nil {
+		t.Fatalf("first lookup: %v", err)
+	}
+	if _, err := ks.Key(ctx, "old"); err != nil || f.hits() != 1 {
+		t.Fatalf("cached lookup: err %v, %d fetches", err, f.hits())
+	}
+
+	// The provider rotates; a token signed with the new key arrives before
+	// the next background refresh
+	f.setKeys(ecJWK("new", &newKey.PublicKey))
+	if _, err := ks.Key(ctx, "new"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("lookup within the refresh interval: %v, want ErrUnknownKey", err)
+	}
+	if f.hits() != 1 {
+		t.Fatalf("unknown key ID fetched the key set within the refresh interval (%d fetches)", f.hits())
+	}
+
+	ks.mu.Lock()
+	ks.fetchedAt = time.Now().Add(-minRefreshInterval)
+	ks.mu.Unlock()
+	key, err := ks.Key(ctx, "new")
+	if err != nil {
+		t.Fatalf("lookup after the refresh interval: %v", err)
+	}
+	if _, ok := key.(*ecdsa.PublicKey); !ok {
+		t.Fatalf("key is %T, want *ecdsa.PublicKey", key)
+	}
+	if _, err := ks.Key(ctx, "old"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("retired key still accepted: %v", err)
+	}
+}
+
+func TestKeySetRunRefreshes(t *testing.T) {
+	f := newFakeOIDC(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := newTestKeySet(t, f)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go ks.Run(ctx, 10*time.Millisecond)
+
+	f.setKeys(rsaJWK("k1", &key.PublicKey))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := ks.lookup("k1"); ok {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("background refresh never picked up the key")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestKeySetSkipsUnusableKeys(t *testing.T) {
+	f := newFakeOIDC(t)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := ecJWK("enc", &key.PublicKey)
+	enc.Use = "enc"
+	offCurve := ecJWK("off-curve", &key.PublicKey)
+	offCurve.Y = offCurve.X
+	f.setKeys(enc, offCurve, jsonWebKey{Kid: "oct", Kty: "oct"}, ecJWK("good", &key.PublicKey))
+	ks := newTestKeySet(t, f)
+
+	if _, err := ks.Key(context.Background(), "good"); err != nil {
+		t.Fatal(err)
+	}
+	for _, kid := range []string{"enc", "off-curve", "oct"} {
+		if _, ok := ks.lookup(kid); ok {
+			t.Errorf("key %q was accepted", kid)
+		}
+	}
+}
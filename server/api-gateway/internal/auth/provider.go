@@ -0,0 +1,194 @@
+// Package auth integrates the gateway with an OpenID Connect provider
+// (Keycloak in production): token exchange at the provider's token endpoint
+// and validation of the access tokens it issues.
+//
+// Endpoints are found through OIDC discovery at
+// <issuer>/.well-known/openid-configuration, so any server implementing
+// discovery, a JWKS endpoint and a token endpoint can stand in for Keycloak
+// in local development and tests.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Provider talks to an OIDC provider on behalf of the gateway's client
+type Provider struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+
+	mu        sync.Mutex
+	discovery *discoveryDocument
+}
+
+type discoveryDocument struct {
+	Issuer        string `json:"issuer"`
+	TokenEndpoint string `json:"token_endpoint"`
+	JWKSURI       string `json:"jwks_uri"`
+}
+
+// TokenResponse is a successful token endpoint response
+type TokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int    `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	RefreshExpiresIn int    `json:"refresh_expires_in,omitempty"`
+	IDToken          string `json:"id_token,omitempty"`
+	Scope            string `json:"scope,omitempty"`
+}
+
+// TokenError is an OAuth 2.0 error returned by the token endpoint
+type TokenError struct {
+	StatusCode  int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *TokenError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("token endpoint returned %s: %s", e.Code, e.Description)
+	}
+	return "token endpoint returned " + e.Code
+}
+
+// NewProvider creates a provider for an issuer URL such as
+// http://keycloak:8080/realms/archlens. A nil client uses a default with a
+// 10 second timeout.
+func NewProvider(issuer, clientID, clientSecret string, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &Provider{
+		issuer:       strings.TrimSuffix(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       client,
+	}
+}
+
+// Issuer returns the expected iss claim of tokens from this provider
+func (p *Provider) Issuer() string {
+	return p.issuer
+}
+
+// discover fetches the discovery document once; failures are retried on
+// the next call
+func (p *Provider) discover(ctx context.Context) (*discoveryDocument, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc discovery failed: status %d", resp.StatusCode)
+	}
+
+	var doc discoveryDocument
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery failed: %w", err)
+	}
+	if strings.TrimSuffix(doc.Issuer, "/") != p.issuer {
+		return nil, fmt.Errorf("oidc discovery failed: issuer %q does not match configured %q", doc.Issuer, p.issuer)
+	}
+	if doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc discovery failed: token_endpoint and jwks_uri are required")
+	}
+	p.discovery = &doc
+	return p.discovery, nil
+}
+
+// ExchangeCode redeems an authorization code obtained by a browser login.
+// codeVerifier is the PKCE verifier, if the login used PKCE.
+func (p *Provider) ExchangeCode(ctx context.Context, code, redirectURI, codeVerifier string) (*TokenResponse, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {redirectURI},
+	}
+	if codeVerifier != "" {
+		form.Set("code_verifier", codeVerifier)
+	}
+	return p.token(ctx, p.clientID, p.clientSecret, form)
+}
+
+// RefreshToken exchanges a refresh token issued to the gateway's client
+func (p *Provider) RefreshToken(ctx context.Context, refreshToken string) (*TokenResponse, error) {
+	return p.token(ctx, p.clientID, p.clientSecret, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+	})
+}
+
+// ClientCredentials obtains a token for a service account. The caller's own
+// client credentials are used, never the gateway's.
+func (p *Provider) ClientCredentials(ctx context.Context, clientID, clientSecret, scope string) (*TokenResponse, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if scope != "" {
+		form.Set("scope", scope)
+	}
+	return p.token(ctx, clientID, clientSecret, form)
+}
+
+func (p *Provider) token(ctx context.Context, clientID, clientSecret string, form url.Values) (*TokenResponse, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		tokenErr := &TokenError{StatusCode: resp.StatusCode}
+		if json.Unmarshal(body, tokenErr) != nil || tokenErr.Code == "" {
+			tokenErr.Code = "server_error"
+			tokenErr.Description = fmt.Sprintf("unexpected status %d", resp.StatusCode)
+		}
+		return nil, tokenErr
+	}
+
+	var tok TokenResponse
+	if err := json.Unmarshal(body, &tok); err != nil {
+		return nil, fmt.Errorf("token request failed: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return nil, fmt.Errorf("token request failed: response has no access_token")
+	}
+	return &tok, nil
+}
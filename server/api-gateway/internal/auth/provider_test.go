@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+)
+
+// fakeOIDC is an OIDC provider serving discovery, a JWKS that tests can
+// rotate and a token endpoint that records what it was sent
+type fakeOIDC struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	issuer    string // overrides the advertised issuer when set
+	jwks      []jsonWebKey
+	jwksHits  int
+	tokenForm url.Values
+	tokenUser string
+	tokenPass string
+	// tokenStatus and tokenBody override the token response when set
+	tokenStatus int
+	tokenBody   string
+}
+
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	t.Helper()
+	f := &fakeOIDC{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		issuer := f.issuer
+		f.mu.Unlock()
+		if issuer == "" {
+			issuer = f.URL
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":         issuer,
+			"token_endpoint": f.URL + "/token",
+			"jwks_uri":       f.URL + "/certs",
+		})
+	})
+	mux.HandleFunc("/certs", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.jwksHits++
+		json.NewEncoder(w).Encode(map[string]any{"keys": f.jwks})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		r.ParseForm()
+		f.tokenForm = r.PostForm
+		f.tokenUser, f.tokenPass, _ = r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		if f.tokenStatus != 0 {
+			w.WriteHeader(f.tokenStatus)
+			w.Write([]byte(f.tokenBody))
+			return
+		}
+		json.NewEncoder(w).Encode(TokenResponse{AccessToken: "access-" + r.PostForm.Get("grant_type"), TokenType: "Bearer", ExpiresIn: 300})
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeOIDC) setKeys(keys ...jsonWebKey) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jwks = keys
+}
+
+func (f *fakeOIDC) hits() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.jwksHits
+}
+
+func rsaJWK(kid string, pub *rsa.PublicKey) jsonWebKey {
+	return jsonWebKey{
+		Kid: kid, Kty: "RSA", Use: "sig",
+		N: base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+		E: base64.RawURLEncoding.EncodeToString([]byte{1, 0, 1}),
+	}
+}
+
+func ecJWK(kid string, pub *ecdsa.PublicKey) jsonWebKey {
+	return jsonWebKey{
+		Kid: kid, Kty: "EC", Use: "sig", Crv: "P-256",
+		X: base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+		Y: base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func TestDiscoveryRejectsIssuerMismatch(t *testing.T) {
+	f := newFakeOIDC(t)
+	f.issuer = "https://evil.example/realms/archlens"
+	p := NewProvider(f.URL, "gateway", "secret", nil)
+	if _, err := p.ClientCredentials(context.Background(), "svc", "pw", ""); err == nil {
+		t.Fatal("token exchange succeeded despite a mismatched discovery issuer")
+	}
+
+	// Failed discovery is retried on the next call
+	f.mu.Lock()
+	f.issuer = ""
+	f.mu.Unlock()
+	if _, err := p.ClientCredentials(context.Background(), "svc", "pw", ""); err != nil {
+		t.Fatalf("retry after fixing discovery: %v", err)
+	}
+}
+
+func TestExchangeCode(t *testing.T) {
+	f := newFakeOIDC(t)
+	p := NewProvider(f.URL+"/", "gateway", "s3cret", nil)
+
+	tok, err := p.ExchangeCode(context.Background(), "the-code", "https://app.example/cb", "verifier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access-authorization_code" {
+		t.Fatalf("access token = %q", tok.AccessToken)
+	}
+	want := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {"the-code"},
+		"redirect_uri":  {"https://app.example/cb"},
+		"code_verifier": {"verifier"},
+	}
+	if f.tokenForm.Encode() != want.Encode() {
+		t.Fatalf("form = %v, want %v", f.tokenForm, want)
+	}
+	if f.tokenUser != "gateway" || f.tokenPass != "s3cret" {
+		t.Fatalf("client authenticated as %q/%q, want the gateway's client", f.tokenUser, f.tokenPass)
+	}
+}
+
+func TestClientCredentialsUsesCallerCredentials(t *testing.T) {
+	f := newFakeOIDC(t)
+	p := NewProvider(f.URL, "gateway", "s3cret", nil)
+
+	if _, err := p.ClientCredentials(context.Background(), "ci-bot", "p@ss word", "repos"); err != nil {
+		t.Fatal(err)
+	}
+	if f.tokenUser != "ci-bot" || f.tokenPass != "p%40ss+word" {
+		t.Fatalf("client authenticated as %q/%q", f.tokenUser, f.tokenPass)
+	}
+	if f.tokenForm.Get("grant_type") != "client_credentials" || f.tokenForm.Get("scope") != "repos" {
+		t.Fatalf("form = %v", f.tokenForm)
+	}
+}
+
+func TestTokenErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		code   string
+	}{
+		{"oauth error", http.StatusUnauthorized, `{"error":"invalid_client","error_description":"bad secret"}`, "invalid_client"},
+		{"unparseable error", http.StatusBadGateway, `<html>`, "server_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeOIDC(t)
+			f.tokenStatus, f.tokenBody = tt.status, tt.body
+			p := NewProvider(f.URL, "gateway", "s3cret", nil)
+
+			_, err := p.RefreshToken(context.Background(), "rt")
+			var tokenErr *TokenError
+			if !errors.As(err, &tokenErr) {
+				t.Fatalf("err = %v, want *TokenError", err)
+			}
+			if tokenErr.Code != tt.code || tokenErr.StatusCode != tt.status {
+				t.Fatalf("token error = %+v", tokenErr)
+			}
+		})
+	}
+}
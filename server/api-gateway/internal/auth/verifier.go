@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// roleRank orders the platform roles; a token carrying several realm roles
+// gets the most privileged one
+var roleRank = []string{"admin", "architect", "developer", "viewer"}
+
+// Claims are the access token claims the gateway relies on
+type Claims struct {
+	jwt.RegisteredClaims
+	OrgID string `json:"org_id"`
+	Role  string `json:"role"`
+	Email string `json:"email"`
+	// RealmAccess carries Keycloak realm roles
+	RealmAccess struct {
+		Roles []string `json:"roles"`
+	} `json:"realm_access"`
+	// AuthorizedParty is the client a token was issued to
+	AuthorizedParty string `json:"azp"`
+}
+
+// VerifierConfig configures access token validation
+type VerifierConfig struct {
+	Issuer   string
+	Audience string
+	// ClockSkew is the leeway allowed on exp, nbf and iat
+	ClockSkew time.Duration
+	// HMACSecret, if set, also accepts HS256 tokens signed with the shared
+	// secret. It exists for local development only.
+	HMACSecret string
+}
+
+// Verifier validates access tokens issued by the OIDC provider
+type Verifier struct {
+	keys *KeySet
+	cfg  VerifierConfig
+}
+
+func NewVerifier(keys *KeySet, cfg VerifierConfig) *Verifier {
+	return &Verifier{keys: keys, cfg: cfg}
+}
+
+// Verify parses and validates a bearer token and normalizes its role
+func (v *Verifier) Verify(ctx context.Context, tokenStr string) (*Claims, error) {
+	methods := []string{"RS256", "ES256"}
+	if v.cfg.HMACSecret != "" {
+		methods = append(methods, "HS256")
+	}
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithLeeway(v.cfg.ClockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	}
+
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+			return []byte(v.cfg.HMACSecret), nil
+		}
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			return nil, fmt.Errorf("token has no key id")
+		}
+		return v.keys.Key(ctx, kid)
+	}, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if !token.Valid {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	// Shared-secret development tokens are not issued by the provider
+	if _, hmac := token.Method.(*jwt.SigningMethodHMAC); !hmac {
+		if claims.Issuer != v.cfg.Issuer {
+			return nil, fmt.Errorf("token issuer %q is not trusted", claims.Issuer)
+		}
+		if v.cfg.Audience != "" && !hasAudience(claims, v.cfg.Audience) {
+			return nil, fmt.Errorf("token is not intended for audience %q", v.cfg.Audience)
+		}
+	}
+
+	if claims.Role == "" {
+		claims.Role = realmRole(claims.RealmAccess.Roles)
+	}
+	return claims, nil
+}
+
+// hasAudience accepts the audience in aud or, as Keycloak does for tokens a
+// client requested for itself, in azp
+func hasAudience(claims *Claims, audience string) bool {
+	for _, aud := range claims.Audience {
+		if aud == audience {
+			return true
+		}
+	}
+	return claims.AuthorizedParty == audience
+}
+
+// realmRole maps Keycloak realm roles onto the most privileged platform role
+func realmRole(roles []string) string {
+	held := make(map[string]bool, len(roles))
+	for _, r := range roles {
+		held[r] = true
+	}
+	for _, r := range roleRank {
+		if held[r] {
+			return r
+		}
+	}
+	return ""
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type testSigner struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+func (s testSigner) sign(t *testing.T, claims jwt.Claims) string {
+	t.Helper()
+	tok := jwt.NewWithClaims(s.method, claims)
+	tok.Header["kid"] = s.kid
+	signed, err := tok.SignedString(s.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// newVerifierFixture serves an RSA and an EC signing key from a fake
+// provider and verifies tokens for the "archlens-api" audience
+func newVerifierFixture(t *testing.T, skew time.Duration) (*Verifier, *fakeOIDC, testSigner, testSigner) {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := newFakeOIDC(t)
+	f.setKeys(rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey))
+	v := NewVerifier(newTestKeySet(t, f), VerifierConfig{
+		Issuer:    f.URL,
+		Audience:  "archlens-api",
+		ClockSkew: skew,
+	})
+	return v, f,
+		testSigner{kid: "rsa-1", method: jwt.SigningMethodRS256, key: rsaKey},
+		testSigner{kid: "ec-1", method: jwt.SigningMethodES256, key: ecKey}
+}
+
+func validClaims(issuer string) *Claims {
+	now := time.Now()
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   "user-1",
+			Audience:  jwt.ClaimStrings{"archlens-api"},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+		OrgID: "org-1",
+	}
+}
+
+func TestVerifyAlgorithms(t *testing.T) {
+	v, f, rs, es := newVerifierFixture(t, 0)
+	for _, s := range []testSigner{rs, es} {
+		t.Run(s.method.Alg(), func(t *testing.T) {
+			claims, err := v.Verify(context.Background(), s.sign(t, validClaims(f.URL)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if claims.Subject != "user-1" || claims.OrgID != "org-1" {
+				t.Fatalf("claims = %+v", claims)
+			}
+		})
+	}
+
+	t.Run("HS256 without a shared secret", func(t *testing.T) {
+		tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims(f.URL)).SignedString([]byte("dev"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Verify(context.Background(), tok); err == nil {
+			t.Fatal("HS256 token accepted although no shared secret is configured")
+		}
+	})
+
+	t.Run("key ID of the other algorithm", func(t *testing.T) {
+		mixed := testSigner{kid: es.kid, method: rs.method, key: rs.key}
+		if _, err := v.Verify(context.Background(), mixed.sign(t, validClaims(f.URL))); err == nil {
+			t.Fatal("RS256 signature accepted for an EC key")
+		}
+	})
+}
+
+func TestVerifyIssuerAndAudience(t *testing.T) {
+	v, f, rs, _ := newVerifierFixture(t, 0)
+	tests := []struct {
+		name   string
+		modify func(*Claims)
+		ok     bool
+	}{
+		{"matching", func(*Claims) {}, true},
+		{"wrong issuer", func(c *Claims) { c.Issuer = "https://other.example/realms/archlens" }, false},
+		{"issuer with trailing slash", func(c *Claims) { c.Issuer += "/" }, false},
+		{"wrong audience", func(c *Claims) { c.Audience = jwt.ClaimStrings{"account"} }, false},
+		{"no audience", func(c *Claims) { c.Audience = nil }, false},
+		{"audience among several", func(c *Claims) { c.Audience = jwt.ClaimStrings{"account", "archlens-api"} }, true},
+		{"audience as authorized party", func(c *Claims) {
+			c.Audience = jwt.ClaimStrings{"account"}
+			c.AuthorizedParty = "archlens-api"
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims(f.URL)
+			tt.modify(claims)
+			_, err := v.Verify(context.Background(), rs.sign(t, claims))
+			if tt.ok && err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyClockSkew(t *testing.T) {
+	const skew = time.Minute
+	v, f, rs, _ := newVerifierFixture(t, skew)
+	// margin keeps the boundary cases clear of the test's own run time and
+	// of the one-second resolution of JWT timestamps
+	const margin = 5 * time.Second
+	tests := []struct {
+		name   string
+		modify func(*Claims, time.Time)
+		ok     bool
+	}{
+		{"expired within skew", func(c *Claims, now time.Time) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-skew + margin))
+		}, true},
+		{"expired beyond skew", func(c *Claims, now time.Time) {
+			c.ExpiresAt = jwt.NewNumericDate(now.Add(-skew - margin))
+		}, false},
+		{"not yet valid within skew", func(c *Claims, now time.Time) {
+			c.NotBefore = jwt.NewNumericDate(now.Add(skew - margin))
+		}, true},
+		{"not yet valid beyond skew", func(c *Claims, now time.Time) {
+			c.NotBefore = jwt.NewNumericDate(now.Add(skew + margin))
+		}, false},
+		{"issued in the future within skew", func(c *Claims, now time.Time) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(skew - margin))
+		}, true},
+		{"issued in the future beyond skew", func(c *Claims, now time.Time) {
+			c.IssuedAt = jwt.NewNumericDate(now.Add(skew + margin))
+		}, false},
+		{"no expiry", func(c *Claims, _ time.Time) { c.ExpiresAt = nil }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims(f.URL)
+			tt.modify(claims, time.Now())
+			_, err := v.Verify(context.Background(), rs.sign(t, claims))
+			if tt.ok && err != nil {
+				t.Fatalf("rejected: %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyRealmRoles(t *testing.T) {
+	v, f, _, es := newVerifierFixture(t, 0)
+	tests := []struct {
+		name  string
+		role  string
+		realm []string
+		want  string
+	}{
+		{"most privileged realm role", "", []string{"viewer", "offline_access", "architect", "developer"}, "architect"},
+		{"admin", "", []string{"admin", "viewer"}, "admin"},
+		{"no platform role", "", []string{"offline_access", "uma_authorization"}, ""},
+		{"explicit role claim wins", "viewer", []string{"admin"}, "viewer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validClaims(f.URL)
+			claims.Role = tt.role
+			claims.RealmAccess.Roles = tt.realm
+			got, err := v.Verify(context.Background(), es.sign(t, claims))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Role != tt.want {
+				t.Fatalf("role = %q, want %q", got.Role, tt.want)
+			}
+		})
+	}
+}
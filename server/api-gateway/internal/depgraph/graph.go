@@ -0,0 +1,284 @@
+// Package depgraph turns the file-level dependency edges of an analysis into
+// queryable views: filtered, aggregated to directories, packages or
+// configured components, and annotated with dependency cycles.
+package depgraph
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/archlens/api-gateway/internal/rules"
+)
+
+// Level is the granularity nodes are aggregated to
+type Level string
+
+const (
+	LevelFile      Level = "file"
+	LevelDirectory Level = "directory"
+	LevelPackage   Level = "package"
+	LevelComponent Level = "component"
+)
+
+// unassigned groups files that match no configured component
+const unassigned = "(unassigned)"
+
+// File is a source file of an analysis. Package is the package or module the
+// parser attributed it to, if any.
+type File struct {
+	Path    string
+	Package string
+}
+
+// FileEdge is a single dependency_edges row
+type FileEdge struct {
+	Source string
+	Target string
+	Type   string
+	Weight float64
+}
+
+// Component is a named group of paths configured for a repository
+type Component struct {
+	Name  string   `json:"name"`
+	Paths []string `json:"paths"`
+}
+
+// Options selects how the graph is aggregated
+type Options struct {
+	Level Level
+	// Depth truncates directories to their first Depth path segments; 0
+	// keeps the full directory
+	Depth      int
+	Components []Component
+	// Cycles enables strongly connected component detection
+	Cycles bool
+}
+
+// Node is a file or group of files
+type Node struct {
+	ID     string `json:"id"`
+	Files  int    `json:"files"`
+	FanIn  int    `json:"fan_in"`
+	FanOut int    `json:"fan_out"`
+}
+
+// Edge aggregates the file dependencies between two nodes
+type Edge struct {
+	Source   string         `json:"source"`
+	Target   string         `json:"target"`
+	Count    int            `json:"count"`
+	Weight   float64        `json:"weight"`
+	DepTypes map[string]int `json:"dep_types"`
+}
+
+// Cycle is a strongly connected component with every edge between its members
+type Cycle struct {
+	Nodes []string `json:"nodes"`
+	Edges []Edge   `json:"edges"`
+}
+
+// Graph is an aggregated view of an analysis' dependencies
+type Graph struct {
+	Level         Level   `json:"level"`
+	Nodes         []Node  `json:"nodes"`
+	Edges         []Edge  `json:"edges"`
+	Cycles        []Cycle `json:"cycles,omitempty"`
+	FileCount     int     `json:"file_count"`
+	FileEdgeCount int     `json:"file_edge_count"`
+}
+
+// OptionsError reports an invalid query option
+type OptionsError struct {
+	Field   string
+	Message string
+}
+
+func (e *OptionsError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Message)
+}
+
+// ParseLevel validates a level name; empty means file level
+func ParseLevel(s string) (Level, error) {
+	switch l := Level(s); l {
+	case "":
+		return LevelFile, nil
+	case LevelFile, LevelDirectory, LevelPackage, LevelComponent:
+		return l, nil
+	}
+	return "", &OptionsError{Field: "level", Message: "must be one of file, directory, package, component"}
+}
+
+// Build aggregates file dependencies to the requested level. Dependencies
+// between files of the same group are folded into the group, except at file
+// level where self-dependencies are kept.
+func Build(files []File, edges []FileEdge, opts Options) (*Graph, error) {
+	group, err := grouping(files, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]*Node)
+	node := func(id string) *Node {
+		n := nodes[id]
+		if n == nil {
+			n = &Node{ID: id}
+			nodes[id] = n
+		}
+		return n
+	}
+	for _, f := range files {
+		node(group(f.Path)).Files++
+	}
+
+	type pair struct{ source, target string }
+	aggregated := make(map[pair]*Edge)
+	for _, e := range edges {
+		p := pair{group(e.Source), group(e.Target)}
+		if p.source == p.target && opts.Level != LevelFile {
+			continue
+		}
+		agg := aggregated[p]
+		if agg == nil {
+			agg = &Edge{Source: p.source, Target: p.target, DepTypes: make(map[string]int)}
+			aggregated[p] = agg
+			node(p.source).FanOut++
+			node(p.target).FanIn++
+		}
+		agg.Count++
+		agg.Weight += e.Weight
+		agg.DepTypes[e.Type]++
+	}
+
+	g := &Graph{
+		Level:         opts.Level,
+		Nodes:         make([]Node, 0, len(nodes)),
+		Edges:         make([]Edge, 0, len(aggregated)),
+		FileCount:     len(files),
+		FileEdgeCount: len(edges),
+	}
+	for _, n := range nodes {
+		g.Nodes = append(g.Nodes, *n)
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	for _, e := range aggregated {
+		g.Edges = append(g.Edges, *e)
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].Source != g.Edges[j].Source {
+			return g.Edges[i].Source < g.Edges[j].Source
+		}
+		return g.Edges[i].Target < g.Edges[j].Target
+	})
+
+	if opts.Cycles {
+		g.Cycles = cycles(g.Edges)
+	}
+	return g, nil
+}
+
+// cycles returns every strongly connected component of the aggregated graph
+// with the edges between its members
+func cycles(edges []Edge) []Cycle {
+	plain := make([]rules.Edge, len(edges))
+	for i, e := range edges {
+		plain[i] = rules.Edge{Source: e.Source, Target: e.Target}
+	}
+
+	out := []Cycle{}
+	for _, members := range rules.StronglyConnected(plain) {
+		in := make(map[string]bool, len(members))
+		for _, m := range members {
+			in[m] = true
+		}
+		c := Cycle{Nodes: members}
+		for _, e := range edges {
+			if in[e.Source] && in[e.Target] {
+				c.Edges = append(c.Edges, e)
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// grouping returns the function mapping a file path to its node ID
+func grouping(files []File, opts Options) (func(string) string, error) {
+	switch opts.Level {
+	case LevelFile:
+		return func(p string) string { return p }, nil
+	case LevelDirectory:
+		return func(p string) string { return directory(p, opts.Depth) }, nil
+	case LevelPackage:
+		packages := make(map[string]string, len(files))
+		for _, f := range files {
+			packages[f.Path] = f.Package
+		}
+		// Files the parser did not attribute to a package fall back to
+		// their directory
+		return func(p string) string {
+			if pkg := packages[p]; pkg != "" {
+				return pkg
+			}
+			return directory(p, opts.Depth)
+		}, nil
+	case LevelComponent:
+		if len(opts.Components) == 0 {
+			return nil, &OptionsError{Field: "level", Message: "component requires components in the repository config"}
+		}
+		type matcher struct {
+			name string
+			re   []*regexp.Regexp
+		}
+		matchers := make([]matcher, 0, len(opts.Components))
+		for _, c := range opts.Components {
+			m := matcher{name: c.Name}
+			for _, glob := range c.Paths {
+				re, err := rules.CompileGlob(glob)
+				if err != nil {
+					return nil, &OptionsError{Field: "components." + c.Name, Message: err.Error()}
+				}
+				m.re = append(m.re, re)
+			}
+			matchers = append(matchers, m)
+		}
+		// Components are matched in configuration order, so more specific
+		// components should be listed first
+		assigned := make(map[string]string, len(files))
+		return func(p string) string {
+			if name, ok := assigned[p]; ok {
+				return name
+			}
+			name := unassigned
+		match:
+			for _, m := range matchers {
+				for _, re := range m.re {
+					if re.MatchString(p) {
+						name = m.name
+						break match
+					}
+				}
+			}
+			assigned[p] = name
+			return name
+		}, nil
+	}
+	return nil, &OptionsError{Field: "level", Message: "must be one of file, directory, package, component"}
+}
+
+// directory returns the directory of a file, truncated to depth segments
+// when depth is positive
+func directory(p string, depth int) string {
+	dir := path.Dir(p)
+	if depth <= 0 || dir == "." {
+		return dir
+	}
+	segments := strings.Split(dir, "/")
+	if len(segments) > depth {
+		segments = segments[:depth]
+	}
+	return strings.Join(segments, "/")
+}
@@ -0,0 +1,228 @@
+package depgraph
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testFiles = []File{
+	{Path: "main.go"},
+	{Path: "src/api/routes.go", Package: "api"},
+	{Path: "src/api/handlers/users.go", Package: "handlers"},
+	{Path: "src/api/handlers/orgs.go", Package: "handlers"},
+	{Path: "src/store/db.go", Package: "store"},
+	{Path: "src/store/sql/queries.go"},
+}
+
+var testEdges = []FileEdge{
+	{Source: "main.go", Target: "src/api/routes.go", Type: "import", Weight: 1},
+	{Source: "src/api/routes.go", Target: "src/api/handlers/users.go", Type: "import", Weight: 1},
+	{Source: "src/api/handlers/users.go", Target: "src/store/db.go", Type: "import", Weight: 1},
+	{Source: "src/api/handlers/orgs.go", Target: "src/store/db.go", Type: "call", Weight: 0.5},
+	{Source: "src/store/db.go", Target: "src/store/sql/queries.go", Type: "import", Weight: 1},
+	// The store calls back into the handlers: a cycle between directories
+	{Source: "src/store/sql/queries.go", Target: "src/api/handlers/orgs.go", Type: "call", Weight: 0.25},
+	{Source: "src/store/db.go", Target: "src/store/db.go", Type: "call", Weight: 1},
+}
+
+func nodeIDs(g *Graph) []string {
+	ids := make([]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		ids[i] = n.ID
+	}
+	return ids
+}
+
+func findEdge(g *Graph, source, target string) *Edge {
+	for i := range g.Edges {
+		if g.Edges[i].Source == source && g.Edges[i].Target == target {
+			return &g.Edges[i]
+		}
+	}
+	return nil
+}
+
+func TestDirectoryDepth(t *testing.T) {
+	tests := []struct {
+		path  string
+		depth int
+		want  string
+	}{
+		{"main.go", 0, "."},
+		{"main.go", 2, "."},
+		{"src/api/handlers/users.go", 0, "src/api/handlers"},
+		{"src/api/handlers/users.go", 1, "src"},
+		{"src/api/handlers/users.go", 2, "src/api"},
+		{"src/api/handlers/users.go", 3, "src/api/handlers"},
+		{"src/api/handlers/users.go", 10, "src/api/handlers"},
+	}
+	for _, tt := range tests {
+		if got := directory(tt.path, tt.depth); got != tt.want {
+			t.Errorf("directory(%q, %d) = %q, want %q", tt.path, tt.depth, got, tt.want)
+		}
+	}
+}
+
+func TestBuildAtDepth(t *testing.T) {
+	tests := []struct {
+		depth int
+		nodes []string
+		edges int
+	}{
+		{0, []string{".", "src/api", "src/api/handlers", "src/store", "src/store/sql"}, 5},
+		{2, []string{".", "src/api", "src/store"}, 3},
+		{1, []string{".", "src"}, 1},
+	}
+	for _, tt := range tests {
+		g, err := Build(testFiles, testEdges, Options{Level: LevelDirectory, Depth: tt.depth})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ids := nodeIDs(g); !reflect.DeepEqual(ids, tt.nodes) {
+			t.Errorf("depth %d: nodes %v, want %v", tt.depth, ids, tt.nodes)
+		}
+		if len(g.Edges) != tt.edges {
+			t.Errorf("depth %d: %d edges %+v, want %d", tt.depth, len(g.Edges), g.Edges, tt.edges)
+		}
+		if g.FileCount != len(testFiles) || g.FileEdgeCount != len(testEdges) {
+			t.Errorf("depth %d: counted %d files and %d edges", tt.depth, g.FileCount, g.FileEdgeCount)
+		}
+	}
+
+	// Dependencies between two groups are aggregated into one edge
+	g, _ := Build(testFiles, testEdges, Options{Level: LevelDirectory, Depth: 2})
+	e := findEdge(g, "src/api", "src/store")
+	if e == nil || e.Count != 2 || e.Weight != 1.5 || !reflect.DeepEqual(e.DepTypes, map[string]int{"import": 1, "call": 1}) {
+		t.Fatalf("src/api → src/store = %+v", e)
+	}
+	for _, n := range g.Nodes {
+		if n.ID == "src/api" && (n.Files != 3 || n.FanIn != 2 || n.FanOut != 1) {
+			t.Errorf("node %+v, want 3 files, fan in 2, fan out 1", n)
+		}
+	}
+}
+
+func TestBuildFileLevelKeepsSelfDependencies(t *testing.T) {
+	g, err := Build(testFiles, testEdges, Options{Level: LevelFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Nodes) != len(testFiles) || len(g.Edges) != len(testEdges) {
+		t.Fatalf("%d nodes and %d edges, want %d and %d", len(g.Nodes), len(g.Edges), len(testFiles), len(testEdges))
+	}
+	if findEdge(g, "src/store/db.go", "src/store/db.go") == nil {
+		t.Error("self-dependency dropped at file level")
+	}
+
+	g, _ = Build(testFiles, testEdges, Options{Level: LevelDirectory})
+	if findEdge(g, "src/store", "src/store") != nil {
+		t.Error("dependency within a directory kept at directory level")
+	}
+}
+
+func TestBuildDetectsCycles(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		cycles [][]string
+	}{
+		{"disabled", Options{Level: LevelDirectory, Depth: 2}, nil},
+		{"directories", Options{Level: LevelDirectory, Depth: 2, Cycles: true}, [][]string{{"src/api", "src/store"}}},
+		{"nested directories", Options{Level: LevelDirectory, Cycles: true}, [][]string{{"src/api/handlers", "src/store", "src/store/sql"}}},
+		// Collapsing everything into one directory leaves no cycle between groups
+		{"one directory", Options{Level: LevelDirectory, Depth: 1, Cycles: true}, [][]string{}},
+		{"files", Options{Level: LevelFile, Cycles: true}, [][]string{
+			{"src/api/handlers/orgs.go", "src/store/db.go", "src/store/sql/queries.go"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := Build(testFiles, testEdges, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.cycles == nil {
+				if g.Cycles != nil {
+					t.Fatalf("cycles %+v with detection disabled", g.Cycles)
+				}
+				return
+			}
+			got := make([][]string, len(g.Cycles))
+			for i, c := range g.Cycles {
+				got[i] = c.Nodes
+				in := map[string]bool{}
+				for _, n := range c.Nodes {
+					in[n] = true
+				}
+				for _, e := range c.Edges {
+					if !in[e.Source] || !in[e.Target] {
+						t.Errorf("cycle %v has edge %s → %s leaving it", c.Nodes, e.Source, e.Target)
+					}
+				}
+				if len(c.Edges) < len(c.Nodes) {
+					t.Errorf("cycle %v has only %d edges", c.Nodes, len(c.Edges))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.cycles) {
+				t.Errorf("cycles %v, want %v", got, tt.cycles)
+			}
+		})
+	}
+}
+
+func TestBuildGroupsPackagesAndComponents(t *testing.T) {
+	g, err := Build(testFiles, testEdges, Options{Level: LevelPackage, Depth: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Files without a package fall back to their truncated directory
+	if ids, want := nodeIDs(g), []string{".", "api", "handlers", "src/store", "store"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("package nodes %v, want %v", ids, want)
+	}
+
+	components := []Component{
+		{Name: "handlers", Paths: []string{"src/api/handlers/**"}},
+		{Name: "api", Paths: []string{"src/api/**"}},
+		{Name: "persistence", Paths: []string{"src/store/*.go", "src/store/sql/*.go"}},
+	}
+	g, err = Build(testFiles, testEdges, Options{Level: LevelComponent, Components: components, Cycles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Components match in configuration order
+	if ids, want := nodeIDs(g), []string{unassigned, "api", "handlers", "persistence"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("component nodes %v, want %v", ids, want)
+	}
+	if len(g.Cycles) != 1 || !reflect.DeepEqual(g.Cycles[0].Nodes, []string{"handlers", "persistence"}) {
+		t.Errorf("component cycles %+v", g.Cycles)
+	}
+}
+
+func TestBuildRejectsInvalidOptions(t *testing.T) {
+	tests := []struct {
+		name  string
+		opts  Options
+		field string
+	}{
+		{"unknown level", Options{Level: "module"}, "level"},
+		{"components not configured", Options{Level: LevelComponent}, "level"},
+		{"empty component glob", Options{Level: LevelComponent, Components: []Component{{Name: "api", Paths: []string{" "}}}}, "components.api"},
+	}
+	for _, tt := range tests {
+		_, err := Build(testFiles, testEdges, tt.opts)
+		var optErr *OptionsError
+		if !errors.As(err, &optErr) || optErr.Field != tt.field {
+			t.Errorf("%s: err %v, want an OptionsError on %s", tt.name, err, tt.field)
+		}
+	}
+
+	for _, s := range []string{"", "file", "directory", "package", "component"} {
+		if _, err := ParseLevel(s); err != nil {
+			t.Errorf("ParseLevel(%q): %v", s, err)
+		}
+	}
+	if _, err := ParseLevel("files"); err == nil {
+		t.Error("ParseLevel accepted an unknown level")
+	}
+}
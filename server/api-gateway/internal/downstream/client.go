@@ -0,0 +1,296 @@
+// Package downstream calls the services behind the gateway. Each service
+// gets one Client, which owns the service's circuit breaker and bulkhead, so
+// every caller shares them: a slow or failing service trips its own breaker
+// and fills its own bulkhead without tying up the rest of the gateway.
+package downstream
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/resilience"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	HeaderIdempotencyKey = "Idempotency-Key"
+
+	maxResponseBody = 8 << 20
+	tracerName      = "github.com/archlens/api-gateway/internal/downstream"
+)
+
+// Config describes a downstream service and how hard to try calling it
+type Config struct {
+	// Name identifies the service in logs, metrics and traces
+	Name    string
+	BaseURL string
+	// Timeout bounds a single attempt; the caller's context bounds the call
+	// as a whole, retries included
+	Timeout time.Duration
+	// MaxConcurrent caps calls in flight; callers wait up to MaxWait for a slot
+	MaxConcurrent int
+	MaxWait       time.Duration
+	Retry         resilience.RetryConfig
+	Breaker       resilience.CircuitBreakerConfig
+}
+
+// DefaultConfig returns the settings used for a service unless overridden
+func DefaultConfig(name, baseURL string) Config {
+	retry := resilience.DefaultRetryConfig()
+	retry.MaxAttempts = 3
+	retry.MaxDelay = 5 * time.Second
+	return Config{
+		Name:          name,
+		BaseURL:       baseURL,
+		Timeout:       30 * time.Second,
+		MaxConcurrent: 16,
+		MaxWait:       time.Second,
+		Retry:         retry,
+		Breaker: resilience.CircuitBreakerConfig{
+			Name:                  name,
+			Window:                resilience.WindowCount,
+			WindowSize:            20,
+			MinimumCalls:          10,
+			FailureRateThreshold:  0.5,
+			SlowCallDuration:      10 * time.Second,
+			SlowCallRateThreshold: 0.8,
+		},
+	}
+}
+
+// Client calls one downstream service
+type Client struct {
+	cfg      Config
+	http     *http.Client
+	breaker  *resilience.CircuitBreaker
+	bulkhead *resilience.Bulkhead
+	logger   *zap.SugaredLogger
+}
+
+// New creates the client of a service
+func New(cfg Config, logger *zap.SugaredLogger) *Client {
+	cfg.BaseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	if cfg.Retry.MaxAttempts <= 0 {
+		cfg.Retry.MaxAttempts = 1
+	}
+	cfg.Breaker.Name = cfg.Name
+	if cfg.Breaker.IsFailure == nil {
+		cfg.Breaker.IsFailure = isFailure
+	}
+	onStateChange := cfg.Breaker.OnStateChange
+	cfg.Breaker.OnStateChange = func(name string, from, to resilience.State) {
+		breakerState.WithLabelValues(name).Set(float64(to))
+		if onStateChange != nil {
+			onStateChange(name, from, to)
+		}
+	}
+	breakerState.WithLabelValues(cfg.Name).Set(float64(resilience.StateClosed))
+
+	return &Client{
+		cfg: cfg,
+		// Per-attempt contexts bound requests; the transport only bounds
+		// connection setup
+		http: &http.Client{Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			MaxIdleConnsPerHost: cfg.MaxConcurrent,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		}},
+		breaker:  resilience.NewCircuitBreaker(cfg.Breaker, logger),
+		bulkhead: resilience.NewBulkhead(cfg.Name, cfg.MaxConcurrent, cfg.MaxWait),
+		logger:   logger,
+	}
+}
+
+// Name returns the service name
+func (c *Client) Name() string {
+	return c.cfg.Name
+}
+
+// Request is a call to a service. Body is sent as JSON unless it is []byte.
+type Request struct {
+	Method string
+	Path   string
+	Header http.Header
+	Body   interface{}
+	// IdempotencyKey makes a non-idempotent request safe to retry; the
+	// service must honour the Idempotency-Key header
+	IdempotencyKey string
+}
+
+// Response is a fully read response of a service
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Do sends a request, retrying it while Retryable allows and the caller's
+// context has time left. Responses other than 2xx are returned as a
+// *StatusError alongside the Response.
+func (c *Client) Do(ctx context.Context, req *Request) (*Response, error) {
+	var body []byte
+	switch b := req.Body.(type) {
+	case nil:
+	case []byte:
+		body = b
+	default:
+		raw, err := json.Marshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("encode %s request: %w", c.cfg.Name, err)
+		}
+		body = raw
+	}
+
+	retry := c.cfg.Retry
+	retry.Retryable = func(err error) bool { return Retryable(req, err) }
+
+	var (
+		resp    *Response
+		attempt int
+	)
+	err := resilience.RetryWithBackoff(ctx, retry, c.logger, c.cfg.Name+" "+req.Method+" "+req.Path, func() error {
+		attempt++
+		r, err := c.attempt(ctx, req, body, attempt)
+		resp = r
+		return err
+	})
+	return resp, err
+}
+
+// JSON sends a request and decodes a 2xx response body into out
+func (c *Client) JSON(ctx context.Context, req *Request, out interface{}) error {
+	resp, err := c.Do(ctx, req)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(resp.Body) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Body, out); err != nil {
+		return fmt.Errorf("decode %s response: %w", c.cfg.Name, err)
+	}
+	return nil
+}
+
+// attempt makes one try at a request. The bulkhead is held only while the
+// request is in flight, not while waiting to retry.
+func (c *Client) attempt(ctx context.Context, req *Request, body []byte, attempt int) (*Response, error) {
+	release, err := c.bulkhead.Acquire(ctx)
+	if err != nil {
+		requestsTotal.WithLabelValues(c.cfg.Name, req.Method, outcomeRejected).Inc()
+		if err == resilience.ErrBulkheadFull {
+			return nil, &CallError{Service: c.cfg.Name, Err: err}
+		}
+		return nil, err
+	}
+	defer release()
+
+	start := time.Now()
+	out, err := c.breaker.Execute(ctx, func(ctx context.Context) (interface{}, error) {
+		resp, err := c.send(ctx, req, body, attempt)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode >= 300 {
+			return resp, &StatusError{Service: c.cfg.Name, StatusCode: resp.StatusCode, Body: resp.Body}
+		}
+		return resp, nil
+	})
+	requestDuration.WithLabelValues(c.cfg.Name, req.Method).Observe(time.Since(start).Seconds())
+
+	resp, _ := out.(*Response)
+	if err == resilience.ErrCircuitOpen {
+		requestsTotal.WithLabelValues(c.cfg.Name, req.Method, outcomeRejected).Inc()
+		return nil, &CallError{Service: c.cfg.Name, Err: err}
+	}
+	requestsTotal.WithLabelValues(c.cfg.Name, req.Method, outcome(resp, err)).Inc()
+	return resp, err
+}
+
+// send performs the HTTP exchange under a client span, bounded by the
+// attempt timeout and by the caller's deadline, whichever comes first
+func (c *Client) send(ctx context.Context, req *Request, body []byte, attempt int) (*Response, error) {
+	url := c.cfg.BaseURL + req.Path
+	ctx, span := otel.Tracer(tracerName).Start(ctx, req.Method+" "+c.cfg.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.PeerService(c.cfg.Name),
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.URLFull(url),
+		),
+	)
+	defer span.End()
+	if attempt > 1 {
+		span.SetAttributes(semconv.HTTPRequestResendCount(attempt - 1))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.Timeout)
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, req.Method, url, bytes.NewReader(body))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	for k, v := range req.Header {
+		httpReq.Header[k] = v
+	}
+	if body != nil && httpReq.Header.Get("Content-Type") == "" {
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	httpReq.Header.Set("Accept", "application/json")
+	if req.IdempotencyKey != "" {
+		httpReq.Header.Set(HeaderIdempotencyKey, req.IdempotencyKey)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(httpReq.Header))
+
+	httpResp, err := c.http.Do(httpReq)
+	if err != nil {
+		err = &CallError{Service: c.cfg.Name, Err: err, Timeout: isTimeout(ctx, err)}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(httpResp.Body, maxResponseBody))
+	span.SetAttributes(semconv.HTTPResponseStatusCode(httpResp.StatusCode))
+	if err != nil {
+		err = &CallError{Service: c.cfg.Name, Err: fmt.Errorf("read response: %w", err), Timeout: isTimeout(ctx, err)}
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	if httpResp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, http.StatusText(httpResp.StatusCode))
+	}
+	span.SetAttributes(attribute.Int("http.response.body.size", len(respBody)))
+
+	return &Response{StatusCode: httpResp.StatusCode, Header: httpResp.Header, Body: respBody}, nil
+}
+
+// Stats returns the state of the service's breaker and bulkhead
+func (c *Client) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"service":  c.cfg.Name,
+		"breaker":  c.breaker.Stats(),
+		"bulkhead": c.bulkhead.Stats(),
+	}
+}
@@ -0,0 +1,215 @@
+package downstream
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/archlens/api-gateway/internal/resilience"
+)
+
+// testService answers every request with status after delay, counting the
+// requests and recording the idempotency key of the last one
+type testService struct {
+	status   atomic.Int32
+	delay    atomic.Int64
+	requests atomic.Int32
+	key      atomic.Value
+}
+
+func (s *testService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.requests.Add(1)
+	s.key.Store(r.Header.Get(HeaderIdempotencyKey))
+	select {
+	case <-time.After(time.Duration(s.delay.Load())):
+	case <-r.Context().Done():
+		return
+	}
+	w.WriteHeader(int(s.status.Load()))
+	w.Write([]byte(`{}`))
+}
+
+func newTestClient(t *testing.T, status int, configure func(*Config)) (*testService, *Client) {
+	t.Helper()
+	svc := &testService{}
+	svc.status.Store(int32(status))
+	srv := httptest.NewServer(svc)
+	t.Cleanup(srv.Close)
+
+	cfg := DefaultConfig(t.Name(), srv.URL)
+	cfg.Retry.InitialDelay = time.Millisecond
+	cfg.Retry.MaxDelay = time.Millisecond
+	if configure != nil {
+		configure(&cfg)
+	}
+	return svc, New(cfg, zap.NewNop().Sugar())
+}
+
+func TestRetryable(t *testing.T) {
+	get := &Request{Method: http.MethodGet}
+	post := &Request{Method: http.MethodPost}
+	keyed := &Request{Method: http.MethodPost, IdempotencyKey: "k"}
+	tests := []struct {
+		name string
+		req  *Request
+		err  error
+		want bool
+	}{
+		{"5xx", get, &StatusError{StatusCode: 503}, true},
+		{"501", get, &StatusError{StatusCode: 501}, false},
+		{"4xx", get, &StatusError{StatusCode: 409}, false},
+		{"429", get, &StatusError{StatusCode: 429}, false},
+		{"timeout", get, &CallError{Err: context.DeadlineExceeded, Timeout: true}, true},
+		{"connection refused", get, &CallError{Err: errors.New("connection refused")}, false},
+		{"open breaker", get, &CallError{Err: resilience.ErrCircuitOpen}, false},
+		{"full bulkhead", get, &CallError{Err: resilience.ErrBulkheadFull}, false},
+		{"caller gave up", get, context.Canceled, false},
+		{"POST", post, &StatusError{StatusCode: 503}, false},
+		{"POST with an idempotency key", keyed, &StatusError{StatusCode: 503}, true},
+		{"PUT", &Request{Method: http.MethodPut}, &StatusError{StatusCode: 502}, true},
+		{"DELETE", &Request{Method: http.MethodDelete}, &CallError{Timeout: true}, true},
+		{"PATCH", &Request{Method: http.MethodPatch}, &StatusError{StatusCode: 500}, false},
+	}
+	for _, tt := range tests {
+		if got := Retryable(tt.req, tt.err); got != tt.want {
+			t.Errorf("%s: Retryable = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDoRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		key      string
+		status   int
+		attempts int32
+	}{
+		{"GET on 503", http.MethodGet, "", 503, 3},
+		{"PUT on 500", http.MethodPut, "", 500, 3},
+		{"GET on 501", http.MethodGet, "", 501, 1},
+		{"GET on 404", http.MethodGet, "", 404, 1},
+		{"GET on 200", http.MethodGet, "", 200, 1},
+		{"POST on 503", http.MethodPost, "", 503, 1},
+		{"POST with a key on 503", http.MethodPost, "run-1", 503, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, c := newTestClient(t, tt.status, nil)
+			resp, err := c.Do(context.Background(), &Request{Method: tt.method, Path: "/x", Body: map[string]string{}, IdempotencyKey: tt.key})
+			if got := svc.requests.Load(); got != tt.attempts {
+				t.Errorf("%d attempts, want %d", got, tt.attempts)
+			}
+			if key, _ := svc.key.Load().(string); key != tt.key {
+				t.Errorf("Idempotency-Key = %q, want %q", key, tt.key)
+			}
+			if resp == nil || resp.StatusCode != tt.status {
+				t.Fatalf("response %+v", resp)
+			}
+			var statusErr *StatusError
+			if isErr := errors.As(err, &statusErr); isErr != (tt.status >= 300) {
+				t.Fatalf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestDoRetriesTimeouts(t *testing.T) {
+	svc, c := newTestClient(t, 200, func(cfg *Config) { cfg.Timeout = 20 * time.Millisecond })
+	svc.delay.Store(int64(time.Second))
+
+	_, err := c.Do(context.Background(), &Request{Method: http.MethodPost, Path: "/x"})
+	var callErr *CallError
+	if !errors.As(err, &callErr) || !callErr.Timeout {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+	if got := svc.requests.Load(); got != 1 {
+		t.Errorf("POST timing out made %d attempts, want 1", got)
+	}
+
+	svc.requests.Store(0)
+	_, err = c.Do(context.Background(), &Request{Method: http.MethodGet, Path: "/x"})
+	if !errors.As(err, &callErr) || !callErr.Timeout {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+	if got := svc.requests.Load(); got != 3 {
+		t.Errorf("GET timing out made %d attempts, want 3", got)
+	}
+}
+
+func TestClientErrorsDoNotTripTheBreaker(t *testing.T) {
+	svc, c := newTestClient(t, 404, func(cfg *Config) {
+		cfg.Retry.MaxAttempts = 1
+		cfg.Breaker = resilience.CircuitBreakerConfig{MaxFailures: 2, ResetTimeout: time.Minute}
+	})
+	breakerState := func() string {
+		return c.Stats()["breaker"].(map[string]interface{})["state"].(string)
+	}
+
+	for i := 0; i < 5; i++ {
+		c.Do(context.Background(), &Request{Method: http.MethodGet, Path: "/x"})
+	}
+	if state := breakerState(); state != "closed" {
+		t.Fatalf("breaker %s after 4xx responses", state)
+	}
+
+	svc.status.Store(500)
+	for i := 0; i < 2; i++ {
+		c.Do(context.Background(), &Request{Method: http.MethodGet, Path: "/x"})
+	}
+	if state := breakerState(); state != "open" {
+		t.Fatalf("breaker %s after 5xx responses", state)
+	}
+	before := svc.requests.Load()
+	_, err := c.Do(context.Background(), &Request{Method: http.MethodGet, Path: "/x"})
+	if !errors.Is(err, resilience.ErrCircuitOpen) || !IsUnavailable(err) {
+		t.Fatalf("err = %v, want the open breaker", err)
+	}
+	if svc.requests.Load() != before {
+		t.Error("the open breaker let a request through")
+	}
+}
+
+func TestBulkheadRejectsWhenFull(t *testing.T) {
+	svc, c := newTestClient(t, 200, func(cfg *Config) {
+		cfg.MaxConcurrent = 1
+		cfg.MaxWait = 10 * time.Millisecond
+	})
+	svc.delay.Store(int64(200 * time.Millisecond))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Do(context.Background(), &Request{Method: http.MethodGet, Path: "/slow"})
+		done <- err
+	}()
+	deadline := time.Now().Add(time.Second)
+	for svc.requests.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("first request never arrived")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_, err := c.Do(context.Background(), &Request{Method: http.MethodGet, Path: "/x"})
+	var callErr *CallError
+	if !errors.Is(err, resilience.ErrBulkheadFull) || !errors.As(err, &callErr) || !IsUnavailable(err) {
+		t.Fatalf("err = %v, want the bulkhead full", err)
+	}
+	if got := svc.requests.Load(); got != 1 {
+		t.Errorf("%d requests reached the service, want 1", got)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	svc.delay.Store(0)
+	if _, err := c.Do(context.Background(), &Request{Method: http.MethodGet, Path: "/x"}); err != nil {
+		t.Fatalf("after the slot was released: %v", err)
+	}
+}
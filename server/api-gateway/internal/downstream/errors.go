@@ -0,0 +1,100 @@
+package downstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/archlens/api-gateway/internal/resilience"
+)
+
+// StatusError reports a response other than 2xx
+type StatusError struct {
+	Service    string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s responded %d %s", e.Service, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// CallError reports a request that got no response: the connection failed,
+// the attempt timed out, or the breaker or bulkhead turned it away
+type CallError struct {
+	Service string
+	Err     error
+	// Timeout is set when the attempt ran out of time
+	Timeout bool
+}
+
+func (e *CallError) Error() string {
+	if e.Timeout {
+		return fmt.Sprintf("%s timed out: %v", e.Service, e.Err)
+	}
+	return fmt.Sprintf("%s unavailable: %v", e.Service, e.Err)
+}
+
+func (e *CallError) Unwrap() error { return e.Err }
+
+// idempotentMethods may be repeated without changing the outcome
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+}
+
+// Retryable decides whether a failed attempt of req is worth repeating. Only
+// idempotent requests are repeated, and only after a 5xx response or a
+// timeout; a request rejected by the open breaker or a full bulkhead, or one
+// whose caller gave up, is not.
+func Retryable(req *Request, err error) bool {
+	if !idempotentMethods[req.Method] && req.IdempotencyKey == "" {
+		return false
+	}
+	if errors.Is(err, resilience.ErrCircuitOpen) || errors.Is(err, resilience.ErrBulkheadFull) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500 && statusErr.StatusCode != http.StatusNotImplemented
+	}
+	var callErr *CallError
+	return errors.As(err, &callErr) && callErr.Timeout
+}
+
+// isTimeout reports whether an attempt failed for running out of time
+func isTimeout(ctx context.Context, err error) bool {
+	if ctx.Err() == context.DeadlineExceeded {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// isFailure decides which errors count against a service's breaker. A 4xx
+// response is the caller's fault, and a call the caller cancelled says
+// nothing about the service.
+func isFailure(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	return resilience.DefaultIsFailure(err)
+}
+
+// IsUnavailable reports whether err means the service could not be reached
+// or is failing, as opposed to rejecting the request
+func IsUnavailable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	var callErr *CallError
+	return errors.As(err, &callErr)
+}
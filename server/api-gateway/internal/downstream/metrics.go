@@ -0,0 +1,51 @@
+package downstream
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	outcomeRejected = "rejected"
+	outcomeError    = "error"
+	outcomeTimeout  = "timeout"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "archlens_downstream_requests_total",
+			Help: "Attempts at calling downstream services by outcome: a status code, error, timeout or rejected",
+		},
+		[]string{"service", "method", "outcome"},
+	)
+
+	requestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "archlens_downstream_request_duration_seconds",
+			Help:    "Duration of attempts at calling downstream services in seconds",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"service", "method"},
+	)
+
+	breakerState = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "archlens_downstream_circuit_state",
+			Help: "Circuit breaker state per downstream service: 0 closed, 1 open, 2 half-open",
+		},
+		[]string{"service"},
+	)
+)
+
+func outcome(resp *Response, err error) string {
+	if resp != nil {
+		return strconv.Itoa(resp.StatusCode)
+	}
+	if callErr, ok := err.(*CallError); ok && callErr.Timeout {
+		return outcomeTimeout
+	}
+	return outcomeError
+}
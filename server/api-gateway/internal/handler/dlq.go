@@ -0,0 +1,190 @@
+package handler
+
+import (
+	"errors"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/archlens/api-gateway/internal/resilience"
+	"github.com/gofiber/fiber/v2"
+)
+
+// deadLetterSpec pages dead-lettered messages in the only order the stores
+// keep them in
+var deadLetterSpec = listing.Spec{
+	Sorts:       map[string]listing.Sort{"last_failed": {Column: "last_failed_at", Type: listing.Time}},
+	DefaultSort: "-last_failed",
+	MaxLimit:    1000,
+}
+
+// deadLetterFilter reads the ?topic= and ?status= filters of the caller's
+// organization
+func deadLetterFilter(c *fiber.Ctx) (resilience.DeadLetterFilter, error) {
+	f := resilience.DeadLetterFilter{
+		OrgID:  callerOrgID(c),
+		Topic:  c.Query("topic"),
+		Status: resilience.DeadLetterStatus(c.Query("status")),
+	}
+	switch f.Status {
+	case "", resilience.DeadLetterPending, resilience.DeadLetterParked:
+		return f, nil
+	default:
+		return f, &listing.Error{Field: "status", Message: "must be one of pending, parked"}
+	}
+}
+
+// deadLetter loads a message of the caller's organization; messages of
+// other organizations are reported as not found
+func deadLetter(c *fiber.Ctx, dlq *resilience.DeadLetterQueue) (*resilience.DeadLetterMessage, error) {
+	if c.Params("orgId") != callerOrgID(c) {
+		return nil, resilience.ErrDeadLetterNotFound
+	}
+	msg, err := dlq.Get(c.UserContext(), c.Params("messageId"))
+	if err != nil {
+		return nil, err
+	}
+	if msg.OrgID != callerOrgID(c) {
+		return nil, resilience.ErrDeadLetterNotFound
+	}
+	return msg, nil
+}
+
+func deadLetterError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, resilience.ErrDeadLetterNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "dead-letter message not found"})
+	}
+	return err
+}
+
+// ListDeadLetters returns a page of the organization's dead-lettered
+// messages, most recently failed first, optionally filtered by ?topic= and
+// ?status=
+func ListDeadLetters(dlq *resilience.DeadLetterQueue) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Params("orgId") != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		f, err := deadLetterFilter(c)
+		if err != nil {
+			return queryError(c, err)
+		}
+		if sort := c.Query("sort"); sort != "" && sort != deadLetterSpec.DefaultSort {
+			return queryError(c, &listing.Error{Field: "sort", Message: "must be " + deadLetterSpec.DefaultSort})
+		}
+		q, err := listing.Parse(c, deadLetterSpec)
+		if err != nil {
+			return queryError(c, err)
+		}
+		if after, ok := q.After(); ok {
+			lastFailed, err := time.Parse(time.RFC3339Nano, after.Sort)
+			if err != nil {
+				return queryError(c, &listing.Error{Field: "cursor", Message: "is invalid"})
+			}
+			f.After = &resilience.DeadLetterCursor{LastFailed: lastFailed, ID: after.ID}
+		}
+		// One more than the page to tell whether another follows
+		f.Limit = q.Limit + 1
+
+		msgs, err := dlq.List(c.UserContext(), f)
+		if err != nil {
+			return err
+		}
+		keys := make([]listing.Key, len(msgs))
+		for i, msg := range msgs {
+			keys[i] = listing.Key{Sort: msg.LastFailed.Format(time.RFC3339Nano), ID: msg.ID}
+		}
+		return c.JSON(listing.NewPage(q, msgs, keys))
+	}
+}
+
+// GetDeadLetter returns a dead-lettered message with its payload
+func GetDeadLetter(dlq *resilience.DeadLetterQueue) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		msg, err := deadLetter(c, dlq)
+		if err != nil {
+			return deadLetterError(c, err)
+		}
+		return c.JSON(msg)
+	}
+}
+
+// ReplayDeadLetter replays a message right away, parked ones included. A
+// message that fails again stays queued and is returned with the failure.
+func ReplayDeadLetter(dlq *resilience.DeadLetterQueue) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		msg, err := deadLetter(c, dlq)
+		if err != nil {
+			return deadLetterError(c, err)
+		}
+
+		var replayErr *resilience.ReplayError
+		err = dlq.Replay(c.UserContext(), msg)
+		switch {
+		case errors.As(err, &replayErr):
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error":       "replay failed",
+				"message":     replayErr.Err.Error(),
+				"dead_letter": msg,
+			})
+		case err != nil:
+			return err
+		}
+		return c.JSON(fiber.Map{"id": msg.ID, "status": "replayed"})
+	}
+}
+
+// ReplayDeadLetters schedules every message of a topic, parked ones
+// included, for replay by the worker with a fresh set of retries
+func ReplayDeadLetters(dlq *resilience.DeadLetterQueue) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Params("orgId") != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		var in struct {
+			Topic  string                      `json:"topic"`
+			Status resilience.DeadLetterStatus `json:"status"`
+		}
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if in.Topic == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "validation failed", "field": "topic", "message": "is required",
+			})
+		}
+		switch in.Status {
+		case "", resilience.DeadLetterPending, resilience.DeadLetterParked:
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "validation failed", "field": "status", "message": "must be one of pending, parked",
+			})
+		}
+
+		n, err := dlq.Reschedule(c.UserContext(), resilience.DeadLetterFilter{
+			OrgID: callerOrgID(c), Topic: in.Topic, Status: in.Status,
+		})
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"topic": in.Topic, "scheduled": n})
+	}
+}
+
+// PurgeDeadLetters removes the organization's messages matching ?topic=
+// and ?status=, or all of them without filters
+func PurgeDeadLetters(dlq *resilience.DeadLetterQueue) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Params("orgId") != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		f, err := deadLetterFilter(c)
+		if err != nil {
+			return queryError(c, err)
+		}
+		n, err := dlq.Purge(c.UserContext(), f)
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"purged": n})
+	}
+}
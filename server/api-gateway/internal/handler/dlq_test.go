@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/archlens/api-gateway/internal/resilience"
+)
+
+func TestListDeadLettersPages(t *testing.T) {
+	const org = "5d0c9a57-6f1e-4c77-9a53-0b0e0e7c0a11"
+	store := resilience.NewMemoryDeadLetterStore(nil, 0)
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	var want []string
+	for i := 0; i < 7; i++ {
+		// Pairs of messages failed at the same time, so pages split ties
+		failed := base.Add(-time.Duration(i/2) * time.Minute)
+		msg := &resilience.DeadLetterMessage{
+			ID:            fmt.Sprintf("00000000-0000-4000-8000-%012d", i),
+			OrgID:         org,
+			OriginalTopic: "audit",
+			Status:        resilience.DeadLetterPending,
+			LastFailed:    failed,
+		}
+		store.Save(context.Background(), msg)
+		want = append(want, msg.ID)
+	}
+	store.Save(context.Background(), &resilience.DeadLetterMessage{
+		ID: "00000000-0000-4000-8000-999999999999", OrgID: "another-org", LastFailed: base,
+	})
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("org_id", org)
+		return c.Next()
+	})
+	app.Get("/organizations/:orgId/dlq", ListDeadLetters(resilience.NewDeadLetterQueue(store, resilience.DeadLetterConfig{}, zap.NewNop().Sugar())))
+
+	list := func(query string) (int, listing.Page[resilience.DeadLetterMessage]) {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest("GET", "/organizations/"+org+"/dlq?"+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var page listing.Page[resilience.DeadLetterMessage]
+		json.NewDecoder(resp.Body).Decode(&page)
+		return resp.StatusCode, page
+	}
+
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > len(want) {
+			t.Fatal("pagination does not end")
+		}
+		status, page := list("limit=2&cursor=" + url.QueryEscape(cursor))
+		if status != fiber.StatusOK {
+			t.Fatalf("status = %d", status)
+		}
+		for _, msg := range page.Data {
+			got = append(got, msg.ID)
+		}
+		if page.Pagination.HasMore != (page.Pagination.NextCursor != "") {
+			t.Fatalf("pagination = %+v", page.Pagination)
+		}
+		if !page.Pagination.HasMore {
+			break
+		}
+		cursor = page.Pagination.NextCursor
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("listed\n%v\nwant\n%v", got, want)
+	}
+
+	for _, query := range []string{"cursor=bm9wZQ", "sort=last_failed", "limit=1001", "status=gone"} {
+		if status, _ := list(query); status != fiber.StatusBadRequest {
+			t.Errorf("%s: status = %d, want 400", query, status)
+		}
+	}
+}
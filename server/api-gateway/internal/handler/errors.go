@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"errors"
+
+	"github.com/archlens/api-gateway/internal/depgraph"
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/archlens/api-gateway/internal/store"
+	"github.com/gofiber/fiber/v2"
+)
+
+// storeError maps store errors onto HTTP responses; unknown errors are
+// passed through to the GlobalErrorHandler
+func storeError(c *fiber.Ctx, err error, resource string) error {
+	var vErr *store.ValidationError
+	switch {
+	case errors.As(err, &vErr):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "validation failed",
+			"field":   vErr.Field,
+			"message": vErr.Message,
+		})
+	case errors.Is(err, store.ErrNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": resource + " not found"})
+	case errors.Is(err, store.ErrConflict):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": resource + " already exists"})
+	default:
+		return err
+	}
+}
+
+// queryError maps invalid query options onto a 400 response
+func queryError(c *fiber.Ctx, err error) error {
+	var optErr *depgraph.OptionsError
+	var listErr *listing.Error
+	switch {
+	case errors.As(err, &optErr):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid query",
+			"field":   optErr.Field,
+			"message": optErr.Message,
+		})
+	case errors.As(err, &listErr):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid query",
+			"field":   listErr.Field,
+			"message": listErr.Message,
+		})
+	}
+	return err
+}
+
+// callerOrgID returns the org_id claim set by JWTAuth
+func callerOrgID(c *fiber.Ctx) string {
+	orgID, _ := c.Locals("org_id").(string)
+	return orgID
+}
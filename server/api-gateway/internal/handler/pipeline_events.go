@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/pipeline"
+	"github.com/archlens/api-gateway/internal/realtime"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	// sseKeepAlive is how often an idle stream gets a comment line so proxies
+	// keep it open. The run is re-read as well, which covers runs executing on
+	// a replica whose events did not reach this one.
+	sseKeepAlive    = 15 * time.Second
+	sseWriteTimeout = 10 * time.Second
+)
+
+// Event types sent on a pipeline event stream
+const (
+	sseEventStage       = "stage"
+	sseEventRunFinished = "run_finished"
+)
+
+// PipelineEvents streams a pipeline run as Server-Sent Events for clients
+// that cannot use the WebSocket hub. Stage results recorded so far are
+// replayed first, then new ones follow as "stage" events; the stream ends
+// with a "run_finished" event carrying the final run once it completes,
+// fails or is cancelled:
+//
+//	event: stage
+//	data: {"stage":"upload","status":"completed",...}
+//
+//	event: run_finished
+//	data: {"id":"...","status":"completed","stages":[...],...}
+func PipelineEvents(orch *pipeline.Orchestrator, hub *realtime.Hub, logger *zap.SugaredLogger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// The stream writer runs after the handler returns, when the request
+		// buffer the param points into may already be reused
+		id := strings.Clone(c.Params("id"))
+		orgID := callerOrgID(c)
+
+		// Subscribe before reading the run so no stage falls between the
+		// snapshot and the live events
+		client := hub.Register(orgID)
+		if err := client.Subscribe(realtime.TopicPipeline + ":" + id); err != nil {
+			hub.Unregister(client)
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "pipeline run not found"})
+		}
+
+		run, err := orch.GetRun(c.UserContext(), id)
+		if errors.Is(err, pipeline.ErrRunNotFound) {
+			hub.Unregister(client)
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "pipeline run not found"})
+		}
+		if err != nil {
+			hub.Unregister(client)
+			return err
+		}
+
+		c.Set(fiber.HeaderContentType, "text/event-stream")
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		c.Set(fiber.HeaderConnection, "keep-alive")
+		c.Set("X-Accel-Buffering", "no")
+
+		// The server WriteTimeout would cut long streams, so each write
+		// extends the connection deadline instead
+		conn := c.Context().Conn()
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer hub.Unregister(client)
+			s := &runStream{w: w, logger: logger, sent: make(map[pipeline.Stage]pipeline.PipelineStatus)}
+			s.flush = func() error {
+				_ = conn.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+				return w.Flush()
+			}
+
+			if done, err := s.sync(run); done || err != nil {
+				return
+			}
+
+			ticker := time.NewTicker(sseKeepAlive)
+			defer ticker.Stop()
+			dropped := client.Done()
+			for {
+				select {
+				case ev := <-client.Events():
+					if done, err := s.handle(ev); done || err != nil {
+						return
+					}
+				case <-dropped:
+					// Too slow for the hub; keep following the run by polling
+					dropped = nil
+				case <-ticker.C:
+					if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+						return
+					}
+					if err := s.flush(); err != nil {
+						return
+					}
+					ctx, cancel := context.WithTimeout(context.Background(), sseWriteTimeout)
+					latest, err := orch.GetRun(ctx, id)
+					cancel()
+					if err != nil {
+						logger.Warnw("failed to refresh pipeline run for event stream", "pipeline_id", id, "error", err)
+						continue
+					}
+					if done, err := s.sync(latest); done || err != nil {
+						return
+					}
+				}
+			}
+		})
+		return nil
+	}
+}
+
+// runStream writes one pipeline run's events, sending each stage result
+// once per status however many sources report it
+type runStream struct {
+	w      *bufio.Writer
+	flush  func() error
+	logger *zap.SugaredLogger
+	sent   map[pipeline.Stage]pipeline.PipelineStatus
+}
+
+// sync sends the stages of a run snapshot that were not sent yet, followed
+// by the terminal event if the run is finished
+func (s *runStream) sync(run *pipeline.PipelineRun) (bool, error) {
+	for _, stage := range run.Stages {
+		if err := s.stage(stage); err != nil {
+			return false, err
+		}
+	}
+	if !run.Finished() {
+		return false, nil
+	}
+	return true, s.write(sseEventRunFinished, run)
+}
+
+// handle applies an event published for the run on the hub. Undecodable
+// events are skipped; the next refresh of the run covers them.
+func (s *runStream) handle(ev realtime.Event) (bool, error) {
+	switch ev.Type {
+	case "stage_completed":
+		var data struct {
+			Stage pipeline.StageResult `json:"stage"`
+		}
+		if err := json.Unmarshal(ev.Data, &data); err != nil {
+			s.logger.Warnw("failed to decode pipeline event", "topic", ev.Topic, "event", ev.Type, "error", err)
+			return false, nil
+		}
+		return false, s.stage(data.Stage)
+	case "run_finished":
+		var run pipeline.PipelineRun
+		if err := json.Unmarshal(ev.Data, &run); err != nil {
+			s.logger.Warnw("failed to decode pipeline event", "topic", ev.Topic, "event", ev.Type, "error", err)
+			return false, nil
+		}
+		return s.sync(&run)
+	}
+	return false, nil
+}
+
+func (s *runStream) stage(result pipeline.StageResult) error {
+	if s.sent[result.Stage] == result.Status {
+		return nil
+	}
+	s.sent[result.Stage] = result.Status
+	return s.write(sseEventStage, result)
+}
+
+func (s *runStream) write(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+		return err
+	}
+	return s.flush()
+}
@@ -1,79 +1,711 @@
 package handler
 
 import (
-	"github.com/archlens/api-gateway/internal/config"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/archlens/api-gateway/internal/auth"
+	"github.com/archlens/api-gateway/internal/depgraph"
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/archlens/api-gateway/internal/pipeline"
+	"github.com/archlens/api-gateway/internal/rules"
+	"github.com/archlens/api-gateway/internal/security"
+	"github.com/archlens/api-gateway/internal/store"
 	"github.com/gofiber/fiber/v2"
 )
 
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
 // ── Auth ──
 
-func AuthToken(cfg *config.Config) fiber.Handler {
+// AuthToken exchanges credentials for tokens at the OIDC provider. It
+// accepts JSON or form bodies with grant_type:
+//
+//	authorization_code  code, redirect_uri and optional PKCE code_verifier
+//	refresh_token       refresh_token
+//	client_credentials  the service account's own client_id and client_secret
+func AuthToken(provider *auth.Provider) fiber.Handler {
+	type request struct {
+		GrantType    string `json:"grant_type" form:"grant_type"`
+		Code         string `json:"code" form:"code"`
+		RedirectURI  string `json:"redirect_uri" form:"redirect_uri"`
+		CodeVerifier string `json:"code_verifier" form:"code_verifier"`
+		RefreshToken string `json:"refresh_token" form:"refresh_token"`
+		ClientID     string `json:"client_id" form:"client_id"`
+		ClientSecret string `json:"client_secret" form:"client_secret"`
+		Scope        string `json:"scope" form:"scope"`
+	}
+
 	return func(c *fiber.Ctx) error {
-		// TODO: integrate with Keycloak for token exchange
-		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
-			"error": "auth token exchange not yet implemented",
-		})
+		var req request
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_request", "message": "invalid request body"})
+		}
+		missing := func(field string) error {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid_request", "message": field + " is required"})
+		}
+
+		var (
+			tok *auth.TokenResponse
+			err error
+		)
+		switch req.GrantType {
+		case "authorization_code":
+			if req.Code == "" {
+				return missing("code")
+			}
+			if req.RedirectURI == "" {
+				return missing("redirect_uri")
+			}
+			tok, err = provider.ExchangeCode(c.UserContext(), req.Code, req.RedirectURI, req.CodeVerifier)
+		case "refresh_token":
+			if req.RefreshToken == "" {
+				return missing("refresh_token")
+			}
+			tok, err = provider.RefreshToken(c.UserContext(), req.RefreshToken)
+		case "client_credentials":
+			if req.ClientID == "" {
+				return missing("client_id")
+			}
+			if req.ClientSecret == "" {
+				return missing("client_secret")
+			}
+			tok, err = provider.ClientCredentials(c.UserContext(), req.ClientID, req.ClientSecret, req.Scope)
+		default:
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "unsupported_grant_type",
+				"message": "grant_type must be authorization_code, refresh_token or client_credentials",
+			})
+		}
+
+		var tokenErr *auth.TokenError
+		switch {
+		case errors.As(err, &tokenErr):
+			// Provider rejections are the client's fault; anything else means
+			// the provider is unusable
+			status := fiber.StatusBadRequest
+			if tokenErr.StatusCode == fiber.StatusUnauthorized {
+				status = fiber.StatusUnauthorized
+			} else if tokenErr.StatusCode >= 500 {
+				status = fiber.StatusBadGateway
+			}
+			return c.Status(status).JSON(fiber.Map{"error": tokenErr.Code, "message": tokenErr.Description})
+		case err != nil:
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "identity provider unavailable"})
+		}
+
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.JSON(tok)
 	}
 }
 
 // ── Organizations ──
 
-func ListOrganizations() fiber.Handler {
+func ListOrganizations(st *store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgs, err := st.ListOrganizations(c.UserContext(), callerOrgID(c))
+		if err != nil {
+			return storeError(c, err, "organization")
+		}
+		return c.JSON(listing.All(orgs))
+	}
+}
+
+func CreateOrganization(st *store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var in store.CreateOrganizationInput
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		org, err := st.CreateOrganization(c.UserContext(), in)
+		if err != nil {
+			return storeError(c, err, "organization")
+		}
+		return c.Status(fiber.StatusCreated).JSON(org)
+	}
+}
+
+func GetOrganization(st *store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		org, err := st.GetOrganization(c.UserContext(), callerOrgID(c), c.Params("orgId"))
+		if err != nil {
+			return storeError(c, err, "organization")
+		}
+		return c.JSON(org)
+	}
+}
+
+// ── API Keys ──
+
+func ListAPIKeys(st *store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		q, err := listing.Parse(c, store.APIKeyListing)
+		if err != nil {
+			return queryError(c, err)
+		}
+		page, err := st.ListAPIKeys(c.UserContext(), orgID, q)
+		if err != nil {
+			return storeError(c, err, "api key")
+		}
+		return c.JSON(page)
+	}
+}
+
+// CreateAPIKey issues a key. The response is the only time the plaintext
+// key is available.
+func CreateAPIKey(st *store.Store, policy *security.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		var in store.APIKeyInput
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if err := in.Validate(); err != nil {
+			return storeError(c, err, "api key")
+		}
+		if missing, err := scopesNotHeld(c, policy, orgID, in.Scopes); err != nil || len(missing) > 0 {
+			return grantEscalation(c, "scopes", missing, err)
+		}
+		userID, _ := c.Locals("user_id").(string)
+		key, plaintext, err := st.CreateAPIKey(c.UserContext(), orgID, userID, in)
+		if err != nil {
+			return storeError(c, err, "api key")
+		}
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.Status(fiber.StatusCreated).JSON(struct {
+			*store.APIKey
+			Key string `json:"key"`
+		}{key, plaintext})
+	}
+}
+
+func GetAPIKey(st *store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		key, err := st.GetAPIKey(c.UserContext(), orgID, c.Params("keyId"))
+		if err != nil {
+			return storeError(c, err, "api key")
+		}
+		return c.JSON(key)
+	}
+}
+
+func UpdateAPIKey(st *store.Store, policy *security.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		var patch store.APIKeyPatch
+		if err := c.BodyParser(&patch); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if err := patch.Validate(); err != nil {
+			return storeError(c, err, "api key")
+		}
+		if missing, err := scopesNotHeld(c, policy, orgID, patch.Scopes); err != nil || len(missing) > 0 {
+			return grantEscalation(c, "scopes", missing, err)
+		}
+		key, err := st.UpdateAPIKey(c.UserContext(), orgID, c.Params("keyId"), patch)
+		if err != nil {
+			return storeError(c, err, "api key")
+		}
+		return c.JSON(key)
+	}
+}
+
+// scopesNotHeld returns the scopes the caller does not hold itself. A key or
+// custom role may carry at most the permissions of whoever grants them, so
+// holding api_keys:manage or roles:manage does not let a caller escalate
+// through a key or a role.
+func scopesNotHeld(c *fiber.Ctx, policy *security.Policy, orgID string, scopes []string) ([]string, error) {
+	held := map[security.Permission]bool{}
+	if _, isKey := c.Locals("api_key_id").(string); isKey {
+		keyScopes, _ := c.Locals("api_key_scopes").([]string)
+		for _, s := range keyScopes {
+			held[security.Permission(s)] = true
+		}
+	} else {
+		role, _ := c.Locals("role").(string)
+		perms, err := policy.Permissions(c.UserContext(), orgID, role)
+		if err != nil {
+			return nil, err
+		}
+		held = perms
+	}
+	var missing []string
+	for _, s := range scopes {
+		if !held[security.Permission(s)] {
+			missing = append(missing, s)
+		}
+	}
+	return missing, nil
+}
+
+func grantEscalation(c *fiber.Ctx, what string, missing []string, err error) error {
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error":   "cannot grant " + what + " the caller does not hold",
+		"missing": missing,
+	})
+}
+
+// RevokeAPIKey disables a key; revoked keys stay listed for auditing
+func RevokeAPIKey(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"data": []interface{}{}, "total": 0})
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		if _, err := st.RevokeAPIKey(c.UserContext(), orgID, c.Params("keyId")); err != nil {
+			return storeError(c, err, "api key")
+		}
+		return c.SendStatus(fiber.StatusNoContent)
 	}
 }
 
-func CreateOrganization() fiber.Handler {
+// ── Roles ──
+
+// ListRoles returns the built-in roles followed by the organization's
+// custom roles
+func ListRoles(st *store.Store) fiber.Handler {
+	type role struct {
+		ID          string   `json:"id,omitempty"`
+		Name        string   `json:"name"`
+		Description *string  `json:"description,omitempty"`
+		Builtin     bool     `json:"builtin"`
+		Permissions []string `json:"permissions"`
+	}
+
 	return func(c *fiber.Ctx) error {
-		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "organization created"})
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		custom, err := st.ListRoles(c.UserContext(), orgID)
+		if err != nil {
+			return storeError(c, err, "role")
+		}
+		list := make([]role, 0, len(security.BuiltinRoles)+len(custom))
+		for _, name := range []string{"viewer", "developer", "architect", "admin"} {
+			perms := security.BuiltinRoles[name]
+			names := make([]string, len(perms))
+			for i, p := range perms {
+				names[i] = string(p)
+			}
+			list = append(list, role{Name: name, Builtin: true, Permissions: names})
+		}
+		for _, r := range custom {
+			list = append(list, role{ID: r.ID, Name: r.Name, Description: r.Description, Permissions: r.Permissions})
+		}
+		return c.JSON(listing.All(list))
 	}
 }
 
-func GetOrganization() fiber.Handler {
+func CreateRole(st *store.Store, policy *security.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		var in store.RoleInput
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if err := in.Validate(); err != nil {
+			return storeError(c, err, "role")
+		}
+		if missing, err := scopesNotHeld(c, policy, orgID, in.Permissions); err != nil || len(missing) > 0 {
+			return grantEscalation(c, "permissions", missing, err)
+		}
+		role, err := st.CreateRole(c.UserContext(), orgID, in)
+		if err != nil {
+			return storeError(c, err, "role")
+		}
+		policy.Invalidate(orgID)
+		return c.Status(fiber.StatusCreated).JSON(role)
+	}
+}
+
+func UpdateRole(st *store.Store, policy *security.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		var in store.RoleInput
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		if err := in.Validate(); err != nil {
+			return storeError(c, err, "role")
+		}
+		if missing, err := scopesNotHeld(c, policy, orgID, in.Permissions); err != nil || len(missing) > 0 {
+			return grantEscalation(c, "permissions", missing, err)
+		}
+		role, err := st.UpdateRole(c.UserContext(), orgID, c.Params("roleId"), in)
+		if err != nil {
+			return storeError(c, err, "role")
+		}
+		policy.Invalidate(orgID)
+		return c.JSON(role)
+	}
+}
+
+func DeleteRole(st *store.Store, policy *security.Policy) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		if err := st.DeleteRole(c.UserContext(), orgID, c.Params("roleId")); err != nil {
+			return storeError(c, err, "role")
+		}
+		policy.Invalidate(orgID)
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// ── Encryption Keys ──
+
+// ListKEKs returns the organization's key-encryption keys, including retired
+// and revoked ones
+func ListKEKs(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		orgID := c.Params("orgId")
-		return c.JSON(fiber.Map{"id": orgID})
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		keks, err := st.ListKEKs(c.UserContext(), orgID)
+		if err != nil {
+			return storeError(c, err, "encryption key")
+		}
+		return c.JSON(listing.All(keks))
+	}
+}
+
+// CreateKEK registers the organization's first key-encryption key. From then
+// on its data is sealed in envelopes under that key, and existing data is
+// moved into envelopes by the re-encryption job.
+func CreateKEK(st *store.Store, env *security.Envelope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return registerKEK(c, st, env, false)
+	}
+}
+
+// RotateKEK replaces the active key-encryption key. The retired key keeps
+// decrypting until the re-encryption job has moved its data to the new one.
+func RotateKEK(st *store.Store, env *security.Envelope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return registerKEK(c, st, env, true)
+	}
+}
+
+// registerKEK creates a key in the requested KMS, generating it unless the
+// caller brings its own hex-encoded key_material, and makes it active
+func registerKEK(c *fiber.Ctx, st *store.Store, env *security.Envelope, rotate bool) error {
+	var req struct {
+		Provider    string `json:"provider"`
+		KeyMaterial string `json:"key_material"`
+	}
+	orgID := c.Params("orgId")
+	if orgID != callerOrgID(c) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+	}
+	if req.Provider == "" {
+		req.Provider = "local"
+	}
+	kms, ok := env.Provider(req.Provider)
+	if !ok {
+		return storeError(c, &store.ValidationError{Field: "provider", Message: "is not a configured kms provider"}, "encryption key")
+	}
+	var material []byte
+	if req.KeyMaterial != "" {
+		var err error
+		if material, err = hex.DecodeString(req.KeyMaterial); err != nil || len(material) != 32 {
+			return storeError(c, &store.ValidationError{Field: "key_material", Message: "must be 32 bytes, hex-encoded"}, "encryption key")
+		}
+	}
+
+	keyRef, err := kms.CreateKey(c.UserContext(), material)
+	if err != nil {
+		return err
+	}
+	userID, _ := c.Locals("user_id").(string)
+	kek, err := st.CreateKEK(c.UserContext(), orgID, userID, req.Provider, keyRef, rotate)
+	if err != nil {
+		_ = kms.DestroyKey(context.Background(), keyRef)
+		if rotate && errors.Is(err, store.ErrNotFound) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "organization has no active encryption key to rotate"})
+		}
+		if errors.Is(err, store.ErrConflict) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "organization already has an active encryption key; rotate it instead"})
+		}
+		return storeError(c, err, "encryption key")
+	}
+	env.Invalidate(orgID)
+	return c.Status(fiber.StatusCreated).JSON(kek)
+}
+
+// RevokeKEK destroys a key-encryption key's material in the KMS and marks it
+// revoked. Data sealed under it becomes permanently unreadable; revoking the
+// active key shreds all of the organization's data, so it is refused until
+// the re-encryption job has moved all of it into envelopes.
+func RevokeKEK(st *store.Store, env *security.Envelope) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		revoked, err := env.RevokeKEK(c.UserContext(), st, orgID, c.Params("kekId"))
+		var unenveloped *security.UnenvelopedDataError
+		var destroyErr *security.KEKDestroyError
+		switch {
+		case errors.Is(err, security.ErrKEKNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "encryption key not found"})
+		case errors.As(err, &unenveloped):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error":   "organization data is not yet sealed under its encryption key",
+				"message": fmt.Sprintf("revoking would leave %d values recoverable; retry once re-encryption has caught up", unenveloped.Values),
+			})
+		case errors.As(err, &destroyErr):
+			// Keys destroyed before the failure are revoked; a retry finishes the rest
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+				"error":   "failed to destroy key material",
+				"message": err.Error(),
+				"keks":    revoked,
+			})
+		case err != nil:
+			return err
+		}
+		return c.JSON(listing.All(revoked))
 	}
 }
 
 // ── Repositories ──
 
-func ListRepositories() fiber.Handler {
+func ListRepositories(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"data": []interface{}{}, "total": 0})
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		q, err := listing.Parse(c, store.RepositoryListing)
+		if err != nil {
+			return queryError(c, err)
+		}
+		page, err := st.ListRepositories(c.UserContext(), orgID, q)
+		if err != nil {
+			return storeError(c, err, "repository")
+		}
+		return c.JSON(page)
 	}
 }
 
-func CreateRepository() fiber.Handler {
+func CreateRepository(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "repository created"})
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		var in store.CreateRepositoryInput
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		repo, err := st.CreateRepository(c.UserContext(), orgID, in)
+		if err != nil {
+			return storeError(c, err, "repository")
+		}
+		return c.Status(fiber.StatusCreated).JSON(repo)
 	}
 }
 
-func GetRepository() fiber.Handler {
+func GetRepository(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		repoID := c.Params("repoId")
-		return c.JSON(fiber.Map{"id": repoID})
+		repo, err := st.GetRepository(c.UserContext(), callerOrgID(c), c.Params("repoId"))
+		if err != nil {
+			return storeError(c, err, "repository")
+		}
+		return c.JSON(repo)
 	}
 }
 
-func TriggerAnalysis() fiber.Handler {
+func TriggerAnalysis(st *store.Store, orch *pipeline.Orchestrator) fiber.Handler {
+	type request struct {
+		CommitSHA string `json:"commit_sha"`
+		Branch    string `json:"branch"`
+		// CancelSuperseded defaults to true: a new push makes older runs for
+		// the same branch obsolete
+		CancelSuperseded *bool `json:"cancel_superseded"`
+	}
 	return func(c *fiber.Ctx) error {
-		repoID := c.Params("repoId")
-		// TODO: publish Kafka event to trigger analysis pipeline
+		orgID := callerOrgID(c)
+		repo, err := st.GetRepository(c.UserContext(), orgID, c.Params("repoId"))
+		if err != nil {
+			return storeError(c, err, "repository")
+		}
+
+		var req request
+		if len(c.Body()) > 0 {
+			if err := c.BodyParser(&req); err != nil {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+			}
+		}
+		if req.CommitSHA != "" && !commitSHAPattern.MatchString(req.CommitSHA) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "validation failed",
+				"field":   "commit_sha",
+				"message": "must be 7-40 hexadecimal characters",
+			})
+		}
+		if req.Branch == "" {
+			req.Branch = repo.DefaultBranch
+		}
+
+		opts := pipeline.RunOptions{CancelSuperseded: req.CancelSuperseded == nil || *req.CancelSuperseded}
+		for _, stage := range repo.PipelineConfig().DisabledStages {
+			opts.DisabledStages = append(opts.DisabledStages, pipeline.Stage(stage))
+		}
+
+		run, err := orch.StartPipeline(c.UserContext(), repo.ID, orgID, req.CommitSHA, req.Branch, opts)
+		if err != nil {
+			return err
+		}
 		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
-			"message": "analysis triggered",
-			"repo_id": repoID,
+			"pipeline_id": run.ID,
+			"repo_id":     run.RepoID,
+			"status":      run.Status,
 		})
 	}
 }
 
+// ── Pipelines ──
+
+func ListPipelineStages(orch *pipeline.Orchestrator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"stages": orch.Stages()})
+	}
+}
+
+func UpdatePipelineConfig(st *store.Store, orch *pipeline.Orchestrator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var cfg store.RepositoryPipelineConfig
+		if err := c.BodyParser(&cfg); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		stages := make([]pipeline.Stage, 0, len(cfg.DisabledStages))
+		for _, s := range cfg.DisabledStages {
+			stages = append(stages, pipeline.Stage(s))
+		}
+		if err := orch.ValidateDisabledStages(stages); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":   "validation failed",
+				"field":   "disabled_stages",
+				"message": err.Error(),
+			})
+		}
+
+		repo, err := st.UpdateRepositoryPipelineConfig(c.UserContext(), callerOrgID(c), c.Params("repoId"), cfg)
+		if err != nil {
+			return storeError(c, err, "repository")
+		}
+		return c.JSON(repo.PipelineConfig())
+	}
+}
+
+func GetPipelineRun(orch *pipeline.Orchestrator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		run, err := orch.GetRun(c.UserContext(), c.Params("id"))
+		if errors.Is(err, pipeline.ErrRunNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "pipeline run not found"})
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(run)
+	}
+}
+
+func CancelPipelineRun(orch *pipeline.Orchestrator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		run, err := orch.GetRun(c.UserContext(), id)
+		if errors.Is(err, pipeline.ErrRunNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "pipeline run not found"})
+		}
+		if err != nil {
+			return err
+		}
+
+		userID, _ := c.Locals("user_id").(string)
+		run, err = orch.CancelRun(c.UserContext(), id, "cancelled by user "+userID)
+		switch {
+		case errors.Is(err, pipeline.ErrRunFinished):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "pipeline run already finished"})
+		case errors.Is(err, pipeline.ErrRunNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "pipeline run not found"})
+		case err != nil:
+			return err
+		}
+		return c.Status(fiber.StatusAccepted).JSON(run)
+	}
+}
+
+func ListPipelineRuns(st *store.Store, orch *pipeline.Orchestrator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID := callerOrgID(c)
+		repo, err := st.GetRepository(c.UserContext(), orgID, c.Params("repoId"))
+		if err != nil {
+			return storeError(c, err, "repository")
+		}
+		q, err := listing.Parse(c, store.PipelineRunListing)
+		if err != nil {
+			return queryError(c, err)
+		}
+		page, err := orch.ListRuns(c.UserContext(), orgID, repo.ID, q)
+		if err != nil {
+			return err
+		}
+		return c.JSON(page)
+	}
+}
+
 // ── Analysis ──
 
-func ListAnalyses() fiber.Handler {
+func ListAnalyses(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"data": []interface{}{}, "total": 0})
+		q, err := listing.Parse(c, store.AnalysisListing)
+		if err != nil {
+			return queryError(c, err)
+		}
+		page, err := st.ListAnalyses(c.UserContext(), callerOrgID(c), c.Params("repoId"), q)
+		if err != nil {
+			return storeError(c, err, "analysis")
+		}
+		return c.JSON(page)
 	}
 }
 
@@ -84,17 +716,90 @@ func GetAnalysis() fiber.Handler {
 	}
 }
 
-func GetDependencyGraph() fiber.Handler {
+// GetDependencyGraph returns the dependency graph of an analysis. Query
+// parameters:
+//
+//	level        file (default), directory, package or component
+//	depth        truncate directories to this many path segments
+//	path_prefix  only files under this path and the dependencies between them
+//	dep_type     comma-separated dependency types, e.g. import,extends
+//	cycles       include strongly connected components (default true)
+func GetDependencyGraph(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"nodes": []interface{}{}, "edges": []interface{}{}})
+		orgID := callerOrgID(c)
+		analysisID := c.Params("analysisId")
+
+		level, err := depgraph.ParseLevel(c.Query("level"))
+		if err != nil {
+			return queryError(c, err)
+		}
+		depth := 0
+		if raw := c.Query("depth"); raw != "" {
+			depth, err = strconv.Atoi(raw)
+			if err != nil || depth < 0 {
+				return queryError(c, &depgraph.OptionsError{Field: "depth", Message: "must be a non-negative integer"})
+			}
+		}
+		cycles := true
+		if raw := c.Query("cycles"); raw != "" {
+			cycles, err = strconv.ParseBool(raw)
+			if err != nil {
+				return queryError(c, &depgraph.OptionsError{Field: "cycles", Message: "must be true or false"})
+			}
+		}
+		filter := store.GraphFilter{PathPrefix: strings.TrimPrefix(c.Query("path_prefix"), "./")}
+		for _, t := range splitList(c.Query("dep_type")) {
+			if !slices.Contains(rules.DepTypes, t) {
+				return queryError(c, &depgraph.OptionsError{Field: "dep_type", Message: "must only contain " + strings.Join(rules.DepTypes, ", ")})
+			}
+			filter.DepTypes = append(filter.DepTypes, t)
+		}
+
+		repoID, err := st.GetAnalysisRepoID(c.UserContext(), orgID, analysisID)
+		if err != nil {
+			return storeError(c, err, "analysis")
+		}
+		repo, err := st.GetRepository(c.UserContext(), orgID, repoID)
+		if err != nil {
+			return storeError(c, err, "analysis")
+		}
+		files, edges, err := st.LoadAnalysisGraph(c.UserContext(), repoID, analysisID, filter)
+		if err != nil {
+			return storeError(c, err, "analysis")
+		}
+
+		graph, err := depgraph.Build(files, edges, depgraph.Options{
+			Level:      level,
+			Depth:      depth,
+			Components: repo.Components(),
+			Cycles:     cycles,
+		})
+		if err != nil {
+			return queryError(c, err)
+		}
+		return c.JSON(fiber.Map{
+			"analysis_id": analysisID,
+			"repo_id":     repoID,
+			"graph":       graph,
+		})
 	}
 }
 
 // ── Drift ──
 
-func ListDriftEvents() fiber.Handler {
+// ListDriftEvents returns a repository's drift events, filtered by e.g.
+// ?severity=error&status=open
+func ListDriftEvents(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"data": []interface{}{}, "total": 0})
+		q, err := listing.Parse(c, store.DriftEventListing)
+		if err != nil {
+			return queryError(c, err)
+		}
+		page, err := st.ListDriftEvents(c.UserContext(), callerOrgID(c), c.Params("repoId"), q)
+		if err != nil {
+			return storeError(c, err, "drift event")
+		}
+		return c.JSON(page)
 	}
 }
 
@@ -107,27 +812,62 @@ func UpdateDriftEvent() fiber.Handler {
 
 // ── Rules ──
 
-func ListRules() fiber.Handler {
+func ListRules(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"data": []interface{}{}, "total": 0})
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		q, err := listing.Parse(c, store.RuleListing)
+		if err != nil {
+			return queryError(c, err)
+		}
+		page, err := st.ListRules(c.UserContext(), orgID, q)
+		if err != nil {
+			return storeError(c, err, "rule")
+		}
+		return c.JSON(page)
 	}
 }
 
-func CreateRule() fiber.Handler {
+func CreateRule(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"message": "rule created"})
+		orgID := c.Params("orgId")
+		if orgID != callerOrgID(c) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+		var in store.RuleInput
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		userID, _ := c.Locals("user_id").(string)
+		rule, err := st.CreateRule(c.UserContext(), orgID, userID, in)
+		if err != nil {
+			return storeError(c, err, "rule")
+		}
+		return c.Status(fiber.StatusCreated).JSON(rule)
 	}
 }
 
-func UpdateRule() fiber.Handler {
+func UpdateRule(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		ruleID := c.Params("ruleId")
-		return c.JSON(fiber.Map{"id": ruleID, "message": "updated"})
+		var in store.RuleInput
+		if err := c.BodyParser(&in); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+		rule, err := st.UpdateRule(c.UserContext(), callerOrgID(c), c.Params("ruleId"), in)
+		if err != nil {
+			return storeError(c, err, "rule")
+		}
+		return c.JSON(rule)
 	}
 }
 
-func DeleteRule() fiber.Handler {
+func DeleteRule(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if err := st.DeleteRule(c.UserContext(), callerOrgID(c), c.Params("ruleId")); err != nil {
+			return storeError(c, err, "rule")
+		}
 		return c.SendStatus(fiber.StatusNoContent)
 	}
 }
@@ -149,9 +889,17 @@ func GetPhantomExecution() fiber.Handler {
 
 // ── Synthetic Fixes ──
 
-func ListSyntheticFixes() fiber.Handler {
+func ListSyntheticFixes(st *store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"data": []interface{}{}, "total": 0})
+		q, err := listing.Parse(c, store.SyntheticFixListing)
+		if err != nil {
+			return queryError(c, err)
+		}
+		page, err := st.ListSyntheticFixes(c.UserContext(), callerOrgID(c), c.Params("driftId"), q)
+		if err != nil {
+			return storeError(c, err, "fix")
+		}
+		return c.JSON(page)
 	}
 }
 
@@ -169,22 +917,3 @@ func GetArchitectureMetrics() fiber.Handler {
 		return c.JSON(fiber.Map{"metrics": []interface{}{}})
 	}
 }
-
-// ── Audit ──
-
-func ListAuditLog() fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"data": []interface{}{}, "total": 0})
-	}
-}
-
-// ── WebSocket ──
-
-func WebSocketUpgrade() fiber.Handler {
-	return func(c *fiber.Ctx) error {
-		// TODO: implement WebSocket upgrade with gofiber/contrib/websocket
-		return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{
-			"error": "websocket not yet implemented",
-		})
-	}
-}
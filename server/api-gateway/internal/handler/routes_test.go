@@ -0,0 +1,372 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/archlens/api-gateway/internal/auth"
+	"github.com/archlens/api-gateway/internal/security"
+)
+
+// tokenProvider is an OIDC provider whose token endpoint issues a token per
+// grant type and rejects the client secret "wrong"
+type tokenProvider struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	form url.Values
+	user string
+}
+
+func newTokenProvider(t *testing.T) *tokenProvider {
+	t.Helper()
+	p := &tokenProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":         p.URL,
+			"token_endpoint": p.URL + "/token",
+			"jwks_uri":       p.URL + "/certs",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		user, pass, _ := r.BasicAuth()
+		p.mu.Lock()
+		p.form, p.user = r.PostForm, user
+		p.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if pass == "wrong" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"invalid_client","error_description":"Invalid client credentials"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(auth.TokenResponse{
+			AccessToken: "at-" + r.PostForm.Get("grant_type"),
+			TokenType:   "Bearer",
+			ExpiresIn:   300,
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestAuthToken(t *testing.T) {
+	provider := newTokenProvider(t)
+	app := fiber.New()
+	app.Post("/auth/token", AuthToken(auth.NewProvider(provider.URL, "gateway", "gw-secret", nil)))
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		status      int
+		token       string
+		client      string
+		form        url.Values
+	}{
+		{
+			name:        "authorization code",
+			contentType: fiber.MIMEApplicationJSON,
+			body:        `{"grant_type":"authorization_code","code":"c0de","redirect_uri":"https://app.example/cb","code_verifier":"v"}`,
+			status:      fiber.StatusOK,
+			token:       "at-authorization_code",
+			client:      "gateway",
+			form: url.Values{
+				"grant_type":    {"authorization_code"},
+				"code":          {"c0de"},
+				"redirect_uri":  {"https://app.example/cb"},
+				"code_verifier": {"v"},
+			},
+		},
+		{
+			name:        "client credentials",
+			contentType: fiber.MIMEApplicationForm,
+			body:        "grant_type=client_credentials&client_id=ci-bot&client_secret=pw&scope=repos",
+			status:      fiber.StatusOK,
+			token:       "at-client_credentials",
+			client:      "ci-bot",
+			form:        url.Values{"grant_type": {"client_credentials"}, "scope": {"repos"}},
+		},
+		{
+			name:        "client credentials rejected",
+			contentType: fiber.MIMEApplicationJSON,
+			body:        `{"grant_type":"client_credentials","client_id":"ci-bot","client_secret":"wrong"}`,
+			status:      fiber.StatusUnauthorized,
+		},
+		{
+			name:        "authorization code without redirect_uri",
+			contentType: fiber.MIMEApplicationJSON,
+			body:        `{"grant_type":"authorization_code","code":"c0de"}`,
+			status:      fiber.StatusBadRequest,
+		},
+		{
+			name:        "unsupported grant",
+			contentType: fiber.MIMEApplicationJSON,
+			body:        `{"grant_type":"password"}`,
+			status:      fiber.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(fiber.MethodPost, "/auth/token", strings.NewReader(tt.body))
+			req.Header.Set(fiber.HeaderContentType, tt.contentType)
+			resp, err := app.Test(req, 5000)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.token == "" {
+				return
+			}
+			if cc := resp.Header.Get(fiber.HeaderCacheControl); cc != "no-store" {
+				t.Fatalf("Cache-Control = %q, want no-store", cc)
+			}
+			var tok auth.TokenResponse
+			if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+				t.Fatal(err)
+			}
+			if tok.AccessToken != tt.token {
+				t.Fatalf("access token = %q, want %q", tok.AccessToken, tt.token)
+			}
+			provider.mu.Lock()
+			defer provider.mu.Unlock()
+			if provider.user != tt.client {
+				t.Fatalf("provider saw client %q, want %q", provider.user, tt.client)
+			}
+			if provider.form.Encode() != tt.form.Encode() {
+				t.Fatalf("provider saw form %v, want %v", provider.form, tt.form)
+			}
+		})
+	}
+}
+
+type staticRoles map[string][]string
+
+func (r staticRoles) CustomRolePermissions(_ context.Context, _, role string) ([]string, error) {
+	return r[role], nil
+}
+
+// apiKeyApp serves the API key handlers to a caller with the given role.
+// The store is nil: these requests must be rejected before reaching it.
+func apiKeyApp(role string) *fiber.App {
+	policy := security.NewPolicy(staticRoles{
+		"key-admin": {"api_keys:manage", "repos:read", "analysis:read"},
+	})
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("org_id", "org-1")
+		c.Locals("role", role)
+		return c.Next()
+	})
+	app.Post("/organizations/:orgId/api-keys", CreateAPIKey(nil, policy))
+	app.Patch("/organizations/:orgId/api-keys/:keyId", UpdateAPIKey(nil, policy))
+	return app
+}
+
+func TestAPIKeyScopesLimitedToCaller(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    string
+		method  string
+		path    string
+		body    string
+		status  int
+		missing []string
+	}{
+		{
+			name:    "create beyond custom role",
+			role:    "key-admin",
+			method:  fiber.MethodPost,
+			path:    "/organizations/org-1/api-keys",
+			body:    `{"name":"ci","scopes":["repos:read","repos:write","dlq:manage"]}`,
+			status:  fiber.StatusForbidden,
+			missing: []string{"repos:write", "dlq:manage"},
+		},
+		{
+			name:    "create beyond builtin role",
+			role:    "architect",
+			method:  fiber.MethodPost,
+			path:    "/organizations/org-1/api-keys",
+			body:    `{"name":"ci","scopes":[" DLQ:Manage "]}`,
+			status:  fiber.StatusForbidden,
+			missing: []string{"dlq:manage"},
+		},
+		{
+			name:    "update beyond custom role",
+			role:    "key-admin",
+			method:  fiber.MethodPatch,
+			path:    "/organizations/org-1/api-keys/k1",
+			body:    `{"scopes":["analysis:trigger"]}`,
+			status:  fiber.StatusForbidden,
+			missing: []string{"analysis:trigger"},
+		},
+		{
+			name:   "ungrantable scope",
+			role:   "admin",
+			method: fiber.MethodPost,
+			path:   "/organizations/org-1/api-keys",
+			body:   `{"name":"ci","scopes":["roles:manage"]}`,
+			status: fiber.StatusBadRequest,
+		},
+		{
+			name:    "unknown role holds nothing",
+			role:    "ghost",
+			method:  fiber.MethodPatch,
+			path:    "/organizations/org-1/api-keys/k1",
+			body:    `{"scopes":["repos:read"]}`,
+			status:  fiber.StatusForbidden,
+			missing: []string{"repos:read"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := apiKeyApp(tt.role).Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.missing == nil {
+				return
+			}
+			var body struct {
+				Missing []string `json:"missing"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(body.Missing, ",") != strings.Join(tt.missing, ",") {
+				t.Fatalf("missing = %v, want %v", body.Missing, tt.missing)
+			}
+		})
+	}
+}
+
+// roleApp serves the role handlers to a caller with the given role. The
+// store is nil: these requests must be rejected before reaching it.
+func roleApp(role string) *fiber.App {
+	policy := security.NewPolicy(staticRoles{
+		"role-admin": {"roles:manage", "repos:read"},
+	})
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("org_id", "org-1")
+		c.Locals("role", role)
+		return c.Next()
+	})
+	app.Post("/organizations/:orgId/roles", CreateRole(nil, policy))
+	app.Put("/organizations/:orgId/roles/:roleId", UpdateRole(nil, policy))
+	return app
+}
+
+func TestRolePermissionsLimitedToCaller(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    string
+		method  string
+		path    string
+		body    string
+		status  int
+		missing []string
+	}{
+		{
+			name:    "create with permissions beyond the caller",
+			role:    "role-admin",
+			method:  fiber.MethodPost,
+			path:    "/organizations/org-1/roles",
+			body:    `{"name":"escalated","permissions":["repos:read","keks:manage","dlq:manage"]}`,
+			status:  fiber.StatusForbidden,
+			missing: []string{"keks:manage", "dlq:manage"},
+		},
+		{
+			name:    "update with permissions beyond the caller",
+			role:    "role-admin",
+			method:  fiber.MethodPut,
+			path:    "/organizations/org-1/roles/r1",
+			body:    `{"name":"reader","permissions":[" Roles:Manage ","api_keys:manage"]}`,
+			status:  fiber.StatusForbidden,
+			missing: []string{"api_keys:manage"},
+		},
+		{
+			name:    "builtin role beyond its permissions",
+			role:    "architect",
+			method:  fiber.MethodPost,
+			path:    "/organizations/org-1/roles",
+			body:    `{"name":"ops","permissions":["audit:read","dlq:manage"]}`,
+			status:  fiber.StatusForbidden,
+			missing: []string{"dlq:manage"},
+		},
+		{
+			name:   "unknown permission",
+			role:   "admin",
+			method: fiber.MethodPost,
+			path:   "/organizations/org-1/roles",
+			body:   `{"name":"ops","permissions":["everything"]}`,
+			status: fiber.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := roleApp(tt.role).Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.missing == nil {
+				return
+			}
+			var body struct {
+				Missing []string `json:"missing"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(body.Missing, ",") != strings.Join(tt.missing, ",") {
+				t.Fatalf("missing = %v, want %v", body.Missing, tt.missing)
+			}
+		})
+	}
+}
+
+func TestGetDependencyGraphRejectsMalformedOptions(t *testing.T) {
+	// Options are checked before the store is read
+	app := fiber.New()
+	app.Get("/analyses/:analysisId/graph", GetDependencyGraph(nil))
+
+	for _, query := range []string{"depth=abc", "depth=-1", "depth=1.5", "cycles=maybe", "level=module", "dep_type=import,bogus"} {
+		resp, err := app.Test(httptest.NewRequest("GET", "/analyses/a1/graph?"+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body struct {
+			Field string `json:"field"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		field, _, _ := strings.Cut(query, "=")
+		if resp.StatusCode != fiber.StatusBadRequest || body.Field != field {
+			t.Errorf("%s: status %d, field %q", query, resp.StatusCode, body.Field)
+		}
+	}
+}
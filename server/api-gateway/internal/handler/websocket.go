@@ -0,0 +1,203 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/realtime"
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	wsHeartbeatInterval = 25 * time.Second
+	wsReadTimeout       = 2 * wsHeartbeatInterval
+	wsWriteTimeout      = 10 * time.Second
+	// clientReplayWindow bounds how far replayed and live events may overlap
+	clientReplayWindow = 1024
+)
+
+// wsClientMessage is sent by clients to manage subscriptions:
+//
+//	{"action": "subscribe", "topics": ["pipeline:*", "drift:<repoId>"], "last_event_id": "..."}
+//	{"action": "unsubscribe", "topics": ["pipeline:*"]}
+//	{"action": "ping"}
+type wsClientMessage struct {
+	Action      string   `json:"action"`
+	Topics      []string `json:"topics"`
+	LastEventID string   `json:"last_event_id"`
+}
+
+// wsServerMessage is sent to clients. Type is one of event, subscribed,
+// unsubscribed, resync, heartbeat, pong or error.
+type wsServerMessage struct {
+	Type    string          `json:"type"`
+	Event   *realtime.Event `json:"event,omitempty"`
+	Topics  []string        `json:"topics,omitempty"`
+	Message string          `json:"message,omitempty"`
+	Time    time.Time       `json:"ts"`
+}
+
+// WebSocketUpgrade rejects plain HTTP requests to the WebSocket endpoint
+func WebSocketUpgrade() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return c.Status(fiber.StatusUpgradeRequired).JSON(fiber.Map{
+				"error": "websocket upgrade required",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// WebSocketHub serves realtime events to an authenticated client. Clients
+// may pass ?topics=a,b and ?last_event_id=... to subscribe and resume on
+// connect, or manage subscriptions with wsClientMessage.
+func WebSocketHub(hub *realtime.Hub, logger *zap.SugaredLogger) fiber.Handler {
+	return websocket.New(func(conn *websocket.Conn) {
+		orgID, _ := conn.Locals("org_id").(string)
+		client := hub.Register(orgID)
+		defer hub.Unregister(client)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		out := make(chan wsServerMessage, 64)
+		send := func(msg wsServerMessage) {
+			msg.Time = time.Now().UTC()
+			select {
+			case out <- msg:
+			case <-ctx.Done():
+			}
+		}
+
+		subscribe := func(topics []string, lastEventID string) {
+			if err := client.Subscribe(topics...); err != nil {
+				send(wsServerMessage{Type: "error", Message: err.Error()})
+				return
+			}
+			send(wsServerMessage{Type: "subscribed", Topics: client.Topics()})
+			if lastEventID == "" {
+				return
+			}
+			events, complete, err := hub.Replay(ctx, client, lastEventID)
+			if err != nil {
+				logger.Warnw("failed to replay realtime events", "org_id", orgID, "error", err)
+				complete = false
+			}
+			if !complete {
+				send(wsServerMessage{Type: "resync", Message: "some events since last_event_id are no longer available"})
+			}
+			for i := range events {
+				send(wsServerMessage{Type: "event", Event: &events[i]})
+			}
+		}
+
+		// Writer: the only goroutine writing to the connection. Replayed and
+		// live events can overlap, so recently delivered IDs are remembered.
+		go func() {
+			defer cancel()
+			delivered := newRecentIDs(2 * clientReplayWindow)
+			ticker := time.NewTicker(wsHeartbeatInterval)
+			defer ticker.Stop()
+			for {
+				var msg wsServerMessage
+				select {
+				case <-ctx.Done():
+					return
+				case <-client.Done():
+					_ = conn.WriteControl(websocket.CloseMessage,
+						websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "client too slow, reconnect with last_event_id"),
+						time.Now().Add(wsWriteTimeout))
+					return
+				case msg = <-out:
+				case ev := <-client.Events():
+					msg = wsServerMessage{Type: "event", Event: &ev, Time: time.Now().UTC()}
+				case <-ticker.C:
+					if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+						return
+					}
+					msg = wsServerMessage{Type: "heartbeat", Time: time.Now().UTC()}
+				}
+				if msg.Event != nil && !delivered.add(msg.Event.ID) {
+					continue
+				}
+				_ = conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+				if err := conn.WriteJSON(msg); err != nil {
+					return
+				}
+			}
+		}()
+
+		_ = conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+		})
+
+		if topics := splitList(conn.Query("topics")); len(topics) > 0 {
+			subscribe(topics, conn.Query("last_event_id"))
+		}
+
+		// Reader: runs on the connection goroutine until the client goes away
+		for ctx.Err() == nil {
+			var msg wsClientMessage
+			if err := conn.ReadJSON(&msg); err != nil {
+				if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					logger.Debugw("websocket closed", "org_id", orgID, "error", err)
+				}
+				return
+			}
+			_ = conn.SetReadDeadline(time.Now().Add(wsReadTimeout))
+
+			switch msg.Action {
+			case "subscribe":
+				subscribe(msg.Topics, msg.LastEventID)
+			case "unsubscribe":
+				client.Unsubscribe(msg.Topics...)
+				send(wsServerMessage{Type: "unsubscribed", Topics: client.Topics()})
+			case "ping":
+				send(wsServerMessage{Type: "pong"})
+			default:
+				send(wsServerMessage{Type: "error", Message: "unknown action " + msg.Action})
+			}
+		}
+	})
+}
+
+// recentIDs is a bounded set of the most recently added IDs
+type recentIDs struct {
+	ids   map[string]bool
+	order []string
+	next  int
+}
+
+func newRecentIDs(size int) *recentIDs {
+	return &recentIDs{ids: make(map[string]bool, size), order: make([]string, size)}
+}
+
+// add records id and reports whether it was not seen before
+func (r *recentIDs) add(id string) bool {
+	if r.ids[id] {
+		return false
+	}
+	if old := r.order[r.next]; old != "" {
+		delete(r.ids, old)
+	}
+	r.order[r.next] = id
+	r.next = (r.next + 1) % len(r.order)
+	r.ids[id] = true
+	return true
+}
+
+// splitList splits a comma-separated query value, dropping empty items
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
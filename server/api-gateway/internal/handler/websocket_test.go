@@ -0,0 +1,157 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+
+	"github.com/archlens/api-gateway/internal/realtime"
+)
+
+// serveHub serves WebSocketHub at /ws to callers of the given organization
+// and returns the hub and the server's address
+func serveHub(t *testing.T, orgID string) (*realtime.Hub, *realtime.MemoryLog, *fiber.App, string) {
+	t.Helper()
+	log := realtime.NewMemoryLog(0)
+	hub := realtime.NewHub(zap.NewNop().Sugar(), log)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go hub.Run(ctx)
+
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("org_id", orgID)
+		return c.Next()
+	})
+	app.Get("/ws", WebSocketUpgrade(), WebSocketHub(hub, zap.NewNop().Sugar()))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	t.Cleanup(func() { app.Shutdown() })
+	return hub, log, app, ln.Addr().String()
+}
+
+func dialHub(t *testing.T, addr, query string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws://"+addr+"/ws?"+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readMessage returns the next message of a type other than heartbeat
+func readMessage(t *testing.T, conn *websocket.Conn) wsServerMessage {
+	t.Helper()
+	for {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg wsServerMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if msg.Type != "heartbeat" {
+			return msg
+		}
+	}
+}
+
+func expectType(t *testing.T, msg wsServerMessage, typ string) {
+	t.Helper()
+	if msg.Type != typ {
+		t.Fatalf("message %+v, want type %s", msg, typ)
+	}
+}
+
+func TestWebSocketRequiresUpgrade(t *testing.T) {
+	_, _, app, _ := serveHub(t, "org-a")
+	resp, err := app.Test(httptest.NewRequest("GET", "/ws", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusUpgradeRequired {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, fiber.StatusUpgradeRequired)
+	}
+}
+
+func TestWebSocketSubscribesAndDeliversEvents(t *testing.T) {
+	hub, _, _, addr := serveHub(t, "org-a")
+	conn := dialHub(t, addr, "topics=pipeline:*")
+
+	msg := readMessage(t, conn)
+	expectType(t, msg, "subscribed")
+	if len(msg.Topics) != 1 || msg.Topics[0] != "pipeline:*" {
+		t.Fatalf("subscribed to %v", msg.Topics)
+	}
+
+	if err := conn.WriteJSON(wsClientMessage{Action: "subscribe", Topics: []string{"drift:repo-1"}}); err != nil {
+		t.Fatal(err)
+	}
+	expectType(t, readMessage(t, conn), "subscribed")
+
+	ctx := context.Background()
+	hub.Publish(ctx, "org-b", "drift:repo-1", "drift_detected", nil)
+	hub.Publish(ctx, "org-a", "metrics:repo-1", "health_score", nil)
+	hub.Publish(ctx, "org-a", "drift:repo-1", "drift_detected", map[string]string{"repo_id": "repo-1"})
+	msg = readMessage(t, conn)
+	expectType(t, msg, "event")
+	if msg.Event.Topic != "drift:repo-1" || msg.Event.Type != "drift_detected" {
+		t.Fatalf("event %+v", msg.Event)
+	}
+
+	for _, tt := range []struct {
+		action wsClientMessage
+		typ    string
+	}{
+		{wsClientMessage{Action: "subscribe", Topics: []string{"builds:*"}}, "error"},
+		{wsClientMessage{Action: "unsubscribe", Topics: []string{"drift:repo-1"}}, "unsubscribed"},
+		{wsClientMessage{Action: "ping"}, "pong"},
+		{wsClientMessage{Action: "shout"}, "error"},
+	} {
+		if err := conn.WriteJSON(tt.action); err != nil {
+			t.Fatal(err)
+		}
+		expectType(t, readMessage(t, conn), tt.typ)
+	}
+
+	// Unsubscribed topics are no longer delivered
+	hub.Publish(ctx, "org-a", "drift:repo-1", "drift_detected", nil)
+	hub.Publish(ctx, "org-a", "pipeline:run-1", "run_finished", nil)
+	msg = readMessage(t, conn)
+	expectType(t, msg, "event")
+	if msg.Event.Topic != "pipeline:run-1" {
+		t.Fatalf("event %+v", msg.Event)
+	}
+}
+
+func TestWebSocketResumesFromLastEventID(t *testing.T) {
+	_, log, _, addr := serveHub(t, "org-a")
+	ctx := context.Background()
+	seen, _ := log.Append(ctx, realtime.Event{OrgID: "org-a", Topic: "pipeline:run-1", Type: "stage_completed"})
+	missed, _ := log.Append(ctx, realtime.Event{OrgID: "org-a", Topic: "pipeline:run-1", Type: "run_finished"})
+	log.Append(ctx, realtime.Event{OrgID: "org-a", Topic: "drift:repo-1", Type: "drift_detected"})
+
+	conn := dialHub(t, addr, "topics=pipeline:run-1&last_event_id="+seen)
+	expectType(t, readMessage(t, conn), "subscribed")
+	msg := readMessage(t, conn)
+	expectType(t, msg, "event")
+	if msg.Event.ID != missed {
+		t.Fatalf("replayed event %s, want %s", msg.Event.ID, missed)
+	}
+
+	// An ID no longer in the log asks the client to resync
+	if err := conn.WriteJSON(wsClientMessage{Action: "subscribe", Topics: []string{"drift:*"}, LastEventID: "bogus"}); err != nil {
+		t.Fatal(err)
+	}
+	expectType(t, readMessage(t, conn), "subscribed")
+	expectType(t, readMessage(t, conn), "resync")
+}
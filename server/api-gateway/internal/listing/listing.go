@@ -0,0 +1,406 @@
+// Package listing implements the query contract shared by all list
+// endpoints: opaque cursor pagination, a capped page size, whitelisted sort
+// fields and typed filter parameters. Every list response is a Page.
+package listing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// Type is the SQL type of a sortable or filterable column
+type Type string
+
+const (
+	Text Type = "text"
+	UUID Type = "uuid"
+	Time Type = "timestamptz"
+	Bool Type = "boolean"
+	Int  Type = "integer"
+)
+
+// Op compares a column with a filter value
+type Op string
+
+const (
+	// Eq matches any of the comma-separated values of the parameter
+	Eq  Op = "="
+	GTE Op = ">="
+	LT  Op = "<"
+)
+
+// Sort is a column a list can be ordered by. It must not be nullable, since
+// cursors compare rows by their sort value.
+type Sort struct {
+	Column string
+	Type   Type
+}
+
+// Filter is a query parameter narrowing a list
+type Filter struct {
+	Column string
+	Type   Type
+	// Op defaults to Eq
+	Op Op
+	// Values whitelists the accepted values of enumerated columns
+	Values []string
+	// Expr replaces the comparison for filters spanning several columns.
+	// %[1]s is the parameter holding the accepted values as an array.
+	Expr string
+}
+
+// Spec declares how a list may be queried
+type Spec struct {
+	Sorts map[string]Sort
+	// DefaultSort names a sort field, prefixed with - for descending order
+	DefaultSort string
+	Filters     map[string]Filter
+	// ID is the unique column breaking ties between equal sort values;
+	// it defaults to id and must be a UUID
+	ID string
+	// MaxLimit overrides the gateway-wide page size cap
+	MaxLimit int
+}
+
+// Error reports an invalid query parameter
+type Error struct {
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Message)
+}
+
+// Key identifies a row's position in a sorted list
+type Key struct {
+	Sort string
+	ID   string
+}
+
+type cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"i"`
+}
+
+type condition struct {
+	filter Filter
+	value  interface{}
+}
+
+// Query is a parsed list request
+type Query struct {
+	Limit      int
+	sortName   string
+	sort       Sort
+	desc       bool
+	id         string
+	after      *cursor
+	conditions []condition
+}
+
+// Pagination describes a page and how to fetch the next one
+type Pagination struct {
+	Limit      int    `json:"limit"`
+	Sort       string `json:"sort,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// Page is the envelope of every list response
+type Page[T any] struct {
+	Data       []T        `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// Parse reads ?limit=, ?sort=, ?cursor= and the filters of spec from the
+// request. Parameters the spec does not declare are ignored.
+func Parse(c *fiber.Ctx, spec Spec) (Query, error) {
+	q := Query{Limit: DefaultLimit, id: spec.ID}
+	if q.id == "" {
+		q.id = "id"
+	}
+	maxLimit := spec.MaxLimit
+	if maxLimit == 0 {
+		maxLimit = MaxLimit
+	}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > maxLimit {
+			return Query{}, &Error{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", maxLimit)}
+		}
+		q.Limit = n
+	}
+
+	sortName := c.Query("sort", spec.DefaultSort)
+	field := strings.TrimPrefix(sortName, "-")
+	s, ok := spec.Sorts[field]
+	if !ok {
+		names := make([]string, 0, len(spec.Sorts))
+		for name := range spec.Sorts {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return Query{}, &Error{Field: "sort", Message: "must be one of " + strings.Join(names, ", ") + ", optionally prefixed with -"}
+	}
+	q.sortName = strings.Clone(sortName)
+	q.sort = s
+	q.desc = strings.HasPrefix(sortName, "-")
+
+	names := make([]string, 0, len(spec.Filters))
+	for name := range spec.Filters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		raw := c.Query(name)
+		if raw == "" {
+			continue
+		}
+		f := spec.Filters[name]
+		if f.Op == "" {
+			f.Op = Eq
+		}
+		value, err := parseFilter(f, strings.Clone(raw))
+		if err != nil {
+			return Query{}, &Error{Field: name, Message: err.Error()}
+		}
+		q.conditions = append(q.conditions, condition{filter: f, value: value})
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		cur, err := decodeCursor(raw)
+		if err != nil {
+			return Query{}, &Error{Field: "cursor", Message: "is invalid"}
+		}
+		if cur.Sort != q.sortName {
+			return Query{}, &Error{Field: "cursor", Message: "was issued for a different sort"}
+		}
+		if !validKey(q.sort.Type, cur.Key) {
+			return Query{}, &Error{Field: "cursor", Message: "is invalid"}
+		}
+		q.after = cur
+	}
+	return q, nil
+}
+
+// parseFilter converts a parameter to the filter's type. Equality filters
+// accept a comma-separated list and yield a slice; range filters a single value.
+func parseFilter(f Filter, raw string) (interface{}, error) {
+	if f.Op != Eq {
+		return parseValue(f, raw)
+	}
+	switch f.Type {
+	case Bool:
+		return parseValue(f, raw)
+	case Int:
+		var values []int
+		for _, part := range strings.Split(raw, ",") {
+			v, err := parseValue(f, strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v.(int))
+		}
+		return values, nil
+	case Time:
+		var values []time.Time
+		for _, part := range strings.Split(raw, ",") {
+			v, err := parseValue(f, strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v.(time.Time))
+		}
+		return values, nil
+	}
+	var values []string
+	for _, part := range strings.Split(raw, ",") {
+		v, err := parseValue(f, strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v.(string))
+	}
+	return values, nil
+}
+
+func parseValue(f Filter, raw string) (interface{}, error) {
+	if raw == "" {
+		return nil, fmt.Errorf("must not contain empty values")
+	}
+	switch f.Type {
+	case UUID:
+		if _, err := uuid.Parse(raw); err != nil {
+			return nil, fmt.Errorf("must be a UUID")
+		}
+	case Time:
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be an RFC 3339 timestamp")
+		}
+		return t, nil
+	case Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be true or false")
+		}
+		return b, nil
+	case Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("must be an integer")
+		}
+		return n, nil
+	}
+	if len(f.Values) > 0 {
+		for _, v := range f.Values {
+			if v == raw {
+				return raw, nil
+			}
+		}
+		return nil, fmt.Errorf("must be one of %s", strings.Join(f.Values, ", "))
+	}
+	return raw, nil
+}
+
+// keyLayouts are the forms of a timestamptz sort key: PostgreSQL's text
+// output, and RFC 3339 for lists that are not kept in SQL
+var keyLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	time.RFC3339Nano,
+}
+
+// validKey reports whether a cursor's sort key is a value of the sort's
+// type, so a tampered cursor is refused instead of failing the query
+func validKey(t Type, key string) bool {
+	switch t {
+	case Time:
+		for _, layout := range keyLayouts {
+			if _, err := time.Parse(layout, key); err == nil {
+				return true
+			}
+		}
+		return false
+	case UUID, Bool, Int:
+		_, err := parseValue(Filter{Type: t}, key)
+		return err == nil
+	}
+	return true
+}
+
+// SQL completes a query selecting columns from a table expression whose
+// WHERE clause is where, with args bound to $1..$n. It adds the filter and
+// cursor conditions, the order and the limit, plus two trailing columns
+// holding each row's Key. One row more than the limit is fetched to tell
+// whether another page follows.
+func (q Query) SQL(columns, from, where string, args []interface{}) (string, []interface{}) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "SELECT %s, (%s)::text, (%s)::text FROM %s WHERE %s", columns, q.sort.Column, q.id, from, where)
+
+	for _, cond := range q.conditions {
+		args = append(args, cond.value)
+		param := fmt.Sprintf("$%d", len(args))
+		f := cond.filter
+		switch {
+		case f.Op != Eq || f.Type == Bool:
+			fmt.Fprintf(&b, " AND (%s) %s %s::%s", f.Column, f.Op, param, f.Type)
+		case f.Expr != "":
+			b.WriteString(" AND " + fmt.Sprintf(f.Expr, param+"::"+string(f.Type)+"[]"))
+		default:
+			fmt.Fprintf(&b, " AND (%s) = ANY(%s::%s[])", f.Column, param, f.Type)
+		}
+	}
+
+	order := "ASC"
+	cmp := ">"
+	if q.desc {
+		order = "DESC"
+		cmp = "<"
+	}
+	if q.after != nil {
+		args = append(args, q.after.Key, q.after.ID)
+		fmt.Fprintf(&b, " AND (%s, %s) %s ($%d::%s, $%d::uuid)",
+			q.sort.Column, q.id, cmp, len(args)-1, q.sort.Type, len(args))
+	}
+	fmt.Fprintf(&b, " ORDER BY %s %s, %s %s", q.sort.Column, order, q.id, order)
+	if q.Limit > 0 {
+		fmt.Fprintf(&b, " LIMIT %d", q.Limit+1)
+	}
+	return b.String(), args
+}
+
+// After returns the Key of the row the request's cursor points past, for
+// lists that are not kept in SQL
+func (q Query) After() (Key, bool) {
+	if q.after == nil {
+		return Key{}, false
+	}
+	return Key{Sort: q.after.Key, ID: q.after.ID}, true
+}
+
+// Unbounded returns the query without its page size, for exports that
+// stream every matching row
+func (q Query) Unbounded() Query {
+	q.Limit = 0
+	return q
+}
+
+// NewPage builds the page for rows fetched by the query's SQL; keys holds
+// the Key of each item
+func NewPage[T any](q Query, items []T, keys []Key) *Page[T] {
+	p := &Page[T]{Data: items, Pagination: Pagination{Limit: q.Limit, Sort: q.sortName}}
+	if q.Limit > 0 && len(items) > q.Limit {
+		p.Data = items[:q.Limit]
+		last := keys[q.Limit-1]
+		p.Pagination.HasMore = true
+		p.Pagination.NextCursor = encodeCursor(&cursor{Sort: q.sortName, Key: last.Sort, ID: last.ID})
+	}
+	if p.Data == nil {
+		p.Data = []T{}
+	}
+	return p
+}
+
+// All wraps a list that is small and bounded by nature in the page envelope
+func All[T any](items []T) *Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	return &Page[T]{Data: items, Pagination: Pagination{Limit: len(items)}}
+}
+
+func encodeCursor(cur *cursor) string {
+	raw, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	var cur cursor
+	if err := json.Unmarshal(raw, &cur); err != nil {
+		return nil, err
+	}
+	if _, err := uuid.Parse(cur.ID); err != nil {
+		return nil, err
+	}
+	return &cur, nil
+}
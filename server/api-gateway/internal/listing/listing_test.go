@@ -0,0 +1,76 @@
+package listing
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// parse runs Parse on a request with the given query string
+func parse(t *testing.T, spec Spec, query string) (Query, error) {
+	t.Helper()
+	var q Query
+	var parseErr error
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		q, parseErr = Parse(c, spec)
+		return nil
+	})
+	if _, err := app.Test(httptest.NewRequest("GET", "/?"+query, nil)); err != nil {
+		t.Fatal(err)
+	}
+	return q, parseErr
+}
+
+func TestParseValidatesCursorKey(t *testing.T) {
+	spec := Spec{
+		Sorts: map[string]Sort{
+			"created_at": {Column: "created_at", Type: Time},
+			"name":       {Column: "name", Type: Text},
+			"owner":      {Column: "owner_id", Type: UUID},
+			"stars":      {Column: "stars", Type: Int},
+			"archived":   {Column: "archived", Type: Bool},
+		},
+		DefaultSort: "-created_at",
+	}
+	const id = "3f1c7d2a-9b4e-4f6a-8c1d-2e5b7a9c0d13"
+
+	tests := []struct {
+		sort  string
+		key   string
+		valid bool
+	}{
+		{"-created_at", "2026-03-01 12:00:00.123456+00", true},
+		{"-created_at", "2026-03-01 17:30:00+05:30", true},
+		{"-created_at", "2026-03-01T12:00:00.123456789Z", true},
+		{"-created_at", "yesterday", false},
+		{"-created_at", "", false},
+		{"name", "", true},
+		{"name", "'; DROP TABLE repositories; --", true},
+		{"owner", id, true},
+		{"owner", "not-a-uuid", false},
+		{"stars", "42", true},
+		{"stars", "4.2", false},
+		{"archived", "true", true},
+		{"archived", "maybe", false},
+	}
+	for _, tt := range tests {
+		cur := encodeCursor(&cursor{Sort: tt.sort, Key: tt.key, ID: id})
+		q, err := parse(t, spec, "sort="+url.QueryEscape(tt.sort)+"&cursor="+cur)
+		if tt.valid {
+			if err != nil {
+				t.Errorf("%s key %q: %v", tt.sort, tt.key, err)
+			} else if key, ok := q.After(); !ok || key.Sort != tt.key || key.ID != id {
+				t.Errorf("%s key %q: After() = %+v, %v", tt.sort, tt.key, key, ok)
+			}
+			continue
+		}
+		var listErr *Error
+		if !errors.As(err, &listErr) || listErr.Field != "cursor" {
+			t.Errorf("%s key %q: err = %v, want a cursor error", tt.sort, tt.key, err)
+		}
+	}
+}
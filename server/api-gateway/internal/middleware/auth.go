@@ -1,23 +1,31 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"strings"
 
-	"github.com/archlens/api-gateway/internal/config"
+	"github.com/archlens/api-gateway/internal/auth"
+	"github.com/archlens/api-gateway/internal/store"
 	"github.com/gofiber/fiber/v2"
-	"github.com/golang-jwt/jwt/v5"
 )
 
-type Claims struct {
-	jwt.RegisteredClaims
-	OrgID string `json:"org_id"`
-	Role  string `json:"role"`
-	Email string `json:"email"`
+// APIKeyAuthenticator resolves API keys presented as bearer tokens
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (*store.APIKey, error)
 }
 
-func JWTAuth(cfg *config.Config) fiber.Handler {
+// JWTAuth authenticates requests with a bearer access token issued by the
+// OIDC provider, or with an org API key, and exposes the caller as locals.
+// API key callers have no user_id or role; security.Policy checks their
+// scopes instead.
+func JWTAuth(verifier *auth.Verifier, keys APIKeyAuthenticator) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		authHeader := c.Get("Authorization")
+		// Browsers cannot set headers on WebSocket handshakes
+		if authHeader == "" && c.Query("access_token") != "" && strings.EqualFold(c.Get("Upgrade"), "websocket") {
+			authHeader = "Bearer " + c.Query("access_token")
+		}
 		if authHeader == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error":   "unauthorized",
@@ -33,17 +41,25 @@ func JWTAuth(cfg *config.Config) fiber.Handler {
 			})
 		}
 
-		tokenStr := parts[1]
-		claims := &Claims{}
-
-		token, err := jwt.ParseWithClaims(tokenStr, claims, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fiber.NewError(fiber.StatusUnauthorized, "unexpected signing method")
+		if auth.IsAPIKey(parts[1]) {
+			key, err := keys.AuthenticateAPIKey(c.UserContext(), parts[1])
+			if errors.Is(err, store.ErrNotFound) {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error":   "unauthorized",
+					"message": "invalid, expired or revoked api key",
+				})
 			}
-			return []byte(cfg.JWTSecret), nil
-		})
+			if err != nil {
+				return err
+			}
+			c.Locals("org_id", key.OrgID)
+			c.Locals("api_key_id", key.ID)
+			c.Locals("api_key_scopes", key.Scopes)
+			return c.Next()
+		}
 
-		if err != nil || !token.Valid {
+		claims, err := verifier.Verify(c.UserContext(), parts[1])
+		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error":   "unauthorized",
 				"message": "invalid or expired token",
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	HeaderIdempotencyKey     = "Idempotency-Key"
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	idempotencyPrefix = "archlens:idempotency:"
+	// idempotencyLockTTL bounds how long a key stays locked by a request
+	// whose replica died before it could record the response
+	idempotencyLockTTL   = time.Minute
+	idempotencyTimeout   = 100 * time.Millisecond
+	idempotencyMaxKeyLen = 255
+	idempotencyMaxBody   = 1 << 20
+)
+
+// idempotentRecord is the state of an idempotency key in Redis. Until the
+// first request finishes it only holds the fingerprint.
+type idempotentRecord struct {
+	Fingerprint string `json:"fingerprint"`
+	Done        bool   `json:"done"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Location    string `json:"location,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+}
+
+// Idempotency makes POST and PATCH requests carrying an Idempotency-Key
+// header safe to retry. The first request with a key runs normally and its
+// response is kept for ttl; retries with the same method, path and body get
+// that response back with Idempotent-Replayed: true, while reusing the key
+// for a different request is rejected with 422. Keys are scoped to the
+// caller, so it must run after authentication.
+//
+// Responses with status 5xx or 429 are not kept, so those requests can be
+// retried for real. If Redis is unreachable requests run without the
+// guarantee and a warning is logged.
+func Idempotency(rdb *redis.Client, ttl time.Duration, logger *zap.SugaredLogger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		method := c.Method()
+		if method != fiber.MethodPost && method != fiber.MethodPatch {
+			return c.Next()
+		}
+		key := c.Get(HeaderIdempotencyKey)
+		if key == "" {
+			return c.Next()
+		}
+		if len(key) > idempotencyMaxKeyLen {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Idempotency-Key must be at most " + strconv.Itoa(idempotencyMaxKeyLen) + " characters",
+			})
+		}
+
+		redisKey := idempotencyPrefix + idempotencyScope(c) + ":" + key
+		sum := sha256.Sum256([]byte(method + " " + c.Path() + "\n" + string(c.Body())))
+		fingerprint := hex.EncodeToString(sum[:])
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), idempotencyTimeout)
+		existing, err := claimIdempotencyKey(ctx, rdb, redisKey, fingerprint)
+		cancel()
+		if err != nil {
+			logger.Warnw("idempotency store unavailable, running request without it", "error", err)
+			return c.Next()
+		}
+		if existing != nil {
+			if existing.Fingerprint != fingerprint {
+				return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+					"error": "Idempotency-Key was already used for a different request",
+				})
+			}
+			if !existing.Done {
+				c.Set(fiber.HeaderRetryAfter, "1")
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": "a request with this Idempotency-Key is still in progress",
+				})
+			}
+			c.Set(HeaderIdempotentReplayed, "true")
+			if existing.ContentType != "" {
+				c.Set(fiber.HeaderContentType, existing.ContentType)
+			}
+			if existing.Location != "" {
+				c.Set(fiber.HeaderLocation, existing.Location)
+			}
+			return c.Status(existing.Status).Send(existing.Body)
+		}
+
+		err = c.Next()
+
+		// Stored with a fresh context: the request's may be cancelled by now
+		storeCtx, storeCancel := context.WithTimeout(context.Background(), idempotencyTimeout)
+		defer storeCancel()
+		status := c.Response().StatusCode()
+		body := c.Response().Body()
+		if err != nil || status >= 500 || status == fiber.StatusTooManyRequests || len(body) > idempotencyMaxBody {
+			if delErr := rdb.Del(storeCtx, redisKey).Err(); delErr != nil {
+				logger.Warnw("failed to release idempotency key", "error", delErr)
+			}
+			return err
+		}
+		record, _ := json.Marshal(idempotentRecord{
+			Fingerprint: fingerprint,
+			Done:        true,
+			Status:      status,
+			ContentType: string(c.Response().Header.ContentType()),
+			Location:    string(c.Response().Header.Peek(fiber.HeaderLocation)),
+			Body:        body,
+		})
+		if setErr := rdb.Set(storeCtx, redisKey, record, ttl).Err(); setErr != nil {
+			logger.Warnw("failed to record idempotent response", "error", setErr)
+		}
+		return nil
+	}
+}
+
+// claimIdempotencyKey locks an unused key for the current request and
+// returns nil, or returns the record already held by the key
+func claimIdempotencyKey(ctx context.Context, rdb *redis.Client, key, fingerprint string) (*idempotentRecord, error) {
+	pending, _ := json.Marshal(idempotentRecord{Fingerprint: fingerprint})
+	for attempt := 0; attempt < 2; attempt++ {
+		claimed, err := rdb.SetNX(ctx, key, pending, idempotencyLockTTL).Result()
+		if err != nil {
+			return nil, err
+		}
+		if claimed {
+			return nil, nil
+		}
+		raw, err := rdb.Get(ctx, key).Bytes()
+		if errors.Is(err, redis.Nil) {
+			// Released between the two calls; try to claim it again
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var record idempotentRecord
+		if err := json.Unmarshal(raw, &record); err != nil {
+			return nil, err
+		}
+		return &record, nil
+	}
+	return nil, errors.New("idempotency key keeps changing")
+}
+
+// idempotencyScope keeps callers from replaying each other's responses: API
+// keys have their own scope, users theirs within the organization
+func idempotencyScope(c *fiber.Ctx) string {
+	orgID, _ := c.Locals("org_id").(string)
+	if keyID, ok := c.Locals("api_key_id").(string); ok && keyID != "" {
+		return orgID + ":key:" + keyID
+	}
+	userID, _ := c.Locals("user_id").(string)
+	return orgID + ":user:" + userID
+}
@@ -0,0 +1,232 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// idempotentHandler answers POST /things with status and a body numbering
+// the calls it served; while block is set it waits for it to be closed
+type idempotentHandler struct {
+	status atomic.Int32
+	calls  atomic.Int32
+	block  chan struct{}
+}
+
+func newIdempotentApp(t *testing.T, addr string) (*fiber.App, *idempotentHandler) {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: addr, ContextTimeoutEnabled: true})
+	t.Cleanup(func() { rdb.Close() })
+
+	h := &idempotentHandler{}
+	h.status.Store(fiber.StatusCreated)
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("org_id", c.Get("X-Org"))
+		c.Locals("user_id", c.Get("X-User"))
+		if key := c.Get("X-Key"); key != "" {
+			c.Locals("api_key_id", key)
+		}
+		return c.Next()
+	})
+	app.Use(Idempotency(rdb, time.Hour, zap.NewNop().Sugar()))
+	serve := func(c *fiber.Ctx) error {
+		n := h.calls.Add(1)
+		if h.block != nil {
+			<-h.block
+		}
+		c.Location("/things/" + strconv.Itoa(int(n)))
+		return c.Status(int(h.status.Load())).JSON(fiber.Map{"call": n})
+	}
+	app.Post("/things", serve)
+	app.Post("/others", serve)
+	app.Get("/things", serve)
+	return app, h
+}
+
+type idempotentCall struct {
+	method, path, key, body string
+	org, user, apiKey       string
+}
+
+func (r idempotentCall) send(t *testing.T, app *fiber.App) (*http.Response, string) {
+	t.Helper()
+	method, path, org, user := r.method, r.path, r.org, r.user
+	if method == "" {
+		method = "POST"
+	}
+	if path == "" {
+		path = "/things"
+	}
+	if org == "" {
+		org = "org-a"
+	}
+	if user == "" && r.apiKey == "" {
+		user = "user-1"
+	}
+	req := httptest.NewRequest(method, path, strings.NewReader(r.body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Org", org)
+	req.Header.Set("X-User", user)
+	if r.apiKey != "" {
+		req.Header.Set("X-Key", r.apiKey)
+	}
+	if r.key != "" {
+		req.Header.Set(HeaderIdempotencyKey, r.key)
+	}
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return resp, string(body)
+}
+
+func TestIdempotencyReplaysTheResponse(t *testing.T) {
+	app, h := newIdempotentApp(t, miniredis.RunT(t).Addr())
+	call := idempotentCall{key: "k1", body: `{"name":"a"}`}
+
+	first, firstBody := call.send(t, app)
+	if first.StatusCode != fiber.StatusCreated || first.Header.Get(HeaderIdempotentReplayed) != "" {
+		t.Fatalf("first: %d, replayed %q", first.StatusCode, first.Header.Get(HeaderIdempotentReplayed))
+	}
+	replay, replayBody := call.send(t, app)
+	if replay.StatusCode != fiber.StatusCreated || replay.Header.Get(HeaderIdempotentReplayed) != "true" {
+		t.Fatalf("replay: %d, replayed %q", replay.StatusCode, replay.Header.Get(HeaderIdempotentReplayed))
+	}
+	if replayBody != firstBody || replay.Header.Get("Location") != first.Header.Get("Location") ||
+		replay.Header.Get("Content-Type") != first.Header.Get("Content-Type") {
+		t.Errorf("replayed %q at %q, want %q at %q", replayBody, replay.Header.Get("Location"), firstBody, first.Header.Get("Location"))
+	}
+	if n := h.calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+
+	// Requests without a key, and methods other than POST and PATCH, always run
+	idempotentCall{body: `{"name":"a"}`}.send(t, app)
+	idempotentCall{method: "GET", key: "k1"}.send(t, app)
+	if n := h.calls.Load(); n != 3 {
+		t.Errorf("handler ran %d times, want 3", n)
+	}
+}
+
+func TestIdempotencyRejectsAReusedKey(t *testing.T) {
+	app, h := newIdempotentApp(t, miniredis.RunT(t).Addr())
+	idempotentCall{key: "k1", body: `{"name":"a"}`}.send(t, app)
+
+	for _, call := range []idempotentCall{
+		{key: "k1", body: `{"name":"b"}`},
+		{key: "k1", path: "/others", body: `{"name":"a"}`},
+	} {
+		if resp, body := call.send(t, app); resp.StatusCode != fiber.StatusUnprocessableEntity {
+			t.Errorf("%s %s: %d %s, want 422", call.path, call.body, resp.StatusCode, body)
+		}
+	}
+	if n := h.calls.Load(); n != 1 {
+		t.Errorf("handler ran %d times, want 1", n)
+	}
+
+	long := idempotentCall{key: strings.Repeat("k", idempotencyMaxKeyLen+1)}
+	if resp, _ := long.send(t, app); resp.StatusCode != fiber.StatusBadRequest {
+		t.Errorf("overlong key: %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestIdempotencyConflictsWhileInFlight(t *testing.T) {
+	app, h := newIdempotentApp(t, miniredis.RunT(t).Addr())
+	h.block = make(chan struct{})
+	call := idempotentCall{key: "k1", body: `{}`}
+
+	done := make(chan int, 1)
+	go func() {
+		resp, _ := call.send(t, app)
+		done <- resp.StatusCode
+	}()
+	deadline := time.Now().Add(time.Second)
+	for h.calls.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("first request never reached the handler")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	resp, _ := call.send(t, app)
+	if resp.StatusCode != fiber.StatusConflict || resp.Header.Get("Retry-After") != "1" {
+		t.Fatalf("concurrent retry: %d, Retry-After %q; want 409", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+	close(h.block)
+	if status := <-done; status != fiber.StatusCreated {
+		t.Fatalf("first request: %d", status)
+	}
+	if resp, _ := call.send(t, app); resp.Header.Get(HeaderIdempotentReplayed) != "true" {
+		t.Errorf("retry after completion was not replayed")
+	}
+}
+
+func TestIdempotencyDoesNotKeepFailures(t *testing.T) {
+	for _, status := range []int{fiber.StatusServiceUnavailable, fiber.StatusInternalServerError, fiber.StatusTooManyRequests} {
+		app, h := newIdempotentApp(t, miniredis.RunT(t).Addr())
+		h.status.Store(int32(status))
+		call := idempotentCall{key: "k1", body: `{}`}
+
+		call.send(t, app)
+		h.status.Store(fiber.StatusCreated)
+		resp, _ := call.send(t, app)
+		if resp.StatusCode != fiber.StatusCreated || resp.Header.Get(HeaderIdempotentReplayed) != "" {
+			t.Errorf("retry after %d: %d, replayed %q; want it to run", status, resp.StatusCode, resp.Header.Get(HeaderIdempotentReplayed))
+		}
+		if n := h.calls.Load(); n != 2 {
+			t.Errorf("after %d: handler ran %d times, want 2", status, n)
+		}
+	}
+}
+
+func TestIdempotencyKeysAreScopedToTheCaller(t *testing.T) {
+	app, h := newIdempotentApp(t, miniredis.RunT(t).Addr())
+	callers := []idempotentCall{
+		{org: "org-a", user: "user-1"},
+		{org: "org-a", user: "user-2"},
+		{org: "org-b", user: "user-1"},
+		{org: "org-a", apiKey: "key-1"},
+		{org: "org-a", apiKey: "key-2"},
+	}
+	for i, caller := range callers {
+		caller.key, caller.body = "shared", `{}`
+		if resp, _ := caller.send(t, app); resp.Header.Get(HeaderIdempotentReplayed) != "" {
+			t.Errorf("caller %d was replayed another caller's response", i)
+		}
+		if resp, _ := caller.send(t, app); resp.Header.Get(HeaderIdempotentReplayed) != "true" {
+			t.Errorf("caller %d was not replayed its own response", i)
+		}
+	}
+	if n := h.calls.Load(); int(n) != len(callers) {
+		t.Errorf("handler ran %d times, want %d", n, len(callers))
+	}
+}
+
+func TestIdempotencyWithoutRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	app, h := newIdempotentApp(t, mr.Addr())
+	mr.Close()
+
+	call := idempotentCall{key: "k1", body: `{}`}
+	for i := 0; i < 2; i++ {
+		if resp, _ := call.send(t, app); resp.StatusCode != fiber.StatusCreated {
+			t.Fatalf("request %d: %d", i+1, resp.StatusCode)
+		}
+	}
+	if n := h.calls.Load(); n != 2 {
+		t.Errorf("handler ran %d times, want 2", n)
+	}
+}
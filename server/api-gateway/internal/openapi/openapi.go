@@ -0,0 +1,155 @@
+// Package openapi holds the OpenAPI 3 document of the gateway's public API.
+// The document is served to clients and Swagger UI, and requests are
+// validated against it before they reach a handler.
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gofiber/fiber/v2"
+)
+
+//go:embed openapi.yaml
+var document []byte
+
+func init() {
+	openapi3.DefineStringFormat("uuid", `^[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}$`)
+
+	// The stock form decoder reports fields missing from the body as null,
+	// which fails every optional string property
+	form := openapi3filter.RegisteredBodyDecoder(fiber.MIMEApplicationForm)
+	openapi3filter.RegisterBodyDecoder(fiber.MIMEApplicationForm,
+		func(body io.Reader, h http.Header, schema *openapi3.SchemaRef, enc openapi3filter.EncodingFn) (interface{}, error) {
+			value, err := form(body, h, schema, enc)
+			if obj, ok := value.(map[string]interface{}); ok {
+				for name, v := range obj {
+					if v == nil {
+						delete(obj, name)
+					}
+				}
+			}
+			return value, err
+		})
+}
+
+// Spec is the loaded document and the operations it declares
+type Spec struct {
+	doc    *openapi3.T
+	json   []byte
+	server *openapi3.Server
+	prefix string
+	routes []*route
+}
+
+// route is a path template of the document split into segments; segments
+// starting with { are parameters
+type route struct {
+	path     string
+	item     *openapi3.PathItem
+	segments []string
+}
+
+// Load parses and validates the embedded document
+func Load() (*Spec, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(document)
+	if err != nil {
+		return nil, fmt.Errorf("parse openapi document: %w", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid openapi document: %w", err)
+	}
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("encode openapi document: %w", err)
+	}
+
+	if len(doc.Servers) != 1 {
+		return nil, fmt.Errorf("openapi document must declare one server, got %d", len(doc.Servers))
+	}
+	s := &Spec{doc: doc, json: raw, server: doc.Servers[0], prefix: strings.TrimSuffix(doc.Servers[0].URL, "/")}
+	for path, item := range doc.Paths {
+		s.routes = append(s.routes, &route{path: path, item: item, segments: split(path)})
+	}
+	return s, nil
+}
+
+// JSON serves the document as JSON
+func (s *Spec) JSON() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		return c.Type("json").Send(s.json)
+	}
+}
+
+// YAML serves the document as written
+func (s *Spec) YAML() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderCacheControl, "no-cache")
+		return c.Type("yaml").Send(document)
+	}
+}
+
+func split(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// find returns the operation declared for a request and its path parameters.
+// Literal segments take precedence over parameters, the way the router
+// prefers /pipelines/stages over /pipelines/:id.
+func (s *Spec) find(method, path string) (*routers.Route, map[string]string) {
+	if !strings.HasPrefix(path, s.prefix+"/") {
+		return nil, nil
+	}
+	segments := split(strings.TrimPrefix(path, s.prefix))
+
+	var (
+		best    *route
+		params  map[string]string
+		literal = -1
+	)
+	for _, r := range s.routes {
+		if len(r.segments) != len(segments) {
+			continue
+		}
+		matched, n := map[string]string{}, 0
+		for i, seg := range r.segments {
+			if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+				matched[seg[1:len(seg)-1]] = segments[i]
+				continue
+			}
+			if seg != segments[i] {
+				matched = nil
+				break
+			}
+			n++
+		}
+		if matched != nil && n > literal {
+			best, params, literal = r, matched, n
+		}
+	}
+	if best == nil {
+		return nil, nil
+	}
+	op := best.item.GetOperation(method)
+	if op == nil {
+		return nil, nil
+	}
+	return &routers.Route{
+		Spec:      s.doc,
+		Server:    s.server,
+		Path:      best.path,
+		PathItem:  best.item,
+		Method:    method,
+		Operation: op,
+	}, params
+}
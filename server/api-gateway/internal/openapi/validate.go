@@ -0,0 +1,156 @@
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+)
+
+// FieldError is one way in which a request does not match the document
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Validate checks the path parameters, query parameters and body of requests
+// against the operation the document declares for them. A request that does
+// not match is answered with 400 and every failing field. Requests to
+// operations the document does not declare pass through untouched.
+//
+// Credentials are checked by the authentication middleware, not here.
+func (s *Spec) Validate() fiber.Handler {
+	options := &openapi3filter.Options{
+		MultiError:         true,
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+	return func(c *fiber.Ctx) error {
+		rt, params := s.find(c.Method(), c.Path())
+		if rt == nil {
+			return c.Next()
+		}
+		req, err := adaptor.ConvertRequest(c, false)
+		if err != nil {
+			return err
+		}
+
+		err = openapi3filter.ValidateRequest(c.UserContext(), &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: params,
+			Route:      rt,
+			Options:    options,
+		})
+		if err == nil {
+			return c.Next()
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":  "validation failed",
+			"errors": fieldErrors(err),
+		})
+	}
+}
+
+// fieldErrors flattens the errors reported by openapi3filter
+func fieldErrors(err error) []FieldError {
+	switch err := err.(type) {
+	case openapi3.MultiError:
+		var list []FieldError
+		for _, e := range err {
+			list = append(list, fieldErrors(e)...)
+		}
+		return list
+	case *openapi3filter.RequestError:
+		if p := err.Parameter; p != nil {
+			return causeErrors(p.Name, p.Schema, err)
+		}
+		return causeErrors("body", nil, err)
+	}
+	return []FieldError{{Field: "request", Message: err.Error()}}
+}
+
+// causeErrors describes the cause of a RequestError; field names the
+// parameter, or the body whose properties schema errors point into
+func causeErrors(field string, param *openapi3.SchemaRef, reqErr *openapi3filter.RequestError) []FieldError {
+	if me, ok := reqErr.Err.(openapi3.MultiError); ok {
+		var list []FieldError
+		for _, e := range me {
+			list = append(list, causeErrors(field, param, &openapi3filter.RequestError{Err: e, Reason: reqErr.Reason})...)
+		}
+		return list
+	}
+
+	var schemaErr *openapi3.SchemaError
+	var parseErr *openapi3filter.ParseError
+	switch {
+	case errors.As(reqErr.Err, &schemaErr):
+		if param == nil {
+			if path := schemaErr.JSONPointer(); len(path) > 0 {
+				field = strings.Join(path, ".")
+			}
+		}
+		return []FieldError{{Field: field, Message: schemaMessage(schemaErr)}}
+	case errors.Is(reqErr.Err, openapi3filter.ErrInvalidRequired):
+		return []FieldError{{Field: field, Message: "is required"}}
+	case errors.As(reqErr.Err, &parseErr):
+		if param != nil && param.Value != nil && param.Value.Type != "" {
+			return []FieldError{{Field: field, Message: "must be " + article(param.Value.Type)}}
+		}
+		return []FieldError{{Field: field, Message: "is malformed"}}
+	}
+	if reqErr.Reason != "" {
+		return []FieldError{{Field: field, Message: reqErr.Reason}}
+	}
+	return []FieldError{{Field: field, Message: reqErr.Err.Error()}}
+}
+
+// schemaMessage phrases a schema violation the way the store's validation
+// errors are phrased
+func schemaMessage(err *openapi3.SchemaError) string {
+	schema := err.Schema
+	switch err.SchemaField {
+	case "required":
+		return "is required"
+	case "type":
+		return "must be " + article(schema.Type)
+	case "enum":
+		values := make([]string, 0, len(schema.Enum))
+		for _, v := range schema.Enum {
+			values = append(values, fmt.Sprint(v))
+		}
+		return "must be one of " + strings.Join(values, ", ")
+	case "minLength":
+		if schema.MinLength == 1 {
+			return "is required"
+		}
+		return fmt.Sprintf("must be at least %d characters", schema.MinLength)
+	case "maxLength":
+		return fmt.Sprintf("must be at most %d characters", *schema.MaxLength)
+	case "minItems":
+		return fmt.Sprintf("must contain at least %d items", schema.MinItems)
+	case "maxItems":
+		return fmt.Sprintf("must contain at most %d items", *schema.MaxItems)
+	case "minimum":
+		return fmt.Sprintf("must be at least %v", *schema.Min)
+	case "maximum":
+		return fmt.Sprintf("must be at most %v", *schema.Max)
+	case "pattern":
+		return "must match " + schema.Pattern
+	case "format":
+		return "must be a valid " + schema.Format
+	case "nullable":
+		return "must not be null"
+	}
+	return err.Reason
+}
+
+func article(typ string) string {
+	switch typ {
+	case "integer", "object", "array":
+		return "an " + typ
+	}
+	return "a " + typ
+}
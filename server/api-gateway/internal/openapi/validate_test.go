@@ -0,0 +1,125 @@
+package openapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const validateOrg = "5b0c7a52-8a3f-4b8e-9d3c-2f8f1e0a7c01"
+
+// newValidatedApp serves 204 behind Validate on a declared and an
+// undeclared route
+func newValidatedApp(t *testing.T) (*Spec, *fiber.App) {
+	t.Helper()
+	spec, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := fiber.New()
+	v1 := app.Group("/api/v1", spec.Validate())
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusNoContent) }
+	v1.Get("/organizations/:orgId/repos", ok)
+	v1.Post("/organizations/:orgId/repos", ok)
+	v1.Post("/undeclared", ok)
+	return spec, app
+}
+
+func TestValidate(t *testing.T) {
+	spec, app := newValidatedApp(t)
+	repos := "/api/v1/organizations/" + validateOrg + "/repos"
+	valid := `{"name":"core","provider":"github","remote_url":"git@github.com:acme/core.git"}`
+
+	tests := []struct {
+		name         string
+		method, path string
+		body         string
+		want         []FieldError
+	}{
+		{name: "valid body", method: "POST", path: repos, body: valid},
+		{name: "valid query", method: "GET", path: repos + "?limit=20&sort=-name"},
+		{name: "undeclared operation", method: "POST", path: "/api/v1/undeclared", body: `{"anything":1}`},
+		{
+			name: "missing properties", method: "POST", path: repos, body: `{"provider":"github"}`,
+			want: []FieldError{{"name", "is required"}, {"remote_url", "is required"}},
+		},
+		{
+			name: "wrong type and length", method: "POST", path: repos,
+			body: `{"name":"` + strings.Repeat("n", 201) + `","provider":7,"remote_url":"x"}`,
+			want: []FieldError{{"name", "must be at most 200 characters"}, {"provider", "must be a string"}},
+		},
+		{
+			name: "empty body", method: "POST", path: repos,
+			want: []FieldError{{"body", "is required"}},
+		},
+		{
+			name: "malformed path parameter", method: "POST", path: "/api/v1/organizations/acme/repos", body: valid,
+			want: []FieldError{{"orgId", "must be a valid uuid"}},
+		},
+		{
+			name: "query parameters", method: "GET", path: repos + "?limit=0&sort=size",
+			want: []FieldError{{"limit", "must be at least 1"}, {"sort", "must be one of created_at, -created_at, updated_at, -updated_at, name, -name"}},
+		},
+		{
+			name: "unparsable query parameter", method: "GET", path: repos + "?limit=many",
+			want: []FieldError{{"limit", "must be an integer"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if resp.StatusCode != fiber.StatusNoContent {
+					body, _ := io.ReadAll(resp.Body)
+					t.Fatalf("status = %d: %s", resp.StatusCode, body)
+				}
+				return
+			}
+			if resp.StatusCode != fiber.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", resp.StatusCode)
+			}
+
+			// The response has the documented ValidationErrors shape
+			var decoded interface{}
+			if err := json.NewDecoder(resp.Body).Decode(&decoded); err != nil {
+				t.Fatal(err)
+			}
+			if err := spec.doc.Components.Schemas["ValidationErrors"].Value.VisitJSON(decoded); err != nil {
+				t.Fatalf("response does not match ValidationErrors: %v", err)
+			}
+			raw, _ := json.Marshal(decoded)
+			var body struct {
+				Error  string       `json:"error"`
+				Errors []FieldError `json:"errors"`
+			}
+			json.Unmarshal(raw, &body)
+			if body.Error != "validation failed" || !sameFieldErrors(body.Errors, tt.want) {
+				t.Errorf("errors = %+v, want %+v", body.Errors, tt.want)
+			}
+		})
+	}
+}
+
+// sameFieldErrors compares field errors regardless of their order
+func sameFieldErrors(got, want []FieldError) bool {
+	count := func(list []FieldError) map[FieldError]int {
+		m := map[FieldError]int{}
+		for _, e := range list {
+			m[e]++
+		}
+		return m
+	}
+	return reflect.DeepEqual(count(got), count(want))
+}
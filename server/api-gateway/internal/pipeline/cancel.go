@@ -0,0 +1,156 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrRunFinished = errors.New("pipeline run already finished")
+
+// runContext creates the context owned by a run. It keeps the values of
+// parent (trace IDs etc.) but not its cancellation.
+func (o *Orchestrator) runContext(parent context.Context, id string) context.Context {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(parent))
+	o.mu.Lock()
+	o.cancels[id] = cancel
+	o.mu.Unlock()
+	return ctx
+}
+
+func (o *Orchestrator) releaseRunContext(id string) {
+	o.mu.Lock()
+	cancel, ok := o.cancels[id]
+	delete(o.cancels, id)
+	o.mu.Unlock()
+	if ok {
+		cancel()
+	}
+}
+
+// CancelRun stops a run: the current stages are interrupted, remaining stages
+// are skipped and the results recorded so far are kept. Runs executing on
+// another replica are flagged in the store and stopped by their owner on its
+// next heartbeat.
+func (o *Orchestrator) CancelRun(ctx context.Context, id, reason string) (*PipelineRun, error) {
+	if reason == "" {
+		reason = "cancelled by user"
+	}
+
+	o.mu.RLock()
+	run, local := o.runs[id]
+	var snapshot *PipelineRun
+	if local {
+		snapshot = run.clone()
+	}
+	o.mu.RUnlock()
+
+	if local {
+		if isTerminal(snapshot.Status) {
+			return nil, ErrRunFinished
+		}
+		o.cancelLocal(id, reason)
+		snapshot.Metadata["cancel_reason"] = reason
+		return snapshot, nil
+	}
+
+	if o.store == nil {
+		return nil, ErrRunNotFound
+	}
+	stored, err := o.store.GetPipelineRun(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if isTerminal(stored.Status) {
+		return nil, ErrRunFinished
+	}
+	if err := o.store.RequestPipelineCancel(ctx, []string{id}, reason); err != nil {
+		return nil, fmt.Errorf("failed to request cancellation: %w", err)
+	}
+	stored.CancelRequested = true
+	stored.Metadata["cancel_reason"] = reason
+	return stored, nil
+}
+
+// cancelLocal cancels a run executing on this replica
+func (o *Orchestrator) cancelLocal(id, reason string) {
+	o.mu.Lock()
+	if run, ok := o.runs[id]; ok {
+		run.Metadata["cancel_reason"] = reason
+	}
+	cancel, ok := o.cancels[id]
+	o.mu.Unlock()
+
+	if ok {
+		o.logger.Infow("cancelling pipeline", "pipeline_id", id, "reason", reason)
+		cancel()
+	}
+}
+
+// cancelSuperseded cancels unfinished runs for the same repository branch as run
+func (o *Orchestrator) cancelSuperseded(ctx context.Context, run *PipelineRun) {
+	reason := fmt.Sprintf("superseded by pipeline %s", run.ID)
+
+	o.mu.RLock()
+	var ids []string
+	for id, r := range o.runs {
+		if r.RepoID == run.RepoID && r.Branch == run.Branch && !isTerminal(r.Status) {
+			ids = append(ids, id)
+		}
+	}
+	o.mu.RUnlock()
+	for _, id := range ids {
+		o.cancelLocal(id, reason)
+	}
+
+	if o.store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, persistTimeout)
+	defer cancel()
+	remote, err := o.store.ListActivePipelineRunIDs(ctx, run.RepoID, run.Branch)
+	if err == nil {
+		remote = without(remote, append(ids, run.ID))
+		if len(remote) > 0 {
+			err = o.store.RequestPipelineCancel(ctx, remote, reason)
+		}
+	}
+	if err != nil {
+		o.logger.Warnw("failed to cancel superseded pipelines", "pipeline_id", run.ID, "error", err)
+	}
+}
+
+// cancelPipeline records a run as cancelled, keeping its partial results
+func (o *Orchestrator) cancelPipeline(run *PipelineRun) {
+	now := time.Now().UTC()
+	o.mu.Lock()
+	run.Status = StatusCancelled
+	run.CompletedAt = &now
+	run.TotalDuration = now.Sub(run.CreatedAt).Seconds() * 1000
+	if run.Metadata["cancel_reason"] == "" {
+		run.Metadata["cancel_reason"] = "cancelled"
+	}
+	reason := run.Metadata["cancel_reason"]
+	o.mu.Unlock()
+	o.finishRun(run)
+	o.logger.Infow("pipeline cancelled", "pipeline_id", run.ID, "reason", reason, "stages", len(run.Stages))
+}
+
+func isTerminal(status PipelineStatus) bool {
+	return status == StatusCompleted || status == StatusFailed || status == StatusCancelled
+}
+
+func without(ids, exclude []string) []string {
+	skip := make(map[string]bool, len(exclude))
+	for _, id := range exclude {
+		skip[id] = true
+	}
+	out := ids[:0]
+	for _, id := range ids {
+		if !skip[id] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
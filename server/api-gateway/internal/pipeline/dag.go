@@ -0,0 +1,191 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/resilience"
+)
+
+// StageFunc implements the work of a single stage
+type StageFunc func(ctx context.Context, run *PipelineRun) (interface{}, error)
+
+// SkipStage is returned by a stage that found nothing to do because an
+// input is missing. The stage is recorded as skipped rather than completed,
+// without failing the run or being retried.
+type SkipStage struct {
+	Reason string
+}
+
+func (e *SkipStage) Error() string {
+	return "stage skipped: " + e.Reason
+}
+
+// StageSpec declares a stage of the pipeline DAG
+type StageSpec struct {
+	Name      Stage
+	DependsOn []Stage
+	Run       StageFunc
+	// Timeout bounds a single attempt; zero means no per-stage limit
+	Timeout time.Duration
+	// Retry enables RetryWithBackoff for the stage; nil runs it once
+	Retry *resilience.RetryConfig
+	// Critical stages fail the whole run; other stages only record their failure
+	Critical bool
+	// Optional stages may be disabled per repository
+	Optional bool
+}
+
+// StageInfo is the public description of a stage in the DAG
+type StageInfo struct {
+	Name      Stage   `json:"name"`
+	DependsOn []Stage `json:"depends_on"`
+	TimeoutMs int64   `json:"timeout_ms,omitempty"`
+	Retries   int     `json:"max_attempts"`
+	Critical  bool    `json:"critical"`
+	Optional  bool    `json:"optional"`
+}
+
+// defaultStages describes the full codebase analysis pipeline:
+//
+//	Upload → Auth → WASM Parser → AST ─┬→ Gemini AI ──┬→ Audit Trail → Sovereign Ledger ─┬→ Dashboard Update
+//	                                   └→ Rule Engine ─┘                                  ├→ Security Alerts
+//	                                                                                      ├→ Compliance Reports
+//	                                                                                      └→ Strategic Insights
+//
+// Stages calling downstream services retry through their client (see
+// newServices) rather than with a stage-level Retry.
+func (o *Orchestrator) defaultStages() []StageSpec {
+	network := resilience.DefaultRetryConfig()
+	network.MaxAttempts = 3
+
+	return []StageSpec{
+		{Name: StageUpload, Run: o.stageUpload, Timeout: 5 * time.Minute, Retry: &network, Critical: true},
+		{Name: StageAuth, DependsOn: []Stage{StageUpload}, Run: o.stageAuth, Timeout: 30 * time.Second, Critical: true},
+		{Name: StageParse, DependsOn: []Stage{StageAuth}, Run: o.stageParse, Timeout: 10 * time.Minute, Retry: &network, Critical: true},
+		{Name: StageAST, DependsOn: []Stage{StageParse}, Run: o.stageAST, Timeout: 5 * time.Minute, Critical: true},
+		{Name: StageAIAnalysis, DependsOn: []Stage{StageAST}, Run: o.stageAIAnalysis, Timeout: 10 * time.Minute, Optional: true},
+		{Name: StageRuleEngine, DependsOn: []Stage{StageAST}, Run: o.stageRuleEngine, Timeout: 5 * time.Minute, Retry: &network, Critical: true},
+		{Name: StageAuditTrail, DependsOn: []Stage{StageAIAnalysis, StageRuleEngine}, Run: o.stageAuditTrail, Timeout: time.Minute, Critical: true},
+		{Name: StageLedger, DependsOn: []Stage{StageAuditTrail}, Run: o.stageLedger, Timeout: time.Minute, Critical: true},
+		{Name: StageDashboard, DependsOn: []Stage{StageLedger}, Run: o.stageDashboard, Timeout: 30 * time.Second},
+		{Name: StageSecAlerts, DependsOn: []Stage{StageLedger}, Run: o.stageSecurityAlerts, Timeout: 2 * time.Minute, Optional: true},
+		{Name: StageCompliance, DependsOn: []Stage{StageLedger}, Run: o.stageCompliance, Timeout: 2 * time.Minute, Optional: true},
+		{Name: StageInsights, DependsOn: []Stage{StageLedger}, Run: o.stageInsights, Timeout: 2 * time.Minute, Optional: true},
+	}
+}
+
+// validateStages checks that stage names are unique, dependencies exist and
+// the graph is acyclic. It returns the stages in a topological order.
+func validateStages(specs []StageSpec) ([]StageSpec, error) {
+	byName := make(map[Stage]StageSpec, len(specs))
+	for _, s := range specs {
+		if s.Name == "" {
+			return nil, fmt.Errorf("stage name is required")
+		}
+		if s.Run == nil {
+			return nil, fmt.Errorf("stage %s has no implementation", s.Name)
+		}
+		if _, dup := byName[s.Name]; dup {
+			return nil, fmt.Errorf("duplicate stage %s", s.Name)
+		}
+		byName[s.Name] = s
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[Stage]int, len(specs))
+	ordered := make([]StageSpec, 0, len(specs))
+
+	var visit func(name Stage, path []Stage) error
+	visit = func(name Stage, path []Stage) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("stage dependency cycle: %v", append(path, name))
+		}
+		state[name] = visiting
+		for _, dep := range byName[name].DependsOn {
+			if _, ok := byName[dep]; !ok {
+				return fmt.Errorf("stage %s depends on unknown stage %s", name, dep)
+			}
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		ordered = append(ordered, byName[name])
+		return nil
+	}
+
+	for _, s := range specs {
+		if err := visit(s.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Stages describes the configured pipeline DAG in topological order
+func (o *Orchestrator) Stages() []StageInfo {
+	infos := make([]StageInfo, 0, len(o.stages))
+	for _, s := range o.stages {
+		attempts := 1
+		if s.Retry != nil {
+			attempts = s.Retry.MaxAttempts
+		}
+		deps := s.DependsOn
+		if deps == nil {
+			deps = []Stage{}
+		}
+		infos = append(infos, StageInfo{
+			Name:      s.Name,
+			DependsOn: deps,
+			TimeoutMs: s.Timeout.Milliseconds(),
+			Retries:   attempts,
+			Critical:  s.Critical,
+			Optional:  s.Optional,
+		})
+	}
+	return infos
+}
+
+// ValidateDisabledStages checks that every stage exists and may be disabled
+func (o *Orchestrator) ValidateDisabledStages(stages []Stage) error {
+	for _, name := range stages {
+		spec, ok := o.stageByName(name)
+		if !ok {
+			return fmt.Errorf("unknown stage %q", name)
+		}
+		if !spec.Optional {
+			return fmt.Errorf("stage %q is required and cannot be disabled", name)
+		}
+	}
+	return nil
+}
+
+func (o *Orchestrator) stageByName(name Stage) (StageSpec, bool) {
+	for _, s := range o.stages {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return StageSpec{}, false
+}
+
+// sleep waits for d or until ctx is done, whichever comes first
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/archlens/api-gateway/internal/downstream"
+	"github.com/archlens/api-gateway/internal/resilience"
+	"github.com/google/uuid"
+)
+
+// Topics of the calls stages dead-letter when their service is unavailable
+const (
+	TopicAnalysisRequested = "archlens.analysis.requested"
+	TopicAuditIngest       = "archlens.audit.ingest"
+	TopicLedgerSign        = "archlens.ledger.sign"
+	TopicDriftScan         = "archlens.drift.scan"
+	TopicHealthScore       = "archlens.analysis.health-score"
+)
+
+// deadLetterCall is the payload of a dead-lettered stage call: the request
+// as it was sent, so replaying it repeats the call exactly
+type deadLetterCall struct {
+	Service        string          `json:"service"`
+	Method         string          `json:"method"`
+	Path           string          `json:"path"`
+	Body           json.RawMessage `json:"body,omitempty"`
+	IdempotencyKey string          `json:"idempotency_key"`
+}
+
+// UseDeadLetters keeps the calls of stages whose service is unavailable in
+// dlq, which replays them once the service is back. It must be called
+// before runs start.
+func (o *Orchestrator) UseDeadLetters(dlq *resilience.DeadLetterQueue) {
+	o.deadLetters = dlq
+	for _, topic := range []string{TopicAnalysisRequested, TopicAuditIngest, TopicLedgerSign, TopicDriftScan, TopicHealthScore} {
+		dlq.Handle(topic, o.replayCall)
+	}
+}
+
+// client returns the client of a service by name
+func (s services) client(name string) *downstream.Client {
+	for _, c := range []*downstream.Client{s.cognitive, s.citadel, s.vault, s.audit} {
+		if c != nil && c.Name() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// replayCall repeats a dead-lettered stage call. The client is looked up on
+// every replay, since UseSharedBreakers replaces the clients.
+func (o *Orchestrator) replayCall(ctx context.Context, msg *resilience.DeadLetterMessage) error {
+	var call deadLetterCall
+	if err := json.Unmarshal(msg.Payload, &call); err != nil {
+		return fmt.Errorf("invalid dead-letter payload: %w", err)
+	}
+	client := o.services.client(call.Service)
+	if client == nil {
+		return fmt.Errorf("%s service not configured", call.Service)
+	}
+	req := &downstream.Request{
+		Method:         call.Method,
+		Path:           call.Path,
+		IdempotencyKey: call.IdempotencyKey,
+	}
+	if len(call.Body) > 0 {
+		req.Body = []byte(call.Body)
+	}
+	_, err := client.Do(ctx, req)
+	return err
+}
+
+// deadLetter queues a stage call that failed for its service being
+// unavailable, returning err annotated with the message ID. Calls the
+// service rejected, or that the run gave up on, are not queued. A run
+// failing the same call again replaces its message rather than adding one.
+func (o *Orchestrator) deadLetter(ctx context.Context, run *PipelineRun, stage Stage, topic string,
+	client *downstream.Client, req *downstream.Request, err error) error {
+	if o.deadLetters == nil || !downstream.IsUnavailable(err) || ctx.Err() != nil {
+		return err
+	}
+
+	body, mErr := json.Marshal(req.Body)
+	if mErr != nil {
+		return err
+	}
+	payload, mErr := json.Marshal(deadLetterCall{
+		Service:        client.Name(),
+		Method:         req.Method,
+		Path:           req.Path,
+		Body:           body,
+		IdempotencyKey: req.IdempotencyKey,
+	})
+	if mErr != nil {
+		return err
+	}
+	msg := &resilience.DeadLetterMessage{
+		ID:            uuid.NewSHA1(uuid.NameSpaceOID, []byte(topic+":"+req.IdempotencyKey)).String(),
+		OrgID:         run.OrgID,
+		OriginalTopic: topic,
+		Payload:       payload,
+		Error:         err.Error(),
+		Metadata: map[string]string{
+			"pipeline_id": run.ID,
+			"repo_id":     run.RepoID,
+			"stage":       string(stage),
+		},
+	}
+	if qErr := o.deadLetters.Enqueue(ctx, msg); qErr != nil {
+		o.logger.Warnw("failed to dead-letter stage call", "pipeline_id", run.ID, "stage", stage, "error", qErr)
+		return err
+	}
+	return fmt.Errorf("%w (queued for replay as dead letter %s)", err, msg.ID)
+}
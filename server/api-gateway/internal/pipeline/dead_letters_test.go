@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/archlens/api-gateway/internal/downstream"
+	"github.com/archlens/api-gateway/internal/resilience"
+)
+
+// flakyService answers 503 until it is marked up, then 200 with an empty
+// JSON object
+type flakyService struct {
+	up    atomic.Bool
+	calls atomic.Int32
+}
+
+func (f *flakyService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.calls.Add(1)
+	if !f.up.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{}`))
+}
+
+func newFlakyClient(t *testing.T, name string) (*flakyService, *downstream.Client) {
+	t.Helper()
+	svc := &flakyService{}
+	srv := httptest.NewServer(svc)
+	t.Cleanup(srv.Close)
+	cfg := downstream.DefaultConfig(name, srv.URL)
+	cfg.Retry.MaxAttempts = 1
+	return svc, downstream.New(cfg, zap.NewNop().Sugar())
+}
+
+func TestServiceStagesDeadLetterWhenUnavailable(t *testing.T) {
+	tests := []struct {
+		stage   Stage
+		topic   string
+		service string
+		run     func(o *Orchestrator) StageFunc
+	}{
+		{StageAIAnalysis, TopicAnalysisRequested, "cognitive", func(o *Orchestrator) StageFunc { return o.stageAIAnalysis }},
+		{StageLedger, TopicLedgerSign, "vault", func(o *Orchestrator) StageFunc { return o.stageLedger }},
+		{StageSecAlerts, TopicDriftScan, "citadel", func(o *Orchestrator) StageFunc { return o.stageSecurityAlerts }},
+		{StageInsights, TopicHealthScore, "cognitive", func(o *Orchestrator) StageFunc { return o.stageInsights }},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.stage), func(t *testing.T) {
+			ctx := context.Background()
+			o := NewOrchestrator(zap.NewNop().Sugar(), ServiceEndpoints{}, nil)
+			svc, client := newFlakyClient(t, tt.service)
+			switch tt.service {
+			case "cognitive":
+				o.services.cognitive = client
+			case "vault":
+				o.services.vault = client
+			case "citadel":
+				o.services.citadel = client
+			}
+			store := resilience.NewMemoryDeadLetterStore(nil, 0)
+			dlq := resilience.NewDeadLetterQueue(store, resilience.DefaultDeadLetterConfig(), zap.NewNop().Sugar())
+			o.UseDeadLetters(dlq)
+
+			run := &PipelineRun{ID: "run-1", OrgID: "org", RepoID: "repo", CommitSHA: "abc1234", Branch: "main"}
+			if _, err := tt.run(o)(ctx, run); err == nil {
+				t.Fatal("stage succeeded against an unavailable service")
+			}
+			msgs, err := dlq.List(ctx, resilience.DeadLetterFilter{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != 1 || msgs[0].OriginalTopic != tt.topic || msgs[0].Metadata["stage"] != string(tt.stage) {
+				t.Fatalf("dead letters = %+v, want one on %s", msgs, tt.topic)
+			}
+
+			// Once the service is back, replaying the message repeats the call
+			svc.up.Store(true)
+			before := svc.calls.Load()
+			if err := dlq.Replay(ctx, msgs[0]); err != nil {
+				t.Fatalf("replay: %v", err)
+			}
+			if svc.calls.Load() != before+1 {
+				t.Errorf("replay made %d calls, want 1", svc.calls.Load()-before)
+			}
+			if msgs, _ := dlq.List(ctx, resilience.DeadLetterFilter{}); len(msgs) != 0 {
+				t.Errorf("%d dead letters left after replay", len(msgs))
+			}
+		})
+	}
+}
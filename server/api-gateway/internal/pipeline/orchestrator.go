@@ -3,10 +3,14 @@ package pipeline
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/archlens/api-gateway/internal/resilience"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
@@ -15,55 +19,60 @@ import (
 type Stage string
 
 const (
-	StageUpload       Stage = "upload"
-	StageAuth         Stage = "authentication"
-	StageParse        Stage = "wasm_parsing"
-	StageAST          Stage = "structural_ast"
-	StageAIAnalysis   Stage = "gemini_analysis"
-	StageRuleEngine   Stage = "rule_evaluation"
-	StageAuditTrail   Stage = "audit_trail"
-	StageLedger       Stage = "sovereign_ledger"
-	StageDashboard    Stage = "dashboard_update"
-	StageSecAlerts    Stage = "security_alerts"
-	StageCompliance   Stage = "compliance_reports"
-	StageInsights     Stage = "strategic_insights"
+	StageUpload     Stage = "upload"
+	StageAuth       Stage = "authentication"
+	StageParse      Stage = "wasm_parsing"
+	StageAST        Stage = "structural_ast"
+	StageAIAnalysis Stage = "gemini_analysis"
+	StageRuleEngine Stage = "rule_evaluation"
+	StageAuditTrail Stage = "audit_trail"
+	StageLedger     Stage = "sovereign_ledger"
+	StageDashboard  Stage = "dashboard_update"
+	StageSecAlerts  Stage = "security_alerts"
+	StageCompliance Stage = "compliance_reports"
+	StageInsights   Stage = "strategic_insights"
 )
 
 // PipelineStatus tracks the state of a pipeline run
 type PipelineStatus string
 
 const (
-	StatusPending    PipelineStatus = "pending"
-	StatusRunning    PipelineStatus = "running"
-	StatusCompleted  PipelineStatus = "completed"
-	StatusFailed     PipelineStatus = "failed"
-	StatusCancelled  PipelineStatus = "cancelled"
+	StatusPending   PipelineStatus = "pending"
+	StatusRunning   PipelineStatus = "running"
+	StatusCompleted PipelineStatus = "completed"
+	StatusFailed    PipelineStatus = "failed"
+	StatusCancelled PipelineStatus = "cancelled"
+	StatusSkipped   PipelineStatus = "skipped"
 )
 
 // StageResult holds the outcome of a single stage
 type StageResult struct {
 	Stage     Stage          `json:"stage"`
 	Status    PipelineStatus `json:"status"`
-	StartedAt time.Time     `json:"started_at"`
-	EndedAt   *time.Time    `json:"ended_at,omitempty"`
-	Duration  float64       `json:"duration_ms"`
-	Output    interface{}   `json:"output,omitempty"`
-	Error     string        `json:"error,omitempty"`
+	StartedAt time.Time      `json:"started_at"`
+	EndedAt   *time.Time     `json:"ended_at,omitempty"`
+	Duration  float64        `json:"duration_ms"`
+	Output    interface{}    `json:"output,omitempty"`
+	Error     string         `json:"error,omitempty"`
+	Attempts  int            `json:"attempts,omitempty"`
 }
 
 // PipelineRun represents a full pipeline execution
 type PipelineRun struct {
-	ID           string            `json:"id"`
-	RepoID       string            `json:"repo_id"`
-	OrgID        string            `json:"org_id"`
-	CommitSHA    string            `json:"commit_sha,omitempty"`
-	Branch       string            `json:"branch"`
-	Status       PipelineStatus    `json:"status"`
-	Stages       []StageResult     `json:"stages"`
-	CreatedAt    time.Time         `json:"created_at"`
-	CompletedAt  *time.Time        `json:"completed_at,omitempty"`
-	TotalDuration float64          `json:"total_duration_ms"`
-	Metadata     map[string]string `json:"metadata,omitempty"`
+	ID              string            `json:"id"`
+	RepoID          string            `json:"repo_id"`
+	OrgID           string            `json:"org_id"`
+	CommitSHA       string            `json:"commit_sha,omitempty"`
+	Branch          string            `json:"branch"`
+	Status          PipelineStatus    `json:"status"`
+	Stages          []StageResult     `json:"stages"`
+	DisabledStages  []Stage           `json:"disabled_stages,omitempty"`
+	CreatedAt       time.Time         `json:"created_at"`
+	CompletedAt     *time.Time        `json:"completed_at,omitempty"`
+	TotalDuration   float64           `json:"total_duration_ms"`
+	ResumeCount     int               `json:"resume_count,omitempty"`
+	CancelRequested bool              `json:"cancel_requested,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
 }
 
 // ServiceEndpoints holds URLs for downstream services
@@ -79,20 +88,46 @@ type ServiceEndpoints struct {
 type Orchestrator struct {
 	logger    *zap.SugaredLogger
 	endpoints ServiceEndpoints
-	runs      map[string]*PipelineRun
-	mu        sync.RWMutex
-	listeners []func(run *PipelineRun, stage StageResult)
+	store     RunStore
+	ruleStore RuleStore
+	services  services
+	// deadLetters, when set, keeps stage calls failed for their service
+	// being unavailable for replay
+	deadLetters     *resilience.DeadLetterQueue
+	stages          []StageSpec
+	runs            map[string]*PipelineRun
+	cancels         map[string]context.CancelFunc
+	mu              sync.RWMutex
+	listeners       []func(run *PipelineRun, stage StageResult)
+	finishListeners []func(run *PipelineRun)
 }
 
-func NewOrchestrator(logger *zap.SugaredLogger, endpoints ServiceEndpoints) *Orchestrator {
-	return &Orchestrator{
+// NewOrchestrator creates an orchestrator. A nil store keeps runs in memory only.
+func NewOrchestrator(logger *zap.SugaredLogger, endpoints ServiceEndpoints, store RunStore) *Orchestrator {
+	o := &Orchestrator{
 		logger:    logger,
 		endpoints: endpoints,
+		store:     store,
+		services:  newServices(endpoints, nil, logger),
 		runs:      make(map[string]*PipelineRun),
+		cancels:   make(map[string]context.CancelFunc),
 	}
+	stages, err := validateStages(o.defaultStages())
+	if err != nil {
+		panic(fmt.Sprintf("invalid pipeline definition: %v", err))
+	}
+	o.stages = stages
+	return o
+}
+
+// UseSharedBreakers shares the circuit breakers of downstream services with
+// the other gateway replicas. It must be called before runs start.
+func (o *Orchestrator) UseSharedBreakers(breakers *resilience.SharedBreakers) {
+	o.services = newServices(o.endpoints, breakers, o.logger)
 }
 
-// OnStageComplete registers a callback for stage completion events
+// OnStageComplete registers a callback for stage completion events. Listeners
+// receive a snapshot of the run and must be registered before runs start.
 func (o *Orchestrator) OnStageComplete(fn func(run *PipelineRun, stage StageResult)) {
 	o.listeners = append(o.listeners, fn)
 }
@@ -103,29 +138,61 @@ func (o *Orchestrator) notifyListeners(run *PipelineRun, stage StageResult) {
 	}
 }
 
-// StartPipeline initiates a full codebase analysis pipeline
-//
-// Flow: Upload → Auth → WASM Parser → AST → Gemini AI → Rule Engine →
-//
-//	Audit Trail → Sovereign Ledger → Dashboard Update
-//	                                  ↘ Security Alerts
-//	                                  ↘ Compliance Reports
-//	                                  ↘ Strategic Insights
-func (o *Orchestrator) StartPipeline(ctx context.Context, repoID, orgID, commitSHA, branch string) (*PipelineRun, error) {
+// OnRunFinished registers a callback for runs reaching a terminal status.
+// It fires after the final state is persisted, so GetRun reflects it.
+func (o *Orchestrator) OnRunFinished(fn func(run *PipelineRun)) {
+	o.finishListeners = append(o.finishListeners, fn)
+}
+
+func (o *Orchestrator) notifyFinished(run *PipelineRun) {
+	o.mu.RLock()
+	snapshot := run.clone()
+	o.mu.RUnlock()
+	for _, fn := range o.finishListeners {
+		go fn(snapshot)
+	}
+}
+
+// RunOptions tunes a single pipeline run
+type RunOptions struct {
+	// DisabledStages are optional stages skipped for this run
+	DisabledStages []Stage
+	// CancelSuperseded cancels unfinished runs for the same repository and branch
+	CancelSuperseded bool
+}
+
+// StartPipeline initiates a full codebase analysis pipeline over the stage DAG
+// (see defaultStages). The run is detached from ctx's cancellation; use
+// CancelRun to stop it.
+func (o *Orchestrator) StartPipeline(ctx context.Context, repoID, orgID, commitSHA, branch string, opts RunOptions) (*PipelineRun, error) {
+	if err := o.ValidateDisabledStages(opts.DisabledStages); err != nil {
+		return nil, err
+	}
+
 	run := &PipelineRun{
-		ID:        uuid.New().String(),
-		RepoID:    repoID,
-		OrgID:     orgID,
-		CommitSHA: commitSHA,
-		Branch:    branch,
-		Status:    StatusRunning,
-		Stages:    []StageResult{},
-		CreatedAt: time.Now().UTC(),
-		Metadata:  map[string]string{},
+		ID:             uuid.New().String(),
+		RepoID:         repoID,
+		OrgID:          orgID,
+		CommitSHA:      commitSHA,
+		Branch:         branch,
+		Status:         StatusRunning,
+		Stages:         []StageResult{},
+		DisabledStages: opts.DisabledStages,
+		CreatedAt:      time.Now().UTC(),
+		Metadata:       map[string]string{},
+	}
+
+	if err := o.persistRun(ctx, run); err != nil {
+		return nil, fmt.Errorf("failed to persist pipeline run: %w", err)
+	}
+
+	if opts.CancelSuperseded {
+		o.cancelSuperseded(ctx, run)
 	}
 
 	o.mu.Lock()
 	o.runs[run.ID] = run
+	snapshot := run.clone()
 	o.mu.Unlock()
 
 	o.logger.Infow("pipeline started",
@@ -135,66 +202,91 @@ func (o *Orchestrator) StartPipeline(ctx context.Context, repoID, orgID, commitS
 		"commit", commitSHA,
 	)
 
-	go o.executePipeline(ctx, run)
-	return run, nil
+	go o.executePipeline(o.runContext(ctx, run.ID), run)
+	return snapshot, nil
 }
 
+// executePipeline schedules every stage as soon as all of its dependencies
+// have finished, so independent stages run concurrently. A failed critical
+// stage or a cancelled ctx aborts the run and the stages that have not
+// started are skipped.
 func (o *Orchestrator) executePipeline(ctx context.Context, run *PipelineRun) {
-	// Sequential stages (each depends on the previous)
-	sequentialStages := []struct {
-		stage Stage
-		fn    func(ctx context.Context, run *PipelineRun) (interface{}, error)
-	}{
-		{StageUpload, o.stageUpload},
-		{StageAuth, o.stageAuth},
-		{StageParse, o.stageParse},
-		{StageAST, o.stageAST},
-		{StageAIAnalysis, o.stageAIAnalysis},
-		{StageRuleEngine, o.stageRuleEngine},
-		{StageAuditTrail, o.stageAuditTrail},
-		{StageLedger, o.stageLedger},
+	// Stages already completed before a restart are not executed again
+	done := o.completedStages(run)
+	disabled := make(map[Stage]bool, len(run.DisabledStages))
+	for _, s := range run.DisabledStages {
+		disabled[s] = true
 	}
 
-	for _, s := range sequentialStages {
-		if ctx.Err() != nil {
-			o.failPipeline(run, "pipeline cancelled")
-			return
-		}
+	stageCtx, abort := context.WithCancel(ctx)
+	defer abort()
 
-		result := o.executeStage(ctx, run, s.stage, s.fn)
-		if result.Status == StatusFailed {
-			o.failPipeline(run, fmt.Sprintf("stage %s failed: %s", s.stage, result.Error))
-			return
-		}
+	finished := make(map[Stage]chan struct{}, len(o.stages))
+	for _, s := range o.stages {
+		finished[s.Name] = make(chan struct{})
 	}
 
-	// Parallel fan-out stages after ledger
+	var (
+		failMu  sync.Mutex
+		failure string
+	)
 	var wg sync.WaitGroup
-	parallelStages := []struct {
-		stage Stage
-		fn    func(ctx context.Context, run *PipelineRun) (interface{}, error)
-	}{
-		{StageDashboard, o.stageDashboard},
-		{StageSecAlerts, o.stageSecurityAlerts},
-		{StageCompliance, o.stageCompliance},
-		{StageInsights, o.stageInsights},
-	}
-
-	for _, s := range parallelStages {
+	for _, spec := range o.stages {
 		wg.Add(1)
-		go func(stage Stage, fn func(ctx context.Context, run *PipelineRun) (interface{}, error)) {
+		go func(spec StageSpec) {
 			defer wg.Done()
-			o.executeStage(ctx, run, stage, fn)
-		}(s.stage, s.fn)
+			defer close(finished[spec.Name])
+
+			for _, dep := range spec.DependsOn {
+				<-finished[dep]
+			}
+
+			switch {
+			case done[spec.Name]:
+				return
+			case stageCtx.Err() != nil:
+				o.skipStage(run, spec.Name, "pipeline aborted before stage started")
+				return
+			case disabled[spec.Name]:
+				o.skipStage(run, spec.Name, "stage disabled for this repository")
+				return
+			}
+
+			result := o.executeStage(stageCtx, run, spec)
+			if result.Status == StatusFailed && spec.Critical {
+				failMu.Lock()
+				if failure == "" {
+					failure = fmt.Sprintf("stage %s failed: %s", spec.Name, result.Error)
+				}
+				failMu.Unlock()
+				abort()
+			}
+		}(spec)
 	}
 	wg.Wait()
+	// Releasing the run's context cancels it, so whether the run was
+	// cancelled must be read first
+	cancelled := ctx.Err() != nil
+	o.releaseRunContext(run.ID)
+
+	if cancelled {
+		o.cancelPipeline(run)
+		return
+	}
+	if failure != "" {
+		o.failPipeline(run, failure)
+		return
+	}
 
 	// Mark complete
 	now := time.Now().UTC()
+	o.mu.Lock()
 	run.CompletedAt = &now
 	run.Status = StatusCompleted
 	run.TotalDuration = now.Sub(run.CreatedAt).Seconds() * 1000
+	o.mu.Unlock()
 
+	o.finishRun(run)
 	o.logger.Infow("pipeline completed",
 		"pipeline_id", run.ID,
 		"duration_ms", run.TotalDuration,
@@ -202,140 +294,240 @@ func (o *Orchestrator) executePipeline(ctx context.Context, run *PipelineRun) {
 	)
 }
 
-func (o *Orchestrator) executeStage(ctx context.Context, run *PipelineRun, stage Stage, fn func(ctx context.Context, run *PipelineRun) (interface{}, error)) StageResult {
+func (o *Orchestrator) executeStage(ctx context.Context, run *PipelineRun, spec StageSpec) StageResult {
 	start := time.Now()
 	result := StageResult{
-		Stage:     stage,
+		Stage:     spec.Name,
 		Status:    StatusRunning,
 		StartedAt: start,
 	}
 
-	o.logger.Debugw("stage started", "pipeline_id", run.ID, "stage", stage)
+	o.logger.Debugw("stage started", "pipeline_id", run.ID, "stage", spec.Name)
+
+	var (
+		output  interface{}
+		skipped *SkipStage
+	)
+	attempt := func() error {
+		result.Attempts++
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if spec.Timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, spec.Timeout)
+		}
+		defer cancel()
+
+		out, err := spec.Run(attemptCtx, run)
+		if errors.As(err, &skipped) {
+			// Not a failure, so not retried
+			return nil
+		}
+		if err != nil && attemptCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			err = fmt.Errorf("stage timed out after %s: %w", spec.Timeout, err)
+		}
+		output = out
+		return err
+	}
+
+	var err error
+	if spec.Retry != nil {
+		err = resilience.RetryWithBackoff(ctx, *spec.Retry, o.logger, "pipeline stage "+string(spec.Name), attempt)
+	} else {
+		err = attempt()
+	}
 
-	output, err := fn(ctx, run)
 	now := time.Now()
 	result.EndedAt = &now
 	result.Duration = now.Sub(start).Seconds() * 1000
 
-	if err != nil {
+	if err != nil && ctx.Err() == context.Canceled {
+		result.Status = StatusCancelled
+		result.Error = err.Error()
+		o.logger.Infow("stage cancelled", "pipeline_id", run.ID, "stage", spec.Name)
+	} else if err != nil {
 		result.Status = StatusFailed
 		result.Error = err.Error()
-		o.logger.Warnw("stage failed", "pipeline_id", run.ID, "stage", stage, "error", err)
+		o.logger.Warnw("stage failed", "pipeline_id", run.ID, "stage", spec.Name, "attempts", result.Attempts, "error", err)
+	} else if skipped != nil {
+		result.Status = StatusSkipped
+		result.Output = map[string]interface{}{"skipped_reason": skipped.Reason}
+		o.logger.Warnw("stage skipped", "pipeline_id", run.ID, "stage", spec.Name, "reason", skipped.Reason)
 	} else {
 		result.Status = StatusCompleted
 		result.Output = output
-		o.logger.Debugw("stage completed", "pipeline_id", run.ID, "stage", stage, "duration_ms", result.Duration)
+		o.logger.Debugw("stage completed", "pipeline_id", run.ID, "stage", spec.Name, "duration_ms", result.Duration)
 	}
 
+	o.recordStage(run, result)
+	return result
+}
+
+// skipStage records a stage that was not executed
+func (o *Orchestrator) skipStage(run *PipelineRun, stage Stage, reason string) {
+	now := time.Now()
+	o.recordStage(run, StageResult{
+		Stage:     stage,
+		Status:    StatusSkipped,
+		StartedAt: now,
+		EndedAt:   &now,
+		Output:    map[string]interface{}{"skipped_reason": reason},
+	})
+}
+
+// recordStage stores a stage result on the run, replacing any earlier result
+// for the same stage left over from an interrupted attempt
+func (o *Orchestrator) recordStage(run *PipelineRun, result StageResult) {
 	o.mu.Lock()
-	run.Stages = append(run.Stages, result)
+	replaced := false
+	for i := range run.Stages {
+		if run.Stages[i].Stage == result.Stage {
+			run.Stages[i] = result
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		run.Stages = append(run.Stages, result)
+	}
+	snapshot := run.clone()
 	o.mu.Unlock()
 
-	o.notifyListeners(run, result)
-	return result
+	o.persistStage(run, result)
+	o.notifyListeners(snapshot, result)
 }
 
 func (o *Orchestrator) failPipeline(run *PipelineRun, reason string) {
 	now := time.Now().UTC()
+	o.mu.Lock()
 	run.Status = StatusFailed
 	run.CompletedAt = &now
 	run.TotalDuration = now.Sub(run.CreatedAt).Seconds() * 1000
+	run.Metadata["failure_reason"] = reason
+	o.mu.Unlock()
+	o.finishRun(run)
 	o.logger.Errorw("pipeline failed", "pipeline_id", run.ID, "reason", reason)
 }
 
-// GetRun returns a pipeline run by ID
-func (o *Orchestrator) GetRun(id string) (*PipelineRun, bool) {
+// GetRun returns a snapshot of a pipeline run by ID
+func (o *Orchestrator) GetRun(ctx context.Context, id string) (*PipelineRun, error) {
 	o.mu.RLock()
-	defer o.mu.RUnlock()
 	run, ok := o.runs[id]
-	return run, ok
+	if ok {
+		snapshot := run.clone()
+		o.mu.RUnlock()
+		return snapshot, nil
+	}
+	o.mu.RUnlock()
+
+	if o.store == nil {
+		return nil, ErrRunNotFound
+	}
+	return o.store.GetPipelineRun(ctx, id)
 }
 
-// ListRuns returns all pipeline runs
-func (o *Orchestrator) ListRuns() []*PipelineRun {
+// ListRuns returns a page of snapshots of the pipeline runs for a repository.
+// Runs are persisted when they start, so the store decides which runs are on
+// the page; those executing on this replica replace their persisted copy.
+func (o *Orchestrator) ListRuns(ctx context.Context, orgID, repoID string, q listing.Query) (*listing.Page[*PipelineRun], error) {
+	if o.store == nil {
+		// Without a store only the runs held in memory exist
+		runs := make([]*PipelineRun, 0)
+		o.mu.RLock()
+		for _, r := range o.runs {
+			if r.OrgID == orgID && r.RepoID == repoID {
+				runs = append(runs, r.clone())
+			}
+		}
+		o.mu.RUnlock()
+		sort.Slice(runs, func(i, j int) bool {
+			return runs[i].CreatedAt.After(runs[j].CreatedAt)
+		})
+		return listing.All(runs), nil
+	}
+
+	page, err := o.store.ListPipelineRuns(ctx, orgID, repoID, q)
+	if err != nil {
+		return nil, err
+	}
 	o.mu.RLock()
-	defer o.mu.RUnlock()
-	runs := make([]*PipelineRun, 0, len(o.runs))
-	for _, r := range o.runs {
-		runs = append(runs, r)
+	for i, r := range page.Data {
+		if live, ok := o.runs[r.ID]; ok {
+			page.Data[i] = live.clone()
+		}
 	}
-	return runs
+	o.mu.RUnlock()
+	return page, nil
+}
+
+// Finished reports whether the run reached a terminal status
+func (r *PipelineRun) Finished() bool {
+	return isTerminal(r.Status)
+}
+
+// clone copies a run so it can be serialized while stages are still executing.
+// Callers must hold o.mu.
+func (r *PipelineRun) clone() *PipelineRun {
+	cp := *r
+	cp.Stages = append([]StageResult(nil), r.Stages...)
+	cp.DisabledStages = append([]Stage(nil), r.DisabledStages...)
+	cp.Metadata = make(map[string]string, len(r.Metadata))
+	for k, v := range r.Metadata {
+		cp.Metadata[k] = v
+	}
+	return &cp
 }
 
 // ── Stage Implementations ──
 
 func (o *Orchestrator) stageUpload(ctx context.Context, run *PipelineRun) (interface{}, error) {
 	// TODO: fetch codebase from repo provider (GitHub, GitLab, etc.)
-	time.Sleep(50 * time.Millisecond)
+	if err := sleep(ctx, 50*time.Millisecond); err != nil {
+		return nil, err
+	}
 	return map[string]interface{}{"files_discovered": 42, "total_bytes": 1_250_000}, nil
 }
 
 func (o *Orchestrator) stageAuth(ctx context.Context, run *PipelineRun) (interface{}, error) {
 	// TODO: validate org permissions, tenant isolation
-	time.Sleep(10 * time.Millisecond)
+	if err := sleep(ctx, 10*time.Millisecond); err != nil {
+		return nil, err
+	}
 	return map[string]interface{}{"authorized": true, "org_id": run.OrgID}, nil
 }
 
 func (o *Orchestrator) stageParse(ctx context.Context, run *PipelineRun) (interface{}, error) {
 	// TODO: call Parser gRPC service for batch parsing
-	time.Sleep(100 * time.Millisecond)
+	if err := sleep(ctx, 100*time.Millisecond); err != nil {
+		return nil, err
+	}
 	return map[string]interface{}{"files_parsed": 42, "parse_time_ms": 95.3, "languages": []string{"typescript", "go", "python"}}, nil
 }
 
 func (o *Orchestrator) stageAST(ctx context.Context, run *PipelineRun) (interface{}, error) {
-	// TODO: extract structural AST and dependency graph
-	time.Sleep(80 * time.Millisecond)
+	// TODO: extract structural AST and persist the dependency graph
+	// (code_files, dependency_edges) for the analysis. Until then the rule
+	// engine and audit trail stages find no graph and are skipped.
+	if err := sleep(ctx, 80*time.Millisecond); err != nil {
+		return nil, err
+	}
 	return map[string]interface{}{"ast_nodes": 1_250, "dependency_edges": 89}, nil
 }
 
-func (o *Orchestrator) stageAIAnalysis(ctx context.Context, run *PipelineRun) (interface{}, error) {
-	// TODO: call Cognitive Service for Gemini analysis
-	time.Sleep(200 * time.Millisecond)
-	return map[string]interface{}{"insights_generated": 7, "model": "gemini-2.0-flash", "tokens_used": 12_500}, nil
-}
-
-func (o *Orchestrator) stageRuleEngine(ctx context.Context, run *PipelineRun) (interface{}, error) {
-	// TODO: evaluate architectural rules against analysis
-	time.Sleep(60 * time.Millisecond)
-	return map[string]interface{}{"rules_evaluated": 15, "violations": 3, "passed": 12}, nil
-}
-
-func (o *Orchestrator) stageAuditTrail(ctx context.Context, run *PipelineRun) (interface{}, error) {
-	// TODO: write immutable audit entry to PostgreSQL
-	time.Sleep(30 * time.Millisecond)
-	return map[string]interface{}{"audit_entry_id": uuid.New().String()}, nil
-}
-
-func (o *Orchestrator) stageLedger(ctx context.Context, run *PipelineRun) (interface{}, error) {
-	// TODO: call Vault Service to append to sovereign ledger
-	time.Sleep(40 * time.Millisecond)
-	return map[string]interface{}{"ledger_hash": "a1b2c3d4e5f6..."}, nil
-}
-
 func (o *Orchestrator) stageDashboard(ctx context.Context, run *PipelineRun) (interface{}, error) {
 	// TODO: push real-time update via WebSocket / SSE
-	time.Sleep(20 * time.Millisecond)
+	if err := sleep(ctx, 20*time.Millisecond); err != nil {
+		return nil, err
+	}
 	return map[string]interface{}{"dashboard_updated": true}, nil
 }
 
-func (o *Orchestrator) stageSecurityAlerts(ctx context.Context, run *PipelineRun) (interface{}, error) {
-	// TODO: generate security alerts from analysis results
-	time.Sleep(30 * time.Millisecond)
-	return map[string]interface{}{"alerts_generated": 1, "severity": "medium"}, nil
-}
-
 func (o *Orchestrator) stageCompliance(ctx context.Context, run *PipelineRun) (interface{}, error) {
 	// TODO: generate compliance report
-	time.Sleep(25 * time.Millisecond)
+	if err := sleep(ctx, 25*time.Millisecond); err != nil {
+		return nil, err
+	}
 	return map[string]interface{}{"compliance_score": 94.2, "frameworks": []string{"SOC2", "ISO27001"}}, nil
 }
 
-func (o *Orchestrator) stageInsights(ctx context.Context, run *PipelineRun) (interface{}, error) {
-	// TODO: generate strategic insights summary
-	time.Sleep(35 * time.Millisecond)
-	return map[string]interface{}{"insights": 5, "health_score": 78.5}, nil
-}
-
 // ToJSON serializes a pipeline run to JSON bytes
 func (r *PipelineRun) ToJSON() ([]byte, error) {
 	return json.Marshal(r)
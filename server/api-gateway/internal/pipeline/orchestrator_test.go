@@ -0,0 +1,184 @@
+package pipeline
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// newTestOrchestrator returns an in-memory orchestrator and a channel
+// receiving every run that finishes
+func newTestOrchestrator(t *testing.T, stages []StageSpec) (*Orchestrator, <-chan *PipelineRun) {
+	t.Helper()
+	o := NewOrchestrator(zap.NewNop().Sugar(), ServiceEndpoints{}, nil)
+	if stages != nil {
+		ordered, err := validateStages(stages)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.stages = ordered
+	}
+	finished := make(chan *PipelineRun, 4)
+	o.OnRunFinished(func(run *PipelineRun) { finished <- run })
+	return o, finished
+}
+
+func waitFinished(t *testing.T, finished <-chan *PipelineRun, id string) *PipelineRun {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case run := <-finished:
+			if run.ID == id {
+				return run
+			}
+		case <-timeout:
+			t.Fatalf("pipeline %s did not finish", id)
+		}
+	}
+}
+
+func stageStatus(run *PipelineRun, stage Stage) PipelineStatus {
+	for _, s := range run.Stages {
+		if s.Stage == stage {
+			return s.Status
+		}
+	}
+	return ""
+}
+
+// blockingStages are first → blocking → last; blocking signals started and
+// runs until its context is cancelled
+func blockingStages(started chan<- struct{}) []StageSpec {
+	ok := func(ctx context.Context, run *PipelineRun) (interface{}, error) {
+		return map[string]interface{}{"ok": true}, nil
+	}
+	return []StageSpec{
+		{Name: StageUpload, Run: ok, Critical: true},
+		{Name: StageAuth, DependsOn: []Stage{StageUpload}, Critical: true,
+			Run: func(ctx context.Context, run *PipelineRun) (interface{}, error) {
+				started <- struct{}{}
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}},
+		{Name: StageParse, DependsOn: []Stage{StageAuth}, Run: ok, Critical: true},
+	}
+}
+
+func TestPipelineCompletes(t *testing.T) {
+	o, finished := newTestOrchestrator(t, nil)
+
+	started, err := o.StartPipeline(context.Background(), "repo", "org", "abc1234", "main", RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := waitFinished(t, finished, started.ID)
+
+	if run.Status != StatusCompleted {
+		t.Fatalf("status = %s, metadata %v", run.Status, run.Metadata)
+	}
+	if _, ok := run.Metadata["cancel_reason"]; ok {
+		t.Errorf("completed run has cancel_reason %q", run.Metadata["cancel_reason"])
+	}
+	if len(run.Stages) != len(o.stages) {
+		t.Errorf("recorded %d stages, want %d", len(run.Stages), len(o.stages))
+	}
+	for _, s := range run.Stages {
+		if s.Status != StatusCompleted && s.Status != StatusSkipped {
+			t.Errorf("stage %s: status %s", s.Stage, s.Status)
+		}
+	}
+
+	// No service is configured, so the stages calling them are skipped
+	for _, stage := range []Stage{StageAIAnalysis, StageAuditTrail, StageLedger, StageSecAlerts, StageInsights} {
+		if got := stageStatus(run, stage); got != StatusSkipped {
+			t.Errorf("stage %s: status %s, want %s", stage, got, StatusSkipped)
+		}
+	}
+	for _, s := range run.Stages {
+		if s.Stage != StageAIAnalysis {
+			continue
+		}
+		out, _ := s.Output.(map[string]interface{})
+		if reason, _ := out["skipped_reason"].(string); reason != "cognitive service not configured" {
+			t.Errorf("ai analysis skipped_reason = %q", reason)
+		}
+	}
+}
+
+func TestCancelRunMidStage(t *testing.T) {
+	started := make(chan struct{}, 1)
+	o, finished := newTestOrchestrator(t, blockingStages(started))
+
+	run, err := o.StartPipeline(context.Background(), "repo", "org", "abc1234", "main", RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if _, err := o.CancelRun(context.Background(), run.ID, "stop"); err != nil {
+		t.Fatal(err)
+	}
+	run = waitFinished(t, finished, run.ID)
+
+	if run.Status != StatusCancelled {
+		t.Fatalf("status = %s", run.Status)
+	}
+	if got := run.Metadata["cancel_reason"]; got != "stop" {
+		t.Errorf("cancel_reason = %q", got)
+	}
+	want := map[Stage]PipelineStatus{
+		StageUpload: StatusCompleted,
+		StageAuth:   StatusCancelled,
+		StageParse:  StatusSkipped,
+	}
+	for stage, status := range want {
+		if got := stageStatus(run, stage); got != status {
+			t.Errorf("stage %s: status %s, want %s", stage, got, status)
+		}
+	}
+	if _, err := o.CancelRun(context.Background(), run.ID, ""); err != ErrRunFinished {
+		t.Errorf("cancelling a finished run: %v", err)
+	}
+}
+
+func TestCancelSupersededRun(t *testing.T) {
+	started := make(chan struct{}, 2)
+	o, finished := newTestOrchestrator(t, blockingStages(started))
+
+	first, err := o.StartPipeline(context.Background(), "repo", "org", "abc1234", "main", RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	other, err := o.StartPipeline(context.Background(), "repo", "org", "abc1234", "feature", RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	second, err := o.StartPipeline(context.Background(), "repo", "org", "def5678", "main", RunOptions{CancelSuperseded: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := waitFinished(t, finished, first.ID)
+	if run.Status != StatusCancelled {
+		t.Fatalf("superseded run: status %s", run.Status)
+	}
+	if got := run.Metadata["cancel_reason"]; !strings.Contains(got, second.ID) {
+		t.Errorf("cancel_reason = %q, want it to name %s", got, second.ID)
+	}
+
+	for _, id := range []string{other.ID, second.ID} {
+		r, err := o.GetRun(context.Background(), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Finished() {
+			t.Errorf("run %s on branch %s finished with %s", id, r.Branch, r.Status)
+		}
+		o.CancelRun(context.Background(), id, "")
+	}
+}
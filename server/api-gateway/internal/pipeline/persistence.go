@@ -0,0 +1,186 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/listing"
+)
+
+var ErrRunNotFound = errors.New("pipeline run not found")
+
+const (
+	// heartbeatInterval is how often a replica refreshes the runs it owns
+	heartbeatInterval = 15 * time.Second
+	// staleAfter is how long a run may go without a heartbeat before another
+	// replica considers it abandoned and takes it over
+	staleAfter = 4 * heartbeatInterval
+	// maxResumes bounds how often a run is resumed before it is given up on
+	maxResumes = 3
+	// persistTimeout bounds each individual write to the run store
+	persistTimeout = 5 * time.Second
+)
+
+// RunStore persists pipeline runs and stage results so they survive restarts
+type RunStore interface {
+	// SavePipelineRun inserts or updates the run row (not its stages)
+	SavePipelineRun(ctx context.Context, run *PipelineRun) error
+	// SavePipelineStage records the outcome of one stage of a run. Outputs
+	// may contain source code and are stored encrypted for the organization.
+	SavePipelineStage(ctx context.Context, orgID, runID string, result StageResult) error
+	GetPipelineRun(ctx context.Context, id string) (*PipelineRun, error)
+	ListPipelineRuns(ctx context.Context, orgID, repoID string, q listing.Query) (*listing.Page[*PipelineRun], error)
+	// TouchPipelineRuns refreshes the heartbeat of runs owned by this replica
+	// and returns those another replica asked to cancel, keyed to the reason
+	TouchPipelineRuns(ctx context.Context, ids []string) (map[string]string, error)
+	// RequestPipelineCancel flags unfinished runs for cancellation by their owner
+	RequestPipelineCancel(ctx context.Context, ids []string, reason string) error
+	// ListActivePipelineRunIDs returns unfinished runs for a repository branch
+	ListActivePipelineRunIDs(ctx context.Context, repoID, branch string) ([]string, error)
+	// ClaimStalePipelineRuns atomically takes over unfinished runs whose
+	// heartbeat is older than the cutoff, incrementing their resume count
+	ClaimStalePipelineRuns(ctx context.Context, cutoff time.Time) ([]*PipelineRun, error)
+}
+
+// Run keeps the runs owned by this replica alive in the store and resumes
+// runs abandoned by replicas that went away. It blocks until ctx is done.
+func (o *Orchestrator) Run(ctx context.Context) {
+	if o.store == nil {
+		return
+	}
+
+	o.recoverStaleRuns(ctx)
+
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.heartbeat(ctx)
+			o.recoverStaleRuns(ctx)
+		}
+	}
+}
+
+func (o *Orchestrator) heartbeat(ctx context.Context) {
+	o.mu.RLock()
+	ids := make([]string, 0, len(o.runs))
+	for id, r := range o.runs {
+		if r.Status == StatusRunning || r.Status == StatusPending {
+			ids = append(ids, id)
+		}
+	}
+	o.mu.RUnlock()
+	if len(ids) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, persistTimeout)
+	defer cancel()
+	cancelled, err := o.store.TouchPipelineRuns(ctx, ids)
+	if err != nil {
+		o.logger.Warnw("failed to refresh pipeline heartbeats", "runs", len(ids), "error", err)
+		return
+	}
+	for id, reason := range cancelled {
+		o.cancelLocal(id, reason)
+	}
+}
+
+func (o *Orchestrator) recoverStaleRuns(ctx context.Context) {
+	claimCtx, cancel := context.WithTimeout(ctx, persistTimeout)
+	runs, err := o.store.ClaimStalePipelineRuns(claimCtx, time.Now().Add(-staleAfter))
+	cancel()
+	if err != nil {
+		o.logger.Warnw("failed to claim stale pipeline runs", "error", err)
+		return
+	}
+
+	for _, run := range runs {
+		if run.Metadata == nil {
+			run.Metadata = map[string]string{}
+		}
+		run.Status = StatusRunning
+
+		o.mu.Lock()
+		o.runs[run.ID] = run
+		o.mu.Unlock()
+
+		if run.CancelRequested {
+			o.cancelPipeline(run)
+			continue
+		}
+		if run.ResumeCount > maxResumes {
+			o.failPipeline(run, "pipeline interrupted by gateway restarts too many times")
+			continue
+		}
+
+		o.logger.Infow("resuming interrupted pipeline",
+			"pipeline_id", run.ID,
+			"repo_id", run.RepoID,
+			"resume_count", run.ResumeCount,
+			"completed_stages", len(o.completedStages(run)),
+		)
+		go o.executePipeline(o.runContext(context.Background(), run.ID), run)
+	}
+}
+
+// completedStages returns the stages of a run that already finished successfully
+func (o *Orchestrator) completedStages(run *PipelineRun) map[Stage]bool {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	done := make(map[Stage]bool, len(run.Stages))
+	for _, s := range run.Stages {
+		if s.Status == StatusCompleted {
+			done[s.Stage] = true
+		}
+	}
+	return done
+}
+
+func (o *Orchestrator) persistRun(ctx context.Context, run *PipelineRun) error {
+	if o.store == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, persistTimeout)
+	defer cancel()
+
+	o.mu.RLock()
+	snapshot := run.clone()
+	o.mu.RUnlock()
+	return o.store.SavePipelineRun(ctx, snapshot)
+}
+
+func (o *Orchestrator) persistStage(run *PipelineRun, result StageResult) {
+	if o.store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), persistTimeout)
+	defer cancel()
+	if err := o.store.SavePipelineStage(ctx, run.OrgID, run.ID, result); err != nil {
+		o.logger.Warnw("failed to persist stage result",
+			"pipeline_id", run.ID,
+			"stage", result.Stage,
+			"error", err,
+		)
+	}
+}
+
+// finishRun records the terminal state of a run. Once persisted, the run is
+// served from the store and no longer held in memory.
+func (o *Orchestrator) finishRun(run *PipelineRun) {
+	defer o.notifyFinished(run)
+	if o.store == nil {
+		return
+	}
+	if err := o.persistRun(context.Background(), run); err != nil {
+		o.logger.Errorw("failed to persist finished pipeline run", "pipeline_id", run.ID, "error", err)
+		return
+	}
+	o.mu.Lock()
+	delete(o.runs, run.ID)
+	o.mu.Unlock()
+}
@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/archlens/api-gateway/internal/rules"
+)
+
+// RuleStore supplies the rule evaluation stage with the dependency graph
+// recorded by the parse stage and the organization's rules, and records the
+// violations it finds as drift events
+type RuleStore interface {
+	LoadDependencyGraph(ctx context.Context, repoID, commitSHA string) (*rules.Graph, error)
+	ListEnabledRules(ctx context.Context, orgID string) ([]rules.Rule, error)
+	// RecordViolations stores violations as open drift events, skipping ones
+	// already open, and returns the IDs of the new ones
+	RecordViolations(ctx context.Context, repoID string, violations []rules.Violation) ([]string, error)
+}
+
+// UseRuleStore configures where the rule evaluation stage reads rules and
+// graphs from. It must be called before runs start.
+func (o *Orchestrator) UseRuleStore(rs RuleStore) {
+	o.ruleStore = rs
+}
+
+func (o *Orchestrator) stageRuleEngine(ctx context.Context, run *PipelineRun) (interface{}, error) {
+	if o.ruleStore == nil {
+		return nil, &SkipStage{Reason: "rule store not configured"}
+	}
+
+	graph, err := o.loadDependencyGraph(ctx, run)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dependency graph: %w", err)
+	}
+	ruleSet, err := o.ruleStore.ListEnabledRules(ctx, run.OrgID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	result := rules.Evaluate(graph, ruleSet)
+	recorded, err := o.ruleStore.RecordViolations(ctx, run.RepoID, result.Violations)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record drift events: %w", err)
+	}
+	if len(result.InvalidRules) > 0 {
+		o.logger.Warnw("skipped invalid architectural rules", "pipeline_id", run.ID, "rules", result.InvalidRules)
+	}
+
+	return map[string]interface{}{
+		"rules_evaluated":  result.RulesEvaluated,
+		"passed":           result.Passed,
+		"violations":       len(result.Violations),
+		"new_drift_events": len(recorded),
+		"drift_event_ids":  recorded,
+		"files":            len(graph.Files),
+		"dependency_edges": len(graph.Edges),
+		"invalid_rules":    result.InvalidRules,
+		"truncated_rules":  result.Truncated,
+	}, nil
+}
+
+// loadDependencyGraph returns the graph recorded for the run's commit, or a
+// *SkipStage if there is none to evaluate
+func (o *Orchestrator) loadDependencyGraph(ctx context.Context, run *PipelineRun) (*rules.Graph, error) {
+	if run.CommitSHA == "" {
+		return nil, &SkipStage{Reason: "run has no commit to load a dependency graph for"}
+	}
+	graph, err := o.ruleStore.LoadDependencyGraph(ctx, run.RepoID, run.CommitSHA)
+	if errors.Is(err, rules.ErrNoGraph) {
+		return nil, &SkipStage{Reason: err.Error()}
+	}
+	return graph, err
+}
@@ -0,0 +1,93 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/archlens/api-gateway/internal/resilience"
+	"github.com/archlens/api-gateway/internal/rules"
+)
+
+type fakeRuleStore struct {
+	graph    *rules.Graph
+	recorded []rules.Violation
+}
+
+func (f *fakeRuleStore) LoadDependencyGraph(context.Context, string, string) (*rules.Graph, error) {
+	if f.graph == nil {
+		return nil, rules.ErrNoGraph
+	}
+	return f.graph, nil
+}
+
+func (f *fakeRuleStore) ListEnabledRules(context.Context, string) ([]rules.Rule, error) {
+	return []rules.Rule{{ID: "r1", Definition: json.RawMessage(`{"type":"no_cycles"}`)}}, nil
+}
+
+func (f *fakeRuleStore) RecordViolations(_ context.Context, _ string, v []rules.Violation) ([]string, error) {
+	ids := make([]string, len(v))
+	for i := range v {
+		ids[i] = fmt.Sprintf("drift-%d", len(f.recorded)+i)
+	}
+	f.recorded = append(f.recorded, v...)
+	return ids, nil
+}
+
+func TestRuleEngineStage(t *testing.T) {
+	cyclic := &rules.Graph{Edges: []rules.Edge{{Source: "a", Target: "b"}, {Source: "b", Target: "a"}}}
+	tests := []struct {
+		name       string
+		graph      *rules.Graph
+		commit     string
+		status     PipelineStatus
+		violations int
+	}{
+		{"graph recorded", cyclic, "abc1234", StatusCompleted, 1},
+		{"no graph recorded", nil, "abc1234", StatusSkipped, 0},
+		{"run without commit", cyclic, "", StatusSkipped, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			retry := resilience.DefaultRetryConfig()
+			retry.InitialDelay = 0
+			o, finished := newTestOrchestrator(t, nil)
+			ordered, err := validateStages([]StageSpec{
+				{Name: StageRuleEngine, Run: o.stageRuleEngine, Retry: &retry, Critical: true},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			o.stages = ordered
+			rs := &fakeRuleStore{graph: tt.graph}
+			o.UseRuleStore(rs)
+
+			started, err := o.StartPipeline(context.Background(), "repo", "org", tt.commit, "main", RunOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			run := waitFinished(t, finished, started.ID)
+
+			if run.Status != StatusCompleted {
+				t.Fatalf("run %s, want completed", run.Status)
+			}
+			stage := run.Stages[0]
+			if stage.Status != tt.status {
+				t.Fatalf("rule stage %s, want %s", stage.Status, tt.status)
+			}
+			if stage.Attempts != 1 {
+				t.Fatalf("rule stage ran %d times, want once", stage.Attempts)
+			}
+			if tt.status == StatusSkipped {
+				out, _ := stage.Output.(map[string]interface{})
+				if reason, _ := out["skipped_reason"].(string); reason == "" {
+					t.Fatalf("skipped stage has no skipped_reason: %v", stage.Output)
+				}
+			}
+			if len(rs.recorded) != tt.violations {
+				t.Fatalf("recorded %d violations, want %d", len(rs.recorded), tt.violations)
+			}
+		})
+	}
+}
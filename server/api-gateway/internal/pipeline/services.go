@@ -0,0 +1,266 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/downstream"
+	"github.com/archlens/api-gateway/internal/resilience"
+	"go.uber.org/zap"
+)
+
+// services are the clients stages call downstream services through. They
+// retry on their own, so stages using them have no stage-level Retry. A nil
+// client means the service is not configured and its stage is skipped.
+type services struct {
+	cognitive *downstream.Client
+	citadel   *downstream.Client
+	vault     *downstream.Client
+	audit     *downstream.Client
+}
+
+func newServices(endpoints ServiceEndpoints, breakers *resilience.SharedBreakers, logger *zap.SugaredLogger) services {
+	client := func(name, url string, tune func(cfg *downstream.Config)) *downstream.Client {
+		if url == "" {
+			return nil
+		}
+		cfg := downstream.DefaultConfig(name, url)
+		cfg.Breaker.Shared = breakers
+		if tune != nil {
+			tune(&cfg)
+		}
+		return downstream.New(cfg, logger)
+	}
+
+	return services{
+		// Model calls are slow and few may run at once
+		cognitive: client("cognitive", endpoints.CognitiveURL, func(cfg *downstream.Config) {
+			cfg.Timeout = 2 * time.Minute
+			cfg.MaxConcurrent = 8
+			cfg.MaxWait = 30 * time.Second
+			cfg.Retry = resilience.DefaultRetryConfig()
+			cfg.Breaker.SlowCallDuration = time.Minute
+		}),
+		citadel: client("citadel", endpoints.CitadelURL, nil),
+		vault: client("vault", endpoints.VaultURL, func(cfg *downstream.Config) {
+			cfg.Timeout = 10 * time.Second
+			cfg.Breaker.SlowCallDuration = 5 * time.Second
+		}),
+		audit: client("audit", endpoints.AuditURL, nil),
+	}
+}
+
+// stageKey identifies one stage of one run, so a service that honours
+// Idempotency-Key performs a retried call only once
+func stageKey(run *PipelineRun, stage Stage) string {
+	return run.ID + ":" + string(stage)
+}
+
+// notConfigured skips a stage whose service has no endpoint configured
+func notConfigured(service string) *SkipStage {
+	return &SkipStage{Reason: service + " service not configured"}
+}
+
+func (o *Orchestrator) stageAIAnalysis(ctx context.Context, run *PipelineRun) (interface{}, error) {
+	if o.services.cognitive == nil {
+		return nil, notConfigured("cognitive")
+	}
+
+	var out struct {
+		AnalysisID   string `json:"analysis_id"`
+		Status       string `json:"status"`
+		AnalysisType string `json:"analysis_type"`
+	}
+	req := &downstream.Request{
+		Method: http.MethodPost,
+		Path:   "/api/v1/analysis/trigger",
+		Body: map[string]interface{}{
+			"repo_id":       run.RepoID,
+			"commit_sha":    run.CommitSHA,
+			"branch":        run.Branch,
+			"analysis_type": "comprehensive",
+		},
+		IdempotencyKey: stageKey(run, StageAIAnalysis),
+	}
+	if err := o.services.cognitive.JSON(ctx, req, &out); err != nil {
+		err = o.deadLetter(ctx, run, StageAIAnalysis, TopicAnalysisRequested, o.services.cognitive, req, err)
+		return nil, fmt.Errorf("failed to trigger cognitive analysis: %w", err)
+	}
+	return map[string]interface{}{
+		"analysis_id":   out.AnalysisID,
+		"status":        out.Status,
+		"analysis_type": out.AnalysisType,
+	}, nil
+}
+
+func (o *Orchestrator) stageAuditTrail(ctx context.Context, run *PipelineRun) (interface{}, error) {
+	if o.services.audit == nil {
+		return nil, notConfigured("audit")
+	}
+	if o.ruleStore == nil {
+		return nil, &SkipStage{Reason: "rule store not configured"}
+	}
+
+	graph, err := o.loadDependencyGraph(ctx, run)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load dependency graph: %w", err)
+	}
+
+	type edge struct {
+		SourcePath string  `json:"sourcePath"`
+		TargetPath string  `json:"targetPath"`
+		DepType    string  `json:"depType"`
+		Weight     float64 `json:"weight"`
+	}
+	edges := make([]edge, 0, len(graph.Edges))
+	for _, e := range graph.Edges {
+		edges = append(edges, edge{SourcePath: e.Source, TargetPath: e.Target, DepType: e.Type, Weight: 1})
+	}
+
+	// Ingestion merges edges into the audit graph, so repeating it is harmless
+	req := &downstream.Request{
+		Method: http.MethodPost,
+		Path:   "/api/v1/audit/dependencies/ingest",
+		Body: map[string]interface{}{
+			"repoId":     run.RepoID,
+			"analysisId": run.ID,
+			"edges":      edges,
+		},
+		IdempotencyKey: stageKey(run, StageAuditTrail),
+	}
+	if err := o.services.audit.JSON(ctx, req, nil); err != nil {
+		err = o.deadLetter(ctx, run, StageAuditTrail, TopicAuditIngest, o.services.audit, req, err)
+		return nil, fmt.Errorf("failed to record dependency graph in audit trail: %w", err)
+	}
+	return map[string]interface{}{"files": len(graph.Files), "dependency_edges": len(edges)}, nil
+}
+
+// ledgerEntry is the digest of a run signed into the sovereign ledger
+type ledgerEntry struct {
+	PipelineID string           `json:"pipeline_id"`
+	OrgID      string           `json:"org_id"`
+	RepoID     string           `json:"repo_id"`
+	CommitSHA  string           `json:"commit_sha"`
+	Branch     string           `json:"branch"`
+	Stages     map[Stage]string `json:"stages"`
+	Outputs    map[Stage]string `json:"output_digests"`
+}
+
+func (o *Orchestrator) stageLedger(ctx context.Context, run *PipelineRun) (interface{}, error) {
+	if o.services.vault == nil {
+		return nil, notConfigured("vault")
+	}
+
+	o.mu.RLock()
+	snapshot := run.clone()
+	o.mu.RUnlock()
+	entry := ledgerEntry{
+		PipelineID: snapshot.ID,
+		OrgID:      snapshot.OrgID,
+		RepoID:     snapshot.RepoID,
+		CommitSHA:  snapshot.CommitSHA,
+		Branch:     snapshot.Branch,
+		Stages:     map[Stage]string{},
+		Outputs:    map[Stage]string{},
+	}
+	for _, s := range snapshot.Stages {
+		entry.Stages[s.Stage] = string(s.Status)
+		if s.Output != nil {
+			raw, err := json.Marshal(s.Output)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode %s output: %w", s.Stage, err)
+			}
+			sum := sha256.Sum256(raw)
+			entry.Outputs[s.Stage] = hex.EncodeToString(sum[:])
+		}
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode ledger entry: %w", err)
+	}
+	digest := sha256.Sum256(data)
+
+	var out struct {
+		Signature string `json:"signature"`
+		PublicKey string `json:"public_key"`
+	}
+	// Signing is deterministic, so it is safe to repeat
+	req := &downstream.Request{
+		Method:         http.MethodPost,
+		Path:           "/api/v1/sign",
+		Body:           map[string]string{"data": string(data)},
+		IdempotencyKey: stageKey(run, StageLedger),
+	}
+	if err := o.services.vault.JSON(ctx, req, &out); err != nil {
+		err = o.deadLetter(ctx, run, StageLedger, TopicLedgerSign, o.services.vault, req, err)
+		return nil, fmt.Errorf("failed to sign ledger entry: %w", err)
+	}
+	return map[string]interface{}{
+		"ledger_hash": hex.EncodeToString(digest[:]),
+		"signature":   out.Signature,
+		"public_key":  out.PublicKey,
+	}, nil
+}
+
+func (o *Orchestrator) stageSecurityAlerts(ctx context.Context, run *PipelineRun) (interface{}, error) {
+	if o.services.citadel == nil {
+		return nil, notConfigured("citadel")
+	}
+
+	var out struct {
+		ScanID string `json:"scan_id"`
+		Status string `json:"status"`
+	}
+	req := &downstream.Request{
+		Method: http.MethodPost,
+		Path:   "/api/v1/drift/scan",
+		Body: map[string]string{
+			"repo_id":    run.RepoID,
+			"commit_sha": run.CommitSHA,
+			"branch":     run.Branch,
+		},
+		IdempotencyKey: stageKey(run, StageSecAlerts),
+	}
+	if err := o.services.citadel.JSON(ctx, req, &out); err != nil {
+		err = o.deadLetter(ctx, run, StageSecAlerts, TopicDriftScan, o.services.citadel, req, err)
+		return nil, fmt.Errorf("failed to start drift scan: %w", err)
+	}
+	return map[string]interface{}{"scan_id": out.ScanID, "status": out.Status}, nil
+}
+
+func (o *Orchestrator) stageInsights(ctx context.Context, run *PipelineRun) (interface{}, error) {
+	if o.services.cognitive == nil {
+		return nil, notConfigured("cognitive")
+	}
+
+	var out struct {
+		HealthScore float64            `json:"health_score"`
+		Dimensions  map[string]float64 `json:"dimensions"`
+		Trend       string             `json:"trend"`
+	}
+	// Scoring only reads, so it is safe to repeat
+	req := &downstream.Request{
+		Method: http.MethodPost,
+		Path:   "/api/v1/analysis/health-score",
+		Body: map[string]interface{}{
+			"repo_id":    run.RepoID,
+			"commit_sha": run.CommitSHA,
+			"branch":     run.Branch,
+		},
+		IdempotencyKey: stageKey(run, StageInsights),
+	}
+	if err := o.services.cognitive.JSON(ctx, req, &out); err != nil {
+		err = o.deadLetter(ctx, run, StageInsights, TopicHealthScore, o.services.cognitive, req, err)
+		return nil, fmt.Errorf("failed to compute health score: %w", err)
+	}
+	return map[string]interface{}{
+		"health_score": out.HealthScore,
+		"dimensions":   out.Dimensions,
+		"trend":        out.Trend,
+	}, nil
+}
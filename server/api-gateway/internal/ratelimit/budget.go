@@ -0,0 +1,80 @@
+// Package ratelimit implements token-bucket rate limiting shared by all
+// gateway replicas through Redis. Buckets are keyed by organization, or by
+// API key for key-authenticated callers, and sized by the organization's
+// plan and the class of route being called.
+package ratelimit
+
+import (
+	"fmt"
+	"time"
+)
+
+// Class groups routes sharing a budget. Every authenticated request spends
+// from the default budget; expensive routes also spend from their own.
+type Class string
+
+const (
+	ClassDefault Class = "default"
+	ClassAnalyze Class = "analyze"
+	ClassPhantom Class = "phantom"
+	// ClassPublic limits unauthenticated routes per client IP
+	ClassPublic Class = "public"
+)
+
+// Budget allows Limit requests per Window. The bucket holds at most Limit
+// tokens and refills continuously, so a full budget can be spent in a burst.
+type Budget struct {
+	Limit  int
+	Window time.Duration
+}
+
+// policy renders the budget as a RateLimit-Policy header value
+func (b Budget) policy() string {
+	return fmt.Sprintf("%d;w=%d", b.Limit, int(b.Window.Seconds()))
+}
+
+// Budgets maps plan and class to a budget
+type Budgets map[string]map[Class]Budget
+
+// publicBudget applies to unauthenticated routes regardless of plan
+var publicBudget = Budget{Limit: 30, Window: time.Minute}
+
+// DefaultBudgets are the budgets per organization plan
+func DefaultBudgets() Budgets {
+	return Budgets{
+		"starter": {
+			ClassDefault: {Limit: 120, Window: time.Minute},
+			ClassAnalyze: {Limit: 20, Window: time.Hour},
+			ClassPhantom: {Limit: 10, Window: time.Hour},
+		},
+		"pro": {
+			ClassDefault: {Limit: 600, Window: time.Minute},
+			ClassAnalyze: {Limit: 100, Window: time.Hour},
+			ClassPhantom: {Limit: 60, Window: time.Hour},
+		},
+		"team": {
+			ClassDefault: {Limit: 1200, Window: time.Minute},
+			ClassAnalyze: {Limit: 300, Window: time.Hour},
+			ClassPhantom: {Limit: 200, Window: time.Hour},
+		},
+		"enterprise": {
+			ClassDefault: {Limit: 3000, Window: time.Minute},
+			ClassAnalyze: {Limit: 1000, Window: time.Hour},
+			ClassPhantom: {Limit: 600, Window: time.Hour},
+		},
+	}
+}
+
+// budget returns the budget for a plan and class, falling back to the
+// starter plan for unknown plans
+func (b Budgets) budget(plan string, class Class) (Budget, bool) {
+	if class == ClassPublic {
+		return publicBudget, true
+	}
+	classes, ok := b[plan]
+	if !ok {
+		classes = b["starter"]
+	}
+	budget, ok := classes[class]
+	return budget, ok
+}
@@ -0,0 +1,232 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	keyPrefix = "archlens:ratelimit:"
+	planTTL   = 5 * time.Minute
+	// redisTimeout keeps a slow Redis from adding much latency; on timeout
+	// the request is let through
+	redisTimeout = 50 * time.Millisecond
+)
+
+// tokenBucket takes cost tokens from the bucket at KEYS[1] if available.
+// Time comes from the Redis server so replicas with skewed clocks agree.
+//
+//	ARGV: capacity, refill rate in tokens per millisecond, cost
+//	returns: allowed (0/1), remaining tokens, ms until a retry can succeed,
+//	         ms until the bucket is full again
+var tokenBucket = redis.NewScript(`
+redis.replicate_commands()
+local capacity = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+  tokens = capacity
+  ts = now
+end
+if now > ts then
+  tokens = math.min(capacity, tokens + (now - ts) * rate)
+  ts = now
+end
+
+local allowed = 0
+local retry = 0
+if tokens >= cost then
+  tokens = tokens - cost
+  allowed = 1
+else
+  retry = math.ceil((cost - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', ts)
+local reset = math.ceil((capacity - tokens) / rate)
+redis.call('PEXPIRE', KEYS[1], reset + 1000)
+return {allowed, math.floor(tokens), retry, reset}
+`)
+
+// PlanLookup returns an organization's plan
+type PlanLookup interface {
+	OrganizationPlan(ctx context.Context, orgID string) (string, error)
+}
+
+type cachedPlan struct {
+	plan    string
+	expires time.Time
+}
+
+// Limiter enforces budgets with token buckets in Redis. It fails open: if
+// Redis is unreachable requests are allowed and a warning is logged.
+type Limiter struct {
+	rdb     *redis.Client
+	plans   PlanLookup
+	budgets Budgets
+	logger  *zap.SugaredLogger
+
+	mu        sync.Mutex
+	planCache map[string]cachedPlan
+
+	lastWarning atomic.Int64
+}
+
+// NewLimiter returns a limiter over rdb, which must have
+// ContextTimeoutEnabled for the limiter to fail open within redisTimeout
+func NewLimiter(rdb *redis.Client, plans PlanLookup, budgets Budgets, logger *zap.SugaredLogger) *Limiter {
+	return &Limiter{
+		rdb:       rdb,
+		plans:     plans,
+		budgets:   budgets,
+		logger:    logger,
+		planCache: make(map[string]cachedPlan),
+	}
+}
+
+// Result is the outcome of spending from a bucket
+type Result struct {
+	Allowed    bool
+	Budget     Budget
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// Take spends one token from the bucket identified by key
+func (l *Limiter) Take(ctx context.Context, key string, budget Budget) (Result, error) {
+	rate := float64(budget.Limit) / float64(budget.Window.Milliseconds())
+	vals, err := tokenBucket.Run(ctx, l.rdb, []string{keyPrefix + key},
+		budget.Limit, strconv.FormatFloat(rate, 'g', -1, 64), 1).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	return Result{
+		Allowed:    vals[0] == 1,
+		Budget:     budget,
+		Remaining:  int(vals[1]),
+		RetryAfter: time.Duration(vals[2]) * time.Millisecond,
+		Reset:      time.Duration(vals[3]) * time.Millisecond,
+	}, nil
+}
+
+// Limit returns middleware spending from the class budget of the caller's
+// plan. It must run after authentication. API key callers have a bucket per
+// key; users share their organization's bucket.
+func (l *Limiter) Limit(class Class) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID, _ := c.Locals("org_id").(string)
+		if orgID == "" {
+			return c.Next()
+		}
+		plan, err := l.plan(c.UserContext(), orgID)
+		if err != nil {
+			l.warn("failed to look up organization plan", err)
+			plan = ""
+		}
+		budget, ok := l.budgets.budget(plan, class)
+		if !ok {
+			return c.Next()
+		}
+
+		key := "org:" + orgID
+		if keyID, ok := c.Locals("api_key_id").(string); ok {
+			key = "key:" + keyID
+		}
+		return l.enforce(c, key+":"+string(class), budget)
+	}
+}
+
+// LimitByIP returns middleware for unauthenticated routes, with one bucket
+// per client IP
+func (l *Limiter) LimitByIP() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return l.enforce(c, "ip:"+c.IP()+":"+string(ClassPublic), publicBudget)
+	}
+}
+
+func (l *Limiter) enforce(c *fiber.Ctx, key string, budget Budget) error {
+	ctx, cancel := context.WithTimeout(c.UserContext(), redisTimeout)
+	res, err := l.Take(ctx, key, budget)
+	cancel()
+	if err != nil {
+		l.warn("rate limiter unavailable, allowing request", err)
+		return c.Next()
+	}
+
+	setHeaders(c, res)
+	if !res.Allowed {
+		retryAfter := int(math.Ceil(res.RetryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error":       "rate limit exceeded",
+			"message":     "request budget exhausted; retry after " + strconv.Itoa(retryAfter) + "s",
+			"limit":       budget.Limit,
+			"window":      budget.Window.String(),
+			"retry_after": retryAfter,
+		})
+	}
+	return c.Next()
+}
+
+// setHeaders writes the RateLimit-* headers of the IETF ratelimit-headers
+// draft. A request may spend from several buckets; the headers describe
+// whichever has the fewest tokens left.
+func setHeaders(c *fiber.Ctx, res Result) {
+	if prev, ok := c.Locals("ratelimit_remaining").(int); ok && prev <= res.Remaining && res.Allowed {
+		return
+	}
+	c.Locals("ratelimit_remaining", res.Remaining)
+	c.Set("RateLimit-Limit", strconv.Itoa(res.Budget.Limit))
+	c.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	c.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+	c.Set("RateLimit-Policy", res.Budget.policy())
+}
+
+// plan returns an organization's plan, cached for planTTL
+func (l *Limiter) plan(ctx context.Context, orgID string) (string, error) {
+	now := time.Now()
+	l.mu.Lock()
+	cached, ok := l.planCache[orgID]
+	l.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.plan, nil
+	}
+
+	plan, err := l.plans.OrganizationPlan(ctx, orgID)
+	if err != nil {
+		return "", err
+	}
+	l.mu.Lock()
+	if len(l.planCache) > 10000 {
+		l.planCache = make(map[string]cachedPlan)
+	}
+	l.planCache[orgID] = cachedPlan{plan: plan, expires: now.Add(planTTL)}
+	l.mu.Unlock()
+	return plan, nil
+}
+
+// warn logs limiter failures at most once a minute, since an outage would
+// otherwise log on every request
+func (l *Limiter) warn(msg string, err error) {
+	now := time.Now().UnixNano()
+	last := l.lastWarning.Load()
+	if now-last < int64(time.Minute) || !l.lastWarning.CompareAndSwap(last, now) {
+		return
+	}
+	l.logger.Warnw(msg, "error", err)
+}
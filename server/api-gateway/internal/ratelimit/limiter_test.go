@@ -0,0 +1,249 @@
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+type fakePlans map[string]string
+
+func (p fakePlans) OrganizationPlan(ctx context.Context, orgID string) (string, error) {
+	return p[orgID], nil
+}
+
+func newTestLimiter(t *testing.T, addr string, plans PlanLookup, budgets Budgets) *Limiter {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: addr, ContextTimeoutEnabled: true})
+	t.Cleanup(func() { rdb.Close() })
+	return NewLimiter(rdb, plans, budgets, zap.NewNop().Sugar())
+}
+
+// limitedApp serves 200 behind l.Limit(class) to callers identified by the
+// X-Org and X-Key headers
+func limitedApp(l *Limiter, class Class) *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("org_id", c.Get("X-Org"))
+		if key := c.Get("X-Key"); key != "" {
+			c.Locals("api_key_id", key)
+		}
+		return c.Next()
+	})
+	app.Get("/", l.Limit(class), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	return app
+}
+
+func call(t *testing.T, app *fiber.App, org, key string) (int, map[string]string) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Org", org)
+	if key != "" {
+		req.Header.Set("X-Key", key)
+	}
+	resp, err := app.Test(req, 5000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := map[string]string{}
+	for _, h := range []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"} {
+		headers[h] = resp.Header.Get(h)
+	}
+	return resp.StatusCode, headers
+}
+
+func TestTakeRefillsContinuously(t *testing.T) {
+	ctx := context.Background()
+	mr := miniredis.RunT(t)
+	l := newTestLimiter(t, mr.Addr(), fakePlans{}, DefaultBudgets())
+	budget := Budget{Limit: 5, Window: time.Second}
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	mr.SetTime(start)
+
+	// A full bucket can be spent in a burst
+	for i := 4; i >= 0; i-- {
+		res, err := l.Take(ctx, "burst", budget)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed || res.Remaining != i {
+			t.Fatalf("take %d: allowed %v, remaining %d", 5-i, res.Allowed, res.Remaining)
+		}
+	}
+	res, err := l.Take(ctx, "burst", budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.RetryAfter != 200*time.Millisecond || res.Reset != time.Second {
+		t.Fatalf("empty bucket: %+v, want denied with retry after 200ms and reset 1s", res)
+	}
+
+	// A token refills every 200ms
+	mr.SetTime(start.Add(400 * time.Millisecond))
+	for i := 0; i < 2; i++ {
+		if res, _ := l.Take(ctx, "burst", budget); !res.Allowed {
+			t.Fatalf("refilled take %d denied", i+1)
+		}
+	}
+	if res, _ := l.Take(ctx, "burst", budget); res.Allowed {
+		t.Fatal("took more tokens than refilled")
+	}
+
+	// Idle time refills up to the capacity only
+	mr.SetTime(start.Add(time.Hour))
+	if res, _ := l.Take(ctx, "burst", budget); !res.Allowed || res.Remaining != 4 {
+		t.Fatalf("after idling: %+v, want 4 remaining", res)
+	}
+}
+
+func TestLimitSpendsThePlanBudget(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	budgets := Budgets{
+		"starter": {ClassAnalyze: {Limit: 1, Window: time.Hour}},
+		"pro":     {ClassAnalyze: {Limit: 3, Window: time.Hour}},
+	}
+	plans := fakePlans{"org-pro": "pro", "org-starter": "starter", "org-unknown": "legacy"}
+	app := limitedApp(newTestLimiter(t, mr.Addr(), plans, budgets), ClassAnalyze)
+
+	tests := []struct {
+		org, key string
+		allowed  int
+	}{
+		{"org-pro", "", 3},
+		{"org-starter", "", 1},
+		// Unknown plans get the starter budget
+		{"org-unknown", "", 1},
+		// API keys have a bucket of their own
+		{"org-starter", "key-1", 1},
+		{"org-starter", "key-2", 1},
+	}
+	for _, tt := range tests {
+		for i := 0; i < tt.allowed; i++ {
+			if status, _ := call(t, app, tt.org, tt.key); status != fiber.StatusOK {
+				t.Fatalf("%s/%s: request %d got %d", tt.org, tt.key, i+1, status)
+			}
+		}
+		if status, _ := call(t, app, tt.org, tt.key); status != fiber.StatusTooManyRequests {
+			t.Errorf("%s/%s: request %d got %d, want 429", tt.org, tt.key, tt.allowed+1, status)
+		}
+	}
+
+	// Callers without an organization are not limited by Limit
+	if status, _ := call(t, app, "", ""); status != fiber.StatusOK {
+		t.Errorf("anonymous request got %d", status)
+	}
+}
+
+func TestLimitHeaders(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+	budgets := Budgets{"starter": {ClassDefault: {Limit: 2, Window: time.Minute}}}
+	app := limitedApp(newTestLimiter(t, mr.Addr(), fakePlans{}, budgets), ClassDefault)
+
+	status, headers := call(t, app, "org-a", "")
+	want := map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "1",
+		"RateLimit-Reset":     "30",
+		"RateLimit-Policy":    "2;w=60",
+		"Retry-After":         "",
+	}
+	if status != fiber.StatusOK {
+		t.Fatalf("status = %d", status)
+	}
+	for h, v := range want {
+		if headers[h] != v {
+			t.Errorf("allowed: %s = %q, want %q", h, headers[h], v)
+		}
+	}
+
+	call(t, app, "org-a", "")
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Org", "org-a")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", resp.StatusCode)
+	}
+	want = map[string]string{
+		"RateLimit-Limit":     "2",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+		"RateLimit-Policy":    "2;w=60",
+		"Retry-After":         "30",
+	}
+	for h, v := range want {
+		if got := resp.Header.Get(h); got != v {
+			t.Errorf("denied: %s = %q, want %q", h, got, v)
+		}
+	}
+	var body struct {
+		Error      string `json:"error"`
+		Limit      int    `json:"limit"`
+		Window     string `json:"window"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != "rate limit exceeded" || body.Limit != 2 || body.Window != "1m0s" || body.RetryAfter != 30 {
+		t.Errorf("body = %+v", body)
+	}
+}
+
+func TestLimitFailsOpen(t *testing.T) {
+	// A Redis accepting connections but never answering
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				for _, c := range conns {
+					c.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	mr := miniredis.RunT(t)
+	down := mr.Addr()
+	mr.Close()
+
+	for name, addr := range map[string]string{"hanging": ln.Addr().String(), "down": down} {
+		t.Run(name, func(t *testing.T) {
+			budgets := Budgets{"starter": {ClassDefault: {Limit: 1, Window: time.Hour}}}
+			app := limitedApp(newTestLimiter(t, addr, fakePlans{}, budgets), ClassDefault)
+			for i := 0; i < 3; i++ {
+				start := time.Now()
+				status, headers := call(t, app, "org-a", "")
+				if status != fiber.StatusOK {
+					t.Fatalf("request %d got %d", i+1, status)
+				}
+				if headers["RateLimit-Limit"] != "" {
+					t.Errorf("request %d has rate limit headers %v", i+1, headers)
+				}
+				if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+					t.Errorf("request %d waited %s for Redis", i+1, elapsed)
+				}
+			}
+		})
+	}
+}
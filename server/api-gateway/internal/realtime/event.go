@@ -0,0 +1,64 @@
+package realtime
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Topic prefixes clients may subscribe to. Concrete topics carry an ID
+// suffix, e.g. "pipeline:<runId>", "drift:<repoId>", "metrics:<repoId>".
+const (
+	TopicPipeline = "pipeline"
+	TopicDrift    = "drift"
+	TopicMetrics  = "metrics"
+)
+
+var knownTopics = map[string]bool{TopicPipeline: true, TopicDrift: true, TopicMetrics: true}
+
+// Event is a single notification delivered to subscribed clients
+type Event struct {
+	ID        string          `json:"id"`
+	OrgID     string          `json:"-"`
+	Topic     string          `json:"topic"`
+	Type      string          `json:"event"`
+	Data      json.RawMessage `json:"data"`
+	Timestamp time.Time       `json:"ts"`
+}
+
+// NewEvent builds an event for an organization; the ID is assigned by the EventLog
+func NewEvent(orgID, topic, eventType string, data interface{}) (Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return Event{}, fmt.Errorf("failed to encode event data: %w", err)
+	}
+	return Event{
+		OrgID:     orgID,
+		Topic:     topic,
+		Type:      eventType,
+		Data:      raw,
+		Timestamp: time.Now().UTC(),
+	}, nil
+}
+
+// ValidateTopic checks a subscription pattern. Patterns are either a concrete
+// topic ("pipeline:<id>") or a prefix wildcard ("pipeline:*").
+func ValidateTopic(pattern string) error {
+	prefix, id, ok := strings.Cut(pattern, ":")
+	if !ok || id == "" {
+		return fmt.Errorf("topic %q must look like <kind>:<id> or <kind>:*", pattern)
+	}
+	if !knownTopics[prefix] {
+		return fmt.Errorf("unknown topic kind %q", prefix)
+	}
+	return nil
+}
+
+// matchTopic reports whether a topic matches a subscription pattern
+func matchTopic(pattern, topic string) bool {
+	if strings.HasSuffix(pattern, ":*") {
+		return strings.HasPrefix(topic, strings.TrimSuffix(pattern, "*"))
+	}
+	return pattern == topic
+}
@@ -0,0 +1,181 @@
+package realtime
+
+import (
+	"context"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// clientBuffer is how many undelivered events a client may fall behind by
+// before it is disconnected; it can resume from its last event ID
+const clientBuffer = 256
+
+// replayLimit caps how many missed events are replayed on resume
+const replayLimit = 1000
+
+// Hub fans events out to connected clients subscribed to matching topics.
+// Events are scoped to the organization they were published for.
+type Hub struct {
+	logger  *zap.SugaredLogger
+	log     EventLog
+	mu      sync.RWMutex
+	clients map[*Client]struct{}
+}
+
+// Client is a single connected subscriber
+type Client struct {
+	OrgID  string
+	events chan Event
+	done   chan struct{}
+	once   sync.Once
+	mu     sync.RWMutex
+	topics map[string]bool
+}
+
+func NewHub(logger *zap.SugaredLogger, log EventLog) *Hub {
+	return &Hub{
+		logger:  logger,
+		log:     log,
+		clients: make(map[*Client]struct{}),
+	}
+}
+
+// Run delivers events appended to the log to local clients until ctx is done
+func (h *Hub) Run(ctx context.Context) {
+	h.log.Tail(ctx, h.dispatch)
+}
+
+// Publish appends an event to the log; it reaches clients through Run
+func (h *Hub) Publish(ctx context.Context, orgID, topic, eventType string, data interface{}) {
+	ev, err := NewEvent(orgID, topic, eventType, data)
+	if err == nil {
+		_, err = h.log.Append(ctx, ev)
+	}
+	if err != nil {
+		h.logger.Warnw("failed to publish realtime event", "topic", topic, "event", eventType, "error", err)
+	}
+}
+
+// Register connects a new client for an organization
+func (h *Hub) Register(orgID string) *Client {
+	c := &Client{
+		OrgID:  orgID,
+		events: make(chan Event, clientBuffer),
+		done:   make(chan struct{}),
+		topics: make(map[string]bool),
+	}
+	h.mu.Lock()
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	return c
+}
+
+// Unregister disconnects a client
+func (h *Hub) Unregister(c *Client) {
+	h.mu.Lock()
+	delete(h.clients, c)
+	h.mu.Unlock()
+	c.close()
+}
+
+// Replay returns the events a client missed after lastID on its current
+// subscriptions. complete is false if some of them are no longer available.
+func (h *Hub) Replay(ctx context.Context, c *Client, lastID string) ([]Event, bool, error) {
+	events, complete, err := h.log.Since(ctx, c.OrgID, lastID, replayLimit)
+	if err != nil {
+		return nil, false, err
+	}
+	matched := events[:0]
+	for _, ev := range events {
+		if c.matches(ev.Topic) {
+			matched = append(matched, ev)
+		}
+	}
+	return matched, complete && len(events) < replayLimit, nil
+}
+
+// ClientCount returns the number of connected clients
+func (h *Hub) ClientCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+func (h *Hub) dispatch(ev Event) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for c := range h.clients {
+		if c.OrgID != ev.OrgID || !c.matches(ev.Topic) {
+			continue
+		}
+		select {
+		case c.events <- ev:
+		default:
+			// Too slow to keep up: drop the connection so the client
+			// reconnects and resumes instead of silently missing events
+			h.logger.Warnw("realtime client too slow, disconnecting", "org_id", c.OrgID)
+			c.close()
+		}
+	}
+}
+
+// Events delivers matching events to the client
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+// Done is closed when the hub drops the client
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Subscribe adds topic patterns to the client's subscriptions
+func (c *Client) Subscribe(patterns ...string) error {
+	for _, p := range patterns {
+		if err := ValidateTopic(p); err != nil {
+			return err
+		}
+	}
+	c.mu.Lock()
+	for _, p := range patterns {
+		c.topics[p] = true
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// Unsubscribe removes topic patterns from the client's subscriptions
+func (c *Client) Unsubscribe(patterns ...string) {
+	c.mu.Lock()
+	for _, p := range patterns {
+		delete(c.topics, p)
+	}
+	c.mu.Unlock()
+}
+
+// Topics returns the client's current subscriptions
+func (c *Client) Topics() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	topics := make([]string, 0, len(c.topics))
+	for t := range c.topics {
+		topics = append(topics, t)
+	}
+	return topics
+}
+
+func (c *Client) matches(topic string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for p := range c.topics {
+		if matchTopic(p, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) close() {
+	c.once.Do(func() { close(c.done) })
+}
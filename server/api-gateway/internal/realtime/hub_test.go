@@ -0,0 +1,242 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/archlens/api-gateway/internal/pipeline"
+)
+
+// newRunningHub returns a hub over an in-memory log of the given size,
+// delivering events until the test ends
+func newRunningHub(t *testing.T, size int) (*Hub, *MemoryLog) {
+	t.Helper()
+	log := NewMemoryLog(size)
+	hub := NewHub(zap.NewNop().Sugar(), log)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go hub.Run(ctx)
+	// Run registers its tailer asynchronously; events appended before
+	// that are only available through Since
+	deadline := time.Now().Add(time.Second)
+	for {
+		log.mu.Lock()
+		tailing := len(log.tailers) > 0
+		log.mu.Unlock()
+		if tailing {
+			return hub, log
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hub is not tailing the log")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// receive returns the next event delivered to c, failing after a second
+func receive(t *testing.T, c *Client) Event {
+	t.Helper()
+	select {
+	case ev := <-c.Events():
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no event delivered")
+		return Event{}
+	}
+}
+
+// assertNoEvent fails if c receives an event shortly
+func assertNoEvent(t *testing.T, c *Client) {
+	t.Helper()
+	select {
+	case ev := <-c.Events():
+		t.Fatalf("unexpected event %s on %s", ev.Type, ev.Topic)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestValidateTopic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		valid   bool
+	}{
+		{"pipeline:*", true},
+		{"pipeline:3f1c7d2a", true},
+		{"drift:repo-1", true},
+		{"metrics:*", true},
+		{"pipeline", false},
+		{"pipeline:", false},
+		{"builds:*", false},
+		{"*", false},
+	}
+	for _, tt := range tests {
+		if err := ValidateTopic(tt.pattern); (err == nil) != tt.valid {
+			t.Errorf("ValidateTopic(%q) = %v, want valid %v", tt.pattern, err, tt.valid)
+		}
+	}
+}
+
+func TestHubDeliversMatchingEventsOfTheOrganization(t *testing.T) {
+	ctx := context.Background()
+	hub, _ := newRunningHub(t, 0)
+
+	all := hub.Register("org-a")
+	one := hub.Register("org-a")
+	other := hub.Register("org-b")
+	defer hub.Unregister(all)
+	defer hub.Unregister(one)
+	defer hub.Unregister(other)
+	if err := all.Subscribe("pipeline:*", "drift:*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := one.Subscribe("pipeline:run-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Subscribe("pipeline:*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := one.Subscribe("pipeline:run-2", "unknown:*"); err == nil {
+		t.Fatal("subscribed to an unknown topic kind")
+	}
+
+	hub.Publish(ctx, "org-a", "pipeline:run-2", "stage_completed", map[string]string{"stage": "upload"})
+	if ev := receive(t, all); ev.Topic != "pipeline:run-2" || ev.Type != "stage_completed" || ev.ID == "" {
+		t.Fatalf("delivered %+v", ev)
+	}
+	// A rejected subscription adds none of its patterns
+	assertNoEvent(t, one)
+	assertNoEvent(t, other)
+
+	hub.Publish(ctx, "org-a", "pipeline:run-1", "run_finished", nil)
+	receive(t, all)
+	if ev := receive(t, one); ev.Topic != "pipeline:run-1" {
+		t.Fatalf("delivered %+v", ev)
+	}
+	assertNoEvent(t, other)
+
+	one.Unsubscribe("pipeline:run-1")
+	hub.Publish(ctx, "org-a", "pipeline:run-1", "run_finished", nil)
+	receive(t, all)
+	assertNoEvent(t, one)
+}
+
+func TestHubReplaysMissedEvents(t *testing.T) {
+	ctx := context.Background()
+	hub, log := newRunningHub(t, 4)
+
+	c := hub.Register("org-a")
+	defer hub.Unregister(c)
+	if err := c.Subscribe("drift:repo-1"); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := log.Append(ctx, Event{OrgID: "org-a", Topic: "drift:repo-1", Type: "drift_detected"})
+	log.Append(ctx, Event{OrgID: "org-a", Topic: "drift:repo-2", Type: "drift_detected"})
+	log.Append(ctx, Event{OrgID: "org-b", Topic: "drift:repo-1", Type: "drift_detected"})
+	last, _ := log.Append(ctx, Event{OrgID: "org-a", Topic: "drift:repo-1", Type: "drift_detected"})
+
+	events, complete, err := hub.Replay(ctx, c, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !complete || len(events) != 1 || events[0].ID != last {
+		t.Fatalf("replayed %+v, complete %v; want only event %s", events, complete, last)
+	}
+
+	// The log holds 4 events, so resuming from before them is incomplete
+	for i := 0; i < 4; i++ {
+		log.Append(ctx, Event{OrgID: "org-a", Topic: "drift:repo-1", Type: "drift_detected"})
+	}
+	events, complete, err = hub.Replay(ctx, c, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if complete || len(events) != 4 {
+		t.Fatalf("replayed %d events, complete %v; want 4, incomplete", len(events), complete)
+	}
+}
+
+func TestHubDropsSlowClients(t *testing.T) {
+	ctx := context.Background()
+	hub, _ := newRunningHub(t, 0)
+
+	slow := hub.Register("org-a")
+	defer hub.Unregister(slow)
+	if err := slow.Subscribe("metrics:*"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= clientBuffer; i++ {
+		hub.Publish(ctx, "org-a", "metrics:repo-1", "health_score", i)
+	}
+	select {
+	case <-slow.Done():
+	case <-time.After(time.Second):
+		t.Fatal("client falling behind by more than its buffer was not dropped")
+	}
+}
+
+func TestPublishStage(t *testing.T) {
+	ctx := context.Background()
+	hub, log := newRunningHub(t, 0)
+	run := &pipeline.PipelineRun{ID: "run-1", OrgID: "org-a", RepoID: "repo-1", CommitSHA: "abc1234", Status: pipeline.StatusRunning}
+
+	stages := []pipeline.StageResult{
+		{Stage: pipeline.StageRuleEngine, Status: pipeline.StatusCompleted, Output: map[string]interface{}{
+			"violations": 2, "new_drift_events": 1, "drift_event_ids": []string{"drift-1"},
+		}},
+		{Stage: pipeline.StageInsights, Status: pipeline.StatusCompleted, Output: map[string]interface{}{"health_score": 87.5}},
+		{Stage: pipeline.StageInsights, Status: pipeline.StatusFailed, Error: "cognitive unavailable"},
+		{Stage: pipeline.StageUpload, Status: pipeline.StatusCompleted},
+	}
+	for _, stage := range stages {
+		publishStage(ctx, hub, run, stage)
+	}
+
+	events, _, err := log.Since(ctx, "org-a", "0", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type published struct{ topic, event string }
+	want := []published{
+		{"pipeline:run-1", "stage_completed"},
+		{"drift:repo-1", "drift_detected"},
+		{"metrics:repo-1", "rule_evaluation"},
+		{"pipeline:run-1", "stage_completed"},
+		{"metrics:repo-1", "health_score"},
+		{"pipeline:run-1", "stage_completed"},
+		{"pipeline:run-1", "stage_completed"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("published %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, ev := range events {
+		if ev.Topic != want[i].topic || ev.Type != want[i].event {
+			t.Errorf("event %d: %s on %s, want %s on %s", i, ev.Type, ev.Topic, want[i].event, want[i].topic)
+		}
+	}
+
+	var drift struct {
+		PipelineID    string   `json:"pipeline_id"`
+		DriftEventIDs []string `json:"drift_event_ids"`
+	}
+	if err := json.Unmarshal(events[1].Data, &drift); err != nil {
+		t.Fatal(err)
+	}
+	if drift.PipelineID != "run-1" || len(drift.DriftEventIDs) != 1 || drift.DriftEventIDs[0] != "drift-1" {
+		t.Errorf("drift event data = %s", events[1].Data)
+	}
+	var metrics struct {
+		Metrics struct {
+			HealthScore float64 `json:"health_score"`
+		} `json:"metrics"`
+	}
+	if err := json.Unmarshal(events[4].Data, &metrics); err != nil {
+		t.Fatal(err)
+	}
+	if metrics.Metrics.HealthScore != 87.5 {
+		t.Errorf("health score event data = %s", events[4].Data)
+	}
+}
@@ -0,0 +1,112 @@
+package realtime
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// EventLog stores recent events so reconnecting clients can resume, and
+// delivers every appended event to tailing hubs (on any gateway replica)
+type EventLog interface {
+	// Append stores ev and returns its assigned ID
+	Append(ctx context.Context, ev Event) (string, error)
+	// Since returns an organization's events after lastID, oldest first. complete
+	// is false when events after lastID have already been trimmed from the log.
+	Since(ctx context.Context, orgID, lastID string, limit int) (events []Event, complete bool, err error)
+	// Tail calls fn for every event appended from now on until ctx is done
+	Tail(ctx context.Context, fn func(Event))
+}
+
+// MemoryLog is an in-process EventLog holding the most recent events in a
+// ring buffer. It suits single-replica deployments and tests.
+type MemoryLog struct {
+	mu      sync.Mutex
+	buf     []Event
+	next    int
+	seq     uint64
+	tailers map[chan Event]struct{}
+}
+
+func NewMemoryLog(size int) *MemoryLog {
+	if size <= 0 {
+		size = 10000
+	}
+	return &MemoryLog{
+		buf:     make([]Event, 0, size),
+		tailers: make(map[chan Event]struct{}),
+	}
+}
+
+func (l *MemoryLog) Append(_ context.Context, ev Event) (string, error) {
+	l.mu.Lock()
+	l.seq++
+	ev.ID = strconv.FormatUint(l.seq, 10)
+	if len(l.buf) < cap(l.buf) {
+		l.buf = append(l.buf, ev)
+	} else {
+		l.buf[l.next] = ev
+		l.next = (l.next + 1) % cap(l.buf)
+	}
+	tailers := make([]chan Event, 0, len(l.tailers))
+	for ch := range l.tailers {
+		tailers = append(tailers, ch)
+	}
+	l.mu.Unlock()
+
+	// Tailers only fan out to client buffers, so a full channel means the
+	// tailer is gone or wedged; the event stays available through Since
+	for _, ch := range tailers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+	return ev.ID, nil
+}
+
+func (l *MemoryLog) Since(_ context.Context, orgID, lastID string, limit int) ([]Event, bool, error) {
+	after, err := strconv.ParseUint(lastID, 10, 64)
+	if err != nil {
+		return nil, false, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// oldest holds the sequence number of the first event still buffered
+	oldest := l.seq - uint64(len(l.buf)) + 1
+	complete := after+1 >= oldest
+
+	events := []Event{}
+	for i := 0; i < len(l.buf) && len(events) < limit; i++ {
+		ev := l.buf[(l.next+i)%len(l.buf)]
+		seq, _ := strconv.ParseUint(ev.ID, 10, 64)
+		if seq > after && ev.OrgID == orgID {
+			events = append(events, ev)
+		}
+	}
+	return events, complete, nil
+}
+
+func (l *MemoryLog) Tail(ctx context.Context, fn func(Event)) {
+	ch := make(chan Event, 1024)
+	l.mu.Lock()
+	l.tailers[ch] = struct{}{}
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		delete(l.tailers, ch)
+		l.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-ch:
+			fn(ev)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package realtime
+
+import (
+	"context"
+
+	"github.com/archlens/api-gateway/internal/pipeline"
+)
+
+// metricsEvents names the metrics:<repoId> event published when a stage
+// producing architecture metrics completes
+var metricsEvents = map[pipeline.Stage]string{
+	pipeline.StageRuleEngine: "rule_evaluation",
+	pipeline.StageInsights:   "health_score",
+}
+
+// PublishPipeline forwards the progress of an orchestrator's runs to the hub:
+// stage results and finished runs on pipeline:<runId>, drift events recorded
+// by the rule engine on drift:<repoId>, and the metrics of the rule engine
+// and insights stages on metrics:<repoId>. It must be called before runs
+// start.
+func PublishPipeline(hub *Hub, orch *pipeline.Orchestrator) {
+	orch.OnStageComplete(func(run *pipeline.PipelineRun, stage pipeline.StageResult) {
+		publishStage(context.Background(), hub, run, stage)
+	})
+	orch.OnRunFinished(func(run *pipeline.PipelineRun) {
+		hub.Publish(context.Background(), run.OrgID, TopicPipeline+":"+run.ID, "run_finished", run)
+	})
+}
+
+func publishStage(ctx context.Context, hub *Hub, run *pipeline.PipelineRun, stage pipeline.StageResult) {
+	hub.Publish(ctx, run.OrgID, TopicPipeline+":"+run.ID, "stage_completed", map[string]interface{}{
+		"pipeline_id": run.ID,
+		"repo_id":     run.RepoID,
+		"run_status":  run.Status,
+		"stage":       stage,
+	})
+	if stage.Status != pipeline.StatusCompleted {
+		return
+	}
+	out, _ := stage.Output.(map[string]interface{})
+
+	if ids, _ := out["drift_event_ids"].([]string); len(ids) > 0 {
+		hub.Publish(ctx, run.OrgID, TopicDrift+":"+run.RepoID, "drift_detected", map[string]interface{}{
+			"pipeline_id":     run.ID,
+			"repo_id":         run.RepoID,
+			"commit_sha":      run.CommitSHA,
+			"drift_event_ids": ids,
+		})
+	}
+	if event, ok := metricsEvents[stage.Stage]; ok {
+		hub.Publish(ctx, run.OrgID, TopicMetrics+":"+run.RepoID, event, map[string]interface{}{
+			"pipeline_id": run.ID,
+			"repo_id":     run.RepoID,
+			"commit_sha":  run.CommitSHA,
+			"metrics":     out,
+		})
+	}
+}
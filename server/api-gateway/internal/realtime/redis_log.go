@@ -0,0 +1,165 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const redisEventStream = "archlens:events"
+
+// RedisLog is an EventLog backed by a Redis stream shared by all gateway
+// replicas, so clients receive events regardless of which replica produced
+// them and can resume on any replica after reconnecting
+type RedisLog struct {
+	rdb    *redis.Client
+	logger *zap.SugaredLogger
+	maxLen int64
+}
+
+func NewRedisLog(rdb *redis.Client, logger *zap.SugaredLogger, maxLen int64) *RedisLog {
+	if maxLen <= 0 {
+		maxLen = 100000
+	}
+	return &RedisLog{rdb: rdb, logger: logger, maxLen: maxLen}
+}
+
+func (l *RedisLog) Append(ctx context.Context, ev Event) (string, error) {
+	payload, err := json.Marshal(struct {
+		Event
+		OrgID string `json:"org_id"`
+	}{ev, ev.OrgID})
+	if err != nil {
+		return "", err
+	}
+	return l.rdb.XAdd(ctx, &redis.XAddArgs{
+		Stream: redisEventStream,
+		MaxLen: l.maxLen,
+		Approx: true,
+		Values: map[string]interface{}{"event": payload},
+	}).Result()
+}
+
+func (l *RedisLog) Since(ctx context.Context, orgID, lastID string, limit int) ([]Event, bool, error) {
+	// The stream is shared by all orgs, so scan in pages until enough of
+	// this org's events are collected
+	const page = 500
+	events := []Event{}
+	complete := true
+	if !streamIDPattern.MatchString(lastID) {
+		return events, false, nil
+	}
+
+	first, err := l.rdb.XRangeN(ctx, redisEventStream, "-", "+", 1).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if len(first) > 0 && compareStreamIDs(lastID, first[0].ID) < 0 {
+		complete = false
+	}
+
+	cursor := lastID
+	for len(events) < limit {
+		msgs, err := l.rdb.XRangeN(ctx, redisEventStream, "("+cursor, "+", page).Result()
+		if err != nil {
+			return nil, false, err
+		}
+		for _, msg := range msgs {
+			cursor = msg.ID
+			ev, ok := decodeStreamMessage(msg)
+			if ok && ev.OrgID == orgID {
+				events = append(events, ev)
+				if len(events) == limit {
+					break
+				}
+			}
+		}
+		if len(msgs) < page {
+			break
+		}
+	}
+	return events, complete, nil
+}
+
+func (l *RedisLog) Tail(ctx context.Context, fn func(Event)) {
+	lastID := "$"
+	for ctx.Err() == nil {
+		streams, err := l.rdb.XRead(ctx, &redis.XReadArgs{
+			Streams: []string{redisEventStream, lastID},
+			Count:   256,
+			Block:   5 * time.Second,
+		}).Result()
+		if err != nil {
+			if errors.Is(err, redis.Nil) || ctx.Err() != nil {
+				continue
+			}
+			l.logger.Warnw("failed to read event stream", "error", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		for _, stream := range streams {
+			for _, msg := range stream.Messages {
+				lastID = msg.ID
+				if ev, ok := decodeStreamMessage(msg); ok {
+					fn(ev)
+				}
+			}
+		}
+	}
+}
+
+func decodeStreamMessage(msg redis.XMessage) (Event, bool) {
+	raw, _ := msg.Values["event"].(string)
+	var decoded struct {
+		Event
+		OrgID string `json:"org_id"`
+	}
+	if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+		return Event{}, false
+	}
+	ev := decoded.Event
+	ev.ID = msg.ID
+	ev.OrgID = decoded.OrgID
+	return ev, true
+}
+
+var streamIDPattern = regexp.MustCompile(`^[0-9]+-[0-9]+$`)
+
+// compareStreamIDs orders well-formed Redis stream IDs ("<ms>-<seq>")
+func compareStreamIDs(a, b string) int {
+	ams, aseq := splitStreamID(a)
+	bms, bseq := splitStreamID(b)
+	switch {
+	case ams != bms:
+		if ams < bms {
+			return -1
+		}
+		return 1
+	case aseq < bseq:
+		return -1
+	case aseq > bseq:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func splitStreamID(id string) (uint64, uint64) {
+	var ms, seq uint64
+	i := 0
+	for ; i < len(id) && id[i] != '-'; i++ {
+		ms = ms*10 + uint64(id[i]-'0')
+	}
+	for i++; i < len(id); i++ {
+		seq = seq*10 + uint64(id[i]-'0')
+	}
+	return ms, seq
+}
@@ -0,0 +1,70 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrBulkheadFull = errors.New("bulkhead is full")
+
+// Bulkhead caps the number of concurrent calls to a dependency, so that a
+// slow dependency ties up at most its own slots instead of every goroutine
+// that calls it
+type Bulkhead struct {
+	name    string
+	slots   chan struct{}
+	maxWait time.Duration
+}
+
+// NewBulkhead allows maxConcurrent calls at a time. Callers wait up to
+// maxWait for a slot; zero rejects them immediately when all slots are taken.
+func NewBulkhead(name string, maxConcurrent int, maxWait time.Duration) *Bulkhead {
+	if maxConcurrent <= 0 {
+		maxConcurrent = 10
+	}
+	return &Bulkhead{
+		name:    name,
+		slots:   make(chan struct{}, maxConcurrent),
+		maxWait: maxWait,
+	}
+}
+
+// Acquire takes a slot, returning the function that gives it back
+func (b *Bulkhead) Acquire(ctx context.Context) (func(), error) {
+	release := func() { <-b.slots }
+	select {
+	case b.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if b.maxWait <= 0 {
+		return nil, ErrBulkheadFull
+	}
+
+	timer := time.NewTimer(b.maxWait)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		return nil, ErrBulkheadFull
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// InFlight returns the number of slots taken
+func (b *Bulkhead) InFlight() int {
+	return len(b.slots)
+}
+
+// Stats returns current bulkhead statistics
+func (b *Bulkhead) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"name":           b.name,
+		"in_flight":      len(b.slots),
+		"max_concurrent": cap(b.slots),
+		"max_wait":       b.maxWait.String(),
+	}
+}
@@ -1,6 +1,7 @@
 package resilience
 
 import (
+	"context"
 	"errors"
 	"sync"
 	"time"
@@ -13,7 +14,7 @@ type State int
 
 const (
 	StateClosed   State = iota // Normal — requests flow through
-	StateOpen                   // Tripped — requests fail fast
+	StateOpen                  // Tripped — requests fail fast
 	StateHalfOpen              // Testing — limited requests allowed
 )
 
@@ -30,30 +31,93 @@ func (s State) String() string {
 	}
 }
 
+// WindowType selects what a closed circuit breaker trips on
+type WindowType int
+
+const (
+	WindowConsecutive WindowType = iota // MaxFailures failures in a row
+	WindowCount                         // Failure or slow-call rate of the last WindowSize calls
+	WindowTime                          // Failure or slow-call rate of the calls of the last WindowDuration
+)
+
+func (w WindowType) String() string {
+	switch w {
+	case WindowConsecutive:
+		return "consecutive"
+	case WindowCount:
+		return "count"
+	case WindowTime:
+		return "time"
+	default:
+		return "unknown"
+	}
+}
+
 var (
-	ErrCircuitOpen    = errors.New("circuit breaker is open")
+	ErrCircuitOpen     = errors.New("circuit breaker is open")
 	ErrTooManyFailures = errors.New("too many failures, circuit opened")
 )
 
 // CircuitBreakerConfig configures the circuit breaker behavior
 type CircuitBreakerConfig struct {
-	Name              string
-	MaxFailures       int
-	ResetTimeout      time.Duration
-	HalfOpenMaxCalls  int
-	OnStateChange     func(name string, from, to State)
+	Name string
+	// MaxFailures is the number of failures in a row that trips a
+	// WindowConsecutive breaker
+	MaxFailures  int
+	ResetTimeout time.Duration
+	// HalfOpenMaxCalls is the number of probes let through while half-open.
+	// Any probe failing reopens the breaker; all of them succeeding closes it.
+	HalfOpenMaxCalls int
+
+	Window         WindowType
+	WindowSize     int           // Calls held by a WindowCount breaker
+	WindowDuration time.Duration // Time spanned by a WindowTime breaker
+	// MinimumCalls is the number of calls the window must hold before its
+	// rates are evaluated, so a handful of early failures cannot trip it
+	MinimumCalls int
+	// FailureRateThreshold trips the breaker once this fraction of the calls
+	// in the window failed
+	FailureRateThreshold float64
+	// SlowCallDuration marks calls taking longer as slow, and
+	// SlowCallRateThreshold trips the breaker once this fraction of the calls
+	// in the window were slow. Zero SlowCallDuration ignores call durations.
+	SlowCallDuration      time.Duration
+	SlowCallRateThreshold float64
+
+	// IsFailure decides which errors count against the protected service;
+	// errors it rejects are passed through without being recorded. Defaults
+	// to DefaultIsFailure.
+	IsFailure     func(err error) bool
+	OnStateChange func(name string, from, to State)
+	// Shared, when set, keeps the breaker's state and window in Redis so all
+	// gateway replicas trip together; nil keeps them local
+	Shared *SharedBreakers
+}
+
+// DefaultIsFailure counts every error except the caller cancelling the call,
+// which says nothing about the service
+func DefaultIsFailure(err error) bool {
+	return !errors.Is(err, context.Canceled)
 }
 
 // CircuitBreaker implements the circuit breaker pattern
 type CircuitBreaker struct {
-	config       CircuitBreakerConfig
-	logger       *zap.SugaredLogger
-	mu           sync.Mutex
-	state        State
-	failures     int
-	successes    int
-	lastFailure  time.Time
-	halfOpenCalls int
+	config CircuitBreakerConfig
+	logger *zap.SugaredLogger
+	mu     sync.Mutex
+	state  State
+	// generation changes with every state change; calls admitted in an
+	// earlier generation finish without being recorded
+	generation uint64
+	// sharedGen is the generation of the shared state last seen
+	sharedGen         uint64
+	openedAt          time.Time
+	lastFailure       time.Time
+	failures          int
+	successes         int
+	window            *slidingWindow
+	halfOpenCalls     int
+	halfOpenSuccesses int
 }
 
 func NewCircuitBreaker(cfg CircuitBreakerConfig, logger *zap.SugaredLogger) *CircuitBreaker {
@@ -66,82 +130,197 @@ func NewCircuitBreaker(cfg CircuitBreakerConfig, logger *zap.SugaredLogger) *Cir
 	if cfg.HalfOpenMaxCalls == 0 {
 		cfg.HalfOpenMaxCalls = 3
 	}
+	if cfg.WindowSize <= 0 {
+		cfg.WindowSize = 100
+	}
+	if cfg.WindowDuration <= 0 {
+		cfg.WindowDuration = time.Minute
+	}
+	if cfg.MinimumCalls <= 0 {
+		cfg.MinimumCalls = 10
+	}
+	if cfg.Window == WindowCount && cfg.MinimumCalls > cfg.WindowSize {
+		cfg.MinimumCalls = cfg.WindowSize
+	}
+	if cfg.FailureRateThreshold <= 0 || cfg.FailureRateThreshold > 1 {
+		cfg.FailureRateThreshold = 0.5
+	}
+	if cfg.SlowCallRateThreshold <= 0 || cfg.SlowCallRateThreshold > 1 {
+		cfg.SlowCallRateThreshold = 1
+	}
+	if cfg.IsFailure == nil {
+		cfg.IsFailure = DefaultIsFailure
+	}
 
-	return &CircuitBreaker{
+	cb := &CircuitBreaker{
 		config: cfg,
 		logger: logger,
 		state:  StateClosed,
 	}
+	switch cfg.Window {
+	case WindowCount:
+		cb.window = newCountWindow(cfg.WindowSize)
+	case WindowTime:
+		cb.window = newTimeWindow(cfg.WindowDuration)
+	}
+	if cfg.Shared != nil {
+		cfg.Shared.register(cb)
+	}
+	return cb
 }
 
-// Execute runs the given function through the circuit breaker
-func (cb *CircuitBreaker) Execute(fn func() (interface{}, error)) (interface{}, error) {
-	cb.mu.Lock()
+// admission is a call let through the breaker, with the generations it was
+// admitted in
+type admission struct {
+	generation uint64
+	// shared is set when the shared state admitted the call
+	shared    bool
+	sharedGen uint64
+}
 
-	switch cb.state {
-	case StateOpen:
-		if time.Since(cb.lastFailure) > cb.config.ResetTimeout {
-			cb.transitionTo(StateHalfOpen)
-		} else {
-			cb.mu.Unlock()
-			return nil, ErrCircuitOpen
+// Execute runs fn through the circuit breaker. It refuses the call with
+// ErrCircuitOpen while the breaker is open or every half-open probe is taken,
+// and with the context's error if ctx is already done.
+func (cb *CircuitBreaker) Execute(ctx context.Context, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	a, err := cb.admit()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	result, err := fn(ctx)
+	cb.record(a, err, time.Since(start))
+	return result, err
+}
+
+// admit lets a call through or refuses it with ErrCircuitOpen
+func (cb *CircuitBreaker) admit() (admission, error) {
+	if cb.config.Shared != nil && cb.config.Shared.available() {
+		if a, err, ok := cb.admitShared(); ok {
+			return a, err
 		}
+	}
 
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.currentState(time.Now()) {
+	case StateOpen:
+		return admission{}, ErrCircuitOpen
 	case StateHalfOpen:
 		if cb.halfOpenCalls >= cb.config.HalfOpenMaxCalls {
-			cb.mu.Unlock()
-			return nil, ErrCircuitOpen
+			return admission{}, ErrCircuitOpen
 		}
 		cb.halfOpenCalls++
 	}
+	return admission{generation: cb.generation}, nil
+}
 
-	cb.mu.Unlock()
+// currentState moves an open breaker whose reset timeout has passed to
+// half-open. Callers must hold cb.mu.
+func (cb *CircuitBreaker) currentState(now time.Time) State {
+	if cb.state == StateOpen && now.Sub(cb.openedAt) >= cb.config.ResetTimeout {
+		cb.transitionTo(StateHalfOpen, now)
+	}
+	return cb.state
+}
 
-	result, err := fn()
+// record accounts for the outcome of a call. A call that outlived the state
+// it was admitted in is ignored: a slow call started while closed must not
+// count as a half-open probe.
+func (cb *CircuitBreaker) record(a admission, err error, elapsed time.Duration) {
+	ignored := err != nil && !cb.config.IsFailure(err)
+	failed := err != nil && !ignored
+	slow := cb.config.SlowCallDuration > 0 && elapsed > cb.config.SlowCallDuration
+	if a.shared && cb.config.Shared.available() && cb.recordShared(a, ignored, failed, slow) {
+		return
+	}
 
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
 
-	if err != nil {
-		cb.onFailure()
-		return result, err
+	if a.generation != cb.generation {
+		return
+	}
+	if ignored {
+		// The probe told us nothing, so free its slot for another
+		if cb.state == StateHalfOpen {
+			cb.halfOpenCalls--
+		}
+		return
 	}
 
-	cb.onSuccess()
-	return result, nil
-}
+	now := time.Now()
+	cb.count(failed, now)
 
-func (cb *CircuitBreaker) onSuccess() {
-	cb.failures = 0
-	cb.successes++
+	switch cb.state {
+	case StateHalfOpen:
+		if failed || slow {
+			cb.transitionTo(StateOpen, now)
+			return
+		}
+		cb.halfOpenSuccesses++
+		if cb.halfOpenSuccesses >= cb.config.HalfOpenMaxCalls {
+			cb.transitionTo(StateClosed, now)
+		}
 
-	if cb.state == StateHalfOpen {
-		cb.transitionTo(StateClosed)
+	case StateClosed:
+		if cb.window == nil {
+			if cb.failures >= cb.config.MaxFailures {
+				cb.transitionTo(StateOpen, now)
+			}
+			return
+		}
+		cb.window.record(now, failed, slow)
+		if cb.windowTripped(now) {
+			cb.transitionTo(StateOpen, now)
+		}
 	}
 }
 
-func (cb *CircuitBreaker) onFailure() {
-	cb.failures++
-	cb.lastFailure = time.Now()
-
-	if cb.state == StateHalfOpen {
-		cb.transitionTo(StateOpen)
-		return
+// count tallies a recorded call for Stats
+func (cb *CircuitBreaker) count(failed bool, now time.Time) {
+	if failed {
+		cb.failures++
+		cb.lastFailure = now
+	} else {
+		cb.failures = 0
+		cb.successes++
 	}
+}
 
-	if cb.failures >= cb.config.MaxFailures {
-		cb.transitionTo(StateOpen)
+// windowTripped reports whether the window holds enough calls and too many of
+// them failed or were slow
+func (cb *CircuitBreaker) windowTripped(now time.Time) bool {
+	calls, failures, slow := cb.window.totals(now)
+	if calls == 0 || calls < cb.config.MinimumCalls {
+		return false
 	}
+	if float64(failures)/float64(calls) >= cb.config.FailureRateThreshold {
+		return true
+	}
+	return cb.config.SlowCallDuration > 0 && float64(slow)/float64(calls) >= cb.config.SlowCallRateThreshold
 }
 
-func (cb *CircuitBreaker) transitionTo(newState State) {
+func (cb *CircuitBreaker) transitionTo(newState State, now time.Time) {
 	old := cb.state
 	cb.state = newState
+	cb.generation++
 	cb.halfOpenCalls = 0
+	cb.halfOpenSuccesses = 0
 
-	if newState == StateClosed {
+	switch newState {
+	case StateOpen:
+		cb.openedAt = now
+	case StateClosed:
 		cb.failures = 0
 		cb.successes = 0
+		if cb.window != nil {
+			cb.window.reset()
+		}
 	}
 
 	cb.logger.Infow("circuit breaker state change",
@@ -159,19 +338,50 @@ func (cb *CircuitBreaker) transitionTo(newState State) {
 func (cb *CircuitBreaker) State() State {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
-	return cb.state
+	return cb.currentState(time.Now())
 }
 
 // Stats returns current circuit breaker statistics
 func (cb *CircuitBreaker) Stats() map[string]interface{} {
 	cb.mu.Lock()
 	defer cb.mu.Unlock()
-	return map[string]interface{}{
-		"name":           cb.config.Name,
-		"state":          cb.state.String(),
-		"failures":       cb.failures,
-		"successes":      cb.successes,
-		"max_failures":   cb.config.MaxFailures,
-		"reset_timeout":  cb.config.ResetTimeout.String(),
+
+	now := time.Now()
+	stats := map[string]interface{}{
+		"name":          cb.config.Name,
+		"state":         cb.currentState(now).String(),
+		"window":        cb.config.Window.String(),
+		"failures":      cb.failures,
+		"successes":     cb.successes,
+		"reset_timeout": cb.config.ResetTimeout.String(),
+	}
+	if cb.config.Shared != nil {
+		stats["shared"] = cb.config.Shared.available()
+		stats["shared_generation"] = cb.sharedGen
+	}
+	if cb.state == StateHalfOpen {
+		stats["half_open_probes"] = cb.halfOpenCalls
+		stats["half_open_successes"] = cb.halfOpenSuccesses
+		stats["half_open_max_calls"] = cb.config.HalfOpenMaxCalls
+	}
+	if cb.window == nil {
+		stats["max_failures"] = cb.config.MaxFailures
+		return stats
+	}
+
+	calls, failures, slow := cb.window.totals(now)
+	stats["window_calls"] = calls
+	stats["minimum_calls"] = cb.config.MinimumCalls
+	stats["failure_rate_threshold"] = cb.config.FailureRateThreshold
+	if calls > 0 {
+		stats["failure_rate"] = float64(failures) / float64(calls)
+	}
+	if cb.config.SlowCallDuration > 0 {
+		stats["slow_call_duration"] = cb.config.SlowCallDuration.String()
+		stats["slow_call_rate_threshold"] = cb.config.SlowCallRateThreshold
+		if calls > 0 {
+			stats["slow_call_rate"] = float64(slow) / float64(calls)
+		}
 	}
+	return stats
 }
@@ -0,0 +1,326 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var errService = errors.New("service failed")
+
+// outcome is a call made through a breaker under test
+type outcome int
+
+const (
+	ok outcome = iota
+	fail
+	slow
+	cancelled
+)
+
+func newTestBreaker(cfg CircuitBreakerConfig) *CircuitBreaker {
+	cfg.Name = "test"
+	return NewCircuitBreaker(cfg, zap.NewNop().Sugar())
+}
+
+func (o outcome) call(ctx context.Context) (interface{}, error) {
+	switch o {
+	case fail:
+		return nil, errService
+	case slow:
+		time.Sleep(10 * time.Millisecond)
+	case cancelled:
+		return nil, context.Canceled
+	}
+	return "done", nil
+}
+
+func TestBreakerTrips(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      CircuitBreakerConfig
+		calls    []outcome
+		wantOpen bool
+	}{
+		{
+			name:  "consecutive failures below the limit",
+			cfg:   CircuitBreakerConfig{MaxFailures: 3},
+			calls: []outcome{fail, fail, ok, fail, fail},
+		},
+		{
+			name:     "consecutive failures at the limit",
+			cfg:      CircuitBreakerConfig{MaxFailures: 3},
+			calls:    []outcome{ok, fail, fail, fail},
+			wantOpen: true,
+		},
+		{
+			name:  "cancelled calls are not failures",
+			cfg:   CircuitBreakerConfig{MaxFailures: 1},
+			calls: []outcome{cancelled, cancelled, cancelled},
+		},
+		{
+			name:     "count window at the failure rate",
+			cfg:      CircuitBreakerConfig{Window: WindowCount, WindowSize: 4, MinimumCalls: 4},
+			calls:    []outcome{fail, ok, fail, ok},
+			wantOpen: true,
+		},
+		{
+			name:  "count window below the failure rate",
+			cfg:   CircuitBreakerConfig{Window: WindowCount, WindowSize: 4, MinimumCalls: 4},
+			calls: []outcome{ok, fail, ok, ok},
+		},
+		{
+			name:  "count window slides past old failures",
+			cfg:   CircuitBreakerConfig{Window: WindowCount, WindowSize: 4, MinimumCalls: 4, FailureRateThreshold: 0.75},
+			calls: []outcome{fail, fail, ok, ok, ok, fail},
+		},
+		{
+			name:  "fewer than the minimum calls",
+			cfg:   CircuitBreakerConfig{Window: WindowCount, WindowSize: 10, MinimumCalls: 5},
+			calls: []outcome{fail, fail, fail, fail},
+		},
+		{
+			name:     "minimum calls reached",
+			cfg:      CircuitBreakerConfig{Window: WindowCount, WindowSize: 10, MinimumCalls: 5},
+			calls:    []outcome{fail, fail, fail, fail, fail},
+			wantOpen: true,
+		},
+		{
+			name:     "minimum calls capped at the window size",
+			cfg:      CircuitBreakerConfig{Window: WindowCount, WindowSize: 2, MinimumCalls: 50},
+			calls:    []outcome{fail, fail},
+			wantOpen: true,
+		},
+		{
+			name: "slow-call rate reached",
+			cfg: CircuitBreakerConfig{Window: WindowCount, WindowSize: 4, MinimumCalls: 4,
+				SlowCallDuration: 5 * time.Millisecond, SlowCallRateThreshold: 0.5},
+			calls:    []outcome{slow, ok, slow, ok},
+			wantOpen: true,
+		},
+		{
+			name: "slow-call rate below the threshold",
+			cfg: CircuitBreakerConfig{Window: WindowCount, WindowSize: 4, MinimumCalls: 4,
+				SlowCallDuration: 5 * time.Millisecond, SlowCallRateThreshold: 0.75},
+			calls: []outcome{slow, ok, slow, ok},
+		},
+		{
+			name:  "slow calls without a slow-call duration",
+			cfg:   CircuitBreakerConfig{Window: WindowCount, WindowSize: 2, MinimumCalls: 2},
+			calls: []outcome{slow, slow},
+		},
+		{
+			name:     "time window at the failure rate",
+			cfg:      CircuitBreakerConfig{Window: WindowTime, WindowDuration: time.Minute, MinimumCalls: 3},
+			calls:    []outcome{ok, fail, fail},
+			wantOpen: true,
+		},
+		{
+			name:  "time window below the minimum calls",
+			cfg:   CircuitBreakerConfig{Window: WindowTime, WindowDuration: time.Minute, MinimumCalls: 3},
+			calls: []outcome{fail, fail},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := newTestBreaker(tt.cfg)
+			for i, o := range tt.calls {
+				if _, err := cb.Execute(context.Background(), o.call); errors.Is(err, ErrCircuitOpen) {
+					t.Fatalf("call %d refused", i+1)
+				}
+			}
+			if got := cb.State() == StateOpen; got != tt.wantOpen {
+				t.Fatalf("state = %s, want open %v", cb.State(), tt.wantOpen)
+			}
+			if tt.wantOpen {
+				called := false
+				_, err := cb.Execute(context.Background(), func(ctx context.Context) (interface{}, error) {
+					called = true
+					return nil, nil
+				})
+				if !errors.Is(err, ErrCircuitOpen) || called {
+					t.Fatalf("open breaker: err %v, called %v", err, called)
+				}
+			}
+		})
+	}
+}
+
+// trippedBreaker returns a breaker opened by one failure, half-open once
+// its 20ms reset timeout passes
+func trippedBreaker(t *testing.T, probes int) *CircuitBreaker {
+	t.Helper()
+	cb := newTestBreaker(CircuitBreakerConfig{MaxFailures: 1, ResetTimeout: 20 * time.Millisecond, HalfOpenMaxCalls: probes})
+	cb.Execute(context.Background(), fail.call)
+	if cb.State() != StateOpen {
+		t.Fatalf("state = %s after a failure", cb.State())
+	}
+	return cb
+}
+
+func waitHalfOpen(t *testing.T, cb *CircuitBreaker) {
+	t.Helper()
+	time.Sleep(30 * time.Millisecond)
+	if cb.State() != StateHalfOpen {
+		t.Fatalf("state = %s after the reset timeout", cb.State())
+	}
+}
+
+// startCall runs a call through cb in the background. The call blocks until
+// an outcome is sent on the returned channel; the error of Execute is
+// delivered on the second one.
+func startCall(t *testing.T, cb *CircuitBreaker) (chan<- outcome, <-chan error) {
+	t.Helper()
+	release := make(chan outcome)
+	admitted := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := cb.Execute(context.Background(), func(ctx context.Context) (interface{}, error) {
+			close(admitted)
+			return (<-release).call(ctx)
+		})
+		done <- err
+	}()
+	select {
+	case <-admitted:
+	case err := <-done:
+		t.Fatalf("call refused: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("call was not admitted")
+	}
+	return release, done
+}
+
+func TestBreakerHalfOpenProbes(t *testing.T) {
+	tests := []struct {
+		name   string
+		probes []outcome
+		want   State
+	}{
+		{"all probes succeed", []outcome{ok, ok}, StateClosed},
+		{"a probe fails", []outcome{ok, fail}, StateOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := trippedBreaker(t, 2)
+			waitHalfOpen(t, cb)
+
+			var releases []chan<- outcome
+			var results []<-chan error
+			for range tt.probes {
+				release, done := startCall(t, cb)
+				releases = append(releases, release)
+				results = append(results, done)
+			}
+			// Every probe slot is taken
+			if _, err := cb.Execute(context.Background(), ok.call); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("extra probe: err %v, want ErrCircuitOpen", err)
+			}
+			for i, o := range tt.probes {
+				releases[i] <- o
+				<-results[i]
+			}
+			if got := cb.State(); got != tt.want {
+				t.Fatalf("state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreakerSlowProbeReopens(t *testing.T) {
+	cb := newTestBreaker(CircuitBreakerConfig{MaxFailures: 1, ResetTimeout: 20 * time.Millisecond, HalfOpenMaxCalls: 1,
+		SlowCallDuration: 5 * time.Millisecond})
+	cb.Execute(context.Background(), fail.call)
+	waitHalfOpen(t, cb)
+	cb.Execute(context.Background(), slow.call)
+	if cb.State() != StateOpen {
+		t.Fatalf("state = %s after a slow probe", cb.State())
+	}
+}
+
+func TestBreakerIgnoredProbeFreesItsSlot(t *testing.T) {
+	cb := trippedBreaker(t, 1)
+	waitHalfOpen(t, cb)
+
+	release, done := startCall(t, cb)
+	if _, err := cb.Execute(context.Background(), ok.call); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second probe: err %v, want ErrCircuitOpen", err)
+	}
+	release <- cancelled
+	<-done
+	if cb.State() != StateHalfOpen {
+		t.Fatalf("state = %s after a cancelled probe", cb.State())
+	}
+	if _, err := cb.Execute(context.Background(), ok.call); err != nil {
+		t.Fatalf("probe after the cancelled one: %v", err)
+	}
+	if cb.State() != StateClosed {
+		t.Fatalf("state = %s after a successful probe", cb.State())
+	}
+}
+
+func TestBreakerIgnoresCallsOfEarlierStates(t *testing.T) {
+	cb := newTestBreaker(CircuitBreakerConfig{MaxFailures: 1, ResetTimeout: 20 * time.Millisecond, HalfOpenMaxCalls: 1})
+
+	// A call admitted while closed outlives the closed state
+	release, done := startCall(t, cb)
+	cb.Execute(context.Background(), fail.call)
+	waitHalfOpen(t, cb)
+	release <- ok
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if cb.State() != StateHalfOpen {
+		t.Fatalf("a call admitted while closed closed the breaker: state %s", cb.State())
+	}
+
+	// A probe admitted while half-open outlives the half-open state
+	probe, probeDone := startCall(t, cb)
+	// Another generation: the breaker reopens and half-opens again
+	cb.mu.Lock()
+	cb.transitionTo(StateOpen, time.Now())
+	cb.mu.Unlock()
+	waitHalfOpen(t, cb)
+	probe <- fail
+	<-probeDone
+	if cb.State() != StateHalfOpen {
+		t.Fatalf("a probe of an earlier generation reopened the breaker: state %s", cb.State())
+	}
+}
+
+func TestBreakerExecute(t *testing.T) {
+	cb := newTestBreaker(CircuitBreakerConfig{MaxFailures: 1})
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "caller")
+	result, err := cb.Execute(ctx, func(ctx context.Context) (interface{}, error) {
+		return ctx.Value(ctxKey{}), nil
+	})
+	if err != nil || result != "caller" {
+		t.Fatalf("Execute = %v, %v; want the caller's context passed through", result, err)
+	}
+
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	called := false
+	_, err = cb.Execute(cancelledCtx, func(ctx context.Context) (interface{}, error) {
+		called = true
+		return nil, nil
+	})
+	if !errors.Is(err, context.Canceled) || called {
+		t.Fatalf("done context: err %v, called %v", err, called)
+	}
+	if cb.State() != StateClosed {
+		t.Fatalf("a refused call tripped the breaker: state %s", cb.State())
+	}
+
+	if _, err := cb.Execute(context.Background(), fail.call); !errors.Is(err, errService) {
+		t.Fatalf("err = %v, want the call's error", err)
+	}
+	if _, err := cb.Execute(context.Background(), ok.call); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+}
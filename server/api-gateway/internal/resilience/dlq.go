@@ -1,124 +1,341 @@
 package resilience
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
+// DeadLetterStatus is where a message stands in the dead-letter queue
+type DeadLetterStatus string
+
+const (
+	DeadLetterPending DeadLetterStatus = "pending" // Awaiting its next replay
+	DeadLetterParked  DeadLetterStatus = "parked"  // Out of retries — replayed only on request
+)
+
+var ErrDeadLetterNotFound = errors.New("dead-letter message not found")
+
 // DeadLetterMessage represents a failed message that needs reprocessing
 type DeadLetterMessage struct {
-	ID            string                 `json:"id"`
-	OriginalTopic string                 `json:"original_topic"`
-	Payload       json.RawMessage        `json:"payload"`
-	Error         string                 `json:"error"`
-	RetryCount    int                    `json:"retry_count"`
-	MaxRetries    int                    `json:"max_retries"`
-	FirstFailed   time.Time              `json:"first_failed"`
-	LastFailed    time.Time              `json:"last_failed"`
-	Metadata      map[string]string      `json:"metadata,omitempty"`
-}
-
-// DeadLetterQueue manages failed messages for retry or manual inspection
+	ID            string            `json:"id"`
+	OrgID         string            `json:"org_id,omitempty"`
+	OriginalTopic string            `json:"original_topic"`
+	Payload       json.RawMessage   `json:"payload"`
+	Error         string            `json:"error"`
+	Status        DeadLetterStatus  `json:"status"`
+	RetryCount    int               `json:"retry_count"`
+	MaxRetries    int               `json:"max_retries"`
+	FirstFailed   time.Time         `json:"first_failed"`
+	LastFailed    time.Time         `json:"last_failed"`
+	NextAttempt   *time.Time        `json:"next_attempt,omitempty"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+// DeadLetterFilter selects messages; empty fields match any message
+type DeadLetterFilter struct {
+	OrgID  string
+	Topic  string
+	Status DeadLetterStatus
+	// Limit caps the number of messages List returns; zero returns all
+	Limit int
+	// After makes List resume past the message it points to
+	After *DeadLetterCursor
+}
+
+// DeadLetterCursor is the position of a message in List order: most
+// recently failed first, then by ID
+type DeadLetterCursor struct {
+	LastFailed time.Time
+	ID         string
+}
+
+// Before reports whether msg is listed before the cursor's position
+func (c DeadLetterCursor) Before(msg *DeadLetterMessage) bool {
+	if !msg.LastFailed.Equal(c.LastFailed) {
+		return msg.LastFailed.After(c.LastFailed)
+	}
+	return msg.ID <= c.ID
+}
+
+// Match reports whether msg passes the filter
+func (f DeadLetterFilter) Match(msg *DeadLetterMessage) bool {
+	return (f.OrgID == "" || msg.OrgID == f.OrgID) &&
+		(f.Topic == "" || msg.OriginalTopic == f.Topic) &&
+		(f.Status == "" || msg.Status == f.Status) &&
+		(f.After == nil || !f.After.Before(msg))
+}
+
+// DeadLetterStore keeps the messages of a dead-letter queue
+type DeadLetterStore interface {
+	// Save inserts a message or replaces the one with its ID
+	Save(ctx context.Context, msg *DeadLetterMessage) error
+	// Get returns ErrDeadLetterNotFound for unknown IDs
+	Get(ctx context.Context, id string) (*DeadLetterMessage, error)
+	// List returns matching messages, most recently failed first, ties
+	// ordered by ID
+	List(ctx context.Context, f DeadLetterFilter) ([]*DeadLetterMessage, error)
+	// Claim returns up to limit pending messages due by now and moves their
+	// next attempt to now+lease, so concurrent workers skip them while they
+	// are replayed
+	Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*DeadLetterMessage, error)
+	// Delete removes a message; unknown IDs are not an error
+	Delete(ctx context.Context, id string) error
+}
+
+// ReplayFunc redelivers a message to where it was headed. An error means it
+// failed again.
+type ReplayFunc func(ctx context.Context, msg *DeadLetterMessage) error
+
+// ReplayError reports a replay that failed; the message stays queued
+type ReplayError struct {
+	Err error
+}
+
+func (e *ReplayError) Error() string {
+	return "replay failed: " + e.Err.Error()
+}
+
+func (e *ReplayError) Unwrap() error { return e.Err }
+
+// DeadLetterConfig configures how dead-lettered messages are replayed
+type DeadLetterConfig struct {
+	// MaxRetries applies to messages enqueued without their own
+	MaxRetries int
+	// Backoff spaces replays of a message; MaxAttempts and Retryable are
+	// not used
+	Backoff      RetryConfig
+	PollInterval time.Duration
+	BatchSize    int
+	// Lease hides a claimed message from other workers while it is replayed
+	Lease time.Duration
+}
+
+// DefaultDeadLetterConfig returns the settings used unless overridden
+func DefaultDeadLetterConfig() DeadLetterConfig {
+	return DeadLetterConfig{
+		MaxRetries: 5,
+		Backoff: RetryConfig{
+			InitialDelay: 30 * time.Second,
+			MaxDelay:     time.Hour,
+			Multiplier:   2.0,
+			JitterFactor: 0.2,
+		},
+		PollInterval: 10 * time.Second,
+		BatchSize:    50,
+		Lease:        5 * time.Minute,
+	}
+}
+
+// DeadLetterQueue keeps failed messages in a store and replays them with
+// backoff until they succeed, parking those that run out of retries
 type DeadLetterQueue struct {
-	logger   *zap.SugaredLogger
+	store  DeadLetterStore
+	cfg    DeadLetterConfig
+	logger *zap.SugaredLogger
+
 	mu       sync.RWMutex
-	messages map[string]*DeadLetterMessage
-	maxSize  int
+	handlers map[string]ReplayFunc
 }
 
-func NewDeadLetterQueue(logger *zap.SugaredLogger, maxSize int) *DeadLetterQueue {
-	if maxSize == 0 {
-		maxSize = 10000
+func NewDeadLetterQueue(store DeadLetterStore, cfg DeadLetterConfig, logger *zap.SugaredLogger) *DeadLetterQueue {
+	defaults := DefaultDeadLetterConfig()
+	if cfg.MaxRetries <= 0 {
+		cfg.MaxRetries = defaults.MaxRetries
+	}
+	if cfg.Backoff.InitialDelay <= 0 {
+		cfg.Backoff = defaults.Backoff
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaults.PollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaults.BatchSize
+	}
+	if cfg.Lease <= 0 {
+		cfg.Lease = defaults.Lease
 	}
 	return &DeadLetterQueue{
+		store:    store,
+		cfg:      cfg,
 		logger:   logger,
-		messages: make(map[string]*DeadLetterMessage),
-		maxSize:  maxSize,
+		handlers: make(map[string]ReplayFunc),
 	}
 }
 
-// Enqueue adds a failed message to the DLQ
-func (q *DeadLetterQueue) Enqueue(msg *DeadLetterMessage) {
+// Handle registers how messages of a topic are replayed. Messages of topics
+// without a handler fail every replay and end up parked.
+func (q *DeadLetterQueue) Handle(topic string, fn ReplayFunc) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
+	q.handlers[topic] = fn
+}
 
-	if len(q.messages) >= q.maxSize {
-		q.logger.Warnw("DLQ at capacity, dropping oldest message", "max_size", q.maxSize)
-		q.evictOldest()
+// Enqueue adds a failed message to the DLQ, scheduling its first replay.
+// Messages without an ID are given one.
+func (q *DeadLetterQueue) Enqueue(ctx context.Context, msg *DeadLetterMessage) error {
+	if msg.ID == "" {
+		msg.ID = uuid.NewString()
+	}
+	if msg.MaxRetries <= 0 {
+		msg.MaxRetries = q.cfg.MaxRetries
 	}
-
 	msg.LastFailed = time.Now().UTC()
 	if msg.FirstFailed.IsZero() {
 		msg.FirstFailed = msg.LastFailed
 	}
-	msg.RetryCount++
+	msg.Status = DeadLetterPending
+	next := msg.LastFailed.Add(calculateDelay(msg.RetryCount, q.cfg.Backoff))
+	msg.NextAttempt = &next
 
-	q.messages[msg.ID] = msg
+	if err := q.store.Save(ctx, msg); err != nil {
+		return fmt.Errorf("failed to dead-letter message: %w", err)
+	}
 	q.logger.Infow("message added to DLQ",
 		"id", msg.ID,
 		"topic", msg.OriginalTopic,
 		"retry_count", msg.RetryCount,
 		"error", msg.Error,
 	)
+	return nil
 }
 
-// Dequeue removes and returns a message for reprocessing
-func (q *DeadLetterQueue) Dequeue(id string) (*DeadLetterMessage, bool) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
-	msg, ok := q.messages[id]
-	if ok {
-		delete(q.messages, id)
+// Get returns a message
+func (q *DeadLetterQueue) Get(ctx context.Context, id string) (*DeadLetterMessage, error) {
+	return q.store.Get(ctx, id)
+}
+
+// List returns matching messages, most recently failed first
+func (q *DeadLetterQueue) List(ctx context.Context, f DeadLetterFilter) ([]*DeadLetterMessage, error) {
+	return q.store.List(ctx, f)
+}
+
+// Purge removes every matching message, returning how many were removed
+func (q *DeadLetterQueue) Purge(ctx context.Context, f DeadLetterFilter) (int, error) {
+	f.Limit = 0
+	msgs, err := q.store.List(ctx, f)
+	if err != nil {
+		return 0, err
+	}
+	for i, msg := range msgs {
+		if err := q.store.Delete(ctx, msg.ID); err != nil {
+			return i, err
+		}
 	}
-	return msg, ok
+	if len(msgs) > 0 {
+		q.logger.Infow("DLQ purged", "org_id", f.OrgID, "topic", f.Topic, "status", f.Status, "count", len(msgs))
+	}
+	return len(msgs), nil
 }
 
-// List returns all messages in the DLQ
-func (q *DeadLetterQueue) List() []*DeadLetterMessage {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
-	msgs := make([]*DeadLetterMessage, 0, len(q.messages))
-	for _, m := range q.messages {
-		msgs = append(msgs, m)
+// Reschedule queues every matching message, parked ones included, for
+// replay by the next poll with a fresh set of retries. It returns how many
+// were rescheduled.
+func (q *DeadLetterQueue) Reschedule(ctx context.Context, f DeadLetterFilter) (int, error) {
+	f.Limit = 0
+	msgs, err := q.store.List(ctx, f)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().UTC()
+	for i, msg := range msgs {
+		msg.Status = DeadLetterPending
+		msg.RetryCount = 0
+		msg.NextAttempt = &now
+		if err := q.store.Save(ctx, msg); err != nil {
+			return i, err
+		}
 	}
-	return msgs
+	return len(msgs), nil
 }
 
-// Size returns the number of messages in the DLQ
-func (q *DeadLetterQueue) Size() int {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
-	return len(q.messages)
+// Run replays due messages until ctx is cancelled
+func (q *DeadLetterQueue) Run(ctx context.Context) {
+	ticker := time.NewTicker(q.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		q.replayDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (q *DeadLetterQueue) replayDue(ctx context.Context) {
+	msgs, err := q.store.Claim(ctx, time.Now().UTC(), q.cfg.Lease, q.cfg.BatchSize)
+	if err != nil {
+		if ctx.Err() == nil {
+			q.logger.Warnw("failed to claim due DLQ messages", "error", err)
+		}
+		return
+	}
+	for _, msg := range msgs {
+		if ctx.Err() != nil {
+			// Unfinished claims expire with their lease
+			return
+		}
+		var replayErr *ReplayError
+		if err := q.Replay(ctx, msg); err != nil && !errors.As(err, &replayErr) {
+			q.logger.Warnw("failed to record DLQ replay", "id", msg.ID, "error", err)
+		}
+	}
 }
 
-// Stats returns DLQ statistics
-func (q *DeadLetterQueue) Stats() map[string]interface{} {
+// Replay redelivers a message now, whatever its status. It is removed once
+// delivered. Otherwise the failure is counted and a *ReplayError returned:
+// the message is rescheduled with backoff, or parked once out of retries.
+func (q *DeadLetterQueue) Replay(ctx context.Context, msg *DeadLetterMessage) error {
 	q.mu.RLock()
-	defer q.mu.RUnlock()
+	fn := q.handlers[msg.OriginalTopic]
+	q.mu.RUnlock()
 
-	topicCounts := make(map[string]int)
-	for _, m := range q.messages {
-		topicCounts[m.OriginalTopic]++
+	var err error
+	if fn == nil {
+		err = fmt.Errorf("no replay handler for topic %s", msg.OriginalTopic)
+	} else {
+		err = fn(ctx, msg)
 	}
-
-	return map[string]interface{}{
-		"total_messages": len(q.messages),
-		"max_size":       q.maxSize,
-		"by_topic":       topicCounts,
+	if err == nil {
+		q.logger.Infow("DLQ message replayed", "id", msg.ID, "topic", msg.OriginalTopic, "retry_count", msg.RetryCount)
+		return q.store.Delete(ctx, msg.ID)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
 	}
-}
 
-func (q *DeadLetterQueue) evictOldest() {
-	var oldest *DeadLetterMessage
-	for _, m := range q.messages {
-		if oldest == nil || m.FirstFailed.Before(oldest.FirstFailed) {
-			oldest = m
-		}
+	msg.RetryCount++
+	msg.Error = err.Error()
+	msg.LastFailed = time.Now().UTC()
+	if msg.RetryCount >= msg.MaxRetries {
+		msg.Status = DeadLetterParked
+		msg.NextAttempt = nil
+		q.logger.Warnw("DLQ message parked after exhausting retries",
+			"id", msg.ID,
+			"topic", msg.OriginalTopic,
+			"retry_count", msg.RetryCount,
+			"error", msg.Error,
+		)
+	} else {
+		next := msg.LastFailed.Add(calculateDelay(msg.RetryCount, q.cfg.Backoff))
+		msg.NextAttempt = &next
+		q.logger.Infow("DLQ replay failed, rescheduled",
+			"id", msg.ID,
+			"topic", msg.OriginalTopic,
+			"retry_count", msg.RetryCount,
+			"next_attempt", next,
+			"error", msg.Error,
+		)
 	}
-	if oldest != nil {
-		delete(q.messages, oldest.ID)
+	if saveErr := q.store.Save(ctx, msg); saveErr != nil {
+		return saveErr
 	}
+	return &ReplayError{Err: err}
 }
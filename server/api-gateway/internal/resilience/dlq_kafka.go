@@ -0,0 +1,249 @@
+package resilience
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/segmentio/kafka-go"
+	"go.uber.org/zap"
+)
+
+const (
+	// DeadLetterTopicSuffix is appended to a topic to name its dead-letter topic
+	DeadLetterTopicSuffix = ".dlq"
+
+	kafkaDLQIndexSize       = 100000
+	kafkaDLQDiscoveryPeriod = 30 * time.Second
+	// kafkaDLQWriterHeader marks the replica that wrote a record, so it can
+	// skip its own writes when consuming them back
+	kafkaDLQWriterHeader = "archlens-dlq-writer"
+	kafkaDLQLeasePrefix  = "archlens:dlq:lease:"
+)
+
+// leaseDeadLetter takes or renews the lease at KEYS[1] for replica ARGV[1],
+// unless another replica holds it
+//
+//	ARGV: replica, lease in ms
+//	returns: acquired (0/1)
+var leaseDeadLetter = redis.NewScript(`
+local owner = redis.call('GET', KEYS[1])
+if owner and owner ~= ARGV[1] then
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return 1
+`)
+
+// KafkaDeadLetterStore keeps each topic's dead-lettered messages in a
+// <topic>.dlq topic, keyed by message ID, with a tombstone for each removed
+// message. The topics should be log-compacted so they hold only the latest
+// version of each message.
+//
+// Every replica consumes all the *.dlq topics into an in-memory index that
+// reads are served from. A claimed message is leased in Redis, so only one
+// replica replays it and counts its retries; the replica keeps the lease
+// across retries, and other replicas take over once it expires.
+type KafkaDeadLetterStore struct {
+	brokers []string
+	writer  *kafka.Writer
+	index   *MemoryDeadLetterStore
+	rdb     *redis.Client
+	logger  *zap.SugaredLogger
+	// writerID tells this replica's records apart
+	writerID string
+
+	mu        sync.Mutex
+	consuming map[dlqPartition]bool
+}
+
+type dlqPartition struct {
+	topic string
+	id    int
+}
+
+func NewKafkaDeadLetterStore(brokers []string, rdb *redis.Client, logger *zap.SugaredLogger) *KafkaDeadLetterStore {
+	return &KafkaDeadLetterStore{
+		brokers: brokers,
+		writer: &kafka.Writer{
+			Addr:                   kafka.TCP(brokers...),
+			Balancer:               &kafka.Hash{},
+			RequiredAcks:           kafka.RequireAll,
+			BatchTimeout:           10 * time.Millisecond,
+			AllowAutoTopicCreation: true,
+		},
+		index:     NewMemoryDeadLetterStore(logger, kafkaDLQIndexSize),
+		rdb:       rdb,
+		logger:    logger,
+		writerID:  uuid.NewString(),
+		consuming: make(map[dlqPartition]bool),
+	}
+}
+
+func (s *KafkaDeadLetterStore) Save(ctx context.Context, msg *DeadLetterMessage) error {
+	value, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := s.write(ctx, msg.OriginalTopic, msg.ID, value); err != nil {
+		return err
+	}
+	s.index.put(msg)
+	return nil
+}
+
+func (s *KafkaDeadLetterStore) Get(ctx context.Context, id string) (*DeadLetterMessage, error) {
+	return s.index.Get(ctx, id)
+}
+
+func (s *KafkaDeadLetterStore) List(ctx context.Context, f DeadLetterFilter) ([]*DeadLetterMessage, error) {
+	return s.index.List(ctx, f)
+}
+
+// Claim returns the due messages this replica could lease. Without Redis
+// it claims none, rather than replaying messages other replicas may hold.
+func (s *KafkaDeadLetterStore) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*DeadLetterMessage, error) {
+	due, err := s.index.Claim(ctx, now, lease, limit)
+	if err != nil {
+		return nil, err
+	}
+	claimed := due[:0]
+	for _, msg := range due {
+		acquired, err := leaseDeadLetter.Run(ctx, s.rdb, []string{kafkaDLQLeasePrefix + msg.ID},
+			s.writerID, lease.Milliseconds()).Int()
+		if err != nil {
+			return nil, fmt.Errorf("failed to lease DLQ message %s: %w", msg.ID, err)
+		}
+		if acquired == 1 {
+			claimed = append(claimed, msg)
+		}
+	}
+	return claimed, nil
+}
+
+func (s *KafkaDeadLetterStore) Delete(ctx context.Context, id string) error {
+	msg, err := s.index.Get(ctx, id)
+	if err != nil {
+		// Not indexed, so there is nothing to tombstone
+		return nil
+	}
+	if err := s.write(ctx, msg.OriginalTopic, id, nil); err != nil {
+		return err
+	}
+	s.index.remove(id)
+	return nil
+}
+
+func (s *KafkaDeadLetterStore) write(ctx context.Context, topic, id string, value []byte) error {
+	err := s.writer.WriteMessages(ctx, kafka.Message{
+		Topic:   topic + DeadLetterTopicSuffix,
+		Key:     []byte(id),
+		Value:   value,
+		Headers: []kafka.Header{{Key: kafkaDLQWriterHeader, Value: []byte(s.writerID)}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write to %s%s: %w", topic, DeadLetterTopicSuffix, err)
+	}
+	return nil
+}
+
+// Run keeps the index in step with the *.dlq topics until ctx is cancelled,
+// picking up new topics and partitions as they appear
+func (s *KafkaDeadLetterStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(kafkaDLQDiscoveryPeriod)
+	defer ticker.Stop()
+	for {
+		if err := s.discover(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Warnw("failed to discover DLQ topics", "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// discover starts consuming the *.dlq partitions not yet consumed
+func (s *KafkaDeadLetterStore) discover(ctx context.Context) error {
+	conn, err := kafka.DialContext(ctx, "tcp", s.brokers[0])
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	partitions, err := conn.ReadPartitions()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range partitions {
+		if !strings.HasSuffix(p.Topic, DeadLetterTopicSuffix) {
+			continue
+		}
+		key := dlqPartition{topic: p.Topic, id: p.ID}
+		if s.consuming[key] {
+			continue
+		}
+		s.consuming[key] = true
+		go s.consume(ctx, p.Topic, p.ID)
+	}
+	return nil
+}
+
+// consume applies a partition to the index, from its first record on
+func (s *KafkaDeadLetterStore) consume(ctx context.Context, topic string, partition int) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   s.brokers,
+		Topic:     topic,
+		Partition: partition,
+		MaxWait:   3 * time.Second,
+	})
+	defer reader.Close()
+	if err := reader.SetOffset(kafka.FirstOffset); err != nil {
+		s.logger.Warnw("failed to rewind DLQ partition", "topic", topic, "partition", partition, "error", err)
+	}
+
+	for {
+		record, err := reader.ReadMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Warnw("error reading DLQ partition", "topic", topic, "partition", partition, "error", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		s.apply(record)
+	}
+}
+
+func (s *KafkaDeadLetterStore) apply(record kafka.Message) {
+	for _, h := range record.Headers {
+		if h.Key == kafkaDLQWriterHeader && string(h.Value) == s.writerID {
+			// Already in the index, which may since have moved on
+			return
+		}
+	}
+	if record.Value == nil {
+		s.index.remove(string(record.Key))
+		return
+	}
+	var msg DeadLetterMessage
+	if err := json.Unmarshal(record.Value, &msg); err != nil {
+		s.logger.Warnw("error unmarshalling DLQ record", "topic", record.Topic, "offset", record.Offset, "error", err)
+		return
+	}
+	s.index.put(&msg)
+}
+
+// Close flushes pending writes
+func (s *KafkaDeadLetterStore) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,105 @@
+package resilience
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// newKafkaReplicas returns n stores sharing one Redis, each indexing the
+// same messages as if it had consumed them from the *.dlq topics
+func newKafkaReplicas(t *testing.T, n int, msgs ...*DeadLetterMessage) (*miniredis.Miniredis, []*KafkaDeadLetterStore) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	replicas := make([]*KafkaDeadLetterStore, n)
+	for i := range replicas {
+		s := NewKafkaDeadLetterStore([]string{"127.0.0.1:9"}, rdb, zap.NewNop().Sugar())
+		t.Cleanup(func() { s.Close() })
+		for _, msg := range msgs {
+			s.index.put(msg)
+		}
+		replicas[i] = s
+	}
+	return mr, replicas
+}
+
+func TestKafkaClaimLeasesMessageToOneReplica(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC()
+	due := now.Add(-time.Second)
+	msgs := []*DeadLetterMessage{
+		{ID: "2b7f8d7e-5a55-4f0e-9a53-3c1f5e0d6a01", OriginalTopic: "audit", Status: DeadLetterPending, NextAttempt: &due},
+		{ID: "2b7f8d7e-5a55-4f0e-9a53-3c1f5e0d6a02", OriginalTopic: "audit", Status: DeadLetterPending, NextAttempt: &due},
+	}
+	mr, replicas := newKafkaReplicas(t, 3, msgs...)
+
+	owner := map[string]int{}
+	for i, s := range replicas {
+		claimed, err := s.Claim(ctx, now, time.Minute, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range claimed {
+			if prev, ok := owner[msg.ID]; ok {
+				t.Errorf("message %s claimed by replicas %d and %d", msg.ID, prev, i)
+			}
+			owner[msg.ID] = i
+		}
+	}
+	if len(owner) != len(msgs) {
+		t.Fatalf("claimed %d of %d messages", len(owner), len(msgs))
+	}
+
+	// The owner keeps its lease across retries, so it replays the message
+	// on its own schedule while the others keep skipping it
+	retry := now.Add(30 * time.Second)
+	first := msgs[0]
+	first.NextAttempt = &retry
+	for _, s := range replicas {
+		s.index.put(first)
+	}
+	for i, s := range replicas {
+		claimed, err := s.Claim(ctx, retry, time.Minute, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := len(claimed) == 1 && claimed[0].ID == first.ID
+		if want := i == owner[first.ID]; got != want {
+			t.Errorf("replica %d claimed the retry: %v, owner is %d", i, got, owner[first.ID])
+		}
+	}
+
+	// Another replica takes over once the owner's lease expires
+	mr.FastForward(2 * time.Minute)
+	later := now.Add(3 * time.Minute)
+	taken := 0
+	for _, s := range replicas {
+		claimed, err := s.Claim(ctx, later, time.Minute, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		taken += len(claimed)
+	}
+	if taken != len(msgs) {
+		t.Errorf("after the leases expired %d messages were claimed, want %d", taken, len(msgs))
+	}
+}
+
+func TestKafkaClaimFailsWithoutRedis(t *testing.T) {
+	due := time.Now().Add(-time.Second)
+	mr, replicas := newKafkaReplicas(t, 1, &DeadLetterMessage{
+		ID: "2b7f8d7e-5a55-4f0e-9a53-3c1f5e0d6a01", OriginalTopic: "audit", Status: DeadLetterPending, NextAttempt: &due,
+	})
+	mr.Close()
+	claimed, err := replicas[0].Claim(context.Background(), time.Now(), time.Minute, 10)
+	if err == nil || len(claimed) != 0 {
+		t.Fatalf("claimed %d messages with Redis down, err = %v", len(claimed), err)
+	}
+}
@@ -0,0 +1,155 @@
+package resilience
+
+import (
+	"container/list"
+	"context"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// MemoryDeadLetterStore keeps dead-lettered messages in process memory. It
+// does not survive a restart and is meant for tests and local development.
+// Once full, saving a new message evicts the one saved longest ago.
+type MemoryDeadLetterStore struct {
+	logger  *zap.SugaredLogger
+	mu      sync.Mutex
+	maxSize int
+	// order holds the messages oldest-saved first, so eviction is O(1);
+	// messages indexes its elements by ID
+	order    *list.List
+	messages map[string]*list.Element
+}
+
+func NewMemoryDeadLetterStore(logger *zap.SugaredLogger, maxSize int) *MemoryDeadLetterStore {
+	if maxSize <= 0 {
+		maxSize = 10000
+	}
+	return &MemoryDeadLetterStore{
+		logger:   logger,
+		maxSize:  maxSize,
+		order:    list.New(),
+		messages: make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryDeadLetterStore) Save(_ context.Context, msg *DeadLetterMessage) error {
+	s.put(msg)
+	return nil
+}
+
+// put stores a copy of msg; replacing a message keeps its place in the
+// eviction order
+func (s *MemoryDeadLetterStore) put(msg *DeadLetterMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.messages[msg.ID]; ok {
+		el.Value = copyDeadLetter(msg)
+		return
+	}
+	if s.order.Len() >= s.maxSize {
+		oldest := s.order.Front()
+		s.order.Remove(oldest)
+		delete(s.messages, oldest.Value.(*DeadLetterMessage).ID)
+		if s.logger != nil {
+			s.logger.Warnw("DLQ at capacity, dropping oldest message", "max_size", s.maxSize)
+		}
+	}
+	s.messages[msg.ID] = s.order.PushBack(copyDeadLetter(msg))
+}
+
+func (s *MemoryDeadLetterStore) Get(_ context.Context, id string) (*DeadLetterMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.messages[id]
+	if !ok {
+		return nil, ErrDeadLetterNotFound
+	}
+	return copyDeadLetter(el.Value.(*DeadLetterMessage)), nil
+}
+
+func (s *MemoryDeadLetterStore) List(_ context.Context, f DeadLetterFilter) ([]*DeadLetterMessage, error) {
+	s.mu.Lock()
+	var msgs []*DeadLetterMessage
+	for el := s.order.Front(); el != nil; el = el.Next() {
+		if msg := el.Value.(*DeadLetterMessage); f.Match(msg) {
+			msgs = append(msgs, copyDeadLetter(msg))
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Slice(msgs, func(i, j int) bool {
+		if !msgs[i].LastFailed.Equal(msgs[j].LastFailed) {
+			return msgs[i].LastFailed.After(msgs[j].LastFailed)
+		}
+		return msgs[i].ID < msgs[j].ID
+	})
+	if f.Limit > 0 && len(msgs) > f.Limit {
+		msgs = msgs[:f.Limit]
+	}
+	return msgs, nil
+}
+
+func (s *MemoryDeadLetterStore) Claim(_ context.Context, now time.Time, lease time.Duration, limit int) ([]*DeadLetterMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*DeadLetterMessage
+	for el := s.order.Front(); el != nil; el = el.Next() {
+		msg := el.Value.(*DeadLetterMessage)
+		if msg.Status == DeadLetterPending && msg.NextAttempt != nil && !msg.NextAttempt.After(now) {
+			due = append(due, msg)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttempt.Before(*due[j].NextAttempt)
+	})
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+
+	leased := now.Add(lease)
+	claimed := make([]*DeadLetterMessage, len(due))
+	for i, msg := range due {
+		msg.NextAttempt = &leased
+		claimed[i] = copyDeadLetter(msg)
+	}
+	return claimed, nil
+}
+
+func (s *MemoryDeadLetterStore) Delete(_ context.Context, id string) error {
+	s.remove(id)
+	return nil
+}
+
+func (s *MemoryDeadLetterStore) remove(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.messages[id]; ok {
+		s.order.Remove(el)
+		delete(s.messages, id)
+	}
+}
+
+// Size returns the number of messages held
+func (s *MemoryDeadLetterStore) Size() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.order.Len()
+}
+
+// copyDeadLetter copies msg so callers cannot change the stored message
+func copyDeadLetter(msg *DeadLetterMessage) *DeadLetterMessage {
+	c := *msg
+	c.Payload = append([]byte(nil), msg.Payload...)
+	c.Metadata = maps.Clone(msg.Metadata)
+	if msg.NextAttempt != nil {
+		next := *msg.NextAttempt
+		c.NextAttempt = &next
+	}
+	return &c
+}
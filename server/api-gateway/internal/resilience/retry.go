@@ -11,11 +11,14 @@ import (
 
 // RetryConfig configures exponential backoff retry behavior
 type RetryConfig struct {
-	MaxAttempts   int
-	InitialDelay  time.Duration
-	MaxDelay      time.Duration
-	Multiplier    float64
-	JitterFactor  float64
+	MaxAttempts  int
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+	JitterFactor float64
+	// Retryable decides whether a failed attempt is worth repeating; nil
+	// retries every error
+	Retryable func(err error) bool
 }
 
 // DefaultRetryConfig returns sensible defaults for AI service calls
@@ -29,7 +32,8 @@ func DefaultRetryConfig() RetryConfig {
 	}
 }
 
-// RetryWithBackoff executes fn with exponential backoff and jitter
+// RetryWithBackoff executes fn with exponential backoff and jitter. Errors
+// cfg.Retryable rejects are returned without further attempts.
 func RetryWithBackoff(ctx context.Context, cfg RetryConfig, logger *zap.SugaredLogger, operation string, fn func() error) error {
 	var lastErr error
 
@@ -48,6 +52,9 @@ func RetryWithBackoff(ctx context.Context, cfg RetryConfig, logger *zap.SugaredL
 			}
 			return nil
 		}
+		if cfg.Retryable != nil && !cfg.Retryable(lastErr) {
+			return lastErr
+		}
 
 		if attempt < cfg.MaxAttempts-1 {
 			delay := calculateDelay(attempt, cfg)
@@ -0,0 +1,410 @@
+package resilience
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	sharedKeyPrefix = "archlens:breaker:"
+	sharedChannel   = "archlens:breaker:events"
+	// sharedTimeout keeps a slow Redis from adding much latency to calls;
+	// on timeout the breaker falls back to its local state
+	sharedTimeout = 50 * time.Millisecond
+	// sharedRetryAfter is how long breakers stay on local state after Redis
+	// failed before trying it again
+	sharedRetryAfter = 5 * time.Second
+)
+
+// acquireShared admits a call by the shared state at KEYS[1], moving an
+// open breaker whose reset timeout has passed to half-open and taking a
+// probe slot while half-open. Time comes from the Redis server so replicas
+// with skewed clocks agree.
+//
+//	ARGV: name, channel, reset timeout in ms, half-open max calls
+//	returns: admitted (0/1), state, generation
+var acquireShared = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local h = redis.call('HMGET', KEYS[1], 'state', 'gen', 'opened_at', 'probes')
+local state = tonumber(h[1]) or 0
+local gen = tonumber(h[2]) or 0
+local probes = tonumber(h[4]) or 0
+
+if state == 1 then
+  if now - (tonumber(h[3]) or 0) < tonumber(ARGV[3]) then
+    return {0, state, gen}
+  end
+  state = 2
+  gen = gen + 1
+  probes = 0
+  redis.call('HSET', KEYS[1], 'state', state, 'gen', gen, 'probes', 0, 'successes', 0)
+  redis.call('PUBLISH', ARGV[2], cjson.encode({breaker = ARGV[1], state = state, gen = gen}))
+end
+if state == 2 then
+  if probes >= tonumber(ARGV[4]) then
+    return {0, state, gen}
+  end
+  redis.call('HINCRBY', KEYS[1], 'probes', 1)
+end
+return {1, state, gen}
+`)
+
+// recordShared adds the outcome of a call admitted in generation ARGV[3] to
+// the shared state at KEYS[1] and the shared window at KEYS[2], tripping,
+// reopening or closing the breaker as the local one would. Outcomes of calls
+// admitted in an earlier generation are dropped.
+//
+//	ARGV: name, channel, generation, ignored (0/1), failed (0/1), slow (0/1),
+//	      window type, max failures | window size | bucket width in ms,
+//	      buckets, minimum calls, failure rate threshold,
+//	      slow call rate threshold (0 ignores slow calls), half-open max calls
+//	returns: state, generation
+var recordShared = redis.NewScript(`
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local h = redis.call('HMGET', KEYS[1], 'state', 'gen')
+local state = tonumber(h[1]) or 0
+local gen = tonumber(h[2]) or 0
+if tonumber(ARGV[3]) ~= gen then
+  return {state, gen}
+end
+
+local function transition(to)
+  state = to
+  gen = gen + 1
+  redis.call('HSET', KEYS[1], 'state', state, 'gen', gen, 'opened_at', now, 'probes', 0, 'successes', 0, 'failures', 0)
+  if state == 0 then
+    redis.call('DEL', KEYS[2])
+  end
+  redis.call('PUBLISH', ARGV[2], cjson.encode({breaker = ARGV[1], state = state, gen = gen}))
+end
+
+local ignored = ARGV[4] == '1'
+local failed = ARGV[5] == '1'
+local slow = ARGV[6] == '1'
+
+if state == 2 then
+  if ignored then
+    redis.call('HINCRBY', KEYS[1], 'probes', -1)
+  elseif failed or slow then
+    transition(1)
+  elseif redis.call('HINCRBY', KEYS[1], 'successes', 1) >= tonumber(ARGV[13]) then
+    transition(0)
+  end
+  return {state, gen}
+end
+if state ~= 0 or ignored then
+  return {state, gen}
+end
+
+local window = ARGV[7]
+if window == 'consecutive' then
+  if failed then
+    if redis.call('HINCRBY', KEYS[1], 'failures', 1) >= tonumber(ARGV[8]) then
+      transition(1)
+    end
+  else
+    redis.call('HSET', KEYS[1], 'failures', 0)
+  end
+  return {state, gen}
+end
+
+local calls, failures, slows = 0, 0, 0
+if window == 'count' then
+  local flag = 0
+  if failed then flag = flag + 1 end
+  if slow then flag = flag + 2 end
+  redis.call('LPUSH', KEYS[2], flag)
+  redis.call('LTRIM', KEYS[2], 0, tonumber(ARGV[8]) - 1)
+  for _, v in ipairs(redis.call('LRANGE', KEYS[2], 0, -1)) do
+    v = tonumber(v)
+    calls = calls + 1
+    if v % 2 == 1 then failures = failures + 1 end
+    if v >= 2 then slows = slows + 1 end
+  end
+else
+  local width = tonumber(ARGV[8])
+  local buckets = tonumber(ARGV[9])
+  local bucket = math.floor(now / width)
+  redis.call('HINCRBY', KEYS[2], bucket .. ':c', 1)
+  if failed then redis.call('HINCRBY', KEYS[2], bucket .. ':f', 1) end
+  if slow then redis.call('HINCRBY', KEYS[2], bucket .. ':s', 1) end
+  redis.call('PEXPIRE', KEYS[2], width * (buckets + 1))
+  local fields = redis.call('HGETALL', KEYS[2])
+  for i = 1, #fields, 2 do
+    local sep = string.find(fields[i], ':', 1, true)
+    if tonumber(string.sub(fields[i], 1, sep - 1)) <= bucket - buckets then
+      redis.call('HDEL', KEYS[2], fields[i])
+    else
+      local kind = string.sub(fields[i], sep + 1)
+      local n = tonumber(fields[i + 1])
+      if kind == 'c' then calls = calls + n
+      elseif kind == 'f' then failures = failures + n
+      else slows = slows + n end
+    end
+  end
+end
+
+if calls > 0 and calls >= tonumber(ARGV[10]) then
+  local slowThreshold = tonumber(ARGV[12])
+  if failures / calls >= tonumber(ARGV[11]) or (slowThreshold > 0 and slows / calls >= slowThreshold) then
+    transition(1)
+  end
+end
+return {state, gen}
+`)
+
+// sharedState is a breaker's state as kept in Redis. The generation grows
+// with every state change, so replicas can tell a stale notification from
+// a current one.
+type sharedState struct {
+	state      State
+	generation uint64
+}
+
+// sharedEvent notifies replicas of a state change
+type sharedEvent struct {
+	Breaker    string `json:"breaker"`
+	State      State  `json:"state"`
+	Generation uint64 `json:"gen"`
+}
+
+// SharedBreakers keeps the state and failure windows of circuit breakers in
+// Redis, so a failing service trips the breakers of every gateway replica at
+// once instead of each replica having to find out on its own. Transitions
+// are published to all replicas. If Redis is unreachable, breakers fall back
+// to their local state until it is back.
+type SharedBreakers struct {
+	rdb    *redis.Client
+	logger *zap.SugaredLogger
+
+	mu       sync.Mutex
+	breakers map[string]*CircuitBreaker
+
+	// downUntil is when to try Redis again after it failed, in unix nanoseconds
+	downUntil atomic.Int64
+}
+
+func NewSharedBreakers(rdb *redis.Client, logger *zap.SugaredLogger) *SharedBreakers {
+	return &SharedBreakers{
+		rdb:      rdb,
+		logger:   logger,
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+// Run applies the state changes published by other replicas until ctx is
+// cancelled. Breakers are synchronised with Redis when it starts.
+func (s *SharedBreakers) Run(ctx context.Context) {
+	sub := s.rdb.Subscribe(ctx, sharedChannel)
+	defer sub.Close()
+
+	s.sync(ctx)
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var ev sharedEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+				s.logger.Warnw("malformed circuit breaker event", "payload", msg.Payload, "error", err)
+				continue
+			}
+			s.mu.Lock()
+			cb := s.breakers[ev.Breaker]
+			s.mu.Unlock()
+			if cb != nil {
+				cb.notifyShared(sharedState{state: ev.State, generation: ev.Generation})
+			}
+		}
+	}
+}
+
+// sync loads the shared state of every registered breaker
+func (s *SharedBreakers) sync(ctx context.Context) {
+	s.mu.Lock()
+	breakers := make([]*CircuitBreaker, 0, len(s.breakers))
+	for _, cb := range s.breakers {
+		breakers = append(breakers, cb)
+	}
+	s.mu.Unlock()
+
+	for _, cb := range breakers {
+		vals, err := s.rdb.HMGet(ctx, sharedKey(cb.config.Name), "state", "gen").Result()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+		state, _ := strconv.Atoi(stringValue(vals[0]))
+		gen, _ := strconv.ParseUint(stringValue(vals[1]), 10, 64)
+		cb.applyShared(sharedState{state: State(state), generation: gen})
+	}
+}
+
+func (s *SharedBreakers) register(cb *CircuitBreaker) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.breakers[cb.config.Name] = cb
+}
+
+// available reports whether breakers should use the shared state, i.e. Redis
+// has not failed recently
+func (s *SharedBreakers) available() bool {
+	return time.Now().UnixNano() >= s.downUntil.Load()
+}
+
+// fail puts breakers on local state for a while
+func (s *SharedBreakers) fail(err error) {
+	now := time.Now()
+	if s.downUntil.Swap(now.Add(sharedRetryAfter).UnixNano()) < now.UnixNano() {
+		s.logger.Warnw("shared circuit breaker state unavailable, falling back to local state",
+			"error", err, "retry_in", sharedRetryAfter.String())
+	}
+}
+
+func (s *SharedBreakers) acquire(cfg *CircuitBreakerConfig) (bool, sharedState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sharedTimeout)
+	defer cancel()
+	vals, err := acquireShared.Run(ctx, s.rdb, []string{sharedKey(cfg.Name)},
+		cfg.Name, sharedChannel, cfg.ResetTimeout.Milliseconds(), cfg.HalfOpenMaxCalls).Int64Slice()
+	if err != nil {
+		return false, sharedState{}, err
+	}
+	return vals[0] == 1, sharedState{state: State(vals[1]), generation: uint64(vals[2])}, nil
+}
+
+func (s *SharedBreakers) record(cfg *CircuitBreakerConfig, generation uint64, ignored, failed, slow bool) (sharedState, error) {
+	param, buckets := cfg.MaxFailures, 0
+	switch cfg.Window {
+	case WindowCount:
+		param = cfg.WindowSize
+	case WindowTime:
+		width := newTimeWindow(cfg.WindowDuration).width
+		param, buckets = int(width.Milliseconds()), timeWindowBuckets
+	}
+	slowThreshold := 0.0
+	if cfg.SlowCallDuration > 0 {
+		slowThreshold = cfg.SlowCallRateThreshold
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedTimeout)
+	defer cancel()
+	key := sharedKey(cfg.Name)
+	vals, err := recordShared.Run(ctx, s.rdb, []string{key, key + ":window"},
+		cfg.Name, sharedChannel, generation, flag(ignored), flag(failed), flag(slow),
+		cfg.Window.String(), param, buckets, cfg.MinimumCalls,
+		strconv.FormatFloat(cfg.FailureRateThreshold, 'g', -1, 64),
+		strconv.FormatFloat(slowThreshold, 'g', -1, 64),
+		cfg.HalfOpenMaxCalls).Int64Slice()
+	if err != nil {
+		return sharedState{}, err
+	}
+	return sharedState{state: State(vals[0]), generation: uint64(vals[1])}, nil
+}
+
+// sharedKey hash-tags the breaker name so its keys share a cluster slot
+func sharedKey(name string) string {
+	return sharedKeyPrefix + "{" + name + "}"
+}
+
+func flag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func stringValue(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
+
+// admitShared admits a call by the shared state. A closed breaker admits
+// without asking Redis, and an open one rejects until its reset timeout has
+// passed; only then, and while half-open, is Redis consulted. ok is false
+// when Redis failed and the local state must decide.
+func (cb *CircuitBreaker) admitShared() (a admission, err error, ok bool) {
+	cb.mu.Lock()
+	switch {
+	case cb.state == StateClosed:
+		a = admission{generation: cb.generation, shared: true, sharedGen: cb.sharedGen}
+		cb.mu.Unlock()
+		return a, nil, true
+	case cb.state == StateOpen && time.Since(cb.openedAt) < cb.config.ResetTimeout:
+		cb.mu.Unlock()
+		return a, ErrCircuitOpen, true
+	}
+	cb.mu.Unlock()
+
+	admitted, st, err := cb.config.Shared.acquire(&cb.config)
+	if err != nil {
+		cb.config.Shared.fail(err)
+		return a, nil, false
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.applySharedLocked(st)
+	if !admitted {
+		return a, ErrCircuitOpen, true
+	}
+	return admission{generation: cb.generation, shared: true, sharedGen: st.generation}, nil, true
+}
+
+// recordShared adds a call outcome to the shared state, reporting false when
+// Redis failed and the local state must record it
+func (cb *CircuitBreaker) recordShared(a admission, ignored, failed, slow bool) bool {
+	st, err := cb.config.Shared.record(&cb.config, a.sharedGen, ignored, failed, slow)
+	if err != nil {
+		cb.config.Shared.fail(err)
+		return false
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if !ignored {
+		cb.count(failed, time.Now())
+	}
+	cb.applySharedLocked(st)
+	return true
+}
+
+// applyShared takes on the state read from Redis
+func (cb *CircuitBreaker) applyShared(st sharedState) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.applySharedLocked(st)
+}
+
+// notifyShared takes on a state published by a replica, unless a later
+// state is already known
+func (cb *CircuitBreaker) notifyShared(st sharedState) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if st.generation > cb.sharedGen {
+		cb.applySharedLocked(st)
+	}
+}
+
+func (cb *CircuitBreaker) applySharedLocked(st sharedState) {
+	cb.sharedGen = st.generation
+	if st.state != cb.state {
+		cb.transitionTo(st.state, time.Now())
+	}
+}
@@ -0,0 +1,276 @@
+package resilience
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// newSharedReplica returns a breaker sharing its state through the Redis at
+// addr, as one gateway replica would hold it
+func newSharedReplica(t *testing.T, addr string, cfg CircuitBreakerConfig) (*CircuitBreaker, *SharedBreakers) {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: addr, ContextTimeoutEnabled: true})
+	t.Cleanup(func() { rdb.Close() })
+	shared := NewSharedBreakers(rdb, zap.NewNop().Sugar())
+	cfg.Shared = shared
+	return newTestBreaker(cfg), shared
+}
+
+// newSharedReplicas returns n replicas of a breaker subscribed to the state
+// changes of each other
+func newSharedReplicas(t *testing.T, n int, cfg CircuitBreakerConfig) (*miniredis.Miniredis, []*CircuitBreaker) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	replicas := make([]*CircuitBreaker, n)
+	for i := range replicas {
+		cb, shared := newSharedReplica(t, mr.Addr(), cfg)
+		runShared(t, shared)
+		replicas[i] = cb
+	}
+	waitFor(t, "replicas to subscribe", func() bool {
+		return mr.PubSubNumSub(sharedChannel)[sharedChannel] == n
+	})
+	return mr, replicas
+}
+
+// runShared runs s until the test ends, stopping it before its client closes
+func runShared(t *testing.T, s *SharedBreakers) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSharedBreakerWindows(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      CircuitBreakerConfig
+		calls    []outcome
+		wantOpen bool
+	}{
+		{
+			name:     "consecutive failures across replicas",
+			cfg:      CircuitBreakerConfig{MaxFailures: 3},
+			calls:    []outcome{fail, fail, fail},
+			wantOpen: true,
+		},
+		{
+			name:  "a success resets consecutive failures",
+			cfg:   CircuitBreakerConfig{MaxFailures: 3},
+			calls: []outcome{fail, fail, ok, fail, fail},
+		},
+		{
+			name:  "cancelled calls are not failures",
+			cfg:   CircuitBreakerConfig{MaxFailures: 1},
+			calls: []outcome{cancelled, cancelled},
+		},
+		{
+			name:     "count window at the failure rate",
+			cfg:      CircuitBreakerConfig{Window: WindowCount, WindowSize: 4, MinimumCalls: 4},
+			calls:    []outcome{fail, ok, fail, ok},
+			wantOpen: true,
+		},
+		{
+			name:  "count window slides past old failures",
+			cfg:   CircuitBreakerConfig{Window: WindowCount, WindowSize: 4, MinimumCalls: 4, FailureRateThreshold: 0.75},
+			calls: []outcome{fail, fail, ok, ok, ok, fail},
+		},
+		{
+			name:  "fewer than the minimum calls",
+			cfg:   CircuitBreakerConfig{Window: WindowCount, WindowSize: 10, MinimumCalls: 5},
+			calls: []outcome{fail, fail, fail, fail},
+		},
+		{
+			name: "slow-call rate reached",
+			cfg: CircuitBreakerConfig{Window: WindowCount, WindowSize: 4, MinimumCalls: 4,
+				SlowCallDuration: 5 * time.Millisecond, SlowCallRateThreshold: 0.5},
+			calls:    []outcome{slow, ok, slow, ok},
+			wantOpen: true,
+		},
+		{
+			name:  "slow calls without a slow-call duration",
+			cfg:   CircuitBreakerConfig{Window: WindowCount, WindowSize: 2, MinimumCalls: 2},
+			calls: []outcome{slow, slow},
+		},
+		{
+			name:     "time window at the failure rate",
+			cfg:      CircuitBreakerConfig{Window: WindowTime, WindowDuration: time.Minute, MinimumCalls: 3},
+			calls:    []outcome{ok, fail, fail},
+			wantOpen: true,
+		},
+		{
+			name:  "time window below the failure rate",
+			cfg:   CircuitBreakerConfig{Window: WindowTime, WindowDuration: time.Minute, MinimumCalls: 3},
+			calls: []outcome{ok, ok, fail},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, replicas := newSharedReplicas(t, 2, tt.cfg)
+			// Calls alternate between the replicas, so neither sees enough of
+			// them to trip on its own
+			for i, o := range tt.calls {
+				if _, err := replicas[i%2].Execute(context.Background(), o.call); errors.Is(err, ErrCircuitOpen) {
+					t.Fatalf("call %d refused", i+1)
+				}
+			}
+			if !tt.wantOpen {
+				for i, cb := range replicas {
+					if cb.State() != StateClosed {
+						t.Errorf("replica %d: state %s", i, cb.State())
+					}
+				}
+				return
+			}
+			for i, cb := range replicas {
+				waitFor(t, "replicas to open", func() bool { return cb.State() == StateOpen })
+				if _, err := cb.Execute(context.Background(), ok.call); !errors.Is(err, ErrCircuitOpen) {
+					t.Errorf("replica %d: err %v, want ErrCircuitOpen", i, err)
+				}
+			}
+		})
+	}
+}
+
+func TestSharedBreakerFollowsTheReplicaThatTrips(t *testing.T) {
+	cfg := CircuitBreakerConfig{MaxFailures: 1, ResetTimeout: 30 * time.Millisecond, HalfOpenMaxCalls: 1}
+	mr, replicas := newSharedReplicas(t, 2, cfg)
+	a, b := replicas[0], replicas[1]
+
+	// Malformed notifications are skipped
+	mr.Publish(sharedChannel, "not json")
+
+	b.Execute(context.Background(), fail.call)
+	if b.State() != StateOpen {
+		t.Fatalf("tripping replica: state %s", b.State())
+	}
+	waitFor(t, "the other replica to open", func() bool { return a.State() == StateOpen })
+	if _, err := a.Execute(context.Background(), ok.call); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("following replica: err %v, want ErrCircuitOpen", err)
+	}
+
+	// One probe for all replicas: while b's probe runs, a is refused
+	time.Sleep(40 * time.Millisecond)
+	release, done := startCall(t, b)
+	if _, err := a.Execute(context.Background(), ok.call); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second probe: err %v, want ErrCircuitOpen", err)
+	}
+	release <- ok
+	<-done
+	for i, cb := range replicas {
+		waitFor(t, "replicas to close", func() bool { return cb.State() == StateClosed })
+		if _, err := cb.Execute(context.Background(), ok.call); err != nil {
+			t.Errorf("replica %d: %v", i, err)
+		}
+	}
+}
+
+func TestSharedBreakerDropsStaleOutcomes(t *testing.T) {
+	cfg := CircuitBreakerConfig{MaxFailures: 1, ResetTimeout: 30 * time.Millisecond, HalfOpenMaxCalls: 1}
+	mr, replicas := newSharedReplicas(t, 2, cfg)
+	a, b := replicas[0], replicas[1]
+	key := sharedKey("test")
+
+	// a admits a call while closed, which outlives the closed state
+	stale, staleDone := startCall(t, a)
+	b.Execute(context.Background(), fail.call)
+	waitFor(t, "a to open", func() bool { return a.State() == StateOpen })
+	stale <- fail
+	<-staleDone
+	if gen := mr.HGet(key, "gen"); gen != "1" {
+		t.Fatalf("generation %s after a stale failure, want 1", gen)
+	}
+
+	// Once b's probe half-opens the breaker, the success of a call admitted
+	// while open must not count as the probe closing it
+	time.Sleep(40 * time.Millisecond)
+	probe, probeDone := startCall(t, b)
+	if mr.HGet(key, "state") != "2" {
+		t.Fatalf("shared state %s, want half-open", mr.HGet(key, "state"))
+	}
+	if _, err := recordOutcome(a, 1, ok); err != nil {
+		t.Fatal(err)
+	}
+	if state := mr.HGet(key, "state"); state != "2" {
+		t.Fatalf("shared state %s after a stale success, want half-open", state)
+	}
+	probe <- fail
+	<-probeDone
+	if state := mr.HGet(key, "state"); state != "1" {
+		t.Fatalf("shared state %s after the probe failed, want open", state)
+	}
+}
+
+// recordOutcome records an outcome in the shared state as a call admitted in
+// generation gen would
+func recordOutcome(cb *CircuitBreaker, gen uint64, o outcome) (sharedState, error) {
+	return cb.config.Shared.record(&cb.config, gen, o == cancelled, o == fail, o == slow)
+}
+
+func TestSharedBreakerSyncsOnStart(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.HSet(sharedKey("test"), "state", "1", "gen", "7")
+
+	cb, shared := newSharedReplica(t, mr.Addr(), CircuitBreakerConfig{ResetTimeout: time.Minute})
+	runShared(t, shared)
+
+	waitFor(t, "the breaker to load the shared state", func() bool { return cb.State() == StateOpen })
+	// A notification of an earlier generation is stale
+	mr.Publish(sharedChannel, `{"breaker":"test","state":0,"gen":3}`)
+	mr.Publish(sharedChannel, `{"breaker":"other","state":0,"gen":9}`)
+	time.Sleep(20 * time.Millisecond)
+	if cb.State() != StateOpen {
+		t.Fatalf("state %s after a stale notification", cb.State())
+	}
+	mr.Publish(sharedChannel, `{"breaker":"test","state":0,"gen":8}`)
+	waitFor(t, "the breaker to close", func() bool { return cb.State() == StateClosed })
+}
+
+func TestSharedBreakerFallsBackToLocalState(t *testing.T) {
+	mr := miniredis.RunT(t)
+	cb, shared := newSharedReplica(t, mr.Addr(), CircuitBreakerConfig{MaxFailures: 2})
+	mr.Close()
+
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if _, err := cb.Execute(context.Background(), fail.call); !errors.Is(err, errService) {
+			t.Fatalf("call %d: err %v", i+1, err)
+		}
+		if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Errorf("call %d waited %s for Redis", i+1, elapsed)
+		}
+	}
+	if shared.available() {
+		t.Fatal("shared state still considered available")
+	}
+	if cb.State() != StateOpen {
+		t.Fatalf("state %s, want the local state to trip", cb.State())
+	}
+	if stats := cb.Stats(); stats["shared"] != false {
+		t.Errorf("stats shared = %v", stats["shared"])
+	}
+}
@@ -0,0 +1,106 @@
+package resilience
+
+import "time"
+
+// timeWindowBuckets is the number of buckets a time-based window is split
+// into; calls expire from the window a bucket at a time
+const timeWindowBuckets = 10
+
+// windowBucket tallies the calls of one slot of a sliding window
+type windowBucket struct {
+	start    time.Time
+	calls    int
+	failures int
+	slow     int
+}
+
+// slidingWindow tallies the outcomes of recent calls: either the last
+// len(buckets) calls, one per bucket, or the calls of the last
+// len(buckets)*width of time. total is kept in step with the buckets so
+// reading it is O(1).
+type slidingWindow struct {
+	buckets []windowBucket
+	// width is the span of time a bucket covers; zero for a count-based window
+	width time.Duration
+	head  int
+	total windowBucket
+}
+
+func newCountWindow(size int) *slidingWindow {
+	return &slidingWindow{buckets: make([]windowBucket, size)}
+}
+
+func newTimeWindow(d time.Duration) *slidingWindow {
+	width := d / timeWindowBuckets
+	if width < time.Millisecond {
+		width = time.Millisecond
+	}
+	return &slidingWindow{buckets: make([]windowBucket, timeWindowBuckets), width: width}
+}
+
+// record adds the outcome of a call made at now
+func (w *slidingWindow) record(now time.Time, failed, slow bool) {
+	if w.width == 0 {
+		w.head = (w.head + 1) % len(w.buckets)
+		w.evict(w.head)
+	} else {
+		w.advance(now)
+	}
+
+	b := &w.buckets[w.head]
+	b.calls++
+	w.total.calls++
+	if failed {
+		b.failures++
+		w.total.failures++
+	}
+	if slow {
+		b.slow++
+		w.total.slow++
+	}
+}
+
+// totals returns the calls in the window as of now, and how many of them
+// failed or were slow
+func (w *slidingWindow) totals(now time.Time) (calls, failures, slow int) {
+	if w.width > 0 {
+		w.advance(now)
+	}
+	return w.total.calls, w.total.failures, w.total.slow
+}
+
+// advance moves the head of a time-based window to the bucket now falls in,
+// evicting the buckets that have fallen out of the window
+func (w *slidingWindow) advance(now time.Time) {
+	start := now.Truncate(w.width)
+	head := w.buckets[w.head].start
+	steps := int(start.Sub(head) / w.width)
+	switch {
+	case head.IsZero() || steps >= len(w.buckets):
+		w.reset()
+		w.buckets[w.head].start = start
+	case steps > 0:
+		for i := 1; i <= steps; i++ {
+			w.head = (w.head + 1) % len(w.buckets)
+			w.evict(w.head)
+			w.buckets[w.head].start = head.Add(time.Duration(i) * w.width)
+		}
+	}
+	// A clock stepping backwards leaves the head where it is
+}
+
+func (w *slidingWindow) evict(i int) {
+	b := &w.buckets[i]
+	w.total.calls -= b.calls
+	w.total.failures -= b.failures
+	w.total.slow -= b.slow
+	*b = windowBucket{}
+}
+
+func (w *slidingWindow) reset() {
+	for i := range w.buckets {
+		w.buckets[i] = windowBucket{}
+	}
+	w.head = 0
+	w.total = windowBucket{}
+}
@@ -0,0 +1,69 @@
+package resilience
+
+import (
+	"testing"
+	"time"
+)
+
+type windowTotals struct{ calls, failures, slow int }
+
+func totalsAt(w *slidingWindow, now time.Time) windowTotals {
+	calls, failures, slow := w.totals(now)
+	return windowTotals{calls, failures, slow}
+}
+
+func TestCountWindowHoldsTheLastCalls(t *testing.T) {
+	now := time.Now()
+	w := newCountWindow(3)
+
+	w.record(now, true, false)
+	w.record(now, false, true)
+	w.record(now, false, false)
+	if got := totalsAt(w, now); got != (windowTotals{3, 1, 1}) {
+		t.Fatalf("totals = %+v", got)
+	}
+	// The fourth call pushes out the first, failed one
+	w.record(now, false, false)
+	if got := totalsAt(w, now); got != (windowTotals{3, 0, 1}) {
+		t.Fatalf("after sliding: %+v", got)
+	}
+	// Count windows do not age
+	if got := totalsAt(w, now.Add(time.Hour)); got != (windowTotals{3, 0, 1}) {
+		t.Fatalf("an hour later: %+v", got)
+	}
+}
+
+func TestTimeWindowExpiresOldBuckets(t *testing.T) {
+	// Buckets are 100ms wide
+	w := newTimeWindow(time.Second)
+	start := time.Now().Truncate(100 * time.Millisecond)
+	at := func(ms int) time.Time { return start.Add(time.Duration(ms) * time.Millisecond) }
+
+	tests := []struct {
+		ms     int
+		record bool
+		failed bool
+		slow   bool
+		want   windowTotals
+	}{
+		{ms: 0, record: true, failed: true, want: windowTotals{1, 1, 0}},
+		{ms: 500, record: true, slow: true, want: windowTotals{2, 1, 1}},
+		{ms: 950, want: windowTotals{2, 1, 1}},
+		// The bucket of the first call falls out of the window
+		{ms: 1000, want: windowTotals{1, 0, 1}},
+		// A clock stepping backwards records into the current bucket
+		{ms: 700, record: true, failed: true, want: windowTotals{2, 1, 1}},
+		{ms: 1550, want: windowTotals{1, 1, 0}},
+		// Idling longer than the window empties it
+		{ms: 5000, want: windowTotals{}},
+		{ms: 5010, record: true, want: windowTotals{1, 0, 0}},
+	}
+	for _, tt := range tests {
+		if tt.record {
+			w.record(at(tt.ms), tt.failed, tt.slow)
+		}
+		if got := totalsAt(w, at(tt.ms)); got != tt.want {
+			t.Fatalf("at %dms: totals = %+v, want %+v", tt.ms, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package rules
+
+import "sort"
+
+// Cycle is a strongly connected group of nodes together with one closed
+// dependency path through it
+type Cycle struct {
+	Nodes []string `json:"nodes"`
+	Edges []Edge   `json:"edges"`
+}
+
+// FindCycles returns one cycle per strongly connected component of the
+// graph formed by edges, including single nodes that depend on themselves.
+// Components and their nodes are sorted so results are stable.
+func FindCycles(edges []Edge) []Cycle {
+	adj := adjacency(edges)
+	next := successors(adj)
+	var cycles []Cycle
+	for _, component := range stronglyConnected(adj, next) {
+		cycles = append(cycles, Cycle{Nodes: component, Edges: closedPath(component, adj, next)})
+	}
+	return cycles
+}
+
+// StronglyConnected returns the strongly connected components of the graph
+// formed by edges that contain a cycle: groups of two or more nodes, and
+// single nodes that depend on themselves. Components and their nodes are
+// sorted so results are stable.
+func StronglyConnected(edges []Edge) [][]string {
+	adj := adjacency(edges)
+	return stronglyConnected(adj, successors(adj))
+}
+
+// adjacency indexes the first edge between each pair of nodes
+func adjacency(edges []Edge) map[string]map[string]Edge {
+	adj := make(map[string]map[string]Edge)
+	for _, e := range edges {
+		if adj[e.Source] == nil {
+			adj[e.Source] = make(map[string]Edge)
+		}
+		if _, ok := adj[e.Source][e.Target]; !ok {
+			adj[e.Source][e.Target] = e
+		}
+		if adj[e.Target] == nil {
+			adj[e.Target] = make(map[string]Edge)
+		}
+	}
+	return adj
+}
+
+func successors(adj map[string]map[string]Edge) func(string) []string {
+	return func(n string) []string {
+		out := make([]string, 0, len(adj[n]))
+		for t := range adj[n] {
+			out = append(out, t)
+		}
+		sort.Strings(out)
+		return out
+	}
+}
+
+// stronglyConnected runs Tarjan's algorithm
+func stronglyConnected(adj map[string]map[string]Edge, next func(string) []string) [][]string {
+	nodes := make([]string, 0, len(adj))
+	for n := range adj {
+		nodes = append(nodes, n)
+	}
+	sort.Strings(nodes)
+
+	var (
+		index      = make(map[string]int, len(nodes))
+		low        = make(map[string]int, len(nodes))
+		onStack    = make(map[string]bool, len(nodes))
+		stack      []string
+		counter    int
+		components [][]string
+	)
+	var connect func(n string)
+	connect = func(n string) {
+		index[n] = counter
+		low[n] = counter
+		counter++
+		stack = append(stack, n)
+		onStack[n] = true
+
+		for _, t := range next(n) {
+			if _, seen := index[t]; !seen {
+				connect(t)
+				low[n] = min(low[n], low[t])
+			} else if onStack[t] {
+				low[n] = min(low[n], index[t])
+			}
+		}
+
+		if low[n] != index[n] {
+			return
+		}
+		var component []string
+		for {
+			top := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			onStack[top] = false
+			component = append(component, top)
+			if top == n {
+				break
+			}
+		}
+		if _, selfLoop := adj[n][n]; len(component) > 1 || selfLoop {
+			sort.Strings(component)
+			components = append(components, component)
+		}
+	}
+	for _, n := range nodes {
+		if _, seen := index[n]; !seen {
+			connect(n)
+		}
+	}
+
+	sort.Slice(components, func(i, j int) bool { return components[i][0] < components[j][0] })
+	return components
+}
+
+// closedPath finds the shortest cycle through the first node of a strongly
+// connected component, staying inside the component
+func closedPath(component []string, adj map[string]map[string]Edge, next func(string) []string) []Edge {
+	start := component[0]
+	if e, ok := adj[start][start]; ok {
+		return []Edge{e}
+	}
+	inComponent := make(map[string]bool, len(component))
+	for _, n := range component {
+		inComponent[n] = true
+	}
+
+	via := map[string]Edge{}
+	queue := []string{start}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, t := range next(n) {
+			if !inComponent[t] {
+				continue
+			}
+			if t == start {
+				path := []Edge{adj[n][t]}
+				for cur := n; cur != start; cur = via[cur].Source {
+					path = append(path, via[cur])
+				}
+				for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+					path[i], path[j] = path[j], path[i]
+				}
+				return path
+			}
+			if _, seen := via[t]; !seen {
+				via[t] = adj[n][t]
+				queue = append(queue, t)
+			}
+		}
+	}
+	return nil
+}
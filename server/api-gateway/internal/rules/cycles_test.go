@@ -0,0 +1,57 @@
+package rules
+
+import (
+	"reflect"
+	"testing"
+)
+
+func importEdge(source, target string) Edge {
+	return Edge{Source: source, Target: target, Type: "import"}
+}
+
+func TestFindCycles(t *testing.T) {
+	tests := []struct {
+		name  string
+		edges []Edge
+		want  []Cycle
+	}{
+		{"empty", nil, nil},
+		{"acyclic", []Edge{importEdge("a", "b"), importEdge("b", "c"), importEdge("a", "c")}, nil},
+		{
+			"self loop",
+			[]Edge{importEdge("a", "b"), importEdge("b", "b")},
+			[]Cycle{{Nodes: []string{"b"}, Edges: []Edge{importEdge("b", "b")}}},
+		},
+		{
+			"two nodes",
+			[]Edge{importEdge("b", "a"), importEdge("a", "b")},
+			[]Cycle{{Nodes: []string{"a", "b"}, Edges: []Edge{importEdge("a", "b"), importEdge("b", "a")}}},
+		},
+		{
+			"shortest path through first node",
+			[]Edge{importEdge("a", "b"), importEdge("b", "c"), importEdge("c", "d"), importEdge("d", "a"), importEdge("c", "a")},
+			[]Cycle{{Nodes: []string{"a", "b", "c", "d"}, Edges: []Edge{importEdge("a", "b"), importEdge("b", "c"), importEdge("c", "a")}}},
+		},
+		{
+			"separate components sorted",
+			[]Edge{importEdge("x", "y"), importEdge("y", "x"), importEdge("m", "n"), importEdge("n", "m"), importEdge("y", "m")},
+			[]Cycle{
+				{Nodes: []string{"m", "n"}, Edges: []Edge{importEdge("m", "n"), importEdge("n", "m")}},
+				{Nodes: []string{"x", "y"}, Edges: []Edge{importEdge("x", "y"), importEdge("y", "x")}},
+			},
+		},
+		{
+			"first of duplicate edges kept",
+			[]Edge{{Source: "a", Target: "b", Type: "import", Line: 3}, {Source: "a", Target: "b", Type: "calls", Line: 9}, importEdge("b", "a")},
+			[]Cycle{{Nodes: []string{"a", "b"}, Edges: []Edge{{Source: "a", Target: "b", Type: "import", Line: 3}, importEdge("b", "a")}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FindCycles(tt.edges)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("FindCycles = %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Package rules evaluates architectural rules against a repository's
+// dependency graph.
+//
+// A rule is stored in architectural_rules.rule_definition as a JSON object
+// whose "type" selects what it checks. Paths are repository-relative and
+// use forward slashes.
+//
+// # Selectors
+//
+// The from, to, allow, scope and except fields are lists of selectors. A
+// selector is either the name of a layer declared in the rule's "layers"
+// map or a path glob: "*" matches within one path segment, "?" matches one
+// character and "**" matches any number of segments.
+//
+//	"layers": {
+//	  "domain":         ["src/domain/**"],
+//	  "infrastructure": ["src/infra/**", "src/db/**"]
+//	}
+//
+// Every dependency rule may restrict the edges it considers with
+// "dep_types" (import, require, extends, implements, uses, calls); by
+// default all edges count. Files matching "except" are exempt.
+//
+// # Rule types
+//
+// forbidden_dependency: files in from must not depend on files in to.
+//
+//	{"type": "forbidden_dependency", "from": ["domain"], "to": ["infrastructure"]}
+//
+// allowed_dependencies: files in from may only depend on each other and on
+// files in allow.
+//
+//	{"type": "allowed_dependencies", "from": ["src/ui/**"], "allow": ["src/api/**", "src/shared/**"]}
+//
+// no_cycles: files in scope (default: all files) must not form dependency
+// cycles among themselves. Each cycle is reported once.
+//
+//	{"type": "no_cycles", "scope": ["src/**"], "dep_types": ["import"]}
+//
+// max_fan_out / max_fan_in: files in scope must not depend on, or be
+// depended on by, more than max distinct files.
+//
+//	{"type": "max_fan_out", "scope": ["src/**"], "max": 15}
+//
+// naming: files in scope must match a regular expression. "match" selects
+// whether the pattern applies to the file "name" (default) or full "path".
+//
+//	{"type": "naming", "scope": ["src/components/**/*.tsx"], "pattern": "^[A-Z][A-Za-z0-9]*\\.tsx$"}
+package rules
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RuleType selects what a rule checks
+type RuleType string
+
+const (
+	TypeForbiddenDependency RuleType = "forbidden_dependency"
+	TypeAllowedDependencies RuleType = "allowed_dependencies"
+	TypeNoCycles            RuleType = "no_cycles"
+	TypeMaxFanOut           RuleType = "max_fan_out"
+	TypeMaxFanIn            RuleType = "max_fan_in"
+	TypeNaming              RuleType = "naming"
+)
+
+// DepTypes are the dependency kinds produced by the parser
+var DepTypes = []string{"import", "require", "extends", "implements", "uses", "calls"}
+
+// Definition is the decoded rule_definition of an architectural rule
+type Definition struct {
+	Type     RuleType            `json:"type"`
+	Layers   map[string][]string `json:"layers,omitempty"`
+	From     []string            `json:"from,omitempty"`
+	To       []string            `json:"to,omitempty"`
+	Allow    []string            `json:"allow,omitempty"`
+	Scope    []string            `json:"scope,omitempty"`
+	Except   []string            `json:"except,omitempty"`
+	DepTypes []string            `json:"dep_types,omitempty"`
+	Max      *int                `json:"max,omitempty"`
+	Pattern  string              `json:"pattern,omitempty"`
+	Match    string              `json:"match,omitempty"`
+}
+
+// DefinitionError describes an invalid field of a rule definition
+type DefinitionError struct {
+	Field   string
+	Message string
+}
+
+func (e *DefinitionError) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Message)
+}
+
+// compiled is a validated definition with its selectors resolved
+type compiled struct {
+	Definition
+	from, to, allow, scope, except selector
+	depTypes                       map[string]bool
+	pattern                        *regexp.Regexp
+}
+
+// Validate checks that raw is a well-formed rule definition
+func Validate(raw json.RawMessage) error {
+	_, err := compile(raw)
+	return err
+}
+
+func compile(raw json.RawMessage) (*compiled, error) {
+	var def Definition
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&def); err != nil {
+		return nil, &DefinitionError{Field: "rule_definition", Message: "is not a valid rule definition: " + err.Error()}
+	}
+
+	c := &compiled{Definition: def}
+	layers := make(map[string]selector, len(def.Layers))
+	for name, globs := range def.Layers {
+		if strings.TrimSpace(name) == "" {
+			return nil, &DefinitionError{Field: "layers", Message: "must not contain an empty layer name"}
+		}
+		if len(globs) == 0 {
+			return nil, &DefinitionError{Field: "layers." + name, Message: "must list at least one path glob"}
+		}
+		sel, err := compileSelector(globs, nil)
+		if err != nil {
+			return nil, &DefinitionError{Field: "layers." + name, Message: err.Error()}
+		}
+		layers[name] = sel
+	}
+
+	var err error
+	for _, f := range []struct {
+		name string
+		in   []string
+		out  *selector
+	}{
+		{"from", def.From, &c.from},
+		{"to", def.To, &c.to},
+		{"allow", def.Allow, &c.allow},
+		{"scope", def.Scope, &c.scope},
+		{"except", def.Except, &c.except},
+	} {
+		if *f.out, err = compileSelector(f.in, layers); err != nil {
+			return nil, &DefinitionError{Field: f.name, Message: err.Error()}
+		}
+	}
+
+	if len(def.DepTypes) > 0 {
+		c.depTypes = make(map[string]bool, len(def.DepTypes))
+		for _, t := range def.DepTypes {
+			if !isDepType(t) {
+				return nil, &DefinitionError{Field: "dep_types", Message: "must only contain " + strings.Join(DepTypes, ", ")}
+			}
+			c.depTypes[t] = true
+		}
+	}
+
+	switch def.Type {
+	case TypeForbiddenDependency:
+		if len(def.From) == 0 {
+			return nil, &DefinitionError{Field: "from", Message: "is required"}
+		}
+		if len(def.To) == 0 {
+			return nil, &DefinitionError{Field: "to", Message: "is required"}
+		}
+	case TypeAllowedDependencies:
+		if len(def.From) == 0 {
+			return nil, &DefinitionError{Field: "from", Message: "is required"}
+		}
+	case TypeNoCycles:
+	case TypeMaxFanOut, TypeMaxFanIn:
+		if def.Max == nil {
+			return nil, &DefinitionError{Field: "max", Message: "is required"}
+		}
+		if *def.Max < 0 {
+			return nil, &DefinitionError{Field: "max", Message: "must not be negative"}
+		}
+	case TypeNaming:
+		if def.Pattern == "" {
+			return nil, &DefinitionError{Field: "pattern", Message: "is required"}
+		}
+		if c.pattern, err = regexp.Compile(def.Pattern); err != nil {
+			return nil, &DefinitionError{Field: "pattern", Message: "is not a valid regular expression: " + err.Error()}
+		}
+		if def.Match != "" && def.Match != "name" && def.Match != "path" {
+			return nil, &DefinitionError{Field: "match", Message: "must be name or path"}
+		}
+	case "":
+		return nil, &DefinitionError{Field: "type", Message: "is required"}
+	default:
+		return nil, &DefinitionError{Field: "type", Message: fmt.Sprintf("%q is not a known rule type", def.Type)}
+	}
+	return c, nil
+}
+
+func isDepType(t string) bool {
+	for _, known := range DepTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,272 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ErrNoGraph is returned when no dependency graph was recorded for a commit
+var ErrNoGraph = errors.New("no dependency graph recorded for this commit")
+
+// maxViolationsPerRule bounds how many violations one rule may report per
+// evaluation so a single broad rule cannot flood the drift log
+const maxViolationsPerRule = 500
+
+// Graph is a repository's file-level dependency graph for one analysis
+type Graph struct {
+	Files []string `json:"files"`
+	Edges []Edge   `json:"edges"`
+}
+
+// Edge is a dependency from one file to another, located at Line in Source
+type Edge struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Type   string `json:"dep_type"`
+	Line   int    `json:"line,omitempty"`
+}
+
+// Rule is an enabled architectural rule of an organization
+type Rule struct {
+	ID          string
+	Name        string
+	Description string
+	Category    string
+	Severity    string
+	Definition  json.RawMessage
+}
+
+// Violation is a single finding, recorded as a drift event
+type Violation struct {
+	RuleID      string `json:"rule_id"`
+	Severity    string `json:"severity"`
+	Category    string `json:"category"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	FilePath    string `json:"file_path,omitempty"`
+	Line        int    `json:"line_number,omitempty"`
+}
+
+// Result summarizes an evaluation
+type Result struct {
+	RulesEvaluated int               `json:"rules_evaluated"`
+	Passed         int               `json:"passed"`
+	Violations     []Violation       `json:"-"`
+	Truncated      []string          `json:"truncated_rules,omitempty"`
+	InvalidRules   map[string]string `json:"invalid_rules,omitempty"`
+}
+
+// finding is a rule-agnostic violation location
+type finding struct {
+	file  string
+	line  int
+	title string
+}
+
+// Evaluate checks rules against a dependency graph. Rules whose definition
+// does not compile are reported in InvalidRules and otherwise ignored.
+func Evaluate(g *Graph, rules []Rule) Result {
+	res := Result{Violations: []Violation{}}
+	for _, r := range rules {
+		c, err := compile(r.Definition)
+		if err != nil {
+			if res.InvalidRules == nil {
+				res.InvalidRules = make(map[string]string)
+			}
+			res.InvalidRules[r.ID] = err.Error()
+			continue
+		}
+
+		res.RulesEvaluated++
+		findings := c.evaluate(g)
+		if len(findings) == 0 {
+			res.Passed++
+			continue
+		}
+		if len(findings) > maxViolationsPerRule {
+			findings = findings[:maxViolationsPerRule]
+			res.Truncated = append(res.Truncated, r.ID)
+		}
+		for _, f := range findings {
+			res.Violations = append(res.Violations, Violation{
+				RuleID:      r.ID,
+				Severity:    r.Severity,
+				Category:    r.Category,
+				Title:       f.title,
+				Description: ruleDescription(r),
+				FilePath:    f.file,
+				Line:        f.line,
+			})
+		}
+	}
+	return res
+}
+
+// ruleDescription names the violated rule in a drift event description
+func ruleDescription(r Rule) string {
+	if r.Description == "" {
+		return "Violates rule " + r.Name
+	}
+	return "Violates rule " + r.Name + ": " + r.Description
+}
+
+func (c *compiled) evaluate(g *Graph) []finding {
+	var findings []finding
+	switch c.Type {
+	case TypeForbiddenDependency:
+		for _, e := range c.edges(g) {
+			if c.from.matches(e.Source) && c.to.matches(e.Target) {
+				findings = append(findings, finding{e.Source, e.Line,
+					fmt.Sprintf("Forbidden %s of %s", e.Type, e.Target)})
+			}
+		}
+	case TypeAllowedDependencies:
+		for _, e := range c.edges(g) {
+			if c.from.matches(e.Source) && !c.from.matches(e.Target) && !c.allow.matches(e.Target) {
+				findings = append(findings, finding{e.Source, e.Line,
+					fmt.Sprintf("%s of %s is not an allowed dependency", capitalize(e.Type), e.Target)})
+			}
+		}
+	case TypeNoCycles:
+		var scoped []Edge
+		for _, e := range c.edges(g) {
+			if c.inScope(e.Source) && c.inScope(e.Target) {
+				scoped = append(scoped, e)
+			}
+		}
+		for _, cycle := range FindCycles(scoped) {
+			first := cycle.Edges[0]
+			chain := make([]string, 0, len(cycle.Edges)+1)
+			for _, e := range cycle.Edges {
+				chain = append(chain, e.Source)
+			}
+			chain = append(chain, first.Source)
+			findings = append(findings, finding{first.Source, first.Line,
+				"Dependency cycle: " + strings.Join(chain, " → ")})
+		}
+	case TypeMaxFanOut:
+		findings = c.fan(g, true)
+	case TypeMaxFanIn:
+		findings = c.fan(g, false)
+	case TypeNaming:
+		for _, file := range files(g) {
+			if !c.inScope(file) {
+				continue
+			}
+			subject := path.Base(file)
+			if c.Match == "path" {
+				subject = file
+			}
+			if !c.pattern.MatchString(subject) {
+				findings = append(findings, finding{file, 0,
+					fmt.Sprintf("%s does not match naming convention %s", subject, c.Pattern)})
+			}
+		}
+	}
+	return findings
+}
+
+// edges returns the edges the rule considers, in a stable order
+func (c *compiled) edges(g *Graph) []Edge {
+	out := make([]Edge, 0, len(g.Edges))
+	for _, e := range g.Edges {
+		if c.depTypes != nil && !c.depTypes[e.Type] {
+			continue
+		}
+		if c.except.matches(e.Source) {
+			continue
+		}
+		out = append(out, e)
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Source != out[j].Source {
+			return out[i].Source < out[j].Source
+		}
+		return out[i].Line < out[j].Line
+	})
+	return out
+}
+
+// inScope reports whether a file is covered by the rule's scope, which
+// defaults to every file
+func (c *compiled) inScope(file string) bool {
+	if c.except.matches(file) {
+		return false
+	}
+	return len(c.scope) == 0 || c.scope.matches(file)
+}
+
+// fan counts the distinct files each in-scope file depends on (out) or is
+// depended on by (in)
+func (c *compiled) fan(g *Graph, out bool) []finding {
+	type counter struct {
+		peers map[string]bool
+		line  int
+	}
+	counts := make(map[string]*counter)
+	for _, e := range c.edges(g) {
+		file, peer := e.Target, e.Source
+		if out {
+			file, peer = e.Source, e.Target
+		}
+		if file == peer || !c.inScope(file) {
+			continue
+		}
+		cnt := counts[file]
+		if cnt == nil {
+			cnt = &counter{peers: make(map[string]bool)}
+			counts[file] = cnt
+		}
+		if cnt.peers[peer] {
+			continue
+		}
+		cnt.peers[peer] = true
+		// Point fan-out violations at the first dependency over the limit
+		if out && len(cnt.peers) == *c.Max+1 {
+			cnt.line = e.Line
+		}
+	}
+
+	var findings []finding
+	for file, cnt := range counts {
+		if len(cnt.peers) <= *c.Max {
+			continue
+		}
+		title := fmt.Sprintf("Depends on %d files, more than the maximum of %d", len(cnt.peers), *c.Max)
+		if !out {
+			title = fmt.Sprintf("Depended on by %d files, more than the maximum of %d", len(cnt.peers), *c.Max)
+		}
+		findings = append(findings, finding{file, cnt.line, title})
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].file < findings[j].file })
+	return findings
+}
+
+// files returns every file in the graph, including edge endpoints
+func files(g *Graph) []string {
+	seen := make(map[string]bool, len(g.Files))
+	for _, f := range g.Files {
+		seen[f] = true
+	}
+	for _, e := range g.Edges {
+		seen[e.Source] = true
+		seen[e.Target] = true
+	}
+	out := make([]string, 0, len(seen))
+	for f := range seen {
+		out = append(out, f)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
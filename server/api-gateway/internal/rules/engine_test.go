@@ -0,0 +1,170 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// testGraph is a small layered application with one cycle
+var testGraph = &Graph{
+	Files: []string{"src/domain/order.go", "src/domain/user.go", "src/infra/db.go", "src/ui/Page.tsx", "src/ui/widget.tsx", "README.md"},
+	Edges: []Edge{
+		{Source: "src/domain/order.go", Target: "src/infra/db.go", Type: "import", Line: 4},
+		{Source: "src/domain/order.go", Target: "src/domain/user.go", Type: "import", Line: 5},
+		{Source: "src/domain/user.go", Target: "src/domain/order.go", Type: "calls", Line: 12},
+		{Source: "src/ui/Page.tsx", Target: "src/domain/order.go", Type: "import", Line: 1},
+		{Source: "src/ui/Page.tsx", Target: "src/infra/db.go", Type: "import", Line: 2},
+		{Source: "src/ui/widget.tsx", Target: "src/ui/Page.tsx", Type: "import", Line: 1},
+	},
+}
+
+// located is a violation reduced to what the tests compare
+type located struct {
+	File  string
+	Line  int
+	Title string
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		want       []located
+	}{
+		{
+			name:       "forbidden dependency between layers",
+			definition: `{"type":"forbidden_dependency","layers":{"domain":["src/domain/**"],"infra":["src/infra/**"]},"from":["domain"],"to":["infra"]}`,
+			want:       []located{{"src/domain/order.go", 4, "Forbidden import of src/infra/db.go"}},
+		},
+		{
+			name:       "forbidden dependency limited by dep_types",
+			definition: `{"type":"forbidden_dependency","from":["src/domain/**"],"to":["src/domain/**"],"dep_types":["calls"]}`,
+			want:       []located{{"src/domain/user.go", 12, "Forbidden calls of src/domain/order.go"}},
+		},
+		{
+			name:       "forbidden dependency with exemption",
+			definition: `{"type":"forbidden_dependency","from":["src/**"],"to":["src/infra/**"],"except":["src/ui/**"]}`,
+			want:       []located{{"src/domain/order.go", 4, "Forbidden import of src/infra/db.go"}},
+		},
+		{
+			name:       "allowed dependencies",
+			definition: `{"type":"allowed_dependencies","from":["src/ui/**"],"allow":["src/domain/**"]}`,
+			want:       []located{{"src/ui/Page.tsx", 2, "Import of src/infra/db.go is not an allowed dependency"}},
+		},
+		{
+			name:       "no cycles",
+			definition: `{"type":"no_cycles","scope":["src/**"]}`,
+			want:       []located{{"src/domain/order.go", 5, "Dependency cycle: src/domain/order.go → src/domain/user.go → src/domain/order.go"}},
+		},
+		{
+			name:       "no cycles among imports only",
+			definition: `{"type":"no_cycles","dep_types":["import"]}`,
+		},
+		{
+			name:       "max fan out",
+			definition: `{"type":"max_fan_out","max":1}`,
+			want: []located{
+				{"src/domain/order.go", 5, "Depends on 2 files, more than the maximum of 1"},
+				{"src/ui/Page.tsx", 2, "Depends on 2 files, more than the maximum of 1"},
+			},
+		},
+		{
+			name:       "max fan in",
+			definition: `{"type":"max_fan_in","scope":["src/infra/**","src/domain/**"],"max":1}`,
+			want: []located{
+				{"src/domain/order.go", 0, "Depended on by 2 files, more than the maximum of 1"},
+				{"src/infra/db.go", 0, "Depended on by 2 files, more than the maximum of 1"},
+			},
+		},
+		{
+			name:       "naming by file name",
+			definition: `{"type":"naming","scope":["src/ui/**/*.tsx"],"pattern":"^[A-Z][A-Za-z0-9]*\\.tsx$"}`,
+			want:       []located{{"src/ui/widget.tsx", 0, "widget.tsx does not match naming convention ^[A-Z][A-Za-z0-9]*\\.tsx$"}},
+		},
+		{
+			name:       "naming by path",
+			definition: `{"type":"naming","match":"path","pattern":"^src/"}`,
+			want:       []located{{"README.md", 0, "README.md does not match naming convention ^src/"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := Evaluate(testGraph, []Rule{{ID: "r1", Name: "rule", Severity: "high", Definition: json.RawMessage(tt.definition)}})
+			if len(res.InvalidRules) > 0 {
+				t.Fatalf("invalid rule: %v", res.InvalidRules)
+			}
+			got := []located{}
+			for _, v := range res.Violations {
+				if v.RuleID != "r1" || v.Severity != "high" {
+					t.Fatalf("violation not attributed to its rule: %+v", v)
+				}
+				got = append(got, located{v.FilePath, v.Line, v.Title})
+			}
+			if tt.want == nil {
+				tt.want = []located{}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("violations = %+v\nwant %+v", got, tt.want)
+			}
+			wantPassed := 0
+			if len(tt.want) == 0 {
+				wantPassed = 1
+			}
+			if res.RulesEvaluated != 1 || res.Passed != wantPassed {
+				t.Fatalf("evaluated %d, passed %d", res.RulesEvaluated, res.Passed)
+			}
+		})
+	}
+}
+
+func TestEvaluateInvalidRules(t *testing.T) {
+	tests := []struct {
+		definition string
+		field      string
+	}{
+		{`{"type":"forbidden_dependency","from":["src/**"]}`, "to"},
+		{`{"type":"max_fan_in","max":-1}`, "max"},
+		{`{"type":"naming","pattern":"("}`, "pattern"},
+		{`{"type":"no_cycles","dep_types":["imports"]}`, "dep_types"},
+		{`{"type":"no_cycles","scope":[""]}`, "scope"},
+		{`{"type":"no_cycles","layers":{"x":[]}}`, "layers.x"},
+		{`{"type":"teleport"}`, "type"},
+		{`{"type":"no_cycles","colour":"red"}`, "rule_definition"},
+	}
+	rules := []Rule{{ID: "ok", Definition: json.RawMessage(`{"type":"no_cycles","dep_types":["import"]}`)}}
+	for i, tt := range tests {
+		rules = append(rules, Rule{ID: fmt.Sprint(i), Definition: json.RawMessage(tt.definition)})
+	}
+
+	res := Evaluate(testGraph, rules)
+	if res.RulesEvaluated != 1 || res.Passed != 1 {
+		t.Fatalf("evaluated %d, passed %d; want the valid rule only", res.RulesEvaluated, res.Passed)
+	}
+	for i, tt := range tests {
+		msg, ok := res.InvalidRules[fmt.Sprint(i)]
+		if !ok {
+			t.Errorf("%s: not reported invalid", tt.definition)
+			continue
+		}
+		err := Validate(json.RawMessage(tt.definition))
+		if defErr, ok := err.(*DefinitionError); !ok || defErr.Field != tt.field || defErr.Error() != msg {
+			t.Errorf("%s: Validate = %v, want field %s", tt.definition, err, tt.field)
+		}
+	}
+}
+
+func TestEvaluateTruncatesViolations(t *testing.T) {
+	g := &Graph{}
+	for i := 0; i < maxViolationsPerRule+10; i++ {
+		g.Edges = append(g.Edges, Edge{Source: fmt.Sprintf("a/%04d.go", i), Target: "b/x.go", Type: "import"})
+	}
+	res := Evaluate(g, []Rule{{ID: "r1", Definition: json.RawMessage(`{"type":"forbidden_dependency","from":["a/**"],"to":["b/**"]}`)}})
+	if len(res.Violations) != maxViolationsPerRule {
+		t.Fatalf("%d violations, want %d", len(res.Violations), maxViolationsPerRule)
+	}
+	if !reflect.DeepEqual(res.Truncated, []string{"r1"}) {
+		t.Fatalf("truncated = %v", res.Truncated)
+	}
+}
@@ -0,0 +1,78 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// selector matches paths against a union of globs; an empty selector
+// matches nothing
+type selector []*regexp.Regexp
+
+func (s selector) matches(path string) bool {
+	for _, re := range s {
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// compileSelector resolves layer names and compiles globs
+func compileSelector(items []string, layers map[string]selector) (selector, error) {
+	var sel selector
+	for _, item := range items {
+		if layer, ok := layers[item]; ok {
+			sel = append(sel, layer...)
+			continue
+		}
+		re, err := CompileGlob(item)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, re)
+	}
+	return sel, nil
+}
+
+// CompileGlob translates a path glob into an anchored regular expression.
+// "*" and "?" do not cross "/"; "**" matches any number of path segments.
+func CompileGlob(pattern string) (*regexp.Regexp, error) {
+	pattern = strings.TrimPrefix(strings.TrimSpace(pattern), "./")
+	if pattern == "" {
+		return nil, fmt.Errorf("must not contain an empty selector")
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				i++
+				if i+1 < len(runes) && runes[i+1] == '/' {
+					// "**/" also matches no directory at all
+					i++
+					b.WriteString("(?:.*/)?")
+				} else {
+					b.WriteString(".*")
+				}
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, fmt.Errorf("glob %q is invalid: %w", pattern, err)
+	}
+	return re, nil
+}
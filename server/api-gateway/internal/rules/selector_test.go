@@ -0,0 +1,47 @@
+package rules
+
+import "testing"
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		glob    string
+		match   []string
+		noMatch []string
+	}{
+		{"src/*.go", []string{"src/a.go", "src/.go"}, []string{"src/a/b.go", "src/a.gox", "a/src/a.go"}},
+		{"src/?.go", []string{"src/a.go"}, []string{"src/ab.go", "src//.go"}},
+		{"src/**", []string{"src/a", "src/a/b/c.go", "src/"}, []string{"src", "srcx/a"}},
+		{"src/**/x.go", []string{"src/x.go", "src/a/x.go", "src/a/b/x.go"}, []string{"src/ax.go", "x.go", "src/a/x.gox"}},
+		{"**/x.go", []string{"x.go", "a/x.go", "a/b/x.go"}, []string{"ax.go", "a/bx.go"}},
+		{"**/", []string{"", "a/", "a/b/"}, []string{"a"}},
+		{"**", []string{"", "a", "a/b/c"}, nil},
+		{"src/**/test/**/*_test.go", []string{"src/test/a_test.go", "src/a/test/b/c/d_test.go"}, []string{"src/test.go", "src/a/test_b/c_test.go"}},
+		{"./src/a.go", []string{"src/a.go"}, []string{"./src/a.go"}},
+		{"  lib/a.b  ", []string{"lib/a.b"}, []string{"lib/axb"}},
+		{"a+(b)[c]", []string{"a+(b)[c]"}, []string{"aab"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.glob, func(t *testing.T) {
+			re, err := CompileGlob(tt.glob)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range tt.match {
+				if !re.MatchString(p) {
+					t.Errorf("%q does not match %q", tt.glob, p)
+				}
+			}
+			for _, p := range tt.noMatch {
+				if re.MatchString(p) {
+					t.Errorf("%q matches %q", tt.glob, p)
+				}
+			}
+		})
+	}
+
+	for _, glob := range []string{"", "  ", "./"} {
+		if _, err := CompileGlob(glob); err == nil {
+			t.Errorf("CompileGlob(%q) succeeded", glob)
+		}
+	}
+}
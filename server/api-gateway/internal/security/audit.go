@@ -0,0 +1,331 @@
+package security
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// AuditSnapshots loads the current state of a resource so the audit trail
+// can record what a request changed. It returns nil for resources it does
+// not know or that do not exist.
+type AuditSnapshots interface {
+	AuditSnapshot(ctx context.Context, orgID string, resource ResourceType, id string) (interface{}, error)
+}
+
+// auditCollections maps the collection segments of API paths to the
+// resources they hold
+var auditCollections = map[string]ResourceType{
+	"organizations": ResourceOrganization,
+	"api-keys":      ResourceAPIKey,
+	"roles":         ResourceRole,
+	"keks":          ResourceKEK,
+	"repos":         ResourceRepository,
+	"pipelines":     ResourcePipelineRun,
+	"analyses":      ResourceAnalysis,
+	"drift":         ResourceDrift,
+	"rules":         ResourceRule,
+	"phantom":       ResourcePhantom,
+	"fixes":         ResourceFix,
+	"dlq":           ResourceDeadLetter,
+}
+
+// Resource IDs are UUIDs; any other segment after a collection is an
+// action on it, e.g. /keks/rotate or /repos/:repoId/analyze
+var auditIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Field names whose values never reach the audit log. Matching is by
+// substring, case-insensitively.
+var redactedFields = []string{"password", "secret", "token", "key_material", "key_hash", "content", "authorization"}
+
+const (
+	redacted = "[REDACTED]"
+	// auditMaxBody bounds the response bodies decoded for the after state
+	auditMaxBody = 256 << 10
+)
+
+// AuditTrail records every mutating request of an organization in its audit
+// log: who did what to which resource, with which outcome, and a redacted
+// diff of the resource before and after
+type AuditTrail struct {
+	sink      AuditSink
+	snapshots AuditSnapshots
+	logger    *zap.SugaredLogger
+}
+
+func NewAuditTrail(sink AuditSink, snapshots AuditSnapshots, logger *zap.SugaredLogger) *AuditTrail {
+	return &AuditTrail{sink: sink, snapshots: snapshots, logger: logger}
+}
+
+// auditTarget is the resource and action a request path addresses. crud
+// is set for plain creates, updates and deletes, whose responses are the
+// resource itself.
+type auditTarget struct {
+	resource ResourceType
+	id       string
+	action   string
+	crud     bool
+}
+
+// parseAuditTarget derives the target from the path below /api/v1, e.g.
+//
+//	POST   /organizations/:orgId/rules       rule.create
+//	PUT    /rules/:ruleId                    rule.update
+//	POST   /repos/:repoId/analyze            repository.analyze
+//	POST   /organizations/:orgId/keks/rotate kek.rotate
+func parseAuditTarget(method, path string) auditTarget {
+	t := auditTarget{resource: "api"}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for i, segment := range segments {
+		if resource, ok := auditCollections[segment]; ok {
+			t.resource, t.id = resource, ""
+			if i+1 < len(segments) && auditIDPattern.MatchString(segments[i+1]) {
+				t.id = segments[i+1]
+			}
+		}
+	}
+
+	// A last segment that is neither a collection nor an ID names the action
+	verb := segments[len(segments)-1]
+	if _, collection := auditCollections[verb]; collection || verb == t.id {
+		t.crud = true
+		switch method {
+		case fiber.MethodPost:
+			verb = "create"
+		case fiber.MethodDelete:
+			verb = "delete"
+		default:
+			verb = "update"
+		}
+	}
+	t.action = string(t.resource) + "." + strings.ReplaceAll(verb, "-", "_")
+	return t
+}
+
+// Record is mounted after authentication. Reads pass through untouched;
+// mutating requests are recorded once the handler finished, whether they
+// succeeded, failed or were denied.
+func (a *AuditTrail) Record() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Method and path alias the request buffer, and the event outlives it
+		method := strings.Clone(c.Method())
+		if method == fiber.MethodGet || method == fiber.MethodHead || method == fiber.MethodOptions {
+			return c.Next()
+		}
+		orgID, _ := c.Locals("org_id").(string)
+		if orgID == "" {
+			return c.Next()
+		}
+
+		path := strings.Clone(strings.TrimPrefix(c.Path(), "/api/v1"))
+		target := parseAuditTarget(method, path)
+		var before interface{}
+		if target.crud && target.id != "" {
+			snapshot, err := a.snapshots.AuditSnapshot(c.UserContext(), orgID, target.resource, target.id)
+			if err != nil {
+				a.logger.Warnw("failed to load audit snapshot", "resource_type", target.resource, "resource_id", target.id, "error", err)
+			}
+			before = normalize(snapshot)
+		}
+
+		err := c.Next()
+
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			var fe *fiber.Error
+			if errors.As(err, &fe) {
+				status = fe.Code
+			}
+		}
+		outcome := OutcomeSuccess
+		switch {
+		case status == fiber.StatusUnauthorized || status == fiber.StatusForbidden || status == fiber.StatusTooManyRequests:
+			outcome = OutcomeDenied
+		case status >= 400:
+			outcome = OutcomeFailure
+		}
+
+		var after interface{}
+		if target.crud && outcome == OutcomeSuccess && method != fiber.MethodDelete {
+			after = responseState(c)
+			if m, ok := after.(map[string]interface{}); ok && target.id == "" {
+				target.id, _ = m["id"].(string)
+			}
+		}
+
+		actorType, actorID := auditActor(c)
+		details := map[string]interface{}{"method": method, "path": path}
+		if diff := auditDiff(before, after); len(diff) > 0 && outcome == OutcomeSuccess {
+			details["diff"] = diff
+		}
+		if outcome != OutcomeSuccess {
+			if m, ok := responseState(c).(map[string]interface{}); ok && m["error"] != nil {
+				details["error"] = m["error"]
+			}
+		}
+		ev := AuditEvent{
+			OrgID:        orgID,
+			ActorType:    actorType,
+			ActorID:      actorID,
+			Action:       target.action,
+			ResourceType: string(target.resource),
+			ResourceID:   target.id,
+			RequestID:    auditRequestID(c),
+			IPAddress:    c.IP(),
+			Outcome:      outcome,
+			StatusCode:   status,
+			Details:      details,
+		}
+
+		// The request context is recycled once the handler returns
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+			defer cancel()
+			if err := a.sink.RecordAuditEvent(ctx, ev); err != nil {
+				a.logger.Errorw("failed to record audit event",
+					"action", ev.Action, "request_id", ev.RequestID, "error", err)
+			}
+		}()
+		return err
+	}
+}
+
+// auditActor names who made a request: the user subject, or the API key
+func auditActor(c *fiber.Ctx) (string, string) {
+	if keyID, ok := c.Locals("api_key_id").(string); ok && keyID != "" {
+		return ActorAPIKey, keyID
+	}
+	userID, _ := c.Locals("user_id").(string)
+	return ActorUser, userID
+}
+
+// auditRequestID returns the request ID, which may alias the request
+// headers and is therefore copied
+func auditRequestID(c *fiber.Ctx) string {
+	requestID, _ := c.Locals("requestid").(string)
+	return strings.Clone(requestID)
+}
+
+// responseState decodes a JSON response body
+func responseState(c *fiber.Ctx) interface{} {
+	body := c.Response().Body()
+	if len(body) == 0 || len(body) > auditMaxBody ||
+		!strings.HasPrefix(string(c.Response().Header.ContentType()), fiber.MIMEApplicationJSON) {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+// normalize turns a snapshot into the generic JSON form of response bodies
+func normalize(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var out interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil
+	}
+	return out
+}
+
+// auditDiff lists the top-level fields that differ between two states as
+// {"field": {"before": ..., "after": ...}}, with sensitive values redacted.
+// States that are not objects are diffed as a whole.
+func auditDiff(before, after interface{}) map[string]interface{} {
+	if before == nil && after == nil {
+		return nil
+	}
+	b, bok := before.(map[string]interface{})
+	a, aok := after.(map[string]interface{})
+	if (before != nil && !bok) || (after != nil && !aok) {
+		return map[string]interface{}{"": change(redact(before), redact(after))}
+	}
+
+	diff := map[string]interface{}{}
+	for k, bv := range b {
+		av, ok := a[k]
+		if ok && reflect.DeepEqual(bv, av) {
+			continue
+		}
+		if !ok && after != nil {
+			continue
+		}
+		diff[k] = changeField(k, bv, av)
+	}
+	for k, av := range a {
+		if _, ok := b[k]; !ok {
+			diff[k] = changeField(k, nil, av)
+		}
+	}
+	delete(diff, "updated_at")
+	return diff
+}
+
+func changeField(name string, before, after interface{}) map[string]interface{} {
+	if sensitive(name) {
+		if before != nil {
+			before = redacted
+		}
+		if after != nil {
+			after = redacted
+		}
+		return change(before, after)
+	}
+	return change(redact(before), redact(after))
+}
+
+func change(before, after interface{}) map[string]interface{} {
+	return map[string]interface{}{"before": before, "after": after}
+}
+
+// redact replaces sensitive values in nested objects
+func redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			if sensitive(k) {
+				out[k] = redacted
+			} else {
+				out[k] = redact(val)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, val := range v {
+			out[i] = redact(val)
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+func sensitive(field string) bool {
+	field = strings.ToLower(field)
+	if field == "key" {
+		return true
+	}
+	for _, s := range redactedFields {
+		if strings.Contains(field, s) {
+			return true
+		}
+	}
+	return false
+}
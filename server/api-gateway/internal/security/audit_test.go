@@ -0,0 +1,186 @@
+package security
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+const (
+	auditOrg  = "5b0c7a52-8a3f-4b8e-9d3c-2f8f1e0a7c01"
+	auditRule = "9e4d2c1b-7a6f-4e3d-8c2b-1a0f9e8d7c02"
+	auditKey  = "3c2b1a0f-9e8d-4c7b-a6f5-e4d3c2b1a003"
+)
+
+// chanSink delivers recorded events on a channel
+type chanSink chan AuditEvent
+
+func (s chanSink) RecordAuditEvent(_ context.Context, ev AuditEvent) error {
+	s <- ev
+	return nil
+}
+
+// staticSnapshots returns the same state for every resource
+type staticSnapshots map[string]interface{}
+
+func (s staticSnapshots) AuditSnapshot(context.Context, string, ResourceType, string) (interface{}, error) {
+	return map[string]interface{}(s), nil
+}
+
+func TestParseAuditTarget(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         auditTarget
+	}{
+		{"POST", "/organizations/" + auditOrg + "/rules", auditTarget{ResourceRule, "", "rule.create", true}},
+		{"PUT", "/rules/" + auditRule, auditTarget{ResourceRule, auditRule, "rule.update", true}},
+		{"PATCH", "/rules/" + auditRule, auditTarget{ResourceRule, auditRule, "rule.update", true}},
+		{"DELETE", "/organizations/" + auditOrg + "/api-keys/" + auditKey, auditTarget{ResourceAPIKey, auditKey, "api_key.delete", true}},
+		{"POST", "/repos/" + auditRule + "/analyze", auditTarget{ResourceRepository, auditRule, "repository.analyze", false}},
+		{"POST", "/organizations/" + auditOrg + "/keks/rotate", auditTarget{ResourceKEK, "", "kek.rotate", false}},
+		{"POST", "/dlq/" + auditKey + "/replay", auditTarget{ResourceDeadLetter, auditKey, "dead_letter.replay", false}},
+		{"POST", "/phantom/simulate-merge", auditTarget{ResourcePhantom, "", "phantom_execution.simulate_merge", false}},
+		{"POST", "/auth/logout", auditTarget{"api", "", "api.logout", false}},
+	}
+	for _, tt := range tests {
+		if got := parseAuditTarget(tt.method, tt.path); got != tt.want {
+			t.Errorf("%s %s = %+v, want %+v", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+// newAuditApp mounts Record for user-1 of the organization in the X-Org
+// header; tests add the handlers behind it
+func newAuditApp(snapshots AuditSnapshots) (*fiber.App, chanSink) {
+	sink := make(chanSink, 8)
+	trail := NewAuditTrail(sink, snapshots, zap.NewNop().Sugar())
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if org := c.Get("X-Org"); org != "" {
+			c.Locals("org_id", org)
+		}
+		c.Locals("user_id", "user-1")
+		return c.Next()
+	}, trail.Record())
+	return app, sink
+}
+
+func auditRequest(t *testing.T, app *fiber.App, method, path, org string) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(`{}`))
+	req.Header.Set("X-Org", org)
+	if _, err := app.Test(req); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func nextAuditEvent(t *testing.T, sink chanSink) AuditEvent {
+	t.Helper()
+	select {
+	case ev := <-sink:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no audit event recorded")
+		return AuditEvent{}
+	}
+}
+
+func TestRecordRedactsTheDiff(t *testing.T) {
+	before := staticSnapshots{
+		"id":            auditRule,
+		"name":          "no cycles",
+		"webhook_token": "tok-old",
+		"config":        map[string]interface{}{"password": "hunter2", "depth": 2.0},
+		"updated_at":    "2026-01-01T00:00:00Z",
+	}
+	app, sink := newAuditApp(before)
+	app.Put("/api/v1/rules/:id", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{
+			"id":            auditRule,
+			"name":          "no cycles in core",
+			"webhook_token": "tok-new",
+			"config":        fiber.Map{"password": "hunter3", "depth": 3},
+			"updated_at":    "2026-02-01T00:00:00Z",
+		})
+	})
+	app.Post("/api/v1/organizations/:org/api-keys", func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"id": auditKey, "name": "ci", "key": "ak_live_secret", "key_hash": "abc"})
+	})
+
+	auditRequest(t, app, "PUT", "/api/v1/rules/"+auditRule, auditOrg)
+	ev := nextAuditEvent(t, sink)
+	if ev.Action != "rule.update" || ev.ResourceType != string(ResourceRule) || ev.ResourceID != auditRule ||
+		ev.Outcome != OutcomeSuccess || ev.ActorType != ActorUser || ev.ActorID != "user-1" || ev.OrgID != auditOrg {
+		t.Fatalf("event %+v", ev)
+	}
+	wantDiff := map[string]interface{}{
+		"name":          change("no cycles", "no cycles in core"),
+		"webhook_token": change(redacted, redacted),
+		"config": change(
+			map[string]interface{}{"password": redacted, "depth": 2.0},
+			map[string]interface{}{"password": redacted, "depth": 3.0},
+		),
+	}
+	if diff := ev.Details["diff"]; !reflect.DeepEqual(diff, wantDiff) {
+		t.Errorf("diff = %v\nwant  %v", diff, wantDiff)
+	}
+
+	// Created resources are identified by the response
+	auditRequest(t, app, "POST", "/api/v1/organizations/"+auditOrg+"/api-keys", auditOrg)
+	ev = nextAuditEvent(t, sink)
+	if ev.Action != "api_key.create" || ev.ResourceID != auditKey {
+		t.Fatalf("event %+v", ev)
+	}
+	diff, _ := ev.Details["diff"].(map[string]interface{})
+	for field, want := range map[string]interface{}{
+		"name":     change(nil, "ci"),
+		"key":      change(nil, redacted),
+		"key_hash": change(nil, redacted),
+	} {
+		if !reflect.DeepEqual(diff[field], want) {
+			t.Errorf("diff[%s] = %v, want %v", field, diff[field], want)
+		}
+	}
+}
+
+func TestRecordOutcomes(t *testing.T) {
+	app, sink := newAuditApp(staticSnapshots{"id": auditRule, "name": "no cycles"})
+	app.Get("/api/v1/rules/:id", func(c *fiber.Ctx) error { return c.JSON(fiber.Map{"id": auditRule}) })
+	app.Delete("/api/v1/rules/:id", func(c *fiber.Ctx) error {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "insufficient permissions"})
+	})
+	app.Post("/api/v1/repos/:id/analyze", func(c *fiber.Ctx) error {
+		return fiber.NewError(fiber.StatusConflict, "analysis already running")
+	})
+
+	// Reads and requests outside an organization are not recorded
+	auditRequest(t, app, "GET", "/api/v1/rules/"+auditRule, auditOrg)
+	auditRequest(t, app, "DELETE", "/api/v1/rules/"+auditRule, "")
+
+	auditRequest(t, app, "DELETE", "/api/v1/rules/"+auditRule, auditOrg)
+	ev := nextAuditEvent(t, sink)
+	if ev.Details["method"] != "DELETE" || ev.Outcome != OutcomeDenied || ev.StatusCode != fiber.StatusForbidden {
+		t.Fatalf("event %+v", ev)
+	}
+	if ev.Details["error"] != "insufficient permissions" || ev.Details["diff"] != nil {
+		t.Errorf("denied request details = %v", ev.Details)
+	}
+
+	auditRequest(t, app, "POST", "/api/v1/repos/"+auditRule+"/analyze", auditOrg)
+	ev = nextAuditEvent(t, sink)
+	if ev.Action != "repository.analyze" || ev.Outcome != OutcomeFailure || ev.StatusCode != fiber.StatusConflict {
+		t.Fatalf("event %+v", ev)
+	}
+
+	select {
+	case ev := <-sink:
+		t.Fatalf("unexpected event %s %v", ev.Action, ev.Details)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
@@ -4,27 +4,108 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Ciphertexts are written as enc:v1:<key id>:<base64 nonce||sealed>. The
+// header names the master key version, so data survives key rotation, and is
+// authenticated together with the tenant ID so a ciphertext cannot be moved
+// to another tenant or relabelled with another key ID.
+//
+// Values without the header are legacy ciphertexts sealed with the tenant
+// key the pre-versioning scheme mixed from master key 1; they are decrypted
+// with it and re-encrypted by the Reencryptor.
+const (
+	ciphertextPrefix = "enc:"
+	formatV1         = "v1"
+	legacyKeyID      = 1
+)
+
+var hkdfSalt = []byte("archlens/tenant-key")
+
+var (
+	ErrUnknownKeyID     = errors.New("ciphertext was sealed with an unknown key version")
+	ErrMalformedPayload = errors.New("malformed ciphertext")
 )
 
-// AESEncryptor implements AES-256-GCM encryption with per-tenant keys
+// AESEncryptor implements AES-256-GCM encryption with per-tenant keys derived
+// by HKDF-SHA256 from a ring of versioned master keys. New data is always
+// sealed with the newest master key; older keys are kept for decryption.
 type AESEncryptor struct {
-	masterKey []byte
+	masterKeys map[uint32][]byte
+	current    uint32
+	tenantID   string
+
+	mu    sync.Mutex
+	aeads map[uint32]cipher.AEAD
 }
 
-// NewAESEncryptor creates a new AES-256-GCM encryptor from a hex-encoded 32-byte key
+// NewAESEncryptor creates a new AES-256-GCM encryptor from a hex-encoded 32-byte key.
+// The key becomes key version 1.
 func NewAESEncryptor(keyHex string) (*AESEncryptor, error) {
-	key, err := hex.DecodeString(keyHex)
-	if err != nil {
-		return nil, fmt.Errorf("invalid key hex: %w", err)
+	return NewAESKeyring(map[uint32]string{legacyKeyID: keyHex})
+}
+
+// NewAESKeyring creates an encryptor from hex-encoded 32-byte master keys by
+// version. The highest version encrypts; all of them decrypt.
+func NewAESKeyring(keys map[uint32]string) (*AESEncryptor, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("at least one key is required")
 	}
-	if len(key) != 32 {
-		return nil, fmt.Errorf("key must be 32 bytes (AES-256), got %d", len(key))
+	e := &AESEncryptor{masterKeys: make(map[uint32][]byte, len(keys)), aeads: map[uint32]cipher.AEAD{}}
+	for id, keyHex := range keys {
+		if id == 0 {
+			return nil, fmt.Errorf("key version must be positive")
+		}
+		key, err := hex.DecodeString(keyHex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key hex for version %d: %w", id, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key version %d must be 32 bytes (AES-256), got %d", id, len(key))
+		}
+		e.masterKeys[id] = key
+		if id > e.current {
+			e.current = id
+		}
 	}
-	return &AESEncryptor{masterKey: key}, nil
+	return e, nil
+}
+
+// ParseKeyring parses a keyring spec of comma-separated version:hex pairs,
+// e.g. "1:<hex>,2:<hex>"
+func ParseKeyring(spec string) (*AESEncryptor, error) {
+	keys := map[uint32]string{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		idStr, keyHex, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("keyring entry must be version:hexkey")
+		}
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key version %q", idStr)
+		}
+		if _, dup := keys[uint32(id)]; dup {
+			return nil, fmt.Errorf("key version %d listed twice", id)
+		}
+		keys[uint32(id)] = keyHex
+	}
+	return NewAESKeyring(keys)
 }
 
 // GenerateKey generates a random 32-byte AES-256 key and returns it hex-encoded
@@ -36,42 +117,160 @@ func GenerateKey() (string, error) {
 	return hex.EncodeToString(key), nil
 }
 
-// Encrypt encrypts plaintext using AES-256-GCM and returns base64-encoded ciphertext
-func (e *AESEncryptor) Encrypt(plaintext []byte) (string, error) {
-	block, err := aes.NewCipher(e.masterKey)
-	if err != nil {
-		return "", fmt.Errorf("failed to create cipher: %w", err)
+// CurrentKeyID returns the key version new ciphertexts are sealed with
+func (e *AESEncryptor) CurrentKeyID() uint32 {
+	return e.current
+}
+
+// KeyIDs returns the available key versions in ascending order
+func (e *AESEncryptor) KeyIDs() []uint32 {
+	ids := make([]uint32, 0, len(e.masterKeys))
+	for id := range e.masterKeys {
+		ids = append(ids, id)
 	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
 
-	gcm, err := cipher.NewGCM(block)
+// CurrentPrefix is the header of ciphertexts sealed with the current key;
+// values without it need re-encryption
+func (e *AESEncryptor) CurrentPrefix() string {
+	return header(e.current)
+}
+
+// DerivePerTenantKey returns an encryptor whose keys are derived for a
+// tenant. It shares the master keys but not the derived keys.
+func (e *AESEncryptor) DerivePerTenantKey(tenantID string) (*AESEncryptor, error) {
+	if tenantID == "" {
+		return nil, fmt.Errorf("tenant ID is required")
+	}
+	return &AESEncryptor{
+		masterKeys: e.masterKeys,
+		current:    e.current,
+		tenantID:   tenantID,
+		aeads:      map[uint32]cipher.AEAD{},
+	}, nil
+}
+
+// Encrypt encrypts plaintext using AES-256-GCM with the current key version
+func (e *AESEncryptor) Encrypt(plaintext []byte) (string, error) {
+	aead, err := e.aead(e.current)
 	if err != nil {
-		return "", fmt.Errorf("failed to create GCM: %w", err)
+		return "", err
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
+	nonce := make([]byte, aead.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return "", fmt.Errorf("failed to generate nonce: %w", err)
 	}
 
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
-	return base64.StdEncoding.EncodeToString(ciphertext), nil
+	h := header(e.current)
+	sealed := aead.Seal(nonce, nonce, plaintext, e.additionalData(h))
+	return h + base64.StdEncoding.EncodeToString(sealed), nil
 }
 
-// Decrypt decrypts base64-encoded AES-256-GCM ciphertext
+// Decrypt decrypts a ciphertext sealed with any key version in the ring
 func (e *AESEncryptor) Decrypt(encoded string) ([]byte, error) {
-	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if !strings.HasPrefix(encoded, ciphertextPrefix) {
+		return e.decryptLegacy(encoded)
+	}
+
+	id, payload, err := parseHeader(encoded)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := e.aead(id)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(payload)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode base64: %w", err)
 	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
 
-	block, err := aes.NewCipher(e.masterKey)
+	nonce, sealed := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, e.additionalData(header(id)))
 	if err != nil {
-		return nil, fmt.Errorf("failed to create cipher: %w", err)
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
 	}
+	return plaintext, nil
+}
 
-	gcm, err := cipher.NewGCM(block)
+// KeyID returns the key version a ciphertext was sealed with; legacy
+// ciphertexts report version 1
+func KeyID(encoded string) (uint32, error) {
+	if !strings.HasPrefix(encoded, ciphertextPrefix) {
+		return legacyKeyID, nil
+	}
+	id, _, err := parseHeader(encoded)
+	return id, err
+}
+
+// NeedsRotation reports whether a ciphertext is sealed with an older key
+// version or in the legacy format
+func (e *AESEncryptor) NeedsRotation(encoded string) bool {
+	return !strings.HasPrefix(encoded, e.CurrentPrefix())
+}
+
+// Rotate re-encrypts a ciphertext with the current key version. It returns
+// the input unchanged, and false, if no rotation was needed.
+func (e *AESEncryptor) Rotate(encoded string) (string, bool, error) {
+	if !e.NeedsRotation(encoded) {
+		return encoded, false, nil
+	}
+	plaintext, err := e.Decrypt(encoded)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create GCM: %w", err)
+		return "", false, err
+	}
+	rotated, err := e.Encrypt(plaintext)
+	if err != nil {
+		return "", false, err
+	}
+	return rotated, true, nil
+}
+
+// aead returns the cipher for a key version, deriving the tenant key once
+func (e *AESEncryptor) aead(id uint32) (cipher.AEAD, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if aead, ok := e.aeads[id]; ok {
+		return aead, nil
+	}
+	master, ok := e.masterKeys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKeyID, id)
+	}
+
+	key := make([]byte, 32)
+	info := "archlens/" + formatV1 + "/tenant/" + e.tenantID
+	if _, err := io.ReadFull(hkdf.New(sha256.New, master, hkdfSalt, []byte(info)), key); err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	e.aeads[id] = aead
+	return aead, nil
+}
+
+// decryptLegacy opens ciphertexts written before key versioning: base64 of
+// nonce||sealed under the legacy tenant key, without associated data
+func (e *AESEncryptor) decryptLegacy(encoded string) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64: %w", err)
+	}
+	master, ok := e.masterKeys[legacyKeyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKeyID, legacyKeyID)
+	}
+	gcm, err := newGCM(legacyTenantKey(master, e.tenantID))
+	if err != nil {
+		return nil, err
 	}
 
 	nonceSize := gcm.NonceSize()
@@ -88,15 +287,57 @@ func (e *AESEncryptor) Decrypt(encoded string) ([]byte, error) {
 	return plaintext, nil
 }
 
-// DerivePerTenantKey derives a tenant-specific key from master key + tenant ID
-func (e *AESEncryptor) DerivePerTenantKey(tenantID string) (*AESEncryptor, error) {
-	// HKDF-like derivation using XOR mixing
-	// In production, use golang.org/x/crypto/hkdf for proper KDF
-	derived := make([]byte, 32)
-	copy(derived, e.masterKey)
-	tenantBytes := []byte(tenantID)
-	for i, b := range tenantBytes {
-		derived[i%32] ^= b
-	}
-	return &AESEncryptor{masterKey: derived}, nil
+// IsLegacyCiphertext reports whether a value without a ciphertext header is
+// a legacy ciphertext rather than plaintext. Only opening it can tell, so it
+// needs master key version 1.
+func (e *AESEncryptor) IsLegacyCiphertext(value string) bool {
+	if strings.HasPrefix(value, ciphertextPrefix) {
+		return false
+	}
+	_, err := e.decryptLegacy(value)
+	return err == nil
+}
+
+// legacyTenantKey derives a tenant key the way it was done before HKDF: the
+// tenant ID XORed into the master key, repeating every 32 bytes
+func legacyTenantKey(master []byte, tenantID string) []byte {
+	derived := make([]byte, len(master))
+	copy(derived, master)
+	for i, b := range []byte(tenantID) {
+		derived[i%len(derived)] ^= b
+	}
+	return derived
+}
+
+func (e *AESEncryptor) additionalData(header string) []byte {
+	return []byte(header + e.tenantID)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+func header(id uint32) string {
+	return ciphertextPrefix + formatV1 + ":" + strconv.FormatUint(uint64(id), 10) + ":"
+}
+
+// parseHeader splits enc:v1:<id>:<payload>
+func parseHeader(encoded string) (uint32, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(encoded, ciphertextPrefix), ":", 3)
+	if len(parts) != 3 || parts[0] != formatV1 {
+		return 0, "", ErrMalformedPayload
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, "", ErrMalformedPayload
+	}
+	return uint32(id), parts[2], nil
 }
@@ -0,0 +1,56 @@
+package security
+
+import (
+	"context"
+	"testing"
+)
+
+// baselineCiphertext was sealed by the encryptor that predates key versioning,
+// with testMasterKey and the tenant key it derived for baselineOrg
+const (
+	baselineOrg        = "7c6e2d4a-52a8-4a53-9d0e-3a3c1f1f0c01"
+	baselinePlaintext  = "package main // sealed before key versioning"
+	baselineCiphertext = "vq3XHgUJ0waNXdgppvsmgcy6PYczkmxsSEReclUZFxddjxM1cZ8N2SU25y2+EsJO0eXq9UfXbuBrL7gMsdfrhicoET4S81j+"
+)
+
+func TestDecryptBaselineCiphertext(t *testing.T) {
+	platform, err := NewAESEncryptor(testMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenant, err := platform.DerivePerTenantKey(baselineOrg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := tenant.Decrypt(baselineCiphertext)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	if string(plaintext) != baselinePlaintext {
+		t.Fatalf("decrypted %q, want %q", plaintext, baselinePlaintext)
+	}
+
+	// The tenant key is required: another tenant's key or the master key
+	// alone cannot open it
+	other, err := platform.DerivePerTenantKey("0b8f0a8e-2d2c-4a7e-8f57-5c9d3e1a7b42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, enc := range map[string]*AESEncryptor{"other tenant": other, "master key": platform} {
+		if _, err := enc.Decrypt(baselineCiphertext); err == nil {
+			t.Errorf("%s opened the ciphertext", name)
+		}
+	}
+
+	env := NewEnvelope(platform, &memKEKs{}, nil)
+	if !env.IsLegacyCiphertext(baselineOrg, baselineCiphertext) {
+		t.Error("baseline ciphertext not recognised as legacy")
+	}
+	if env.IsLegacyCiphertext("0b8f0a8e-2d2c-4a7e-8f57-5c9d3e1a7b42", baselineCiphertext) {
+		t.Error("baseline ciphertext recognised as legacy for another tenant")
+	}
+	got, err := env.Decrypt(context.Background(), baselineOrg, baselineCiphertext)
+	if err != nil || string(got) != baselinePlaintext {
+		t.Fatalf("envelope decrypt = %q, %v", got, err)
+	}
+}
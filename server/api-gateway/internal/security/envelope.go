@@ -0,0 +1,451 @@
+package security
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tenants with a registered key-encryption key (KEK) get envelope
+// encryption: every value is sealed with its own random data key, and the
+// data key is wrapped by the tenant's KEK in a KMS. Envelope ciphertexts are
+// written as
+//
+//	env:v1:<kek id>:<base64 wrapped data key>:<base64 nonce||sealed>
+//
+// Destroying a KEK makes every data key it wrapped, and therefore the data,
+// permanently unreadable. Tenants without a KEK use the platform keyring.
+//
+// Only envelopes are shredded: platform-key ciphertexts and plaintext stay
+// recoverable by whoever holds the platform keys, whatever the tenant's KEKs.
+// RevokeKEK therefore refuses to revoke an active KEK while the Reencryptor
+// has not moved all of the organization's data into envelopes.
+const envelopePrefix = "env:"
+
+// KEK statuses. Only the active KEK wraps new data keys; retired KEKs still
+// unwrap until the Reencryptor has moved their data to the active one.
+const (
+	KEKActive  = "active"
+	KEKRetired = "retired"
+	KEKRevoked = "revoked"
+)
+
+var (
+	// ErrKEKRevoked is returned for tenants whose data has been
+	// crypto-shredded and who have not registered a new KEK
+	ErrKEKRevoked  = errors.New("the organization's encryption key has been revoked")
+	ErrUnknownKEK  = errors.New("ciphertext was sealed with an unknown key-encryption key")
+	ErrKEKNotFound = errors.New("encryption key not found")
+)
+
+// UnenvelopedDataError refuses revoking an active KEK while some of the
+// organization's data is not in envelopes, so destroying the KEK would not
+// shred it
+type UnenvelopedDataError struct {
+	Values int64
+}
+
+func (e *UnenvelopedDataError) Error() string {
+	return fmt.Sprintf("%d encrypted values are not yet sealed under the organization's key", e.Values)
+}
+
+// KEKDestroyError reports a KEK whose material could not be destroyed; it
+// and the KEKs after it were left as they were
+type KEKDestroyError struct {
+	KEK KEK
+	Err error
+}
+
+func (e *KEKDestroyError) Error() string {
+	return fmt.Sprintf("failed to destroy key %s: %v", e.KEK.ID, e.Err)
+}
+
+func (e *KEKDestroyError) Unwrap() error { return e.Err }
+
+// KEK is a tenant key-encryption key held in a KMS
+type KEK struct {
+	ID        string     `json:"id"`
+	OrgID     string     `json:"org_id"`
+	Provider  string     `json:"provider"`
+	KeyRef    string     `json:"key_ref"`
+	Status    string     `json:"status"`
+	CreatedBy *string    `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RetiredAt *time.Time `json:"retired_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// KEKStore lists the KEKs registered by organizations
+type KEKStore interface {
+	ListKEKs(ctx context.Context, orgID string) ([]KEK, error)
+	// ActiveKEKOrgs returns the organizations that have an active KEK
+	ActiveKEKOrgs(ctx context.Context) ([]string, error)
+}
+
+// KEKRevocationStore records revocations for RevokeKEK
+type KEKRevocationStore interface {
+	KEKStore
+	// CountUnenveloped returns how many of an organization's encrypted
+	// values are not envelopes
+	CountUnenveloped(ctx context.Context, orgID string) (int64, error)
+	// MarkKEKsRevoked records that the material of KEKs was destroyed and
+	// returns them updated
+	MarkKEKsRevoked(ctx context.Context, orgID string, ids []string) ([]KEK, error)
+}
+
+// RevokedKEK is a KEK RevokeKEK set out to destroy, with whether it did
+type RevokedKEK struct {
+	KEK
+	Destroyed bool `json:"destroyed"`
+}
+
+// kekTTL bounds how long another replica may keep using a KEK after it was
+// rotated. Revocation does not depend on it: the KMS refuses destroyed keys.
+const kekTTL = 30 * time.Second
+
+type cachedKEKs struct {
+	keks    []KEK
+	expires time.Time
+}
+
+// Envelope encrypts tenant data with the tenant's KEK if one is registered
+// and with the platform keyring otherwise
+type Envelope struct {
+	platform *AESEncryptor
+	keks     KEKStore
+	kms      map[string]KMS
+
+	mu    sync.Mutex
+	cache map[string]cachedKEKs
+}
+
+// NewEnvelope creates an envelope encryptor; kms maps provider names, as
+// stored with each KEK, to their implementation
+func NewEnvelope(platform *AESEncryptor, keks KEKStore, kms map[string]KMS) *Envelope {
+	return &Envelope{platform: platform, keks: keks, kms: kms, cache: make(map[string]cachedKEKs)}
+}
+
+// IsCiphertext reports whether a stored value was written by an Envelope.
+// Encrypted columns may still hold plaintext written before they were
+// encrypted; such values carry neither header.
+func IsCiphertext(value string) bool {
+	return strings.HasPrefix(value, ciphertextPrefix) || strings.HasPrefix(value, envelopePrefix)
+}
+
+// IsLegacyCiphertext reports whether a value without a ciphertext header
+// was sealed before key versioning, rather than stored in plaintext before
+// its field was encrypted
+func (e *Envelope) IsLegacyCiphertext(orgID, value string) bool {
+	if IsCiphertext(value) {
+		return false
+	}
+	enc, err := e.platform.DerivePerTenantKey(orgID)
+	return err == nil && enc.IsLegacyCiphertext(value)
+}
+
+// Provider returns the KMS configured for a provider name
+func (e *Envelope) Provider(name string) (KMS, bool) {
+	k, ok := e.kms[name]
+	return k, ok
+}
+
+// Encrypt seals plaintext for an organization
+func (e *Envelope) Encrypt(ctx context.Context, orgID string, plaintext []byte) (string, error) {
+	keks, err := e.tenantKEKs(ctx, orgID)
+	if err != nil {
+		return "", err
+	}
+	active, revoked := activeKEK(keks)
+	if active == nil {
+		if revoked {
+			return "", ErrKEKRevoked
+		}
+		enc, err := e.platform.DerivePerTenantKey(orgID)
+		if err != nil {
+			return "", err
+		}
+		return enc.Encrypt(plaintext)
+	}
+	return e.seal(ctx, orgID, *active, plaintext)
+}
+
+// Decrypt opens a ciphertext written by Encrypt for the same organization.
+// While an organization's KEK is revoked and no new one is registered, its
+// platform-key ciphertexts are refused too. That is a policy check, not
+// shredding; see RevokeKEK.
+func (e *Envelope) Decrypt(ctx context.Context, orgID, ciphertext string) ([]byte, error) {
+	keks, err := e.tenantKEKs(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(ciphertext, envelopePrefix) {
+		if active, revoked := activeKEK(keks); active == nil && revoked {
+			return nil, ErrKEKRevoked
+		}
+		enc, err := e.platform.DerivePerTenantKey(orgID)
+		if err != nil {
+			return nil, err
+		}
+		return enc.Decrypt(ciphertext)
+	}
+
+	kekID, wrapped, sealed, err := parseEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	var kek *KEK
+	for i := range keks {
+		if keks[i].ID == kekID {
+			kek = &keks[i]
+		}
+	}
+	if kek == nil {
+		return nil, ErrUnknownKEK
+	}
+	if kek.Status == KEKRevoked {
+		return nil, ErrKEKRevoked
+	}
+	kms, ok := e.kms[kek.Provider]
+	if !ok {
+		return nil, fmt.Errorf("kms provider %q is not configured", kek.Provider)
+	}
+
+	header := envelopeHeader(kekID)
+	dataKey, err := kms.Unwrap(ctx, kek.KeyRef, wrapped, []byte(orgID+":"+kekID))
+	if errors.Is(err, ErrKeyDestroyed) {
+		return nil, ErrKEKRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, sealed := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, []byte(header+orgID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Rotate re-encrypts a ciphertext into the organization's current format:
+// an envelope under its active KEK, or the newest platform key. It returns
+// the input unchanged, and false, if it is already current.
+func (e *Envelope) Rotate(ctx context.Context, orgID, ciphertext string) (string, bool, error) {
+	current, err := e.CurrentPrefix(ctx, orgID)
+	if err != nil {
+		return "", false, err
+	}
+	if strings.HasPrefix(ciphertext, current) {
+		return ciphertext, false, nil
+	}
+	plaintext, err := e.Decrypt(ctx, orgID, ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+	rotated, err := e.Encrypt(ctx, orgID, plaintext)
+	if err != nil {
+		return "", false, err
+	}
+	return rotated, true, nil
+}
+
+// CurrentPrefix is the header new ciphertexts of an organization start with
+func (e *Envelope) CurrentPrefix(ctx context.Context, orgID string) (string, error) {
+	keks, err := e.tenantKEKs(ctx, orgID)
+	if err != nil {
+		return "", err
+	}
+	if active, _ := activeKEK(keks); active != nil {
+		return envelopeHeader(active.ID), nil
+	}
+	return e.platform.CurrentPrefix(), nil
+}
+
+// PlatformPrefix is the header of ciphertexts under the newest platform key
+func (e *Envelope) PlatformPrefix() string {
+	return e.platform.CurrentPrefix()
+}
+
+// RevokeKEK destroys the material of a KEK in its KMS, then records it as
+// revoked. Revoking the active KEK revokes the retired ones as well, and is
+// refused with *UnenvelopedDataError while any of the organization's data is
+// not in envelopes, so that it shreds all of its data. A KEK already
+// revoked is destroyed again, which completes an earlier attempt.
+//
+// Material is destroyed before anything is recorded: a KEK is never shown as
+// revoked while its data is recoverable. If destroying fails, the KEKs
+// destroyed so far are recorded and a *KEKDestroyError returned alongside
+// the outcome for each KEK; retrying picks up the rest.
+func (e *Envelope) RevokeKEK(ctx context.Context, st KEKRevocationStore, orgID, kekID string) ([]RevokedKEK, error) {
+	keks, err := st.ListKEKs(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	var target *KEK
+	for i := range keks {
+		if keks[i].ID == kekID {
+			target = &keks[i]
+		}
+	}
+	if target == nil {
+		return nil, ErrKEKNotFound
+	}
+
+	// The target goes last, so a failure leaves it in force until all the
+	// retired KEKs it stands for are gone
+	var targets []RevokedKEK
+	if target.Status == KEKActive {
+		for _, k := range keks {
+			if k.Status == KEKRetired {
+				targets = append(targets, RevokedKEK{KEK: k})
+			}
+		}
+		n, err := st.CountUnenveloped(ctx, orgID)
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			return nil, &UnenvelopedDataError{Values: n}
+		}
+	}
+	targets = append(targets, RevokedKEK{KEK: *target})
+
+	var destroyErr error
+	var destroyed []string
+	for i := range targets {
+		kek := targets[i].KEK
+		kms, ok := e.kms[kek.Provider]
+		if !ok {
+			destroyErr = &KEKDestroyError{KEK: kek, Err: fmt.Errorf("kms provider %q is not configured", kek.Provider)}
+			break
+		}
+		if err := kms.DestroyKey(ctx, kek.KeyRef); err != nil {
+			destroyErr = &KEKDestroyError{KEK: kek, Err: err}
+			break
+		}
+		targets[i].Destroyed = true
+		destroyed = append(destroyed, kek.ID)
+	}
+	if len(destroyed) == 0 {
+		return targets, destroyErr
+	}
+
+	defer e.Invalidate(orgID)
+	marked, err := st.MarkKEKsRevoked(ctx, orgID, destroyed)
+	if err != nil {
+		return targets, errors.Join(destroyErr, fmt.Errorf("failed to record revocation: %w", err))
+	}
+	for i := range targets {
+		for _, k := range marked {
+			if targets[i].ID == k.ID {
+				targets[i].KEK = k
+			}
+		}
+	}
+	return targets, destroyErr
+}
+
+// Invalidate drops an organization's cached KEKs after they change
+func (e *Envelope) Invalidate(orgID string) {
+	e.mu.Lock()
+	delete(e.cache, orgID)
+	e.mu.Unlock()
+}
+
+func (e *Envelope) seal(ctx context.Context, orgID string, kek KEK, plaintext []byte) (string, error) {
+	kms, ok := e.kms[kek.Provider]
+	if !ok {
+		return "", fmt.Errorf("kms provider %q is not configured", kek.Provider)
+	}
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := kms.Wrap(ctx, kek.KeyRef, dataKey, []byte(orgID+":"+kek.ID))
+	if errors.Is(err, ErrKeyDestroyed) {
+		return "", ErrKEKRevoked
+	}
+	if err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	header := envelopeHeader(kek.ID)
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(header+orgID))
+	return header + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (e *Envelope) tenantKEKs(ctx context.Context, orgID string) ([]KEK, error) {
+	now := time.Now()
+	e.mu.Lock()
+	cached, ok := e.cache[orgID]
+	e.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.keks, nil
+	}
+	keks, err := e.keks.ListKEKs(ctx, orgID)
+	if err != nil {
+		return nil, err
+	}
+	e.mu.Lock()
+	if len(e.cache) > 10000 {
+		e.cache = make(map[string]cachedKEKs)
+	}
+	e.cache[orgID] = cachedKEKs{keks: keks, expires: now.Add(kekTTL)}
+	e.mu.Unlock()
+	return keks, nil
+}
+
+// activeKEK returns the active KEK, if any, and whether any KEK was revoked
+func activeKEK(keks []KEK) (*KEK, bool) {
+	var revoked bool
+	for i := range keks {
+		switch keks[i].Status {
+		case KEKActive:
+			return &keks[i], false
+		case KEKRevoked:
+			revoked = true
+		}
+	}
+	return nil, revoked
+}
+
+func envelopeHeader(kekID string) string {
+	return envelopePrefix + formatV1 + ":" + kekID + ":"
+}
+
+// parseEnvelope splits env:v1:<kek id>:<wrapped>:<sealed>
+func parseEnvelope(ciphertext string) (string, []byte, []byte, error) {
+	parts := strings.Split(strings.TrimPrefix(ciphertext, envelopePrefix), ":")
+	if len(parts) != 4 || parts[0] != formatV1 || parts[1] == "" {
+		return "", nil, nil, ErrMalformedPayload
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", nil, nil, ErrMalformedPayload
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", nil, nil, ErrMalformedPayload
+	}
+	return parts[1], wrapped, sealed, nil
+}
@@ -0,0 +1,265 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMasterKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+// memKEKs is an in-memory KEKRevocationStore
+type memKEKs struct {
+	mu          sync.Mutex
+	keks        []KEK
+	unenveloped int64
+}
+
+func (m *memKEKs) ListKEKs(_ context.Context, orgID string) ([]KEK, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []KEK
+	for _, k := range m.keks {
+		if k.OrgID == orgID {
+			out = append(out, k)
+		}
+	}
+	return out, nil
+}
+
+func (m *memKEKs) ActiveKEKOrgs(context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var orgs []string
+	for _, k := range m.keks {
+		if k.Status == KEKActive {
+			orgs = append(orgs, k.OrgID)
+		}
+	}
+	return orgs, nil
+}
+
+func (m *memKEKs) CountUnenveloped(context.Context, string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.unenveloped, nil
+}
+
+func (m *memKEKs) MarkKEKsRevoked(_ context.Context, orgID string, ids []string) ([]KEK, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []KEK
+	for i := range m.keks {
+		for _, id := range ids {
+			if m.keks[i].ID == id && m.keks[i].OrgID == orgID {
+				now := time.Now()
+				m.keks[i].Status = KEKRevoked
+				m.keks[i].RevokedAt = &now
+				out = append(out, m.keks[i])
+			}
+		}
+	}
+	return out, nil
+}
+
+func (m *memKEKs) status(id string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, k := range m.keks {
+		if k.ID == id {
+			return k.Status
+		}
+	}
+	return ""
+}
+
+// failingKMS refuses to destroy one key
+type failingKMS struct {
+	KMS
+	failRef string
+}
+
+func (f *failingKMS) DestroyKey(ctx context.Context, keyRef string) error {
+	if keyRef == f.failRef {
+		return errors.New("kms unavailable")
+	}
+	return f.KMS.DestroyKey(ctx, keyRef)
+}
+
+type envelopeFixture struct {
+	env  *Envelope
+	kms  *LocalKMS
+	keks *memKEKs
+	org  string
+}
+
+func newEnvelopeFixture(t *testing.T) *envelopeFixture {
+	t.Helper()
+	platform, err := NewAESEncryptor(testMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kms, err := NewLocalKMS(t.TempDir(), platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keks := &memKEKs{}
+	return &envelopeFixture{
+		env:  NewEnvelope(platform, keks, map[string]KMS{"local": kms}),
+		kms:  kms,
+		keks: keks,
+		org:  "7c6e2d4a-52a8-4a53-9d0e-3a3c1f1f0c01",
+	}
+}
+
+// register makes a new KEK the organization's active one, retiring the
+// current one
+func (f *envelopeFixture) register(t *testing.T) KEK {
+	t.Helper()
+	ref, err := f.kms.CreateKey(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.keks.mu.Lock()
+	for i := range f.keks.keks {
+		if f.keks.keks[i].Status == KEKActive {
+			f.keks.keks[i].Status = KEKRetired
+		}
+	}
+	kek := KEK{
+		ID:       fmt.Sprintf("kek-%d", len(f.keks.keks)+1),
+		OrgID:    f.org,
+		Provider: "local",
+		KeyRef:   ref,
+		Status:   KEKActive,
+	}
+	f.keks.keks = append(f.keks.keks, kek)
+	f.keks.mu.Unlock()
+	f.env.Invalidate(f.org)
+	return kek
+}
+
+func TestRevokeKEKShredsDespiteNewKEK(t *testing.T) {
+	ctx := context.Background()
+	f := newEnvelopeFixture(t)
+
+	platformCT, err := f.env.Encrypt(ctx, f.org, []byte("written before any KEK"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kek := f.register(t)
+
+	// The platform-key value is still in the database, so destroying the
+	// KEK would not shred it
+	f.keks.unenveloped = 1
+	_, err = f.env.RevokeKEK(ctx, f.keks, f.org, kek.ID)
+	var unenveloped *UnenvelopedDataError
+	if !errors.As(err, &unenveloped) || unenveloped.Values != 1 {
+		t.Fatalf("revoking with platform-key data left: %v", err)
+	}
+	if f.keks.status(kek.ID) != KEKActive {
+		t.Fatal("refused revocation changed the KEK")
+	}
+
+	migrated, changed, err := f.env.Rotate(ctx, f.org, platformCT)
+	if err != nil || !changed {
+		t.Fatalf("migrating into an envelope: changed=%v err=%v", changed, err)
+	}
+	f.keks.unenveloped = 0
+	sealed, err := f.env.Encrypt(ctx, f.org, []byte("written under the KEK"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	revoked, err := f.env.RevokeKEK(ctx, f.keks, f.org, kek.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || !revoked[0].Destroyed || revoked[0].Status != KEKRevoked {
+		t.Fatalf("revoked = %+v", revoked)
+	}
+	if _, err := f.env.Encrypt(ctx, f.org, []byte("x")); !errors.Is(err, ErrKEKRevoked) {
+		t.Errorf("encrypting after revocation: %v", err)
+	}
+
+	// A new KEK must not bring the shredded data back
+	f.register(t)
+	for name, ct := range map[string]string{"migrated": migrated, "sealed": sealed} {
+		if _, err := f.env.Decrypt(ctx, f.org, ct); !errors.Is(err, ErrKEKRevoked) {
+			t.Errorf("%s value after registering a new KEK: %v", name, err)
+		}
+		if _, _, err := f.env.Rotate(ctx, f.org, ct); !errors.Is(err, ErrKEKRevoked) {
+			t.Errorf("re-encrypting %s value under the new KEK: %v", name, err)
+		}
+	}
+
+	fresh, err := f.env.Encrypt(ctx, f.org, []byte("after"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := f.env.Decrypt(ctx, f.org, fresh); err != nil || string(got) != "after" {
+		t.Errorf("new KEK round trip: %q, %v", got, err)
+	}
+}
+
+func TestRevokeKEKDestroyFailure(t *testing.T) {
+	ctx := context.Background()
+	f := newEnvelopeFixture(t)
+
+	retired := f.register(t)
+	oldCT, err := f.env.Encrypt(ctx, f.org, []byte("under the retired KEK"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	active := f.register(t)
+	newCT, err := f.env.Encrypt(ctx, f.org, []byte("under the active KEK"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.env.kms["local"] = &failingKMS{KMS: f.kms, failRef: active.KeyRef}
+	revoked, err := f.env.RevokeKEK(ctx, f.keks, f.org, active.ID)
+	var destroyErr *KEKDestroyError
+	if !errors.As(err, &destroyErr) || destroyErr.KEK.ID != active.ID {
+		t.Fatalf("err = %v", err)
+	}
+	if len(revoked) != 2 || revoked[0].ID != retired.ID || !revoked[0].Destroyed || revoked[1].Destroyed {
+		t.Fatalf("revoked = %+v", revoked)
+	}
+	if got, want := f.keks.status(retired.ID), KEKRevoked; got != want {
+		t.Errorf("retired KEK status = %s, want %s", got, want)
+	}
+	if got, want := f.keks.status(active.ID), KEKActive; got != want {
+		t.Errorf("undestroyed KEK status = %s, want %s", got, want)
+	}
+	if _, err := f.env.Decrypt(ctx, f.org, oldCT); !errors.Is(err, ErrKEKRevoked) {
+		t.Errorf("value under destroyed KEK: %v", err)
+	}
+	if _, err := f.env.Decrypt(ctx, f.org, newCT); err != nil {
+		t.Errorf("value under undestroyed KEK: %v", err)
+	}
+
+	// Retrying once the KMS is back finishes the revocation
+	f.env.kms["local"] = f.kms
+	revoked, err = f.env.RevokeKEK(ctx, f.keks, f.org, active.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || !revoked[0].Destroyed || f.keks.status(active.ID) != KEKRevoked {
+		t.Fatalf("retry: revoked = %+v", revoked)
+	}
+	if _, err := f.env.Decrypt(ctx, f.org, newCT); !errors.Is(err, ErrKEKRevoked) {
+		t.Errorf("value after retry: %v", err)
+	}
+}
+
+func TestRevokeKEKNotFound(t *testing.T) {
+	f := newEnvelopeFixture(t)
+	f.register(t)
+	if _, err := f.env.RevokeKEK(context.Background(), f.keks, f.org, "kek-404"); !errors.Is(err, ErrKEKNotFound) {
+		t.Fatalf("err = %v", err)
+	}
+}
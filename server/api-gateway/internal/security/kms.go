@@ -0,0 +1,245 @@
+package security
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrKeyDestroyed is returned for operations on a key whose material has
+// been destroyed
+var ErrKeyDestroyed = errors.New("key material has been destroyed")
+
+// KMS holds tenant key-encryption keys and wraps data keys with them. Key
+// material never leaves the KMS; callers only hold references.
+type KMS interface {
+	// CreateKey stores a new 32-byte key and returns its reference. Tenants
+	// bringing their own key pass its material; nil generates one.
+	CreateKey(ctx context.Context, material []byte) (string, error)
+	Wrap(ctx context.Context, keyRef string, dataKey, aad []byte) ([]byte, error)
+	Unwrap(ctx context.Context, keyRef string, wrapped, aad []byte) ([]byte, error)
+	// DestroyKey irreversibly deletes the key material. Destroying a key
+	// that is already gone is not an error.
+	DestroyKey(ctx context.Context, keyRef string) error
+}
+
+// KeyResealer is implemented by KMSs that seal stored keys with the platform
+// keyring. The Reencryptor reseals them, so old platform key versions can be
+// dropped once it has caught up.
+type KeyResealer interface {
+	// ResealKeys seals every stored key still sealed with an older platform
+	// key version with the newest one, returning how many it resealed
+	ResealKeys(ctx context.Context) (int, error)
+}
+
+var keyRefPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// LocalKMS keeps keys as files in a directory, each sealed with the
+// platform keyring so a copy of the directory alone does not expose them.
+// All gateway replicas must share the directory. A destroyed key leaves a
+// <ref>.destroyed marker behind, so a concurrent reseal cannot bring its
+// file back.
+type LocalKMS struct {
+	dir      string
+	platform *AESEncryptor
+}
+
+func NewLocalKMS(dir string, platform *AESEncryptor) (*LocalKMS, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create kms directory: %w", err)
+	}
+	return &LocalKMS{dir: dir, platform: platform}, nil
+}
+
+func (k *LocalKMS) CreateKey(ctx context.Context, material []byte) (string, error) {
+	if material == nil {
+		material = make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, material); err != nil {
+			return "", fmt.Errorf("failed to generate key: %w", err)
+		}
+	}
+	if len(material) != 32 {
+		return "", fmt.Errorf("key must be 32 bytes (AES-256), got %d", len(material))
+	}
+
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return "", fmt.Errorf("failed to generate key reference: %w", err)
+	}
+	ref := hex.EncodeToString(id)
+	sealed, err := k.fileEncryptor(ref).Encrypt(material)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(k.path(ref), []byte(sealed), 0o600); err != nil {
+		return "", fmt.Errorf("failed to store key: %w", err)
+	}
+	return ref, nil
+}
+
+func (k *LocalKMS) Wrap(ctx context.Context, keyRef string, dataKey, aad []byte) ([]byte, error) {
+	gcm, err := k.load(keyRef)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return gcm.Seal(nonce, nonce, dataKey, aad), nil
+}
+
+func (k *LocalKMS) Unwrap(ctx context.Context, keyRef string, wrapped, aad []byte) ([]byte, error) {
+	gcm, err := k.load(keyRef)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("wrapped key too short")
+	}
+	nonce, sealed := wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():]
+	dataKey, err := gcm.Open(nil, nonce, sealed, aad)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+// DestroyKey marks the key destroyed, then overwrites its file before
+// removing it
+func (k *LocalKMS) DestroyKey(ctx context.Context, keyRef string) error {
+	if !keyRefPattern.MatchString(keyRef) {
+		return fmt.Errorf("invalid key reference")
+	}
+	if err := os.WriteFile(k.markerPath(keyRef), nil, 0o600); err != nil {
+		return fmt.Errorf("failed to mark key destroyed: %w", err)
+	}
+	return k.shred(keyRef)
+}
+
+func (k *LocalKMS) shred(keyRef string) error {
+	path := k.path(keyRef)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, make([]byte, info.Size()), 0o600); err != nil {
+		return fmt.Errorf("failed to overwrite key: %w", err)
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove key: %w", err)
+	}
+	return nil
+}
+
+// ResealKeys implements KeyResealer. Each file is replaced by renaming a
+// resealed copy over it, so readers never see a partial file.
+func (k *LocalKMS) ResealKeys(ctx context.Context) (int, error) {
+	entries, err := os.ReadDir(k.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list keys: %w", err)
+	}
+	resealed := 0
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return resealed, err
+		}
+		ref, ok := strings.CutSuffix(entry.Name(), ".key")
+		if !ok || !keyRefPattern.MatchString(ref) {
+			continue
+		}
+		changed, err := k.reseal(ref)
+		if err != nil {
+			return resealed, fmt.Errorf("failed to reseal key %s: %w", ref, err)
+		}
+		if changed {
+			resealed++
+		}
+	}
+	return resealed, nil
+}
+
+func (k *LocalKMS) reseal(keyRef string) (bool, error) {
+	sealed, err := os.ReadFile(k.path(keyRef))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	rotated, changed, err := k.fileEncryptor(keyRef).Rotate(string(sealed))
+	if err != nil || !changed {
+		return false, err
+	}
+
+	tmp, err := os.CreateTemp(k.dir, "."+keyRef+".*.tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(rotated); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), k.path(keyRef)); err != nil {
+		return false, err
+	}
+	// The key may have been destroyed since it was read, in which case the
+	// rename brought it back
+	if _, err := os.Stat(k.markerPath(keyRef)); err == nil {
+		return false, k.shred(keyRef)
+	}
+	return true, nil
+}
+
+// load reads a key on every use rather than caching it, so destroying the
+// file on any replica takes effect everywhere at once
+func (k *LocalKMS) load(keyRef string) (cipher.AEAD, error) {
+	if !keyRefPattern.MatchString(keyRef) {
+		return nil, fmt.Errorf("invalid key reference")
+	}
+	if _, err := os.Stat(k.markerPath(keyRef)); err == nil {
+		return nil, ErrKeyDestroyed
+	}
+	sealed, err := os.ReadFile(k.path(keyRef))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrKeyDestroyed
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	material, err := k.fileEncryptor(keyRef).Decrypt(string(sealed))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key: %w", err)
+	}
+	return newGCM(material)
+}
+
+// fileEncryptor binds each key file to its reference, so files cannot be
+// swapped between references
+func (k *LocalKMS) fileEncryptor(keyRef string) *AESEncryptor {
+	enc, _ := k.platform.DerivePerTenantKey("kms/" + keyRef)
+	return enc
+}
+
+func (k *LocalKMS) path(keyRef string) string {
+	return filepath.Join(k.dir, keyRef+".key")
+}
+
+func (k *LocalKMS) markerPath(keyRef string) string {
+	return filepath.Join(k.dir, keyRef+".destroyed")
+}
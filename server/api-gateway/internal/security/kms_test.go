@@ -0,0 +1,99 @@
+package security
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+const testMasterKeyV2 = "202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f"
+
+func newTestKMS(t *testing.T, dir string, keys map[uint32]string) *LocalKMS {
+	t.Helper()
+	platform, err := NewAESKeyring(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kms, err := NewLocalKMS(dir, platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kms
+}
+
+func TestLocalKMSResealSurvivesDroppingOldMasterKey(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dataKey := bytes.Repeat([]byte{7}, 32)
+	aad := []byte("org-1")
+
+	v1 := newTestKMS(t, dir, map[uint32]string{1: testMasterKey})
+	ref, err := v1.CreateKey(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrapped, err := v1.Wrap(ctx, ref, dataKey, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gone, err := v1.CreateKey(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v1.DestroyKey(ctx, gone); err != nil {
+		t.Fatal(err)
+	}
+
+	both := newTestKMS(t, dir, map[uint32]string{1: testMasterKey, 2: testMasterKeyV2})
+	n, err := both.ResealKeys(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("resealed %d keys, want 1", n)
+	}
+	if n, err := both.ResealKeys(ctx); err != nil || n != 0 {
+		t.Fatalf("second reseal = %d, %v; want 0, nil", n, err)
+	}
+
+	v2 := newTestKMS(t, dir, map[uint32]string{2: testMasterKeyV2})
+	got, err := v2.Unwrap(ctx, ref, wrapped, aad)
+	if err != nil {
+		t.Fatalf("unwrap after dropping master key v1: %v", err)
+	}
+	if !bytes.Equal(got, dataKey) {
+		t.Fatal("unwrapped data key differs")
+	}
+	if _, err := v2.Wrap(ctx, gone, dataKey, aad); !errors.Is(err, ErrKeyDestroyed) {
+		t.Fatalf("wrap with destroyed key: %v, want ErrKeyDestroyed", err)
+	}
+}
+
+func TestLocalKMSResealDoesNotRestoreDestroyedKey(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	v1 := newTestKMS(t, dir, map[uint32]string{1: testMasterKey})
+	ref, err := v1.CreateKey(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	both := newTestKMS(t, dir, map[uint32]string{1: testMasterKey, 2: testMasterKeyV2})
+
+	// Destroyed while a reseal is in flight: the marker is written but the
+	// file is still there when the reseal reads it
+	if err := os.WriteFile(both.markerPath(ref), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := both.ResealKeys(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := both.Wrap(ctx, ref, make([]byte, 32), nil); !errors.Is(err, ErrKeyDestroyed) {
+		t.Fatalf("wrap after reseal: %v, want ErrKeyDestroyed", err)
+	}
+	if _, err := os.Stat(both.path(ref)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("key file still present after reseal: %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package security
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// ResourceType names a kind of resource addressed by ID in routes
+type ResourceType string
+
+const (
+	ResourceRepository  ResourceType = "repository"
+	ResourceAnalysis    ResourceType = "analysis"
+	ResourceDrift       ResourceType = "drift_event"
+	ResourceRule        ResourceType = "rule"
+	ResourcePhantom     ResourceType = "phantom_execution"
+	ResourceFix         ResourceType = "synthetic_fix"
+	ResourcePipelineRun ResourceType = "pipeline_run"
+
+	// Resources nested under their organization in routes
+	ResourceOrganization ResourceType = "organization"
+	ResourceAPIKey       ResourceType = "api_key"
+	ResourceRole         ResourceType = "role"
+	ResourceKEK          ResourceType = "kek"
+	ResourceDeadLetter   ResourceType = "dead_letter"
+)
+
+// notFoundMessages are the 404 bodies per resource type, matching the
+// handlers' own not-found responses
+var notFoundMessages = map[ResourceType]string{
+	ResourceRepository:  "repository not found",
+	ResourceAnalysis:    "analysis not found",
+	ResourceDrift:       "drift event not found",
+	ResourceRule:        "rule not found",
+	ResourcePhantom:     "phantom execution not found",
+	ResourceFix:         "synthetic fix not found",
+	ResourcePipelineRun: "pipeline run not found",
+}
+
+// OwnerLookup finds the organization owning a resource. It returns an empty
+// org ID if the resource does not exist.
+type OwnerLookup interface {
+	ResourceOrg(ctx context.Context, resource ResourceType, id string) (string, error)
+}
+
+// Actor types of audit events
+const (
+	ActorUser   = "user"
+	ActorAPIKey = "api_key"
+)
+
+// Outcomes of audited requests
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+	OutcomeDenied  = "denied"
+)
+
+// AuditEvent is a security-relevant event recorded in the audit log.
+// ActorID is the user subject or, for API keys, the key ID.
+type AuditEvent struct {
+	OrgID        string
+	ActorType    string
+	ActorID      string
+	Action       string
+	ResourceType string
+	ResourceID   string
+	RequestID    string
+	IPAddress    string
+	Outcome      string
+	StatusCode   int
+	Details      map[string]interface{}
+}
+
+// AuditSink persists audit events
+type AuditSink interface {
+	RecordAuditEvent(ctx context.Context, ev AuditEvent) error
+}
+
+const (
+	// Resources never change owner, so owners are cached for long; the TTL
+	// only bounds how long deleted resources linger
+	ownerCacheTTL     = 10 * time.Minute
+	ownerCacheMaxSize = 50000
+	auditTimeout      = 5 * time.Second
+)
+
+type ownerKey struct {
+	resource ResourceType
+	id       string
+}
+
+type cachedOwner struct {
+	orgID   string
+	expires time.Time
+}
+
+// OwnershipResolver rejects requests for resources owned by another
+// organization. Routes without an :orgId parameter address resources by ID
+// only, so the owner has to be looked up.
+type OwnershipResolver struct {
+	owners OwnerLookup
+	audit  AuditSink
+	logger *zap.SugaredLogger
+
+	mu    sync.Mutex
+	cache map[ownerKey]cachedOwner
+}
+
+func NewOwnershipResolver(owners OwnerLookup, audit AuditSink, logger *zap.SugaredLogger) *OwnershipResolver {
+	return &OwnershipResolver{
+		owners: owners,
+		audit:  audit,
+		logger: logger,
+		cache:  make(map[ownerKey]cachedOwner),
+	}
+}
+
+// Owner returns the organization owning a resource, or an empty string if
+// it does not exist. Missing resources are not cached.
+func (r *OwnershipResolver) Owner(ctx context.Context, resource ResourceType, id string) (string, error) {
+	key := ownerKey{resource, id}
+	now := time.Now()
+	r.mu.Lock()
+	entry, ok := r.cache[key]
+	r.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.orgID, nil
+	}
+
+	orgID, err := r.owners.ResourceOrg(ctx, resource, id)
+	if err != nil || orgID == "" {
+		return "", err
+	}
+
+	r.mu.Lock()
+	if len(r.cache) >= ownerCacheMaxSize {
+		for k, e := range r.cache {
+			if now.After(e.expires) {
+				delete(r.cache, k)
+			}
+		}
+		if len(r.cache) >= ownerCacheMaxSize {
+			r.cache = make(map[ownerKey]cachedOwner)
+		}
+	}
+	r.cache[key] = cachedOwner{orgID: orgID, expires: now.Add(ownerCacheTTL)}
+	r.mu.Unlock()
+	return orgID, nil
+}
+
+// Require admits the request only if the resource named by the route
+// parameter belongs to the caller's organization. Resources of other
+// organizations are reported as not found, exactly like missing ones, and
+// every such attempt is audited.
+func (r *OwnershipResolver) Require(resource ResourceType, param string) fiber.Handler {
+	notFound := notFoundMessages[resource]
+	if notFound == "" {
+		notFound = string(resource) + " not found"
+	}
+
+	return func(c *fiber.Ctx) error {
+		// Params alias fasthttp's request buffer, which is reused; the ID
+		// outlives the request in the cache and the audit event
+		id := strings.Clone(c.Params(param))
+		callerOrg, _ := c.Locals("org_id").(string)
+
+		owner, err := r.Owner(c.UserContext(), resource, id)
+		if err != nil {
+			return err
+		}
+		if owner == "" {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": notFound})
+		}
+		if owner != callerOrg {
+			r.auditDenied(c, resource, id, callerOrg)
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": notFound})
+		}
+		return c.Next()
+	}
+}
+
+// auditDenied records a cross-tenant attempt in the caller's audit log. The
+// owning organization is deliberately left out so the log does not disclose
+// it to the caller's auditors.
+func (r *OwnershipResolver) auditDenied(c *fiber.Ctx, resource ResourceType, id, callerOrg string) {
+	actorType, actorID := auditActor(c)
+	ev := AuditEvent{
+		OrgID:        callerOrg,
+		ActorType:    actorType,
+		ActorID:      actorID,
+		Action:       "access_denied",
+		ResourceType: string(resource),
+		ResourceID:   id,
+		RequestID:    auditRequestID(c),
+		IPAddress:    c.IP(),
+		Outcome:      OutcomeDenied,
+		StatusCode:   fiber.StatusNotFound,
+		Details: map[string]interface{}{
+			"reason": "cross_tenant_access",
+			"method": strings.Clone(c.Method()),
+			"path":   strings.Clone(c.Path()),
+		},
+	}
+
+	r.logger.Warnw("cross-tenant access denied",
+		"org_id", callerOrg, "actor", actorID, "resource_type", resource, "resource_id", id)
+
+	// The request context is recycled once the handler returns
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+		defer cancel()
+		if err := r.audit.RecordAuditEvent(ctx, ev); err != nil {
+			r.logger.Errorw("failed to record audit event", "action", ev.Action, "error", err)
+		}
+	}()
+}
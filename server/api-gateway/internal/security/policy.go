@@ -0,0 +1,235 @@
+package security
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Permission is an action on a resource type, written resource:action
+type Permission string
+
+const (
+	PermOrgsRead        Permission = "orgs:read"
+	PermOrgsCreate      Permission = "orgs:create"
+	PermAPIKeysManage   Permission = "api_keys:manage"
+	PermRolesManage     Permission = "roles:manage"
+	PermKEKsManage      Permission = "keks:manage"
+	PermReposRead       Permission = "repos:read"
+	PermReposWrite      Permission = "repos:write"
+	PermAnalysisRead    Permission = "analysis:read"
+	PermAnalysisTrigger Permission = "analysis:trigger"
+	PermPipelinesRead   Permission = "pipelines:read"
+	PermPipelinesWrite  Permission = "pipelines:write"
+	PermDriftRead       Permission = "drift:read"
+	PermDriftWrite      Permission = "drift:write"
+	PermRulesRead       Permission = "rules:read"
+	PermRulesWrite      Permission = "rules:write"
+	PermRulesDelete     Permission = "rules:delete"
+	PermPhantomRead     Permission = "phantom:read"
+	PermPhantomRun      Permission = "phantom:run"
+	PermFixesRead       Permission = "fixes:read"
+	PermFixesApply      Permission = "fixes:apply"
+	PermMetricsRead     Permission = "metrics:read"
+	PermAuditRead       Permission = "audit:read"
+	PermDLQManage       Permission = "dlq:manage"
+)
+
+// Permissions lists every permission. Those managing identities and
+// credentials can only be held by users, never granted to API keys.
+var Permissions = []Permission{
+	PermOrgsRead, PermOrgsCreate, PermAPIKeysManage, PermRolesManage, PermKEKsManage,
+	PermReposRead, PermReposWrite,
+	PermAnalysisRead, PermAnalysisTrigger,
+	PermPipelinesRead, PermPipelinesWrite,
+	PermDriftRead, PermDriftWrite,
+	PermRulesRead, PermRulesWrite, PermRulesDelete,
+	PermPhantomRead, PermPhantomRun,
+	PermFixesRead, PermFixesApply,
+	PermMetricsRead,
+	PermAuditRead,
+	PermDLQManage,
+}
+
+var userOnly = map[Permission]bool{
+	PermOrgsRead:      true,
+	PermOrgsCreate:    true,
+	PermAPIKeysManage: true,
+	PermRolesManage:   true,
+	PermKEKsManage:    true,
+}
+
+// ValidPermission reports whether p is a known permission
+func ValidPermission(p string) bool {
+	for _, perm := range Permissions {
+		if string(perm) == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Grantable reports whether p can be granted to an API key
+func Grantable(p string) bool {
+	return ValidPermission(p) && !userOnly[Permission(p)]
+}
+
+var (
+	viewerPermissions = []Permission{
+		PermOrgsRead, PermReposRead, PermAnalysisRead, PermPipelinesRead, PermDriftRead,
+		PermRulesRead, PermPhantomRead, PermFixesRead, PermMetricsRead,
+	}
+	developerPermissions = append(append([]Permission{}, viewerPermissions...),
+		PermAnalysisTrigger, PermDriftWrite, PermPhantomRun,
+	)
+	architectPermissions = append(append([]Permission{}, developerPermissions...),
+		PermReposWrite, PermPipelinesWrite, PermRulesWrite, PermRulesDelete, PermFixesApply, PermAuditRead,
+	)
+)
+
+// BuiltinRoles maps the platform roles to their permissions. Organizations
+// can define further roles but cannot redefine these.
+var BuiltinRoles = map[string][]Permission{
+	"viewer":    viewerPermissions,
+	"developer": developerPermissions,
+	"architect": architectPermissions,
+	"admin":     Permissions,
+}
+
+var builtinSets = func() map[string]map[Permission]bool {
+	sets := make(map[string]map[Permission]bool, len(BuiltinRoles))
+	for role, perms := range BuiltinRoles {
+		sets[role] = permissionSet(perms)
+	}
+	return sets
+}()
+
+// CustomRoles resolves organization-defined roles. It returns nil
+// permissions if the organization has no role with that name.
+type CustomRoles interface {
+	CustomRolePermissions(ctx context.Context, orgID, role string) ([]string, error)
+}
+
+// customRoleTTL bounds how long a changed custom role may take to apply on
+// replicas that did not make the change
+const customRoleTTL = 30 * time.Second
+
+type cachedRole struct {
+	permissions map[Permission]bool
+	expires     time.Time
+}
+
+// Policy decides whether a caller holds a permission. Built-in roles are
+// resolved in memory; custom roles are loaded per organization and cached.
+type Policy struct {
+	custom CustomRoles
+
+	mu    sync.Mutex
+	cache map[string]cachedRole
+}
+
+func NewPolicy(custom CustomRoles) *Policy {
+	return &Policy{custom: custom, cache: make(map[string]cachedRole)}
+}
+
+// Permissions returns the permissions held by a role in an organization;
+// unknown roles hold none
+func (p *Policy) Permissions(ctx context.Context, orgID, role string) (map[Permission]bool, error) {
+	if set, ok := builtinSets[role]; ok {
+		return set, nil
+	}
+	if role == "" {
+		return map[Permission]bool{}, nil
+	}
+
+	key := orgID + "/" + role
+	p.mu.Lock()
+	entry, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.permissions, nil
+	}
+
+	names, err := p.custom.CustomRolePermissions(ctx, orgID, role)
+	if err != nil {
+		return nil, err
+	}
+	perms := make(map[Permission]bool, len(names))
+	for _, n := range names {
+		perms[Permission(n)] = true
+	}
+	p.mu.Lock()
+	p.cache[key] = cachedRole{permissions: perms, expires: time.Now().Add(customRoleTTL)}
+	p.mu.Unlock()
+	return perms, nil
+}
+
+// Invalidate drops an organization's cached custom roles after they change
+func (p *Policy) Invalidate(orgID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key := range p.cache {
+		if strings.HasPrefix(key, orgID+"/") {
+			delete(p.cache, key)
+		}
+	}
+}
+
+// Require admits the request only if the caller holds perm. It also enforces
+// tenant isolation: an :orgId route parameter must be the caller's
+// organization, and other organizations are reported as not found rather
+// than forbidden so their existence is not disclosed.
+//
+// API key callers hold exactly the scopes granted to the key; users hold the
+// permissions of their role.
+func (p *Policy) Require(perm Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		orgID, _ := c.Locals("org_id").(string)
+		if orgID == "" {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "tenant context missing — org_id not found in token",
+			})
+		}
+		if paramOrgID := c.Params("orgId"); paramOrgID != "" && paramOrgID != orgID {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "organization not found"})
+		}
+
+		var allowed bool
+		if _, isKey := c.Locals("api_key_id").(string); isKey {
+			scopes, _ := c.Locals("api_key_scopes").([]string)
+			for _, s := range scopes {
+				if Permission(s) == perm {
+					allowed = true
+					break
+				}
+			}
+		} else {
+			role, _ := c.Locals("role").(string)
+			perms, err := p.Permissions(c.UserContext(), orgID, role)
+			if err != nil {
+				return err
+			}
+			allowed = perms[perm]
+		}
+		if !allowed {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":               "insufficient permissions",
+				"required_permission": perm,
+			})
+		}
+
+		c.Locals("permission", string(perm))
+		return c.Next()
+	}
+}
+
+func permissionSet(perms []Permission) map[Permission]bool {
+	set := make(map[Permission]bool, len(perms))
+	for _, p := range perms {
+		set[p] = true
+	}
+	return set
+}
@@ -0,0 +1,303 @@
+package security
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+var unencryptedRows = promauto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "archlens_unencrypted_rows",
+		Help: "Rows of encrypted fields still holding plaintext, as of the last re-encryption pass",
+	},
+	[]string{"field"},
+)
+
+// EncryptedValue is one stored value of an encrypted field
+type EncryptedValue struct {
+	ID    string
+	OrgID string
+	Value string
+}
+
+// CiphertextStore exposes the encrypted fields of the database to the
+// Reencryptor. Fields are identified by name, e.g. "synthetic_fixes.patch".
+type CiphertextStore interface {
+	EncryptedFields() []string
+	// StaleCiphertexts returns up to limit values of field, ordered by ID and
+	// after the given ID, whose ciphertext starts with none of the keep
+	// prefixes. A non-empty orgID restricts it to one organization.
+	StaleCiphertexts(ctx context.Context, field, orgID string, keep []string, afterID string, limit int) ([]EncryptedValue, error)
+	// ReplaceCiphertext swaps a value only if it still holds old, so
+	// concurrent writes win over the migration. It reports whether it did.
+	ReplaceCiphertext(ctx context.Context, field, id, old, replacement string) (bool, error)
+	// HeaderlessValues returns up to limit values of field, ordered by ID and
+	// after the given ID, without a ciphertext header: plaintext stored before
+	// the field was encrypted, and legacy ciphertexts
+	HeaderlessValues(ctx context.Context, field, afterID string, limit int) ([]EncryptedValue, error)
+}
+
+const reencryptBatchSize = 200
+
+// Reencryptor migrates stored ciphertexts to each organization's current
+// key in the background: to the newest platform key version, or into
+// envelopes under the organization's active KEK. It also reseals the keys of
+// KMSs implementing KeyResealer. Old key versions and retired KEKs can be
+// dropped once it has caught up.
+type Reencryptor struct {
+	env    *Envelope
+	store  CiphertextStore
+	logger *zap.SugaredLogger
+}
+
+func NewReencryptor(env *Envelope, store CiphertextStore, logger *zap.SugaredLogger) *Reencryptor {
+	return &Reencryptor{env: env, store: store, logger: logger}
+}
+
+// Run performs a pass immediately and then every interval until ctx is done
+func (r *Reencryptor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		r.Pass(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Pass re-encrypts every stale value of every encrypted field once. The
+// first sweep covers legacy ciphertexts, which have no header, and counts
+// the plaintext values among them in archlens_unencrypted_rows; plaintext is
+// left to EncryptPlaintext. The second covers platform-key ciphertexts of
+// all organizations; then each organization with a KEK is swept for values
+// not yet under it. Values that cannot be decrypted are logged and skipped;
+// values of organizations whose KEK was revoked are skipped silently.
+func (r *Reencryptor) Pass(ctx context.Context) {
+	if !r.sweepHeaderless(ctx) {
+		return
+	}
+	orgs, err := r.env.keks.ActiveKEKOrgs(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Errorw("re-encryption pass failed", "error", err)
+		}
+		return
+	}
+	for _, field := range r.store.EncryptedFields() {
+		keep := []string{r.env.PlatformPrefix(), envelopePrefix}
+		if !r.sweep(ctx, field, "", keep) {
+			return
+		}
+		for _, orgID := range orgs {
+			current, err := r.env.CurrentPrefix(ctx, orgID)
+			if err != nil {
+				r.logger.Errorw("re-encryption pass failed", "field", field, "org_id", orgID, "error", err)
+				return
+			}
+			if !r.sweep(ctx, field, orgID, []string{current}) {
+				return
+			}
+		}
+	}
+	r.resealKeys(ctx)
+}
+
+// resealKeys moves KMS keys sealed with the platform keyring to its newest
+// version
+func (r *Reencryptor) resealKeys(ctx context.Context) {
+	for name, kms := range r.env.kms {
+		resealer, ok := kms.(KeyResealer)
+		if !ok {
+			continue
+		}
+		n, err := resealer.ResealKeys(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				r.logger.Errorw("failed to reseal kms keys", "provider", name, "resealed", n, "error", err)
+			}
+			continue
+		}
+		if n > 0 {
+			r.logger.Infow("resealed kms keys", "provider", name, "resealed", n, "key_id", r.env.platform.CurrentKeyID())
+		}
+	}
+}
+
+// sweep migrates one field and reports whether the pass can go on
+func (r *Reencryptor) sweep(ctx context.Context, field, orgID string, keep []string) bool {
+	rotated, failed, err := r.migrateField(ctx, field, orgID, keep)
+	if err != nil {
+		if ctx.Err() == nil {
+			r.logger.Errorw("re-encryption pass failed", "field", field, "org_id", orgID, "rotated", rotated, "error", err)
+		}
+		return false
+	}
+	if rotated > 0 || failed > 0 {
+		r.logger.Infow("re-encrypted field",
+			"field", field, "org_id", orgID, "rotated", rotated, "failed", failed, "key_id", r.env.platform.CurrentKeyID())
+	}
+	return true
+}
+
+func (r *Reencryptor) migrateField(ctx context.Context, field, orgID string, keep []string) (rotated, failed int, err error) {
+	after := ""
+	for {
+		batch, err := r.store.StaleCiphertexts(ctx, field, orgID, keep, after, reencryptBatchSize)
+		if err != nil {
+			return rotated, failed, err
+		}
+		for _, v := range batch {
+			after = v.ID
+			ok, bad, err := r.rotate(ctx, field, v)
+			if err != nil {
+				return rotated, failed, err
+			}
+			if ok {
+				rotated++
+			}
+			if bad {
+				failed++
+			}
+		}
+		if len(batch) < reencryptBatchSize {
+			return rotated, failed, nil
+		}
+	}
+}
+
+// rotate re-encrypts one value under its organization's current key and
+// reports whether it was replaced. Values that cannot be decrypted are
+// logged and reported as failed; an error means the sweep must stop.
+func (r *Reencryptor) rotate(ctx context.Context, field string, v EncryptedValue) (rotated, failed bool, err error) {
+	replacement, changed, err := r.env.Rotate(ctx, v.OrgID, v.Value)
+	if errors.Is(err, ErrKEKRevoked) {
+		return false, false, nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return false, false, ctx.Err()
+		}
+		r.logger.Warnw("cannot re-encrypt value", "field", field, "id", v.ID, "error", err)
+		return false, true, nil
+	}
+	if !changed {
+		return false, false, nil
+	}
+	ok, err := r.store.ReplaceCiphertext(ctx, field, v.ID, v.Value, replacement)
+	return ok, false, err
+}
+
+// sweepHeaderless re-encrypts the legacy ciphertexts of every field and
+// publishes how many plaintext values remain. It reports whether the pass
+// can go on.
+func (r *Reencryptor) sweepHeaderless(ctx context.Context) bool {
+	for _, field := range r.store.EncryptedFields() {
+		var plaintext int64
+		rotated, failed := 0, 0
+		err := r.eachHeaderless(ctx, field, func(v EncryptedValue, legacy bool) error {
+			if !legacy {
+				plaintext++
+				return nil
+			}
+			ok, bad, err := r.rotate(ctx, field, v)
+			if ok {
+				rotated++
+			}
+			if bad {
+				failed++
+			}
+			return err
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				r.logger.Errorw("re-encryption pass failed", "field", field, "rotated", rotated, "error", err)
+			}
+			return false
+		}
+		if rotated > 0 || failed > 0 {
+			r.logger.Infow("re-encrypted legacy ciphertexts",
+				"field", field, "rotated", rotated, "failed", failed, "key_id", r.env.platform.CurrentKeyID())
+		}
+		unencryptedRows.WithLabelValues(field).Set(float64(plaintext))
+		if plaintext > 0 {
+			r.logger.Warnw("encrypted field holds plaintext rows; run encrypt-rows to migrate them", "field", field, "rows", plaintext)
+		}
+	}
+	return true
+}
+
+// EncryptPlaintext encrypts in place the values of a field stored before it
+// was encrypted. Legacy ciphertexts among them are re-encrypted rather than
+// encrypted again. Like re-encryption it only replaces values that did not
+// change meanwhile, so it is safe to run next to live gateways.
+func (r *Reencryptor) EncryptPlaintext(ctx context.Context, field string) (encrypted, failed int, err error) {
+	err = r.eachHeaderless(ctx, field, func(v EncryptedValue, legacy bool) error {
+		if legacy {
+			ok, bad, err := r.rotate(ctx, field, v)
+			if ok {
+				encrypted++
+			}
+			if bad {
+				failed++
+			}
+			return err
+		}
+		ciphertext, err := r.env.Encrypt(ctx, v.OrgID, []byte(v.Value))
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failed++
+			r.logger.Warnw("cannot encrypt value", "field", field, "id", v.ID, "error", err)
+			return nil
+		}
+		ok, err := r.store.ReplaceCiphertext(ctx, field, v.ID, v.Value, ciphertext)
+		if ok {
+			encrypted++
+		}
+		return err
+	})
+	return encrypted, failed, err
+}
+
+// CountPlaintext returns how many values of a field are stored in plaintext
+func (r *Reencryptor) CountPlaintext(ctx context.Context, field string) (int64, error) {
+	var n int64
+	err := r.eachHeaderless(ctx, field, func(v EncryptedValue, legacy bool) error {
+		if !legacy {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// eachHeaderless calls fn for every value of a field without a ciphertext
+// header, telling it whether the value is a legacy ciphertext. It stops at
+// the first error fn returns.
+func (r *Reencryptor) eachHeaderless(ctx context.Context, field string, fn func(v EncryptedValue, legacy bool) error) error {
+	after := ""
+	for {
+		batch, err := r.store.HeaderlessValues(ctx, field, after, reencryptBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, v := range batch {
+			after = v.ID
+			if err := fn(v, r.env.IsLegacyCiphertext(v.OrgID, v.Value)); err != nil {
+				return err
+			}
+		}
+		if len(batch) < reencryptBatchSize {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package security
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+)
+
+const testField = "synthetic_fixes.patch"
+
+// memCiphertexts is an in-memory CiphertextStore with a single field
+type memCiphertexts struct {
+	mu     sync.Mutex
+	values map[string]EncryptedValue
+}
+
+func (m *memCiphertexts) EncryptedFields() []string { return []string{testField} }
+
+func (m *memCiphertexts) page(after string, limit int, match func(string) bool) []EncryptedValue {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []EncryptedValue
+	for _, v := range m.values {
+		if v.ID > after && match(v.Value) {
+			out = append(out, v)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+func (m *memCiphertexts) StaleCiphertexts(_ context.Context, _, orgID string, keep []string, after string, limit int) ([]EncryptedValue, error) {
+	return m.page(after, limit, func(v string) bool {
+		for _, p := range keep {
+			if strings.HasPrefix(v, p) {
+				return false
+			}
+		}
+		return IsCiphertext(v)
+	}), nil
+}
+
+func (m *memCiphertexts) HeaderlessValues(_ context.Context, _, after string, limit int) ([]EncryptedValue, error) {
+	return m.page(after, limit, func(v string) bool { return !IsCiphertext(v) }), nil
+}
+
+func (m *memCiphertexts) ReplaceCiphertext(_ context.Context, _, id, old, replacement string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.values[id]
+	if !ok || v.Value != old {
+		return false, nil
+	}
+	v.Value = replacement
+	m.values[id] = v
+	return true, nil
+}
+
+func (m *memCiphertexts) value(id string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[id].Value
+}
+
+// sealLegacy writes a ciphertext the way values were sealed before key
+// versioning: base64 of nonce||sealed under the master key with the tenant ID
+// XORed into it
+func sealLegacy(t *testing.T, orgID, plaintext string) string {
+	t.Helper()
+	key, err := hex.DecodeString(testMasterKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, b := range []byte(orgID) {
+		key[i%32] ^= b
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, []byte(plaintext), nil))
+}
+
+// newHeaderlessFixture stores a plaintext value, a legacy ciphertext and a
+// plaintext value that merely looks like one
+func newHeaderlessFixture(t *testing.T) (*envelopeFixture, *memCiphertexts, *Reencryptor) {
+	t.Helper()
+	f := newEnvelopeFixture(t)
+	base64ish := base64.StdEncoding.EncodeToString([]byte("just some bytes that are long enough to pass for a nonce"))
+	st := &memCiphertexts{values: map[string]EncryptedValue{
+		"1": {ID: "1", OrgID: f.org, Value: "package main"},
+		"2": {ID: "2", OrgID: f.org, Value: sealLegacy(t, f.org, "legacy secret")},
+		"3": {ID: "3", OrgID: f.org, Value: base64ish},
+	}}
+	return f, st, NewReencryptor(f.env, st, zap.NewNop().Sugar())
+}
+
+func (f *envelopeFixture) open(t *testing.T, value string) string {
+	t.Helper()
+	plaintext, err := f.env.Decrypt(context.Background(), f.org, value)
+	if err != nil {
+		t.Fatalf("decrypt %q: %v", value, err)
+	}
+	return string(plaintext)
+}
+
+func TestPassRotatesLegacyCiphertexts(t *testing.T) {
+	ctx := context.Background()
+	f, st, r := newHeaderlessFixture(t)
+
+	if n, err := r.CountPlaintext(ctx, testField); err != nil || n != 2 {
+		t.Fatalf("CountPlaintext = %d, %v; want 2", n, err)
+	}
+	r.Pass(ctx)
+
+	legacy := st.value("2")
+	if !strings.HasPrefix(legacy, f.env.PlatformPrefix()) {
+		t.Fatalf("legacy ciphertext not rotated: %q", legacy)
+	}
+	if got := f.open(t, legacy); got != "legacy secret" {
+		t.Fatalf("rotated legacy value decrypts to %q", got)
+	}
+	if st.value("1") != "package main" {
+		t.Fatal("pass encrypted plaintext; that is left to EncryptPlaintext")
+	}
+	if got := testutil.ToFloat64(unencryptedRows.WithLabelValues(testField)); got != 2 {
+		t.Fatalf("archlens_unencrypted_rows = %v, want 2", got)
+	}
+}
+
+func TestEncryptPlaintextDoesNotEncryptLegacyTwice(t *testing.T) {
+	ctx := context.Background()
+	f, st, r := newHeaderlessFixture(t)
+	base64ish := st.value("3")
+
+	encrypted, failed, err := r.EncryptPlaintext(ctx, testField)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted != 3 || failed != 0 {
+		t.Fatalf("encrypted %d, failed %d; want 3, 0", encrypted, failed)
+	}
+	for id, want := range map[string]string{"1": "package main", "2": "legacy secret", "3": base64ish} {
+		if got := f.open(t, st.value(id)); got != want {
+			t.Errorf("value %s decrypts to %q, want %q", id, got, want)
+		}
+	}
+	if n, err := r.CountPlaintext(ctx, testField); err != nil || n != 0 {
+		t.Fatalf("CountPlaintext = %d, %v; want 0", n, err)
+	}
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/archlens/api-gateway/internal/security"
+)
+
+// Analysis summarizes an analysis of a repository commit; violations and
+// metrics are served by their own endpoints. Summary is stored encrypted and
+// is null when the organization's key was revoked.
+type Analysis struct {
+	ID          string          `json:"id"`
+	RepoID      string          `json:"repo_id"`
+	CommitSHA   string          `json:"commit_sha"`
+	Branch      string          `json:"branch"`
+	Status      string          `json:"status"`
+	HealthScore *float32        `json:"health_score,omitempty"`
+	Summary     json.RawMessage `json:"summary"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	CompletedAt *time.Time      `json:"completed_at,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+}
+
+// AnalysisListing declares how analysis lists are queried
+var AnalysisListing = listing.Spec{
+	Sorts: map[string]listing.Sort{
+		"created_at": {Column: "created_at", Type: listing.Time},
+	},
+	DefaultSort: "-created_at",
+	Filters: map[string]listing.Filter{
+		"status":     {Column: "status", Type: listing.Text, Values: []string{"pending", "running", "completed", "failed"}},
+		"branch":     {Column: "branch", Type: listing.Text},
+		"commit_sha": {Column: "commit_sha", Type: listing.Text},
+	},
+}
+
+const analysisColumns = `id, repo_id, commit_sha, branch, status, health_score, summary, started_at, completed_at, created_at`
+
+func scanAnalysis(row rowScanner) (*Analysis, error) {
+	var a Analysis
+	if err := row.Scan(&a.ID, &a.RepoID, &a.CommitSHA, &a.Branch, &a.Status, &a.HealthScore, &a.Summary,
+		&a.StartedAt, &a.CompletedAt, &a.CreatedAt); err != nil {
+		return nil, translateError(err)
+	}
+	return &a, nil
+}
+
+// ListAnalyses returns a page of the analyses of a repository
+func (s *Store) ListAnalyses(ctx context.Context, orgID, repoID string, q listing.Query) (*listing.Page[*Analysis], error) {
+	page, err := listPage(ctx, s, q, analysisColumns, "analysis_results",
+		"repo_id = $2 AND repo_id IN (SELECT id FROM repositories WHERE org_id = $1)",
+		[]interface{}{orgID, repoID}, scanAnalysis)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range page.Data {
+		summary, err := s.open(ctx, orgID, string(a.Summary))
+		if err != nil && !errors.Is(err, security.ErrKEKRevoked) {
+			return nil, fmt.Errorf("failed to decrypt summary of analysis %s: %w", a.ID, err)
+		}
+		a.Summary = nil
+		if err == nil && json.Valid(summary) {
+			a.Summary = summary
+		}
+	}
+	return page, nil
+}
@@ -0,0 +1,223 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/auth"
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/archlens/api-gateway/internal/security"
+)
+
+// APIKey is an org-scoped credential for non-interactive clients. The key
+// itself is only returned when it is created.
+type APIKey struct {
+	ID         string     `json:"id"`
+	OrgID      string     `json:"org_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  *string    `json:"created_by,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the key was granted scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyInput holds the fields accepted when creating an API key
+type APIKeyInput struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyPatch holds the fields that can be changed on an existing key. The
+// expiry cannot be extended; issue a new key instead.
+type APIKeyPatch struct {
+	Name   *string  `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// Validate normalizes the input and checks it against the schema constraints
+func (in *APIKeyInput) Validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	if in.Name == "" {
+		return &ValidationError{Field: "name", Message: "is required"}
+	}
+	if len(in.Name) > 200 {
+		return &ValidationError{Field: "name", Message: "must be at most 200 characters"}
+	}
+	scopes, err := normalizeScopes(in.Scopes)
+	if err != nil {
+		return err
+	}
+	in.Scopes = scopes
+	if in.ExpiresAt != nil && !in.ExpiresAt.After(time.Now()) {
+		return &ValidationError{Field: "expires_at", Message: "must be in the future"}
+	}
+	return nil
+}
+
+// Validate normalizes the patch and checks it against the schema constraints
+func (p *APIKeyPatch) Validate() error {
+	if p.Name != nil {
+		name := strings.TrimSpace(*p.Name)
+		if name == "" {
+			return &ValidationError{Field: "name", Message: "must not be empty"}
+		}
+		if len(name) > 200 {
+			return &ValidationError{Field: "name", Message: "must be at most 200 characters"}
+		}
+		p.Name = &name
+	}
+	if p.Scopes != nil {
+		scopes, err := normalizeScopes(p.Scopes)
+		if err != nil {
+			return err
+		}
+		p.Scopes = scopes
+	}
+	return nil
+}
+
+// normalizeScopes rejects scopes that are not grantable permissions and
+// drops duplicates
+func normalizeScopes(in []string) ([]string, error) {
+	if len(in) == 0 {
+		return nil, &ValidationError{Field: "scopes", Message: "must grant at least one scope"}
+	}
+	seen := make(map[string]bool, len(in))
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !security.Grantable(s) {
+			return nil, &ValidationError{Field: "scopes", Message: s + " is not a permission that can be granted to an api key"}
+		}
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out, nil
+}
+
+const apiKeyColumns = `id, org_id, name, prefix, scopes, created_by, expires_at, last_used_at, revoked_at, created_at`
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var k APIKey
+	if err := row.Scan(&k.ID, &k.OrgID, &k.Name, &k.Prefix, &k.Scopes, &k.CreatedBy,
+		&k.ExpiresAt, &k.LastUsedAt, &k.RevokedAt, &k.CreatedAt); err != nil {
+		return nil, translateError(err)
+	}
+	return &k, nil
+}
+
+// APIKeyListing declares how API key lists are queried
+var APIKeyListing = listing.Spec{
+	Sorts: map[string]listing.Sort{
+		"created_at": {Column: "created_at", Type: listing.Time},
+		"name":       {Column: "name", Type: listing.Text},
+	},
+	DefaultSort: "-created_at",
+	Filters: map[string]listing.Filter{
+		"revoked": {Column: "revoked_at IS NOT NULL", Type: listing.Bool},
+	},
+}
+
+// ListAPIKeys returns a page of an organization's keys, including revoked
+// and expired ones
+func (s *Store) ListAPIKeys(ctx context.Context, orgID string, q listing.Query) (*listing.Page[*APIKey], error) {
+	return listPage(ctx, s, q, apiKeyColumns, "api_keys", "org_id = $1", []interface{}{orgID}, scanAPIKey)
+}
+
+// GetAPIKey returns a key if it belongs to the organization
+func (s *Store) GetAPIKey(ctx context.Context, orgID, id string) (*APIKey, error) {
+	row := s.pool.QueryRow(ctx,
+		`SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1 AND org_id = $2`,
+		id, orgID,
+	)
+	return scanAPIKey(row)
+}
+
+// CreateAPIKey issues a key for an organization and returns it together with
+// the plaintext key, which cannot be retrieved later. createdBy is resolved
+// like the creator of a rule.
+func (s *Store) CreateAPIKey(ctx context.Context, orgID, createdBy string, in APIKeyInput) (*APIKey, string, error) {
+	if err := in.Validate(); err != nil {
+		return nil, "", err
+	}
+	key, prefix, hash, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+	row := s.pool.QueryRow(ctx,
+		`INSERT INTO api_keys (org_id, name, prefix, key_hash, scopes, expires_at, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6,
+		         (SELECT id FROM users WHERE org_id = $1 AND (id::text = $7 OR external_id = $7) LIMIT 1))
+		 RETURNING `+apiKeyColumns,
+		orgID, in.Name, prefix, hash, in.Scopes, in.ExpiresAt, createdBy,
+	)
+	k, err := scanAPIKey(row)
+	if err != nil {
+		return nil, "", err
+	}
+	return k, key, nil
+}
+
+// UpdateAPIKey renames a key or replaces its scopes. Revoked keys cannot be
+// changed.
+func (s *Store) UpdateAPIKey(ctx context.Context, orgID, id string, p APIKeyPatch) (*APIKey, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	row := s.pool.QueryRow(ctx,
+		`UPDATE api_keys
+		 SET name = COALESCE($3, name), scopes = COALESCE($4, scopes)
+		 WHERE id = $1 AND org_id = $2 AND revoked_at IS NULL
+		 RETURNING `+apiKeyColumns,
+		id, orgID, p.Name, p.Scopes,
+	)
+	return scanAPIKey(row)
+}
+
+// RevokeAPIKey permanently disables a key. Revoking a key twice keeps the
+// original revocation time.
+func (s *Store) RevokeAPIKey(ctx context.Context, orgID, id string) (*APIKey, error) {
+	row := s.pool.QueryRow(ctx,
+		`UPDATE api_keys SET revoked_at = COALESCE(revoked_at, NOW())
+		 WHERE id = $1 AND org_id = $2
+		 RETURNING `+apiKeyColumns,
+		id, orgID,
+	)
+	return scanAPIKey(row)
+}
+
+// AuthenticateAPIKey looks up an active key by its plaintext value and
+// records its use. last_used_at is only written once a minute per key so
+// busy CI keys do not turn every request into a row update.
+func (s *Store) AuthenticateAPIKey(ctx context.Context, key string) (*APIKey, error) {
+	row := s.pool.QueryRow(ctx,
+		`WITH active AS (
+		     SELECT `+apiKeyColumns+` FROM api_keys
+		     WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+		 ), touched AS (
+		     UPDATE api_keys SET last_used_at = NOW()
+		     WHERE id = (SELECT id FROM active)
+		       AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+		 )
+		 SELECT `+apiKeyColumns+` FROM active`,
+		auth.HashAPIKey(key),
+	)
+	return scanAPIKey(row)
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/resilience"
+	"github.com/archlens/api-gateway/internal/security"
+)
+
+// deadLetterStore implements resilience.DeadLetterStore on the dead_letters
+// table. Payloads are encrypted for the message's organization.
+type deadLetterStore struct {
+	s *Store
+}
+
+// DeadLetters returns the dead-letter queue backend kept in PostgreSQL
+func (s *Store) DeadLetters() resilience.DeadLetterStore {
+	return deadLetterStore{s: s}
+}
+
+const deadLetterColumns = `id, org_id, original_topic, payload, error, status, retry_count, max_retries,
+	metadata, first_failed_at, last_failed_at, next_attempt_at`
+
+func (d deadLetterStore) scan(ctx context.Context, row rowScanner) (*resilience.DeadLetterMessage, error) {
+	var msg resilience.DeadLetterMessage
+	var payload, status string
+	if err := row.Scan(&msg.ID, &msg.OrgID, &msg.OriginalTopic, &payload, &msg.Error, &status,
+		&msg.RetryCount, &msg.MaxRetries, &msg.Metadata, &msg.FirstFailed, &msg.LastFailed,
+		&msg.NextAttempt); err != nil {
+		return nil, translateError(err)
+	}
+	msg.Status = resilience.DeadLetterStatus(status)
+	// Payloads of organizations whose key was revoked are gone for good;
+	// replaying such a message fails until it is parked
+	plaintext, err := d.s.open(ctx, msg.OrgID, payload)
+	if err != nil && !errors.Is(err, security.ErrKEKRevoked) {
+		return nil, fmt.Errorf("failed to decrypt dead-letter payload: %w", err)
+	}
+	if err == nil {
+		msg.Payload = plaintext
+	}
+	return &msg, nil
+}
+
+func (d deadLetterStore) Save(ctx context.Context, msg *resilience.DeadLetterMessage) error {
+	sealed, err := d.s.seal(ctx, msg.OrgID, msg.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt dead-letter payload: %w", err)
+	}
+	metadata := msg.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	_, err = d.s.pool.Exec(ctx,
+		`INSERT INTO dead_letters (id, org_id, original_topic, payload, error, status, retry_count, max_retries,
+			metadata, first_failed_at, last_failed_at, next_attempt_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		 ON CONFLICT (id) DO UPDATE SET
+			payload = EXCLUDED.payload,
+			error = EXCLUDED.error,
+			status = EXCLUDED.status,
+			retry_count = EXCLUDED.retry_count,
+			max_retries = EXCLUDED.max_retries,
+			metadata = EXCLUDED.metadata,
+			last_failed_at = EXCLUDED.last_failed_at,
+			next_attempt_at = EXCLUDED.next_attempt_at`,
+		msg.ID, msg.OrgID, msg.OriginalTopic, sealed, msg.Error, string(msg.Status), msg.RetryCount,
+		msg.MaxRetries, metadataJSON, msg.FirstFailed, msg.LastFailed, msg.NextAttempt,
+	)
+	return translateError(err)
+}
+
+func (d deadLetterStore) Get(ctx context.Context, id string) (*resilience.DeadLetterMessage, error) {
+	row := d.s.pool.QueryRow(ctx, `SELECT `+deadLetterColumns+` FROM dead_letters WHERE id = $1`, id)
+	msg, err := d.scan(ctx, row)
+	if errors.Is(err, ErrNotFound) {
+		return nil, resilience.ErrDeadLetterNotFound
+	}
+	return msg, err
+}
+
+func (d deadLetterStore) List(ctx context.Context, f resilience.DeadLetterFilter) ([]*resilience.DeadLetterMessage, error) {
+	var limit *int
+	if f.Limit > 0 {
+		limit = &f.Limit
+	}
+	var afterFailed *time.Time
+	var afterID *string
+	if f.After != nil {
+		afterFailed, afterID = &f.After.LastFailed, &f.After.ID
+	}
+	return d.query(ctx,
+		`SELECT `+deadLetterColumns+` FROM dead_letters
+		 WHERE ($1 = '' OR org_id::text = $1)
+		   AND ($2 = '' OR original_topic = $2)
+		   AND ($3 = '' OR status = $3)
+		   AND ($5::timestamptz IS NULL OR last_failed_at < $5
+		        OR (last_failed_at = $5 AND id > $6::uuid))
+		 ORDER BY last_failed_at DESC, id
+		 LIMIT $4`,
+		f.OrgID, f.Topic, string(f.Status), limit, afterFailed, afterID,
+	)
+}
+
+// Claim locks the due rows it picks, so replicas claiming at the same time
+// split them between themselves instead of waiting on each other
+func (d deadLetterStore) Claim(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*resilience.DeadLetterMessage, error) {
+	return d.query(ctx,
+		`WITH due AS (
+			SELECT id AS due_id FROM dead_letters
+			WHERE status = 'pending' AND next_attempt_at <= $1
+			ORDER BY next_attempt_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		 )
+		 UPDATE dead_letters d SET next_attempt_at = $2
+		 FROM due WHERE d.id = due.due_id
+		 RETURNING `+deadLetterColumns,
+		now, now.Add(lease), limit,
+	)
+}
+
+func (d deadLetterStore) Delete(ctx context.Context, id string) error {
+	_, err := d.s.pool.Exec(ctx, `DELETE FROM dead_letters WHERE id = $1`, id)
+	if errors.Is(translateError(err), ErrNotFound) {
+		// A malformed ID names no message
+		return nil
+	}
+	return translateError(err)
+}
+
+func (d deadLetterStore) query(ctx context.Context, sql string, args ...interface{}) ([]*resilience.DeadLetterMessage, error) {
+	rows, err := d.s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	msgs := []*resilience.DeadLetterMessage{}
+	for rows.Next() {
+		msg, err := d.scan(ctx, rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, translateError(rows.Err())
+}
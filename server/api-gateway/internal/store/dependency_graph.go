@@ -0,0 +1,143 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/archlens/api-gateway/internal/depgraph"
+	"github.com/archlens/api-gateway/internal/rules"
+	"github.com/jackc/pgx/v5"
+)
+
+// commitPrefixPattern accepts full commit SHAs and abbreviations long enough
+// to be unambiguous in practice
+var commitPrefixPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+// LoadDependencyGraph returns the file-level dependency graph recorded by the
+// parse stage for the latest analysis of a commit. commitSHA may be
+// abbreviated to at least 7 hex characters. It returns rules.ErrNoGraph if
+// the commit was never parsed.
+func (s *Store) LoadDependencyGraph(ctx context.Context, repoID, commitSHA string) (*rules.Graph, error) {
+	if !commitPrefixPattern.MatchString(commitSHA) {
+		return nil, &ValidationError{Field: "commit_sha", Message: "must be 7 to 40 hexadecimal characters"}
+	}
+	var analysisID string
+	err := s.pool.QueryRow(ctx,
+		`SELECT id FROM analysis_results
+		 WHERE repo_id = $1 AND starts_with(lower(commit_sha), $2)
+		 ORDER BY created_at DESC
+		 LIMIT 1`,
+		repoID, strings.ToLower(commitSHA),
+	).Scan(&analysisID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, rules.ErrNoGraph
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	rows, err := s.pool.Query(ctx, `SELECT path FROM code_files WHERE repo_id = $1 ORDER BY path`, repoID)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	files, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	rows, err = s.pool.Query(ctx,
+		`SELECT src.path, dst.path, e.dep_type, COALESCE((e.metadata->>'line')::int, 0)
+		 FROM dependency_edges e
+		 JOIN code_files src ON src.id = e.source_file_id
+		 JOIN code_files dst ON dst.id = e.target_file_id
+		 WHERE e.analysis_id = $1`,
+		analysisID,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	edges, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (rules.Edge, error) {
+		var e rules.Edge
+		err := row.Scan(&e.Source, &e.Target, &e.Type, &e.Line)
+		return e, err
+	})
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return &rules.Graph{Files: files, Edges: edges}, nil
+}
+
+// GraphFilter restricts the dependencies loaded for an analysis
+type GraphFilter struct {
+	// PathPrefix keeps files under a path and dependencies between them
+	PathPrefix string
+	// DepTypes keeps only these dependency types; empty keeps all
+	DepTypes []string
+}
+
+// GetAnalysisRepoID returns the repository of an analysis if it belongs to
+// the given organization
+func (s *Store) GetAnalysisRepoID(ctx context.Context, orgID, analysisID string) (string, error) {
+	var repoID string
+	err := s.pool.QueryRow(ctx,
+		`SELECT a.repo_id FROM analysis_results a
+		 JOIN repositories r ON r.id = a.repo_id
+		 WHERE a.id = $1 AND r.org_id = $2`,
+		analysisID, orgID,
+	).Scan(&repoID)
+	return repoID, translateError(err)
+}
+
+// LoadAnalysisGraph returns the files and file-level dependencies of an
+// analysis, filtered in the database so large graphs are not loaded whole
+func (s *Store) LoadAnalysisGraph(ctx context.Context, repoID, analysisID string, f GraphFilter) ([]depgraph.File, []depgraph.FileEdge, error) {
+	if f.DepTypes == nil {
+		f.DepTypes = []string{}
+	}
+
+	rows, err := s.pool.Query(ctx,
+		`SELECT path, COALESCE(metadata->>'package', '')
+		 FROM code_files
+		 WHERE repo_id = $1 AND starts_with(path, $2)
+		 ORDER BY path`,
+		repoID, f.PathPrefix,
+	)
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+	files, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (depgraph.File, error) {
+		var file depgraph.File
+		err := row.Scan(&file.Path, &file.Package)
+		return file, err
+	})
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+
+	rows, err = s.pool.Query(ctx,
+		`SELECT src.path, dst.path, e.dep_type, e.weight
+		 FROM dependency_edges e
+		 JOIN code_files src ON src.id = e.source_file_id
+		 JOIN code_files dst ON dst.id = e.target_file_id
+		 WHERE e.analysis_id = $1
+		   AND starts_with(src.path, $2) AND starts_with(dst.path, $2)
+		   AND (cardinality($3::text[]) = 0 OR e.dep_type = ANY($3))`,
+		analysisID, f.PathPrefix, f.DepTypes,
+	)
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+	edges, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (depgraph.FileEdge, error) {
+		var e depgraph.FileEdge
+		var weight float32
+		err := row.Scan(&e.Source, &e.Target, &e.Type, &weight)
+		e.Weight = float64(weight)
+		return e, err
+	})
+	if err != nil {
+		return nil, nil, translateError(err)
+	}
+	return files, edges, nil
+}
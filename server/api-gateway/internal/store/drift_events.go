@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/archlens/api-gateway/internal/rules"
+	"github.com/jackc/pgx/v5"
+)
+
+// DriftEvent is a deviation of a repository from its architectural rules
+type DriftEvent struct {
+	ID           string          `json:"id"`
+	RepoID       string          `json:"repo_id"`
+	RuleID       *string         `json:"rule_id,omitempty"`
+	Severity     string          `json:"severity"`
+	Category     string          `json:"category"`
+	Title        string          `json:"title"`
+	Description  *string         `json:"description,omitempty"`
+	FilePath     *string         `json:"file_path,omitempty"`
+	LineNumber   *int            `json:"line_number,omitempty"`
+	SuggestedFix json.RawMessage `json:"suggested_fix,omitempty"`
+	Status       string          `json:"status"`
+	ResolvedBy   *string         `json:"resolved_by,omitempty"`
+	ResolvedAt   *time.Time      `json:"resolved_at,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// DriftEventListing declares how drift event lists are queried, e.g.
+// ?severity=error,critical&status=open
+var DriftEventListing = listing.Spec{
+	Sorts: map[string]listing.Sort{
+		"created_at": {Column: "created_at", Type: listing.Time},
+	},
+	DefaultSort: "-created_at",
+	Filters: map[string]listing.Filter{
+		"severity":       {Column: "severity", Type: listing.Text, Values: []string{"info", "warning", "error", "critical"}},
+		"status":         {Column: "status", Type: listing.Text, Values: []string{"open", "acknowledged", "resolved", "ignored"}},
+		"category":       {Column: "category", Type: listing.Text, Values: []string{"dependency", "security", "performance", "convention"}},
+		"rule_id":        {Column: "rule_id", Type: listing.UUID},
+		"file_path":      {Column: "file_path", Type: listing.Text},
+		"created_after":  {Column: "created_at", Type: listing.Time, Op: listing.GTE},
+		"created_before": {Column: "created_at", Type: listing.Time, Op: listing.LT},
+	},
+}
+
+const driftEventColumns = `id, repo_id, rule_id, severity, category, title, description, file_path, line_number,
+	suggested_fix, status, resolved_by, resolved_at, created_at`
+
+func scanDriftEvent(row rowScanner) (*DriftEvent, error) {
+	var e DriftEvent
+	if err := row.Scan(&e.ID, &e.RepoID, &e.RuleID, &e.Severity, &e.Category, &e.Title, &e.Description,
+		&e.FilePath, &e.LineNumber, &e.SuggestedFix, &e.Status, &e.ResolvedBy, &e.ResolvedAt, &e.CreatedAt); err != nil {
+		return nil, translateError(err)
+	}
+	return &e, nil
+}
+
+// ListDriftEvents returns a page of the drift events of a repository
+func (s *Store) ListDriftEvents(ctx context.Context, orgID, repoID string, q listing.Query) (*listing.Page[*DriftEvent], error) {
+	return listPage(ctx, s, q, driftEventColumns, "drift_events",
+		"repo_id = $2 AND repo_id IN (SELECT id FROM repositories WHERE org_id = $1)",
+		[]interface{}{orgID, repoID}, scanDriftEvent)
+}
+
+// RecordViolations stores rule violations as open drift events of a
+// repository and returns the IDs of the new ones. A violation that is already
+// open (same rule, file, line and title) is not recorded again.
+func (s *Store) RecordViolations(ctx context.Context, repoID string, violations []rules.Violation) ([]string, error) {
+	if len(violations) == 0 {
+		return nil, nil
+	}
+
+	batch := &pgx.Batch{}
+	for _, v := range violations {
+		var line *int
+		if v.Line > 0 {
+			line = &v.Line
+		}
+		batch.Queue(
+			`INSERT INTO drift_events (repo_id, rule_id, severity, category, title, description, file_path, line_number)
+			 SELECT $1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8
+			 WHERE NOT EXISTS (
+			     SELECT 1 FROM drift_events
+			     WHERE repo_id = $1 AND rule_id = $2 AND status = 'open'
+			       AND title = $5 AND file_path = $7 AND line_number IS NOT DISTINCT FROM $8
+			 )
+			 RETURNING id`,
+			repoID, v.RuleID, v.Severity, v.Category, v.Title, v.Description, v.FilePath, line,
+		)
+	}
+
+	results := s.pool.SendBatch(ctx, batch)
+	defer results.Close()
+	var inserted []string
+	for range violations {
+		var id string
+		err := results.QueryRow().Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return inserted, translateError(err)
+		}
+		inserted = append(inserted, id)
+	}
+	return inserted, nil
+}
@@ -0,0 +1,205 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/archlens/api-gateway/internal/security"
+)
+
+// encryptedField describes a column holding security.Envelope
+// ciphertexts. from must alias the table as t and join whatever is needed
+// to select the owning organization in org.
+type encryptedField struct {
+	table  string
+	column string
+	from   string
+	org    string
+}
+
+// encryptedFields lists every encrypted column by name. The re-encryption job
+// walks all of them, so a column must be registered here when it starts
+// holding ciphertexts. Values without a ciphertext header are either legacy
+// ciphertexts from before key versioning or plaintext written before the
+// column was encrypted; plaintext is read as it is until encrypt-rows
+// migrates it.
+//
+// The gateway only reads fix and phantom patches and analysis results: the
+// services writing them must seal them with the organization's Envelope.
+var encryptedFields = map[string]encryptedField{
+	"dead_letters.payload": {
+		table:  "dead_letters",
+		column: "payload",
+		from:   "dead_letters t",
+		org:    "t.org_id",
+	},
+	"synthetic_fixes.patch": {
+		table:  "synthetic_fixes",
+		column: "patch",
+		from:   "synthetic_fixes t JOIN repositories r ON r.id = t.repo_id",
+		org:    "r.org_id",
+	},
+	"phantom_executions.diff_patch": {
+		table:  "phantom_executions",
+		column: "diff_patch",
+		from:   "phantom_executions t JOIN repositories r ON r.id = t.repo_id",
+		org:    "r.org_id",
+	},
+	"analysis_results.summary": {
+		table:  "analysis_results",
+		column: "summary",
+		from:   "analysis_results t JOIN repositories r ON r.id = t.repo_id",
+		org:    "r.org_id",
+	},
+	"analysis_results.violations": {
+		table:  "analysis_results",
+		column: "violations",
+		from:   "analysis_results t JOIN repositories r ON r.id = t.repo_id",
+		org:    "r.org_id",
+	},
+	"pipeline_stage_results.output": {
+		table:  "pipeline_stage_results",
+		column: "output",
+		from:   "pipeline_stage_results t JOIN pipeline_runs r ON r.id = t.run_id",
+		org:    "r.org_id",
+	},
+}
+
+// isCiphertextSQL matches values carrying a ciphertext header
+const isCiphertextSQL = `(starts_with(t.%[1]s, 'enc:') OR starts_with(t.%[1]s, 'env:'))`
+
+var errEncryptionDisabled = errors.New("encryption is not configured")
+
+// seal encrypts a value of an encrypted field for an organization
+func (s *Store) seal(ctx context.Context, orgID string, plaintext []byte) (string, error) {
+	if s.enc == nil {
+		return "", errEncryptionDisabled
+	}
+	return s.enc.Encrypt(ctx, orgID, plaintext)
+}
+
+// open decrypts a value of an encrypted field; plaintext left from before
+// the field was encrypted is returned unchanged
+func (s *Store) open(ctx context.Context, orgID, value string) ([]byte, error) {
+	if s.enc == nil {
+		if security.IsCiphertext(value) {
+			return nil, errEncryptionDisabled
+		}
+		return []byte(value), nil
+	}
+	if !security.IsCiphertext(value) && !s.enc.IsLegacyCiphertext(orgID, value) {
+		return []byte(value), nil
+	}
+	return s.enc.Decrypt(ctx, orgID, value)
+}
+
+// EncryptedFields implements security.CiphertextStore
+func (s *Store) EncryptedFields() []string {
+	names := make([]string, 0, len(encryptedFields))
+	for name := range encryptedFields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupEncryptedField(name string) (encryptedField, error) {
+	f, ok := encryptedFields[name]
+	if !ok {
+		return f, fmt.Errorf("unknown encrypted field %q", name)
+	}
+	return f, nil
+}
+
+// StaleCiphertexts implements security.CiphertextStore
+func (s *Store) StaleCiphertexts(ctx context.Context, field, orgID string, keep []string, afterID string, limit int) ([]security.EncryptedValue, error) {
+	f, err := lookupEncryptedField(field)
+	if err != nil {
+		return nil, err
+	}
+	return s.encryptedValues(ctx, f,
+		fmt.Sprintf(isCiphertextSQL, f.column)+`
+		   AND NOT EXISTS (SELECT 1 FROM unnest($3::text[]) p WHERE starts_with(t.`+f.column+`, p))
+		   AND ($4 = '' OR `+f.org+`::text = $4)`,
+		afterID, limit, keep, orgID,
+	)
+}
+
+// HeaderlessValues implements security.CiphertextStore
+func (s *Store) HeaderlessValues(ctx context.Context, field, afterID string, limit int) ([]security.EncryptedValue, error) {
+	f, err := lookupEncryptedField(field)
+	if err != nil {
+		return nil, err
+	}
+	return s.encryptedValues(ctx, f, `NOT `+fmt.Sprintf(isCiphertextSQL, f.column), afterID, limit)
+}
+
+// CountUnenveloped returns how many of an organization's encrypted values
+// are not envelopes: platform-key ciphertexts and plaintext, which
+// destroying its KEKs would not shred. It implements
+// security.KEKRevocationStore.
+func (s *Store) CountUnenveloped(ctx context.Context, orgID string) (int64, error) {
+	var total int64
+	for _, name := range s.EncryptedFields() {
+		f := encryptedFields[name]
+		var n int64
+		err := s.pool.QueryRow(ctx,
+			`SELECT count(*) FROM `+f.from+`
+			 WHERE `+f.org+` = $1 AND t.`+f.column+` IS NOT NULL AND NOT starts_with(t.`+f.column+`, 'env:')`,
+			orgID,
+		).Scan(&n)
+		if err != nil {
+			return 0, translateError(err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// encryptedValues pages through the non-null values of a field matching
+// cond, which may use parameters from $3 on
+func (s *Store) encryptedValues(ctx context.Context, f encryptedField, cond, afterID string, limit int, args ...interface{}) ([]security.EncryptedValue, error) {
+	if afterID == "" {
+		afterID = "00000000-0000-0000-0000-000000000000"
+	}
+	rows, err := s.pool.Query(ctx,
+		`SELECT t.id::text, `+f.org+`::text, t.`+f.column+`
+		 FROM `+f.from+`
+		 WHERE t.`+f.column+` IS NOT NULL AND t.id > $1::uuid AND `+cond+`
+		 ORDER BY t.id
+		 LIMIT $2`,
+		append([]interface{}{afterID, limit}, args...)...,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	values := []security.EncryptedValue{}
+	for rows.Next() {
+		var v security.EncryptedValue
+		if err := rows.Scan(&v.ID, &v.OrgID, &v.Value); err != nil {
+			return nil, translateError(err)
+		}
+		values = append(values, v)
+	}
+	return values, translateError(rows.Err())
+}
+
+// ReplaceCiphertext implements security.CiphertextStore
+func (s *Store) ReplaceCiphertext(ctx context.Context, field, id, old, replacement string) (bool, error) {
+	f, err := lookupEncryptedField(field)
+	if err != nil {
+		return false, err
+	}
+	tag, err := s.pool.Exec(ctx,
+		`UPDATE `+f.table+` SET `+f.column+` = $3 WHERE id = $1 AND `+f.column+` = $2`,
+		id, old, replacement,
+	)
+	if err != nil {
+		return false, translateError(err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
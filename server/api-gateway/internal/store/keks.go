@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+
+	"github.com/archlens/api-gateway/internal/security"
+)
+
+const kekColumns = `id, org_id, provider, key_ref, status, created_by, created_at, retired_at, revoked_at`
+
+func scanKEK(row rowScanner) (*security.KEK, error) {
+	var k security.KEK
+	if err := row.Scan(&k.ID, &k.OrgID, &k.Provider, &k.KeyRef, &k.Status, &k.CreatedBy,
+		&k.CreatedAt, &k.RetiredAt, &k.RevokedAt); err != nil {
+		return nil, translateError(err)
+	}
+	return &k, nil
+}
+
+// ListKEKs returns all key-encryption keys an organization has registered,
+// newest first. It implements security.KEKStore.
+func (s *Store) ListKEKs(ctx context.Context, orgID string) ([]security.KEK, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT `+kekColumns+` FROM tenant_keks WHERE org_id = $1 ORDER BY created_at DESC`,
+		orgID,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	keks := []security.KEK{}
+	for rows.Next() {
+		k, err := scanKEK(rows)
+		if err != nil {
+			return nil, err
+		}
+		keks = append(keks, *k)
+	}
+	return keks, translateError(rows.Err())
+}
+
+// ActiveKEKOrgs implements security.KEKStore
+func (s *Store) ActiveKEKOrgs(ctx context.Context) ([]string, error) {
+	rows, err := s.pool.Query(ctx, `SELECT org_id::text FROM tenant_keks WHERE status = 'active' ORDER BY org_id`)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	orgs := []string{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, translateError(err)
+		}
+		orgs = append(orgs, id)
+	}
+	return orgs, translateError(rows.Err())
+}
+
+// CreateKEK registers a KMS key as an organization's active KEK. Without
+// rotate it fails with ErrConflict if the organization already has one; with
+// rotate the current active KEK is retired and it fails with ErrNotFound if
+// there is none. createdBy is resolved like the creator of a rule.
+func (s *Store) CreateKEK(ctx context.Context, orgID, createdBy, provider, keyRef string, rotate bool) (*security.KEK, error) {
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if rotate {
+		tag, err := tx.Exec(ctx,
+			`UPDATE tenant_keks SET status = 'retired', retired_at = NOW()
+			 WHERE org_id = $1 AND status = 'active'`,
+			orgID,
+		)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		if tag.RowsAffected() == 0 {
+			return nil, ErrNotFound
+		}
+	}
+	row := tx.QueryRow(ctx,
+		`INSERT INTO tenant_keks (org_id, provider, key_ref, created_by)
+		 VALUES ($1, $2, $3,
+		         (SELECT id FROM users WHERE org_id = $1 AND (id::text = $4 OR external_id = $4) LIMIT 1))
+		 RETURNING `+kekColumns,
+		orgID, provider, keyRef, createdBy,
+	)
+	k, err := scanKEK(row)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, translateError(err)
+	}
+	return k, nil
+}
+
+// MarkKEKsRevoked records that the material of an organization's KEKs was
+// destroyed. Marking a KEK twice keeps the original revocation time. It
+// implements security.KEKRevocationStore.
+func (s *Store) MarkKEKsRevoked(ctx context.Context, orgID string, ids []string) ([]security.KEK, error) {
+	rows, err := s.pool.Query(ctx,
+		`UPDATE tenant_keks
+		 SET status = 'revoked', revoked_at = COALESCE(revoked_at, NOW())
+		 WHERE org_id = $1 AND id = ANY($2::uuid[])
+		 RETURNING `+kekColumns,
+		orgID, ids,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	keks := []security.KEK{}
+	for rows.Next() {
+		k, err := scanKEK(rows)
+		if err != nil {
+			return nil, err
+		}
+		keks = append(keks, *k)
+	}
+	return keks, translateError(rows.Err())
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/jackc/pgx/v5"
+)
+
+// keyedRow reads the Key columns appended by listing.Query.SQL after the
+// columns a scan function knows about
+type keyedRow struct {
+	row rowScanner
+	key *listing.Key
+}
+
+func (r keyedRow) Scan(dest ...interface{}) error {
+	return r.row.Scan(append(dest, &r.key.Sort, &r.key.ID)...)
+}
+
+// listPage runs a list query built by q and scans one page of results
+func listPage[T any](ctx context.Context, s *Store, q listing.Query, columns, from, where string, args []interface{},
+	scan func(rowScanner) (T, error)) (*listing.Page[T], error) {
+	sql, args := q.SQL(columns, from, where, args)
+	rows, err := s.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return collectPage(rows, q, scan)
+}
+
+func collectPage[T any](rows pgx.Rows, q listing.Query, scan func(rowScanner) (T, error)) (*listing.Page[T], error) {
+	defer rows.Close()
+	items := []T{}
+	keys := []listing.Key{}
+	for rows.Next() {
+		var key listing.Key
+		item, err := scan(keyedRow{row: rows, key: &key})
+		if err != nil {
+			return nil, translateError(err)
+		}
+		items = append(items, item)
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+	return listing.NewPage(q, items, keys), nil
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Organization is a tenant of the platform
+type Organization struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Slug      string          `json:"slug"`
+	Plan      string          `json:"plan"`
+	Settings  json.RawMessage `json:"settings"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// CreateOrganizationInput holds the fields accepted when creating an organization
+type CreateOrganizationInput struct {
+	Name     string          `json:"name"`
+	Slug     string          `json:"slug"`
+	Plan     string          `json:"plan"`
+	Settings json.RawMessage `json:"settings"`
+}
+
+var (
+	slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+	validPlans  = map[string]bool{"starter": true, "pro": true, "team": true, "enterprise": true}
+)
+
+// Validate normalizes the input and checks it against the schema constraints
+func (in *CreateOrganizationInput) Validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	in.Slug = strings.ToLower(strings.TrimSpace(in.Slug))
+	if in.Plan == "" {
+		in.Plan = "starter"
+	}
+	if len(in.Settings) == 0 {
+		in.Settings = json.RawMessage(`{}`)
+	}
+
+	if in.Name == "" {
+		return &ValidationError{Field: "name", Message: "is required"}
+	}
+	if len(in.Name) > 200 {
+		return &ValidationError{Field: "name", Message: "must be at most 200 characters"}
+	}
+	if !slugPattern.MatchString(in.Slug) {
+		return &ValidationError{Field: "slug", Message: "must be 2-63 lowercase letters, digits or hyphens"}
+	}
+	if !validPlans[in.Plan] {
+		return &ValidationError{Field: "plan", Message: "must be one of starter, pro, team, enterprise"}
+	}
+	if !isJSONObject(in.Settings) {
+		return &ValidationError{Field: "settings", Message: "must be a JSON object"}
+	}
+	return nil
+}
+
+const organizationColumns = `id, name, slug, plan, settings, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanOrganization(row rowScanner) (*Organization, error) {
+	var o Organization
+	if err := row.Scan(&o.ID, &o.Name, &o.Slug, &o.Plan, &o.Settings, &o.CreatedAt, &o.UpdatedAt); err != nil {
+		return nil, translateError(err)
+	}
+	return &o, nil
+}
+
+// ListOrganizations returns the organizations visible to the caller's org
+func (s *Store) ListOrganizations(ctx context.Context, callerOrgID string) ([]*Organization, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT `+organizationColumns+` FROM organizations WHERE id = $1 ORDER BY created_at`,
+		callerOrgID,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	orgs := []*Organization{}
+	for rows.Next() {
+		o, err := scanOrganization(rows)
+		if err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, o)
+	}
+	return orgs, translateError(rows.Err())
+}
+
+// GetOrganization returns an organization if it belongs to the caller
+func (s *Store) GetOrganization(ctx context.Context, callerOrgID, id string) (*Organization, error) {
+	if id != callerOrgID {
+		return nil, ErrNotFound
+	}
+	row := s.pool.QueryRow(ctx,
+		`SELECT `+organizationColumns+` FROM organizations WHERE id = $1`,
+		id,
+	)
+	return scanOrganization(row)
+}
+
+// CreateOrganization inserts a new organization; slugs are globally unique
+func (s *Store) CreateOrganization(ctx context.Context, in CreateOrganizationInput) (*Organization, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	row := s.pool.QueryRow(ctx,
+		`INSERT INTO organizations (name, slug, plan, settings)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING `+organizationColumns,
+		in.Name, in.Slug, in.Plan, in.Settings,
+	)
+	return scanOrganization(row)
+}
+
+// OrganizationPlan returns an organization's plan for rate limiting. It does
+// not check the caller, so it must only be given the caller's own org ID.
+func (s *Store) OrganizationPlan(ctx context.Context, orgID string) (string, error) {
+	var plan string
+	err := s.pool.QueryRow(ctx, `SELECT plan FROM organizations WHERE id = $1`, orgID).Scan(&plan)
+	return plan, translateError(err)
+}
@@ -0,0 +1,40 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/archlens/api-gateway/internal/security"
+)
+
+// ownerQueries resolve the owning organization of each resource type that
+// routes address by ID alone
+var ownerQueries = map[security.ResourceType]string{
+	security.ResourceRepository: `SELECT org_id FROM repositories WHERE id = $1`,
+	security.ResourceAnalysis: `SELECT r.org_id FROM analysis_results a
+		JOIN repositories r ON r.id = a.repo_id WHERE a.id = $1`,
+	security.ResourceDrift: `SELECT r.org_id FROM drift_events d
+		JOIN repositories r ON r.id = d.repo_id WHERE d.id = $1`,
+	security.ResourceRule: `SELECT org_id FROM architectural_rules WHERE id = $1`,
+	security.ResourcePhantom: `SELECT r.org_id FROM phantom_executions p
+		JOIN repositories r ON r.id = p.repo_id WHERE p.id = $1`,
+	security.ResourceFix: `SELECT r.org_id FROM synthetic_fixes f
+		JOIN repositories r ON r.id = f.repo_id WHERE f.id = $1`,
+	security.ResourcePipelineRun: `SELECT org_id FROM pipeline_runs WHERE id = $1`,
+}
+
+// ResourceOrg implements security.OwnerLookup. Missing resources and
+// malformed IDs yield an empty org ID.
+func (s *Store) ResourceOrg(ctx context.Context, resource security.ResourceType, id string) (string, error) {
+	query, ok := ownerQueries[resource]
+	if !ok {
+		return "", fmt.Errorf("no owner lookup for resource type %q", resource)
+	}
+	var orgID string
+	err := translateError(s.pool.QueryRow(ctx, query, id).Scan(&orgID))
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	return orgID, err
+}
@@ -0,0 +1,280 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/archlens/api-gateway/internal/pipeline"
+	"github.com/archlens/api-gateway/internal/security"
+	"github.com/jackc/pgx/v5"
+)
+
+// Store implements pipeline.RunStore
+var _ pipeline.RunStore = (*Store)(nil)
+
+const pipelineRunColumns = `id, repo_id, org_id, commit_sha, branch, status, metadata,
+	total_duration_ms, resume_count, disabled_stages, cancel_requested_at IS NOT NULL, created_at, completed_at`
+
+func scanPipelineRun(row rowScanner) (*pipeline.PipelineRun, error) {
+	var r pipeline.PipelineRun
+	var status string
+	var disabled []string
+	if err := row.Scan(&r.ID, &r.RepoID, &r.OrgID, &r.CommitSHA, &r.Branch, &status, &r.Metadata,
+		&r.TotalDuration, &r.ResumeCount, &disabled, &r.CancelRequested, &r.CreatedAt, &r.CompletedAt); err != nil {
+		return nil, err
+	}
+	r.Status = pipeline.PipelineStatus(status)
+	r.Stages = []pipeline.StageResult{}
+	for _, s := range disabled {
+		r.DisabledStages = append(r.DisabledStages, pipeline.Stage(s))
+	}
+	return &r, nil
+}
+
+// SavePipelineRun upserts a run row; stage results are written separately
+func (s *Store) SavePipelineRun(ctx context.Context, run *pipeline.PipelineRun) error {
+	metadata := run.Metadata
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	disabled := make([]string, 0, len(run.DisabledStages))
+	for _, s := range run.DisabledStages {
+		disabled = append(disabled, string(s))
+	}
+	_, err := s.pool.Exec(ctx,
+		`INSERT INTO pipeline_runs (id, repo_id, org_id, commit_sha, branch, status, metadata,
+			total_duration_ms, resume_count, disabled_stages, created_at, completed_at)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		 ON CONFLICT (id) DO UPDATE SET
+			status = EXCLUDED.status,
+			metadata = EXCLUDED.metadata,
+			total_duration_ms = EXCLUDED.total_duration_ms,
+			completed_at = EXCLUDED.completed_at,
+			heartbeat_at = NOW()`,
+		run.ID, run.RepoID, run.OrgID, run.CommitSHA, run.Branch, string(run.Status), metadata,
+		run.TotalDuration, run.ResumeCount, disabled, run.CreatedAt, run.CompletedAt,
+	)
+	return translateError(err)
+}
+
+// SavePipelineStage upserts the result of one stage of a run. The output
+// holds AST snapshots and AI analysis payloads and is encrypted.
+func (s *Store) SavePipelineStage(ctx context.Context, orgID, runID string, result pipeline.StageResult) error {
+	var output *string
+	if result.Output != nil {
+		encoded, err := json.Marshal(result.Output)
+		if err != nil {
+			return fmt.Errorf("failed to encode stage output: %w", err)
+		}
+		sealed, err := s.seal(ctx, orgID, encoded)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt stage output: %w", err)
+		}
+		output = &sealed
+	}
+	_, err := s.pool.Exec(ctx,
+		`INSERT INTO pipeline_stage_results (run_id, stage, status, started_at, ended_at, duration_ms, output, error, attempts)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), $9)
+		 ON CONFLICT (run_id, stage) DO UPDATE SET
+			status = EXCLUDED.status,
+			started_at = EXCLUDED.started_at,
+			ended_at = EXCLUDED.ended_at,
+			duration_ms = EXCLUDED.duration_ms,
+			output = EXCLUDED.output,
+			error = EXCLUDED.error,
+			attempts = EXCLUDED.attempts`,
+		runID, string(result.Stage), string(result.Status), result.StartedAt, result.EndedAt,
+		result.Duration, output, result.Error, result.Attempts,
+	)
+	return translateError(err)
+}
+
+// GetPipelineRun loads a run together with its recorded stage results
+func (s *Store) GetPipelineRun(ctx context.Context, id string) (*pipeline.PipelineRun, error) {
+	row := s.pool.QueryRow(ctx, `SELECT `+pipelineRunColumns+` FROM pipeline_runs WHERE id = $1`, id)
+	run, err := scanPipelineRun(row)
+	if err != nil {
+		if errors.Is(translateError(err), ErrNotFound) {
+			return nil, pipeline.ErrRunNotFound
+		}
+		return nil, err
+	}
+	if err := s.loadPipelineStages(ctx, []*pipeline.PipelineRun{run}); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// PipelineRunListing declares how pipeline run lists are queried
+var PipelineRunListing = listing.Spec{
+	Sorts: map[string]listing.Sort{
+		"created_at": {Column: "created_at", Type: listing.Time},
+	},
+	DefaultSort: "-created_at",
+	Filters: map[string]listing.Filter{
+		"status":     {Column: "status", Type: listing.Text, Values: []string{"pending", "running", "completed", "failed", "cancelled"}},
+		"branch":     {Column: "branch", Type: listing.Text},
+		"commit_sha": {Column: "commit_sha", Type: listing.Text},
+	},
+}
+
+// ListPipelineRuns returns a page of the runs of a repository
+func (s *Store) ListPipelineRuns(ctx context.Context, orgID, repoID string, q listing.Query) (*listing.Page[*pipeline.PipelineRun], error) {
+	page, err := listPage(ctx, s, q, pipelineRunColumns, "pipeline_runs", "org_id = $1 AND repo_id = $2",
+		[]interface{}{orgID, repoID}, scanPipelineRun)
+	if err != nil {
+		return nil, err
+	}
+	return page, s.loadPipelineStages(ctx, page.Data)
+}
+
+// TouchPipelineRuns refreshes the heartbeat of unfinished runs and reports
+// which of them have a pending cancellation request
+func (s *Store) TouchPipelineRuns(ctx context.Context, ids []string) (map[string]string, error) {
+	rows, err := s.pool.Query(ctx,
+		`UPDATE pipeline_runs SET heartbeat_at = NOW()
+		 WHERE id = ANY($1::uuid[]) AND status IN ('pending', 'running')
+		 RETURNING id, cancel_requested_at IS NOT NULL, COALESCE(metadata->>'cancel_reason', '')`,
+		ids,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	cancelled := map[string]string{}
+	for rows.Next() {
+		var id, reason string
+		var requested bool
+		if err := rows.Scan(&id, &requested, &reason); err != nil {
+			return nil, translateError(err)
+		}
+		if requested {
+			cancelled[id] = reason
+		}
+	}
+	return cancelled, translateError(rows.Err())
+}
+
+// RequestPipelineCancel flags unfinished runs for cancellation by the replica executing them
+func (s *Store) RequestPipelineCancel(ctx context.Context, ids []string, reason string) error {
+	_, err := s.pool.Exec(ctx,
+		`UPDATE pipeline_runs
+		 SET cancel_requested_at = NOW(),
+			metadata = metadata || jsonb_build_object('cancel_reason', $2::text)
+		 WHERE id = ANY($1::uuid[]) AND status IN ('pending', 'running') AND cancel_requested_at IS NULL`,
+		ids, reason,
+	)
+	return translateError(err)
+}
+
+// ListActivePipelineRunIDs returns the unfinished runs for a repository branch
+func (s *Store) ListActivePipelineRunIDs(ctx context.Context, repoID, branch string) ([]string, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT id FROM pipeline_runs
+		 WHERE repo_id = $1 AND branch = $2 AND status IN ('pending', 'running')`,
+		repoID, branch,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+	return ids, translateError(err)
+}
+
+// ClaimStalePipelineRuns takes over unfinished runs whose heartbeat predates
+// the cutoff. SKIP LOCKED keeps concurrent replicas from claiming the same run.
+func (s *Store) ClaimStalePipelineRuns(ctx context.Context, cutoff time.Time) ([]*pipeline.PipelineRun, error) {
+	rows, err := s.pool.Query(ctx,
+		`UPDATE pipeline_runs SET heartbeat_at = NOW(), resume_count = resume_count + 1
+		 WHERE id IN (
+			SELECT id FROM pipeline_runs
+			WHERE status IN ('pending', 'running') AND heartbeat_at < $1
+			ORDER BY heartbeat_at
+			LIMIT 100
+			FOR UPDATE SKIP LOCKED
+		 )
+		 RETURNING `+pipelineRunColumns,
+		cutoff,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	runs, err := collectPipelineRuns(rows)
+	if err != nil {
+		return nil, err
+	}
+	return runs, s.loadPipelineStages(ctx, runs)
+}
+
+func collectPipelineRuns(rows pgx.Rows) ([]*pipeline.PipelineRun, error) {
+	defer rows.Close()
+	runs := []*pipeline.PipelineRun{}
+	for rows.Next() {
+		r, err := scanPipelineRun(rows)
+		if err != nil {
+			return nil, translateError(err)
+		}
+		runs = append(runs, r)
+	}
+	return runs, translateError(rows.Err())
+}
+
+// loadPipelineStages attaches recorded stage results to runs in one query
+func (s *Store) loadPipelineStages(ctx context.Context, runs []*pipeline.PipelineRun) error {
+	if len(runs) == 0 {
+		return nil
+	}
+	byID := make(map[string]*pipeline.PipelineRun, len(runs))
+	ids := make([]string, 0, len(runs))
+	for _, r := range runs {
+		byID[r.ID] = r
+		ids = append(ids, r.ID)
+	}
+
+	rows, err := s.pool.Query(ctx,
+		`SELECT run_id, stage, status, started_at, ended_at, duration_ms, output, COALESCE(error, ''), attempts
+		 FROM pipeline_stage_results
+		 WHERE run_id = ANY($1::uuid[])
+		 ORDER BY started_at`,
+		ids,
+	)
+	if err != nil {
+		return translateError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var runID, stage, status string
+		var result pipeline.StageResult
+		var output *string
+		if err := rows.Scan(&runID, &stage, &status, &result.StartedAt, &result.EndedAt,
+			&result.Duration, &output, &result.Error, &result.Attempts); err != nil {
+			return translateError(err)
+		}
+		result.Stage = pipeline.Stage(stage)
+		result.Status = pipeline.PipelineStatus(status)
+		r, ok := byID[runID]
+		if !ok {
+			continue
+		}
+		if output != nil {
+			// Outputs of organizations whose key was revoked are gone for
+			// good; the rest of the run stays readable
+			plaintext, err := s.open(ctx, r.OrgID, *output)
+			if err != nil && !errors.Is(err, security.ErrKEKRevoked) {
+				return fmt.Errorf("failed to decrypt output of stage %s: %w", stage, err)
+			}
+			var decoded interface{}
+			if err == nil && json.Unmarshal(plaintext, &decoded) == nil {
+				result.Output = decoded
+			}
+		}
+		r.Stages = append(r.Stages, result)
+	}
+	return translateError(rows.Err())
+}
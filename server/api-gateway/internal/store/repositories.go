@@ -0,0 +1,175 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/depgraph"
+	"github.com/archlens/api-gateway/internal/listing"
+)
+
+// Repository is a source code repository registered for analysis
+type Repository struct {
+	ID            string          `json:"id"`
+	OrgID         string          `json:"org_id"`
+	Name          string          `json:"name"`
+	Provider      string          `json:"provider"`
+	RemoteURL     string          `json:"remote_url"`
+	DefaultBranch string          `json:"default_branch"`
+	LastSyncedAt  *time.Time      `json:"last_synced_at,omitempty"`
+	Config        json.RawMessage `json:"config"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// CreateRepositoryInput holds the fields accepted when registering a repository
+type CreateRepositoryInput struct {
+	Name          string          `json:"name"`
+	Provider      string          `json:"provider"`
+	RemoteURL     string          `json:"remote_url"`
+	DefaultBranch string          `json:"default_branch"`
+	Config        json.RawMessage `json:"config"`
+}
+
+// RepositoryPipelineConfig is the "pipeline" section of a repository's config
+type RepositoryPipelineConfig struct {
+	DisabledStages []string `json:"disabled_stages"`
+}
+
+// PipelineConfig decodes the pipeline section of the repository config
+func (r *Repository) PipelineConfig() RepositoryPipelineConfig {
+	var cfg struct {
+		Pipeline RepositoryPipelineConfig `json:"pipeline"`
+	}
+	_ = json.Unmarshal(r.Config, &cfg)
+	if cfg.Pipeline.DisabledStages == nil {
+		cfg.Pipeline.DisabledStages = []string{}
+	}
+	return cfg.Pipeline
+}
+
+// Components decodes the "components" section of the repository config,
+// which groups paths for component-level dependency views:
+//
+//	{"components": [{"name": "billing", "paths": ["services/billing/**"]}]}
+func (r *Repository) Components() []depgraph.Component {
+	var cfg struct {
+		Components []depgraph.Component `json:"components"`
+	}
+	_ = json.Unmarshal(r.Config, &cfg)
+	return cfg.Components
+}
+
+var validProviders = map[string]bool{"github": true, "gitlab": true, "bitbucket": true, "azure_devops": true}
+
+// Validate normalizes the input and checks it against the schema constraints
+func (in *CreateRepositoryInput) Validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	in.Provider = strings.ToLower(strings.TrimSpace(in.Provider))
+	in.RemoteURL = strings.TrimSpace(in.RemoteURL)
+	in.DefaultBranch = strings.TrimSpace(in.DefaultBranch)
+	if in.DefaultBranch == "" {
+		in.DefaultBranch = "main"
+	}
+	if len(in.Config) == 0 {
+		in.Config = json.RawMessage(`{}`)
+	}
+
+	if in.Name == "" {
+		return &ValidationError{Field: "name", Message: "is required"}
+	}
+	if len(in.Name) > 200 {
+		return &ValidationError{Field: "name", Message: "must be at most 200 characters"}
+	}
+	if !validProviders[in.Provider] {
+		return &ValidationError{Field: "provider", Message: "must be one of github, gitlab, bitbucket, azure_devops"}
+	}
+	if in.RemoteURL == "" {
+		return &ValidationError{Field: "remote_url", Message: "is required"}
+	}
+	if !strings.HasPrefix(in.RemoteURL, "git@") {
+		u, err := url.Parse(in.RemoteURL)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "ssh") {
+			return &ValidationError{Field: "remote_url", Message: "must be an http(s), ssh or scp-style git URL"}
+		}
+	}
+	if !isJSONObject(in.Config) {
+		return &ValidationError{Field: "config", Message: "must be a JSON object"}
+	}
+	return nil
+}
+
+const repositoryColumns = `id, org_id, name, provider, remote_url, default_branch, last_synced_at, config, created_at, updated_at`
+
+func scanRepository(row rowScanner) (*Repository, error) {
+	var r Repository
+	if err := row.Scan(&r.ID, &r.OrgID, &r.Name, &r.Provider, &r.RemoteURL, &r.DefaultBranch,
+		&r.LastSyncedAt, &r.Config, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, translateError(err)
+	}
+	return &r, nil
+}
+
+// RepositoryListing declares how repository lists are queried
+var RepositoryListing = listing.Spec{
+	Sorts: map[string]listing.Sort{
+		"created_at": {Column: "created_at", Type: listing.Time},
+		"updated_at": {Column: "updated_at", Type: listing.Time},
+		"name":       {Column: "name", Type: listing.Text},
+	},
+	DefaultSort: "-created_at",
+	Filters: map[string]listing.Filter{
+		"provider":       {Column: "provider", Type: listing.Text, Values: []string{"github", "gitlab", "bitbucket", "azure_devops"}},
+		"default_branch": {Column: "default_branch", Type: listing.Text},
+	},
+}
+
+// ListRepositories returns a page of the repositories owned by an organization
+func (s *Store) ListRepositories(ctx context.Context, orgID string, q listing.Query) (*listing.Page[*Repository], error) {
+	return listPage(ctx, s, q, repositoryColumns, "repositories", "org_id = $1", []interface{}{orgID}, scanRepository)
+}
+
+// GetRepository returns a repository if it is owned by the given organization
+func (s *Store) GetRepository(ctx context.Context, orgID, id string) (*Repository, error) {
+	row := s.pool.QueryRow(ctx,
+		`SELECT `+repositoryColumns+` FROM repositories WHERE id = $1 AND org_id = $2`,
+		id, orgID,
+	)
+	return scanRepository(row)
+}
+
+// CreateRepository registers a repository; remote URLs are unique per organization
+func (s *Store) CreateRepository(ctx context.Context, orgID string, in CreateRepositoryInput) (*Repository, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	row := s.pool.QueryRow(ctx,
+		`INSERT INTO repositories (org_id, name, provider, remote_url, default_branch, config)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 RETURNING `+repositoryColumns,
+		orgID, in.Name, in.Provider, in.RemoteURL, in.DefaultBranch, in.Config,
+	)
+	return scanRepository(row)
+}
+
+// UpdateRepositoryPipelineConfig replaces the pipeline section of a repository's config
+func (s *Store) UpdateRepositoryPipelineConfig(ctx context.Context, orgID, id string, cfg RepositoryPipelineConfig) (*Repository, error) {
+	if cfg.DisabledStages == nil {
+		cfg.DisabledStages = []string{}
+	}
+	pipelineCfg, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	row := s.pool.QueryRow(ctx,
+		`UPDATE repositories
+		 SET config = config || jsonb_build_object('pipeline', $3::jsonb)
+		 WHERE id = $1 AND org_id = $2
+		 RETURNING `+repositoryColumns,
+		id, orgID, pipelineCfg,
+	)
+	return scanRepository(row)
+}
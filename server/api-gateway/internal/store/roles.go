@@ -0,0 +1,152 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/security"
+)
+
+// Role is an organization-defined role granting a set of permissions
+type Role struct {
+	ID          string    `json:"id"`
+	OrgID       string    `json:"org_id"`
+	Name        string    `json:"name"`
+	Description *string   `json:"description,omitempty"`
+	Permissions []string  `json:"permissions"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// RoleInput holds the fields accepted when creating or replacing a role
+type RoleInput struct {
+	Name        string   `json:"name"`
+	Description *string  `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+var roleNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_-]{1,62}$`)
+
+// Validate normalizes the input and checks it against the schema constraints
+// and the permission catalogue
+func (in *RoleInput) Validate() error {
+	in.Name = strings.ToLower(strings.TrimSpace(in.Name))
+	if !roleNamePattern.MatchString(in.Name) {
+		return &ValidationError{Field: "name", Message: "must be 2-63 lowercase letters, digits, underscores or hyphens"}
+	}
+	if _, builtin := security.BuiltinRoles[in.Name]; builtin {
+		return &ValidationError{Field: "name", Message: "is a built-in role"}
+	}
+	seen := make(map[string]bool, len(in.Permissions))
+	perms := make([]string, 0, len(in.Permissions))
+	for _, p := range in.Permissions {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if !security.ValidPermission(p) {
+			return &ValidationError{Field: "permissions", Message: "unknown permission " + p}
+		}
+		if !seen[p] {
+			seen[p] = true
+			perms = append(perms, p)
+		}
+	}
+	in.Permissions = perms
+	return nil
+}
+
+const roleColumns = `id, org_id, name, description, permissions, created_at, updated_at`
+
+func scanRole(row rowScanner) (*Role, error) {
+	var r Role
+	if err := row.Scan(&r.ID, &r.OrgID, &r.Name, &r.Description, &r.Permissions, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, translateError(err)
+	}
+	return &r, nil
+}
+
+// ListRoles returns an organization's custom roles by name
+func (s *Store) ListRoles(ctx context.Context, orgID string) ([]*Role, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT `+roleColumns+` FROM org_roles WHERE org_id = $1 ORDER BY name`,
+		orgID,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	list := []*Role{}
+	for rows.Next() {
+		r, err := scanRole(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, r)
+	}
+	return list, translateError(rows.Err())
+}
+
+// GetRole returns a custom role if it belongs to the organization
+func (s *Store) GetRole(ctx context.Context, orgID, id string) (*Role, error) {
+	row := s.pool.QueryRow(ctx,
+		`SELECT `+roleColumns+` FROM org_roles WHERE id = $1 AND org_id = $2`,
+		id, orgID,
+	)
+	return scanRole(row)
+}
+
+// CreateRole adds a custom role; names are unique per organization
+func (s *Store) CreateRole(ctx context.Context, orgID string, in RoleInput) (*Role, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	row := s.pool.QueryRow(ctx,
+		`INSERT INTO org_roles (org_id, name, description, permissions)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING `+roleColumns,
+		orgID, in.Name, in.Description, in.Permissions,
+	)
+	return scanRole(row)
+}
+
+// UpdateRole replaces a custom role's fields
+func (s *Store) UpdateRole(ctx context.Context, orgID, id string, in RoleInput) (*Role, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	row := s.pool.QueryRow(ctx,
+		`UPDATE org_roles SET name = $3, description = $4, permissions = $5
+		 WHERE id = $1 AND org_id = $2
+		 RETURNING `+roleColumns,
+		id, orgID, in.Name, in.Description, in.Permissions,
+	)
+	return scanRole(row)
+}
+
+// DeleteRole removes a custom role; users holding it lose its permissions
+func (s *Store) DeleteRole(ctx context.Context, orgID, id string) error {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM org_roles WHERE id = $1 AND org_id = $2`, id, orgID)
+	if err != nil {
+		return translateError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CustomRolePermissions implements security.CustomRoles. Unknown roles and
+// organizations hold no permissions.
+func (s *Store) CustomRolePermissions(ctx context.Context, orgID, role string) ([]string, error) {
+	var perms []string
+	err := s.pool.QueryRow(ctx,
+		`SELECT permissions FROM org_roles WHERE org_id = $1 AND name = $2`,
+		orgID, role,
+	).Scan(&perms)
+	if err = translateError(err); errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return perms, err
+}
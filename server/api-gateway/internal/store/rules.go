@@ -0,0 +1,193 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/archlens/api-gateway/internal/rules"
+)
+
+// Rule is an architectural rule evaluated against every analysis of an
+// organization's repositories. Definition uses the rules package DSL.
+type Rule struct {
+	ID          string          `json:"id"`
+	OrgID       string          `json:"org_id"`
+	Name        string          `json:"name"`
+	Description *string         `json:"description,omitempty"`
+	Category    string          `json:"category"`
+	Severity    string          `json:"severity"`
+	Definition  json.RawMessage `json:"rule_definition"`
+	Enabled     bool            `json:"enabled"`
+	CreatedBy   *string         `json:"created_by,omitempty"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+// RuleInput holds the fields accepted when creating or replacing a rule
+type RuleInput struct {
+	Name        string          `json:"name"`
+	Description *string         `json:"description"`
+	Category    string          `json:"category"`
+	Severity    string          `json:"severity"`
+	Definition  json.RawMessage `json:"rule_definition"`
+	Enabled     *bool           `json:"enabled"`
+}
+
+var (
+	validRuleCategories = map[string]bool{"dependency": true, "security": true, "performance": true, "convention": true}
+	validSeverities     = map[string]bool{"info": true, "warning": true, "error": true, "critical": true}
+)
+
+// Validate normalizes the input and checks it against the schema constraints
+// and the rule DSL
+func (in *RuleInput) Validate() error {
+	in.Name = strings.TrimSpace(in.Name)
+	in.Category = strings.ToLower(strings.TrimSpace(in.Category))
+	in.Severity = strings.ToLower(strings.TrimSpace(in.Severity))
+	if in.Severity == "" {
+		in.Severity = "warning"
+	}
+	if in.Enabled == nil {
+		enabled := true
+		in.Enabled = &enabled
+	}
+
+	if in.Name == "" {
+		return &ValidationError{Field: "name", Message: "is required"}
+	}
+	if len(in.Name) > 200 {
+		return &ValidationError{Field: "name", Message: "must be at most 200 characters"}
+	}
+	if !validRuleCategories[in.Category] {
+		return &ValidationError{Field: "category", Message: "must be one of dependency, security, performance, convention"}
+	}
+	if !validSeverities[in.Severity] {
+		return &ValidationError{Field: "severity", Message: "must be one of info, warning, error, critical"}
+	}
+	if len(in.Definition) == 0 {
+		return &ValidationError{Field: "rule_definition", Message: "is required"}
+	}
+	if err := rules.Validate(in.Definition); err != nil {
+		var defErr *rules.DefinitionError
+		if errors.As(err, &defErr) && defErr.Field != "rule_definition" {
+			return &ValidationError{Field: "rule_definition." + defErr.Field, Message: defErr.Message}
+		}
+		return &ValidationError{Field: "rule_definition", Message: err.Error()}
+	}
+	return nil
+}
+
+const ruleColumns = `id, org_id, name, description, category, severity, rule_definition, enabled, created_by, created_at, updated_at`
+
+func scanRule(row rowScanner) (*Rule, error) {
+	var r Rule
+	if err := row.Scan(&r.ID, &r.OrgID, &r.Name, &r.Description, &r.Category, &r.Severity,
+		&r.Definition, &r.Enabled, &r.CreatedBy, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, translateError(err)
+	}
+	return &r, nil
+}
+
+// RuleListing declares how rule lists are queried
+var RuleListing = listing.Spec{
+	Sorts: map[string]listing.Sort{
+		"created_at": {Column: "created_at", Type: listing.Time},
+		"updated_at": {Column: "updated_at", Type: listing.Time},
+		"name":       {Column: "name", Type: listing.Text},
+	},
+	DefaultSort: "-created_at",
+	Filters: map[string]listing.Filter{
+		"category": {Column: "category", Type: listing.Text, Values: []string{"dependency", "security", "performance", "convention"}},
+		"severity": {Column: "severity", Type: listing.Text, Values: []string{"info", "warning", "error", "critical"}},
+		"enabled":  {Column: "enabled", Type: listing.Bool},
+	},
+}
+
+// ListRules returns a page of an organization's rules
+func (s *Store) ListRules(ctx context.Context, orgID string, q listing.Query) (*listing.Page[*Rule], error) {
+	return listPage(ctx, s, q, ruleColumns, "architectural_rules", "org_id = $1", []interface{}{orgID}, scanRule)
+}
+
+// GetRule returns a rule if it is owned by the given organization
+func (s *Store) GetRule(ctx context.Context, orgID, id string) (*Rule, error) {
+	row := s.pool.QueryRow(ctx,
+		`SELECT `+ruleColumns+` FROM architectural_rules WHERE id = $1 AND org_id = $2`,
+		id, orgID,
+	)
+	return scanRule(row)
+}
+
+// CreateRule adds a rule to an organization. createdBy is the caller's user
+// ID or identity provider subject; it is left empty if no such user exists.
+func (s *Store) CreateRule(ctx context.Context, orgID, createdBy string, in RuleInput) (*Rule, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	row := s.pool.QueryRow(ctx,
+		`INSERT INTO architectural_rules (org_id, name, description, category, severity, rule_definition, enabled, created_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7,
+		         (SELECT id FROM users WHERE org_id = $1 AND (id::text = $8 OR external_id = $8) LIMIT 1))
+		 RETURNING `+ruleColumns,
+		orgID, in.Name, in.Description, in.Category, in.Severity, in.Definition, *in.Enabled, createdBy,
+	)
+	return scanRule(row)
+}
+
+// UpdateRule replaces the editable fields of a rule
+func (s *Store) UpdateRule(ctx context.Context, orgID, id string, in RuleInput) (*Rule, error) {
+	if err := in.Validate(); err != nil {
+		return nil, err
+	}
+	row := s.pool.QueryRow(ctx,
+		`UPDATE architectural_rules
+		 SET name = $3, description = $4, category = $5, severity = $6, rule_definition = $7, enabled = $8
+		 WHERE id = $1 AND org_id = $2
+		 RETURNING `+ruleColumns,
+		id, orgID, in.Name, in.Description, in.Category, in.Severity, in.Definition, *in.Enabled,
+	)
+	return scanRule(row)
+}
+
+// DeleteRule removes a rule; drift events it raised are kept
+func (s *Store) DeleteRule(ctx context.Context, orgID, id string) error {
+	tag, err := s.pool.Exec(ctx,
+		`DELETE FROM architectural_rules WHERE id = $1 AND org_id = $2`,
+		id, orgID,
+	)
+	if err != nil {
+		return translateError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ListEnabledRules returns the rules the pipeline evaluates for an organization
+func (s *Store) ListEnabledRules(ctx context.Context, orgID string) ([]rules.Rule, error) {
+	rows, err := s.pool.Query(ctx,
+		`SELECT id, name, COALESCE(description, ''), category, severity, rule_definition
+		 FROM architectural_rules
+		 WHERE org_id = $1 AND enabled
+		 ORDER BY created_at`,
+		orgID,
+	)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer rows.Close()
+
+	list := []rules.Rule{}
+	for rows.Next() {
+		var r rules.Rule
+		if err := rows.Scan(&r.ID, &r.Name, &r.Description, &r.Category, &r.Severity, &r.Definition); err != nil {
+			return nil, translateError(err)
+		}
+		list = append(list, r)
+	}
+	return list, translateError(rows.Err())
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/archlens/api-gateway/internal/security"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var (
+	ErrNotFound = errors.New("resource not found")
+	ErrConflict = errors.New("resource already exists")
+)
+
+// ValidationError reports invalid input for a single field
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Field, e.Message)
+}
+
+// isJSONObject reports whether raw is a JSON object; null, arrays and
+// scalars are refused
+func isJSONObject(raw []byte) bool {
+	var obj map[string]json.RawMessage
+	return json.Unmarshal(raw, &obj) == nil && obj != nil
+}
+
+// Store provides PostgreSQL-backed persistence for gateway resources
+type Store struct {
+	pool *pgxpool.Pool
+	enc  *security.Envelope
+}
+
+// New connects to PostgreSQL and verifies the connection
+func New(ctx context.Context, dsn string) (*Store, error) {
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pool: %w", err)
+	}
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("failed to ping postgres: %w", err)
+	}
+	return &Store{pool: pool}, nil
+}
+
+// UseEncryption sets the encryptor for the fields listed in encryptedFields.
+// The envelope itself reads tenant keys from the store, so it is attached
+// after construction; until then writes of encrypted fields fail.
+func (s *Store) UseEncryption(enc *security.Envelope) {
+	s.enc = enc
+}
+
+// Close releases all pooled connections
+func (s *Store) Close() {
+	s.pool.Close()
+}
+
+// Ping checks that the database is reachable
+func (s *Store) Ping(ctx context.Context) error {
+	return s.pool.Ping(ctx)
+}
+
+// translateError maps driver errors onto the store's sentinel errors
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505": // unique_violation
+			return ErrConflict
+		case "23503", // foreign_key_violation
+			"22P02": // invalid_text_representation (e.g. malformed UUID)
+			return ErrNotFound
+		}
+	}
+	return err
+}
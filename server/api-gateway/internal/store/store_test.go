@@ -0,0 +1,38 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestValidateRequiresJSONObjects(t *testing.T) {
+	tests := []struct {
+		raw string
+		ok  bool
+	}{
+		{``, true},
+		{`{}`, true},
+		{` {"pipeline": {"disabled_stages": ["insights"]}}`, true},
+		{`null`, false},
+		{`[]`, false},
+		{`"text"`, false},
+		{`42`, false},
+		{`true`, false},
+		{`{"a":1} {}`, false},
+		{`{`, false},
+	}
+	for _, tt := range tests {
+		org := CreateOrganizationInput{Name: "Acme", Slug: "acme", Settings: json.RawMessage(tt.raw)}
+		repo := CreateRepositoryInput{Name: "api", Provider: "github", RemoteURL: "https://github.com/acme/api", Config: json.RawMessage(tt.raw)}
+		for field, err := range map[string]error{"settings": org.Validate(), "config": repo.Validate()} {
+			var verr *ValidationError
+			switch {
+			case tt.ok && err != nil:
+				t.Errorf("%s %q: %v", field, tt.raw, err)
+			case !tt.ok && (!errors.As(err, &verr) || verr.Field != field):
+				t.Errorf("%s %q: err = %v, want a %s validation error", field, tt.raw, err, field)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/archlens/api-gateway/internal/listing"
+	"github.com/archlens/api-gateway/internal/security"
+)
+
+// SyntheticFix is a patch proposed to resolve a drift event. Patch is stored
+// encrypted and is empty when the organization's key was revoked.
+type SyntheticFix struct {
+	ID           string     `json:"id"`
+	DriftEventID string     `json:"drift_event_id"`
+	RepoID       string     `json:"repo_id"`
+	Title        string     `json:"title"`
+	Description  *string    `json:"description,omitempty"`
+	Patch        string     `json:"patch"`
+	Confidence   float32    `json:"confidence"`
+	Status       string     `json:"status"`
+	AppliedBy    *string    `json:"applied_by,omitempty"`
+	AppliedAt    *time.Time `json:"applied_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+}
+
+// SyntheticFixListing declares how fix lists are queried
+var SyntheticFixListing = listing.Spec{
+	Sorts: map[string]listing.Sort{
+		"created_at": {Column: "created_at", Type: listing.Time},
+	},
+	DefaultSort: "-created_at",
+	Filters: map[string]listing.Filter{
+		"status": {Column: "status", Type: listing.Text, Values: []string{"proposed", "accepted", "applied", "rejected"}},
+	},
+}
+
+const syntheticFixColumns = `id, drift_event_id, repo_id, title, description, patch, confidence, status, applied_by, applied_at, created_at`
+
+func scanSyntheticFix(row rowScanner) (*SyntheticFix, error) {
+	var f SyntheticFix
+	if err := row.Scan(&f.ID, &f.DriftEventID, &f.RepoID, &f.Title, &f.Description, &f.Patch, &f.Confidence,
+		&f.Status, &f.AppliedBy, &f.AppliedAt, &f.CreatedAt); err != nil {
+		return nil, translateError(err)
+	}
+	return &f, nil
+}
+
+// ListSyntheticFixes returns a page of the fixes proposed for a drift event
+func (s *Store) ListSyntheticFixes(ctx context.Context, orgID, driftEventID string, q listing.Query) (*listing.Page[*SyntheticFix], error) {
+	page, err := listPage(ctx, s, q, syntheticFixColumns, "synthetic_fixes",
+		"drift_event_id = $2 AND repo_id IN (SELECT id FROM repositories WHERE org_id = $1)",
+		[]interface{}{orgID, driftEventID}, scanSyntheticFix)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range page.Data {
+		patch, err := s.open(ctx, orgID, f.Patch)
+		if err != nil && !errors.Is(err, security.ErrKEKRevoked) {
+			return nil, fmt.Errorf("failed to decrypt patch of fix %s: %w", f.ID, err)
+		}
+		f.Patch = string(patch)
+	}
+	return page, nil
+}
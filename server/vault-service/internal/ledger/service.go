@@ -4,10 +4,12 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"time"
 
 	"github.com/archlens/vault-service/internal/config"
 	"github.com/archlens/vault-service/internal/crypto"
+	"github.com/archlens/vault-service/internal/listing"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -26,7 +28,7 @@ type Entry struct {
 	Payload      map[string]interface{} `json:"payload"`
 	Signature    string                 `json:"signature"`
 	PublicKey    string                 `json:"public_key"`
-	PreviousHash string                `json:"previous_hash"`
+	PreviousHash string                 `json:"previous_hash"`
 	Hash         string                 `json:"hash"`
 	Timestamp    time.Time              `json:"timestamp"`
 }
@@ -93,9 +95,34 @@ func (s *Service) VerifyChain() (bool, int) {
 	return true, -1
 }
 
+// ListHandler pages the chain, optionally filtered by ?entry_type=. Entries
+// are ordered by their position in the chain, oldest first by default.
 func (s *Service) ListHandler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		return c.JSON(fiber.Map{"entries": s.chain, "total": len(s.chain)})
+		q, err := listing.Parse(c, []string{"timestamp"}, "timestamp")
+		if err != nil {
+			return listing.Respond(c, err)
+		}
+		entryType := c.Query("entry_type")
+		type indexed struct {
+			seq   int
+			entry Entry
+		}
+		var matches []indexed
+		for i, e := range s.chain {
+			if entryType == "" || e.EntryType == entryType {
+				matches = append(matches, indexed{seq: i, entry: e})
+			}
+		}
+		page := listing.Paginate(q, matches,
+			func(m indexed) string { return fmt.Sprintf("%020d", m.seq) },
+			func(m indexed) string { return m.entry.ID })
+
+		entries := make([]Entry, len(page.Data))
+		for i, m := range page.Data {
+			entries[i] = m.entry
+		}
+		return c.JSON(listing.Page[Entry]{Data: entries, Pagination: page.Pagination})
 	}
 }
 
@@ -0,0 +1,158 @@
+// Package listing pages in-memory collections with the same query contract
+// and response envelope as the API gateway's list endpoints: ?limit=,
+// ?sort= (prefixed with - for descending order) and an opaque ?cursor=.
+package listing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	DefaultLimit = 50
+	MaxLimit     = 200
+)
+
+// Error reports an invalid query parameter
+type Error struct {
+	Field   string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s", e.Field, e.Message)
+}
+
+// Respond writes err as a 400 response if it is an Error
+func Respond(c *fiber.Ctx, err error) error {
+	if e, ok := err.(*Error); ok {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error":   "invalid query",
+			"field":   e.Field,
+			"message": e.Message,
+		})
+	}
+	return err
+}
+
+type cursor struct {
+	Sort string `json:"s"`
+	Key  string `json:"k"`
+	ID   string `json:"i"`
+}
+
+// Query is a parsed list request
+type Query struct {
+	Limit    int
+	Field    string
+	sortName string
+	desc     bool
+	after    *cursor
+}
+
+// Pagination describes a page and how to fetch the next one
+type Pagination struct {
+	Limit      int    `json:"limit"`
+	Sort       string `json:"sort,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// Page is the envelope of every list response
+type Page[T any] struct {
+	Data       []T        `json:"data"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// Parse reads ?limit=, ?sort= and ?cursor=; sorts whitelists the fields a
+// list can be ordered by
+func Parse(c *fiber.Ctx, sorts []string, defaultSort string) (Query, error) {
+	q := Query{Limit: DefaultLimit}
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > MaxLimit {
+			return Query{}, &Error{Field: "limit", Message: fmt.Sprintf("must be between 1 and %d", MaxLimit)}
+		}
+		q.Limit = n
+	}
+
+	q.sortName = strings.Clone(c.Query("sort", defaultSort))
+	q.Field = strings.TrimPrefix(q.sortName, "-")
+	q.desc = strings.HasPrefix(q.sortName, "-")
+	known := false
+	for _, s := range sorts {
+		known = known || s == q.Field
+	}
+	if !known {
+		return Query{}, &Error{Field: "sort", Message: "must be one of " + strings.Join(sorts, ", ") + ", optionally prefixed with -"}
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		var cur cursor
+		b, err := base64.RawURLEncoding.DecodeString(raw)
+		if err != nil || json.Unmarshal(b, &cur) != nil {
+			return Query{}, &Error{Field: "cursor", Message: "is invalid"}
+		}
+		if cur.Sort != q.sortName {
+			return Query{}, &Error{Field: "cursor", Message: "was issued for a different sort"}
+		}
+		q.after = &cur
+	}
+	return q, nil
+}
+
+// TimeKey formats t so that keys sort in time order
+func TimeKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// Paginate orders items by key, the value of the sorted field, breaking ties
+// by id, and returns the page following the query's cursor
+func Paginate[T any](q Query, items []T, key func(T) string, id func(T) string) *Page[T] {
+	less := func(a, b T) bool {
+		ka, kb := key(a), key(b)
+		if ka != kb {
+			return ka < kb
+		}
+		return id(a) < id(b)
+	}
+	sorted := append([]T(nil), items...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if q.desc {
+			return less(sorted[j], sorted[i])
+		}
+		return less(sorted[i], sorted[j])
+	})
+
+	start := 0
+	if q.after != nil {
+		start = sort.Search(len(sorted), func(i int) bool {
+			k, ik := key(sorted[i]), id(sorted[i])
+			if k != q.after.Key {
+				return (k > q.after.Key) != q.desc
+			}
+			return (ik > q.after.ID) != q.desc && ik != q.after.ID
+		})
+	}
+	rest := sorted[start:]
+
+	p := &Page[T]{Data: rest, Pagination: Pagination{Limit: q.Limit, Sort: q.sortName}}
+	if len(rest) > q.Limit {
+		last := rest[q.Limit-1]
+		p.Data = rest[:q.Limit]
+		p.Pagination.HasMore = true
+		raw, _ := json.Marshal(cursor{Sort: q.sortName, Key: key(last), ID: id(last)})
+		p.Pagination.NextCursor = base64.RawURLEncoding.EncodeToString(raw)
+	}
+	if p.Data == nil {
+		p.Data = []T{}
+	}
+	return p
+}
@@ -1,10 +1,12 @@
 package rationale
 
 import (
+	"slices"
 	"time"
 
 	"github.com/archlens/vault-service/internal/config"
 	"github.com/archlens/vault-service/internal/crypto"
+	"github.com/archlens/vault-service/internal/listing"
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
@@ -41,10 +43,18 @@ func NewService(cfg *config.Config, logger *zap.SugaredLogger, signer *crypto.Ed
 	}
 }
 
+// ListHandler pages rationales, optionally filtered by ?org_id=, ?repo_id=,
+// ?category= and ?tag=
 func (s *Service) ListHandler() fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		q, err := listing.Parse(c, []string{"created_at", "updated_at", "title"}, "-created_at")
+		if err != nil {
+			return listing.Respond(c, err)
+		}
 		orgID := c.Query("org_id")
 		repoID := c.Query("repo_id")
+		category := c.Query("category")
+		tag := c.Query("tag")
 		var results []*Rationale
 		for _, r := range s.store {
 			if orgID != "" && r.OrgID != orgID {
@@ -53,12 +63,24 @@ func (s *Service) ListHandler() fiber.Handler {
 			if repoID != "" && r.RepoID != repoID {
 				continue
 			}
+			if category != "" && r.Category != category {
+				continue
+			}
+			if tag != "" && !slices.Contains(r.Tags, tag) {
+				continue
+			}
 			results = append(results, r)
 		}
-		if results == nil {
-			results = []*Rationale{}
+		key := func(r *Rationale) string {
+			switch q.Field {
+			case "updated_at":
+				return listing.TimeKey(r.UpdatedAt)
+			case "title":
+				return r.Title
+			}
+			return listing.TimeKey(r.CreatedAt)
 		}
-		return c.JSON(fiber.Map{"data": results, "total": len(results)})
+		return c.JSON(listing.Paginate(q, results, key, func(r *Rationale) string { return r.ID }))
 	}
 }
 